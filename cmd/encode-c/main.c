@@ -24,7 +24,8 @@ int main(int argc, char *argv[]) {
   struct video_store_h264_encoder *e = NULL;
 
   int ret = video_store_h264_encoder_init(
-      &e, 30, "./mp4s/h264_%Y-%m-%d_%H-%M-%S.mp4", bitrate, fps, preset);
+      &e, 30, "./mp4s/h264_%Y-%m-%d_%H-%M-%S.mp4", bitrate, fps, preset, 0, 1,
+      5, 10000);
   if (ret != VIDEO_STORE_ENCODER_RESP_OK) {
     printf("Failed to init encoder: %d\n", ret);
     return 1;
@@ -36,7 +37,8 @@ int main(int argc, char *argv[]) {
   }
 
   ret = video_store_h264_encoder_init(
-      &e, 30, "./mp4s/h264_%Y-%m-%d_%H-%M-%S.mp4", bitrate, fps, preset);
+      &e, 30, "./mp4s/h264_%Y-%m-%d_%H-%M-%S.mp4", bitrate, fps, preset, 0, 1,
+      5, 10000);
   if (ret != VIDEO_STORE_ENCODER_RESP_OK) {
     printf("Failed to init encoder: %d\n", ret);
     return 1;
@@ -51,10 +51,12 @@ int main(int argc, char *argv[]) {
 
   if (isH264) {
     ret = video_store_raw_seg_init_h264(
-        &rs, 30, "./mp4s/h264_%Y-%m-%d_%H-%M-%S.mp4", width, height);
+        &rs, 30, "./mp4s/h264_%Y-%m-%d_%H-%M-%S.mp4", width, height, 0, 0, 0, 5,
+        10000, NULL, 0);
   } else {
     ret = video_store_raw_seg_init_h265(
-        &rs, 30, "./mp4s/h265_%Y-%m-%d_%H-%M-%S.mp4", width, height);
+        &rs, 30, "./mp4s/h265_%Y-%m-%d_%H-%M-%S.mp4", width, height, 0, 0, 0, 5,
+        10000, NULL, 0);
   }
   if (ret != VIDEO_STORE_RAW_SEG_RESP_OK) {
     printf("video_store_raw_seg_init failed: %d\n", ret);
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"path/filepath"
 
 	"github.com/viam-modules/video-store/videostore"
 	"go.viam.com/rdk/components/camera"
@@ -79,6 +80,12 @@ func newComponent(
 	var vs videostore.VideoStore
 	if vsConfig.FramePoller.Camera != nil {
 		vs, err = videostore.NewFramePollingVideoStore(vsConfig, logger)
+		if errors.Is(err, videostore.ErrReadOnlyStorage) {
+			// Stored video can still be fetched and saved from read-only storage.
+			logger.Warnf("storage path %s is read-only, video-store will not be storing video", vsConfig.Storage.StoragePath)
+			vsConfig.Type = videostore.SourceTypeReadOnly
+			vs, err = videostore.NewReadOnlyVideoStore(vsConfig, logger)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -131,6 +138,20 @@ func (c *component) DoCommand(ctx context.Context, command map[string]interface{
 
 		if req.Async {
 			ret["status"] = "async"
+		} else {
+			ret["codec"] = codecName(res.Codec)
+		}
+		// A range spanning a change of codec is saved to a file per codec.
+		if len(res.Clips) > 0 {
+			clips := make([]interface{}, 0, len(res.Clips))
+			for _, clip := range res.Clips {
+				clips = append(clips, map[string]interface{}{
+					"filename": clip.Filename,
+					"from":     clip.From.Local().Format(videostore.TimeFormat),
+					"codec":    codecName(clip.Codec),
+				})
+			}
+			ret["clips"] = clips
 		}
 		return ret, nil
 	case "fetch":
@@ -143,14 +164,214 @@ func (c *component) DoCommand(ctx context.Context, command map[string]interface{
 		if err != nil {
 			return nil, err
 		}
-		if len(res.Video) > maxGRPCSize {
+		size := len(res.Video)
+		for _, clip := range res.Clips {
+			size += len(clip.Video)
+		}
+		if size > maxGRPCSize {
 			return nil, errors.New("video file size exceeds max grpc size")
 		}
 		// TODO(seanp): Do we need to encode the video bytes to base64?
 		videoBytesBase64 := base64.StdEncoding.EncodeToString(res.Video)
-		return map[string]interface{}{
+		ret := map[string]interface{}{
 			"command": "fetch",
 			"video":   videoBytesBase64,
+			"codec":   codecName(res.Codec),
+		}
+		// A range spanning a change of codec is fetched as a video per codec.
+		if len(res.Clips) > 0 {
+			clips := make([]interface{}, 0, len(res.Clips))
+			for _, clip := range res.Clips {
+				clips = append(clips, map[string]interface{}{
+					"video": base64.StdEncoding.EncodeToString(clip.Video),
+					"from":  clip.From.Local().Format(videostore.TimeFormat),
+					"codec": codecName(clip.Codec),
+				})
+			}
+			ret["clips"] = clips
+		}
+		return ret, nil
+	// Export command re-encodes the video between the given timestamps with the requested
+	// changes, e.g. annotations drawn as captions. The exported video file is written to the
+	// upload path. The response contains the name of the exported file.
+	case "export":
+		c.logger.Debug("export command received")
+		req, err := ToExportCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		req.Progress = func(p videostore.ExportProgress) {
+			c.logger.Debugf("export %.0f%% done, %d frames and %d bytes written", 100*p.Fraction, p.Frames, p.Bytes)
+		}
+		res, err := c.videostore.Export(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp := map[string]interface{}{
+			"command":  "export",
+			"filename": res.Filename,
+			"encoder":  res.Encoder,
+			"manifest": res.Manifest,
+		}
+		if res.Key != "" {
+			resp["key"] = res.Key
+		}
+		return resp, nil
+	// Export-sprites command writes a sprite sheet of thumbnails and a WebVTT file mapping
+	// time offsets to them to the upload path, for scrubbing previews in web players.
+	// Either the from/to timestamps or a segment name selects the video to cover.
+	case "export-sprites":
+		c.logger.Debug("export-sprites command received")
+		req, err := ToExportSpritesCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.videostore.ExportSpriteSheet(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"command": "export-sprites",
+			"sprite":  res.SpriteFilename,
+			"vtt":     res.VTTFilename,
+		}, nil
+	// Export-filmstrip command writes a single horizontal strip of thumbnails labeled with
+	// their times to the upload path, for timeline scrubbing UIs.
+	case "export-filmstrip":
+		c.logger.Debug("export-filmstrip command received")
+		req, err := ToExportFilmstripCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.videostore.ExportFilmstrip(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"command":          "export-filmstrip",
+			"filename":         res.Filename,
+			"interval_seconds": res.Interval.Seconds(),
+			"thumbnails":       res.Thumbnails,
+			"thumbnail_width":  res.ThumbnailWidth,
+		}, nil
+	// Validate command checks the given attributes against the host without applying them.
+	// The response lists every problem found so config can be fixed before reconfiguring.
+	case "validate":
+		c.logger.Debug("validate command received")
+		cfg, err := ToValidateCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		problems, err := ValidateAttributes(cfg, c.name.Name)
+		if err != nil {
+			return nil, err
+		}
+		problemList := make([]interface{}, 0, len(problems))
+		for _, p := range problems {
+			problemList = append(problemList, map[string]interface{}{
+				"field":   p.Field,
+				"message": p.Message,
+			})
+		}
+		return map[string]interface{}{
+			"command":  "validate",
+			"valid":    len(problems) == 0,
+			"problems": problemList,
+		}, nil
+	// Find-duplicates command lists groups of stored segments that look the same,
+	// e.g. from a stuck camera, using the perceptual hash computed for each segment.
+	case "find-duplicates":
+		c.logger.Debug("find-duplicates command received")
+		maxDistance, err := ToFindDuplicatesCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		duplicates, err := c.videostore.FindDuplicateSegments(ctx, maxDistance)
+		if err != nil {
+			return nil, err
+		}
+		groupList := make([]interface{}, 0, len(duplicates))
+		for _, d := range duplicates {
+			segments := make([]interface{}, 0, len(d.Segments))
+			for _, s := range d.Segments {
+				segments = append(segments, filepath.Base(s))
+			}
+			groupList = append(groupList, map[string]interface{}{
+				"segments": segments,
+				"bytes":    d.Bytes,
+			})
+		}
+		return map[string]interface{}{
+			"command": "find-duplicates",
+			"groups":  groupList,
+		}, nil
+	// Stored-ranges command lists the spans of stored video which can be fetched, split at
+	// each gap and change of codec, with the codec of each span and each of its segments.
+	case "stored-ranges":
+		c.logger.Debug("stored-ranges command received")
+		ranges, err := c.videostore.StoredRanges(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rangeList := make([]interface{}, 0, len(ranges))
+		for _, r := range ranges {
+			segments := make([]interface{}, 0, len(r.Segments))
+			for _, s := range r.Segments {
+				segments = append(segments, map[string]interface{}{
+					"segment": filepath.Base(s.Path),
+					"from":    s.From.Local().Format(videostore.TimeFormat),
+					"to":      s.To.Local().Format(videostore.TimeFormat),
+					"codec":   codecName(s.Codec),
+				})
+			}
+			rangeList = append(rangeList, map[string]interface{}{
+				"from":     r.From.Local().Format(videostore.TimeFormat),
+				"to":       r.To.Local().Format(videostore.TimeFormat),
+				"codec":    codecName(r.Codec),
+				"segments": segments,
+			})
+		}
+		return map[string]interface{}{
+			"command": "stored-ranges",
+			"ranges":  rangeList,
+		}, nil
+	// Storage-growth command reports how fast stored video is growing, the projected time
+	// until the disk fills and whether either crosses its configured alert threshold.
+	case "storage-growth":
+		c.logger.Debug("storage-growth command received")
+		growth := c.videostore.StorageGrowth()
+		return map[string]interface{}{
+			"command":          "storage-growth",
+			"bytes_per_second": growth.BytesPerSecond,
+			"hours_to_full":    growth.TimeToFull.Hours(),
+			"rate_alert":       growth.RateAlert,
+			"fill_alert":       growth.FillAlert,
+		}, nil
+	// Memory-headroom command reports the memory left before the cgroup memory limit of
+	// the module's container, and whether memory is being shed because it is nearly used.
+	case "memory-headroom":
+		c.logger.Debug("memory-headroom command received")
+		headroom, err := c.videostore.MemoryHeadroom()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"command":        "memory-headroom",
+			"limit_bytes":    headroom.Limit,
+			"usage_bytes":    headroom.Usage,
+			"headroom_bytes": headroom.Headroom,
+			"under_pressure": headroom.UnderPressure,
+		}, nil
+	// Capabilities command lists the video encoders, decoders and muxers the linked
+	// FFmpeg was built with, to check config against before applying it.
+	case "capabilities":
+		c.logger.Debug("capabilities command received")
+		caps := videostore.GetCapabilities()
+		return map[string]interface{}{
+			"command":  "capabilities",
+			"encoders": stringList(caps.Encoders),
+			"decoders": stringList(caps.Decoders),
+			"muxers":   stringList(caps.Muxers),
 		}, nil
 	default:
 		return nil, errors.New("invalid command")
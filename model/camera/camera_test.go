@@ -0,0 +1,153 @@
+package camera
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// fakeVideoStore returns fetch, save and ranges from Fetch, Save and StoredRanges.
+type fakeVideoStore struct {
+	videostore.VideoStore
+	fetch  *videostore.FetchResponse
+	save   *videostore.SaveResponse
+	ranges []videostore.StoredRange
+}
+
+func (s *fakeVideoStore) Fetch(context.Context, *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+	return s.fetch, nil
+}
+
+func (s *fakeVideoStore) Save(context.Context, *videostore.SaveRequest) (*videostore.SaveResponse, error) {
+	return s.save, nil
+}
+
+func (s *fakeVideoStore) StoredRanges(context.Context) ([]videostore.StoredRange, error) {
+	return s.ranges, nil
+}
+
+func TestDoCommandClips(t *testing.T) {
+	from := time.Date(2024, 9, 6, 15, 0, 0, 0, time.Local)
+	switched := from.Add(10 * time.Second)
+	command := func(cmd string) map[string]interface{} {
+		return map[string]interface{}{
+			"command": cmd,
+			"from":    from.Format(videostore.TimeFormat),
+			"to":      from.Add(20 * time.Second).Format(videostore.TimeFormat),
+		}
+	}
+	vs := &fakeVideoStore{
+		fetch: &videostore.FetchResponse{
+			Video: []byte("h264 video"),
+			Codec: videostore.CodecTypeH264,
+			Clips: []videostore.FetchedClip{
+				{Video: []byte("h264 video"), From: from, Codec: videostore.CodecTypeH264},
+				{Video: []byte("h265 video"), From: switched, Codec: videostore.CodecTypeH265},
+			},
+		},
+		save: &videostore.SaveResponse{
+			Filename: "first.mp4",
+			Codec:    videostore.CodecTypeH264,
+			Clips: []videostore.SavedClip{
+				{Filename: "first.mp4", From: from, Codec: videostore.CodecTypeH264},
+				{Filename: "second.mp4", From: switched, Codec: videostore.CodecTypeH265},
+			},
+		},
+	}
+	c := &component{videostore: vs, logger: logging.NewTestLogger(t)}
+
+	t.Run("Fetch returns every clip", func(t *testing.T) {
+		res, err := c.DoCommand(context.Background(), command("fetch"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res["video"], test.ShouldEqual, base64.StdEncoding.EncodeToString([]byte("h264 video")))
+		test.That(t, res["codec"], test.ShouldEqual, "h264")
+		test.That(t, res["clips"], test.ShouldResemble, []interface{}{
+			map[string]interface{}{
+				"video": base64.StdEncoding.EncodeToString([]byte("h264 video")),
+				"from":  from.Format(videostore.TimeFormat),
+				"codec": "h264",
+			},
+			map[string]interface{}{
+				"video": base64.StdEncoding.EncodeToString([]byte("h265 video")),
+				"from":  switched.Format(videostore.TimeFormat),
+				"codec": "h265",
+			},
+		})
+	})
+
+	t.Run("Save returns every clip", func(t *testing.T) {
+		res, err := c.DoCommand(context.Background(), command("save"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res["filename"], test.ShouldEqual, "first.mp4")
+		test.That(t, res["codec"], test.ShouldEqual, "h264")
+		test.That(t, res["clips"], test.ShouldResemble, []interface{}{
+			map[string]interface{}{"filename": "first.mp4", "from": from.Format(videostore.TimeFormat), "codec": "h264"},
+			map[string]interface{}{"filename": "second.mp4", "from": switched.Format(videostore.TimeFormat), "codec": "h265"},
+		})
+	})
+
+	t.Run("Stored ranges report the codec of each segment", func(t *testing.T) {
+		end := switched.Add(10 * time.Second)
+		vs.ranges = []videostore.StoredRange{
+			{From: from, To: switched, Codec: videostore.CodecTypeH264, Segments: []videostore.StoredSegment{
+				{Path: "/storage/first.mp4", From: from, To: switched, Codec: videostore.CodecTypeH264},
+			}},
+			{From: switched, To: end, Codec: videostore.CodecTypeH265, Segments: []videostore.StoredSegment{
+				{Path: "/storage/second.mp4", From: switched, To: end, Codec: videostore.CodecTypeH265},
+			}},
+		}
+		res, err := c.DoCommand(context.Background(), map[string]interface{}{"command": "stored-ranges"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res["ranges"], test.ShouldResemble, []interface{}{
+			map[string]interface{}{
+				"from": from.Format(videostore.TimeFormat), "to": switched.Format(videostore.TimeFormat), "codec": "h264",
+				"segments": []interface{}{map[string]interface{}{
+					"segment": "first.mp4", "from": from.Format(videostore.TimeFormat), "to": switched.Format(videostore.TimeFormat), "codec": "h264",
+				}},
+			},
+			map[string]interface{}{
+				"from": switched.Format(videostore.TimeFormat), "to": end.Format(videostore.TimeFormat), "codec": "h265",
+				"segments": []interface{}{map[string]interface{}{
+					"segment": "second.mp4", "from": switched.Format(videostore.TimeFormat), "to": end.Format(videostore.TimeFormat), "codec": "h265",
+				}},
+			},
+		})
+	})
+
+	t.Run("Single codec ranges have no clips", func(t *testing.T) {
+		vs.fetch.Clips, vs.save.Clips = nil, nil
+		res, err := c.DoCommand(context.Background(), command("fetch"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res, test.ShouldNotContainKey, "clips")
+		res, err = c.DoCommand(context.Background(), command("save"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res, test.ShouldNotContainKey, "clips")
+	})
+}
+
+func TestToFetchCommand(t *testing.T) {
+	from := time.Date(2024, 9, 6, 15, 0, 0, 0, time.Local)
+	command := map[string]interface{}{
+		"command": "fetch",
+		"from":    from.Format(videostore.TimeFormat),
+		"to":      from.Add(20 * time.Second).Format(videostore.TimeFormat),
+	}
+
+	t.Run("The active segment is excluded", func(t *testing.T) {
+		command["active_segment"] = "exclude"
+		req, err := ToFetchCommand(command)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, req.ActiveSegment, test.ShouldEqual, videostore.ActiveSegmentExclude)
+	})
+
+	t.Run("Snapshots of the frame encoder's segments are rejected", func(t *testing.T) {
+		command["active_segment"] = "snapshot"
+		_, err := ToFetchCommand(command)
+		test.That(t, err, test.ShouldBeError, "active_segment snapshot is only supported by RTP video stores with a flush policy")
+	})
+}
@@ -3,6 +3,7 @@ package camera
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/viam-modules/video-store/videostore"
 	"go.viam.com/rdk/components/camera"
@@ -14,14 +15,28 @@ type Storage struct {
 	SizeGB      int    `json:"size_gb"`
 	UploadPath  string `json:"upload_path,omitempty"`
 	StoragePath string `json:"storage_path,omitempty"`
+	LivePath    string `json:"live_path,omitempty"`
+	LiveSizeMB  int    `json:"live_size_mb,omitempty"`
+	// ArchivePath, if set, is where each complete day of segments is archived into one file.
+	ArchivePath            string `json:"archive_path,omitempty"`
+	ArchiveDeleteOriginals bool   `json:"archive_delete_originals,omitempty"`
+	// ExportInputPath is the directory exports may read files from by path.
+	ExportInputPath string `json:"export_input_path,omitempty"`
+	// Storage growth alert thresholds, 0 disables them.
+	AlertMaxBytesPerSecond float64 `json:"alert_max_bytes_per_second,omitempty"`
+	AlertMinHoursToFull    float64 `json:"alert_min_hours_to_full,omitempty"`
 }
 
 // Video is the config for storge.
 type Video struct {
-	Codec   string `json:"codec,omitempty"`
-	Bitrate int    `json:"bitrate,omitempty"`
-	Preset  string `json:"preset,omitempty"`
-	Format  string `json:"format,omitempty"`
+	Codec             string `json:"codec,omitempty"`
+	Bitrate           int    `json:"bitrate,omitempty"`
+	Preset            string `json:"preset,omitempty"`
+	Format            string `json:"format,omitempty"`
+	SceneCutThreshold int    `json:"scenecut_threshold,omitempty"`
+	Threads           int    `json:"threads,omitempty"`
+	HardwareAccel     string `json:"hardware_accel,omitempty"`
+	HardwareDevice    string `json:"hardware_device,omitempty"`
 }
 
 // Config is the configuration for the video storage camera component.
@@ -69,8 +84,12 @@ func applyVideoEncoderDefaults(c Video) videostore.EncoderConfig {
 		c.Preset = defaultVideoPreset
 	}
 	return videostore.EncoderConfig{
-		Bitrate: c.Bitrate,
-		Preset:  c.Preset,
+		Bitrate:           c.Bitrate,
+		Preset:            c.Preset,
+		SceneCutThreshold: c.SceneCutThreshold,
+		Threads:           c.Threads,
+		HardwareAccel:     c.HardwareAccel,
+		HardwareDevice:    c.HardwareDevice,
 	}
 }
 
@@ -95,6 +114,19 @@ func applyStorageDefaults(c Storage, name string) (videostore.StorageConfig, err
 		OutputFileNamePrefix: name,
 		UploadPath:           c.UploadPath,
 		StoragePath:          c.StoragePath,
+		Live: videostore.LiveStorageConfig{
+			Path:   c.LivePath,
+			SizeMB: c.LiveSizeMB,
+		},
+		Archive: videostore.ArchiveConfig{
+			Path:            c.ArchivePath,
+			DeleteOriginals: c.ArchiveDeleteOriginals,
+		},
+		ExportInputPath: c.ExportInputPath,
+		Alerts: videostore.GrowthAlertConfig{
+			MaxBytesPerSecond: c.AlertMaxBytesPerSecond,
+			MinTimeToFull:     time.Duration(c.AlertMinHoursToFull * float64(time.Hour)),
+		},
 	}, nil
 }
 
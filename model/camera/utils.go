@@ -1,8 +1,10 @@
 package camera
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
 	"os"
 	"time"
 
@@ -69,7 +71,754 @@ func ToFetchCommand(command map[string]interface{}) (*videostore.FetchRequest, e
 	if err != nil {
 		return nil, err
 	}
-	return &videostore.FetchRequest{From: from, To: to}, nil
+	req := &videostore.FetchRequest{From: from, To: to}
+	if policy, ok := command["active_segment"]; ok {
+		switch policy {
+		case "exclude":
+			req.ActiveSegment = videostore.ActiveSegmentExclude
+		case "snapshot":
+			// The module's segments are encoded from frames, which aren't fragmented, so
+			// nothing of the active segment can be read until it is complete.
+			return nil, errors.New("active_segment snapshot is only supported by RTP video stores with a flush policy")
+		default:
+			return nil, fmt.Errorf("active_segment %v must be exclude", policy)
+		}
+	}
+	return req, nil
+}
+
+// ToExportCommand converts a do command to a *videostore.ExportRequest.
+func ToExportCommand(command map[string]interface{}) (*videostore.ExportRequest, error) {
+	from, to, err := parseTimeRange(command)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := command["metadata"].(string)
+	if !ok {
+		metadata = ""
+	}
+	req := &videostore.ExportRequest{
+		From:     from,
+		To:       to,
+		Metadata: metadata,
+	}
+	if annotations, ok := command["annotations"]; ok {
+		list, ok := annotations.([]interface{})
+		if !ok {
+			return nil, errors.New("annotations must be a list")
+		}
+		for i, item := range list {
+			annotation, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("annotation %d must be an object", i)
+			}
+			from, to, err := parseTimeRange(annotation)
+			if err != nil {
+				return nil, fmt.Errorf("annotation %d: %s", i, err.Error())
+			}
+			text, ok := annotation["text"].(string)
+			if !ok {
+				return nil, fmt.Errorf("annotation %d text not found", i)
+			}
+			req.Annotations = append(req.Annotations, videostore.Annotation{From: from, To: to, Text: text})
+		}
+	}
+	if subtitles, ok := command["subtitles"]; ok {
+		s, ok := subtitles.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("subtitles must be an object")
+		}
+		req.Subtitles = &videostore.Subtitles{}
+		if srt, ok := s["srt"].(string); ok {
+			req.Subtitles.SRT = srt
+		}
+		if path, ok := s["path"].(string); ok {
+			req.Subtitles.Path = path
+		}
+		if startStr, ok := s["start"].(string); ok {
+			start, err := videostore.ParseDateTimeString(startStr)
+			if err != nil {
+				return nil, fmt.Errorf("subtitles start: %s", err.Error())
+			}
+			req.Subtitles.Start = start
+		}
+	}
+	if activity, ok := command["activity"]; ok {
+		a, ok := activity.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("activity must be an object")
+		}
+		req.Activity = &videostore.ActivityFilter{}
+		// Numbers arrive as float64 after passing through a protobuf struct.
+		if threshold, ok := a["threshold"]; ok {
+			if req.Activity.Threshold, ok = threshold.(float64); !ok {
+				return nil, errors.New("activity threshold must be a number")
+			}
+		}
+		if padding, ok := a["padding_seconds"]; ok {
+			seconds, ok := padding.(float64)
+			if !ok {
+				return nil, errors.New("activity padding_seconds must be a number")
+			}
+			req.Activity.Padding = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if detections, ok := command["detections"]; ok {
+		d, ok := detections.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("detections must be an object")
+		}
+		if req.Detections, err = parseDetections(d); err != nil {
+			return nil, err
+		}
+	}
+	if gapFill, ok := command["gap_fill"]; ok {
+		g, ok := gapFill.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("gap_fill must be an object")
+		}
+		req.GapFill = &videostore.GapFill{}
+		for key, field := range map[string]*string{
+			"fill":  &req.GapFill.Fill,
+			"label": &req.GapFill.Label,
+		} {
+			if v, ok := g[key]; ok {
+				if *field, ok = v.(string); !ok {
+					return nil, fmt.Errorf("gap_fill %s must be a string", key)
+				}
+			}
+		}
+		if minGap, ok := g["min_gap_seconds"]; ok {
+			seconds, ok := minGap.(float64)
+			if !ok {
+				return nil, errors.New("gap_fill min_gap_seconds must be a number")
+			}
+			req.GapFill.MinGap = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if _, ok := command["frame_rate"]; ok {
+		frameRate, err := parseInt(command, "frame_rate")
+		if err != nil {
+			return nil, err
+		}
+		req.FrameRate = frameRate
+	}
+	if crop, ok := command["crop"]; ok {
+		region, ok := crop.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("crop must be an object")
+		}
+		req.Crop = &videostore.CropRegion{}
+		for key, field := range map[string]*int{
+			"x":      &req.Crop.X,
+			"y":      &req.Crop.Y,
+			"width":  &req.Crop.Width,
+			"height": &req.Crop.Height,
+		} {
+			n, err := parseInt(region, key)
+			if err != nil {
+				return nil, fmt.Errorf("crop %s", err.Error())
+			}
+			*field = n
+		}
+	}
+	if pad, ok := command["pad"]; ok {
+		p, ok := pad.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("pad must be an object")
+		}
+		aspect, ok := p["aspect_ratio"].(string)
+		if !ok {
+			return nil, errors.New("pad aspect_ratio not found")
+		}
+		req.Pad = &videostore.Pad{}
+		if _, err := fmt.Sscanf(aspect, "%d:%d", &req.Pad.AspectWidth, &req.Pad.AspectHeight); err != nil {
+			return nil, fmt.Errorf("pad aspect_ratio %s must be of the form width:height", aspect)
+		}
+		if color, ok := p["color"].(string); ok {
+			req.Pad.Color = color
+		}
+	}
+	if smartCrop, ok := command["smart_crop"]; ok {
+		c, ok := smartCrop.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("smart_crop must be an object")
+		}
+		aspect, ok := c["aspect_ratio"].(string)
+		if !ok {
+			return nil, errors.New("smart_crop aspect_ratio not found")
+		}
+		req.SmartCrop = &videostore.SmartCrop{}
+		if _, err := fmt.Sscanf(aspect, "%d:%d", &req.SmartCrop.AspectWidth, &req.SmartCrop.AspectHeight); err != nil {
+			return nil, fmt.Errorf("smart_crop aspect_ratio %s must be of the form width:height", aspect)
+		}
+		if fallback, ok := c["fallback"]; ok {
+			f, ok := fallback.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("smart_crop fallback must be an object")
+			}
+			req.SmartCrop.Fallback = &image.Point{}
+			for key, field := range map[string]*int{
+				"x": &req.SmartCrop.Fallback.X,
+				"y": &req.SmartCrop.Fallback.Y,
+			} {
+				n, err := parseInt(f, key)
+				if err != nil {
+					return nil, fmt.Errorf("smart_crop fallback %s", err.Error())
+				}
+				*field = n
+			}
+		}
+	}
+	if enhance, ok := command["enhance"]; ok {
+		e, ok := enhance.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("enhance must be an object")
+		}
+		req.Enhance = &videostore.Enhance{}
+		if denoise, ok := e["denoise"]; ok {
+			if req.Enhance.Denoise, ok = denoise.(string); !ok {
+				return nil, errors.New("enhance denoise must be a string")
+			}
+		}
+		for key, field := range map[string]*float64{
+			"denoise_strength": &req.Enhance.DenoiseStrength,
+			"sharpen":          &req.Enhance.Sharpen,
+		} {
+			if v, ok := e[key]; ok {
+				if *field, ok = v.(float64); !ok {
+					return nil, fmt.Errorf("enhance %s must be a number", key)
+				}
+			}
+		}
+	}
+	if watermark, ok := command["watermark"]; ok {
+		w, ok := watermark.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("watermark must be an object")
+		}
+		req.Watermark = &videostore.Watermark{}
+		if req.Watermark.ImagePath, ok = w["image_path"].(string); !ok {
+			return nil, errors.New("watermark image_path not found")
+		}
+		if position, ok := w["position"]; ok {
+			if req.Watermark.Position, ok = position.(string); !ok {
+				return nil, errors.New("watermark position must be a string")
+			}
+		}
+		for key, field := range map[string]*float64{
+			"opacity": &req.Watermark.Opacity,
+			"scale":   &req.Watermark.Scale,
+		} {
+			if v, ok := w[key]; ok {
+				if *field, ok = v.(float64); !ok {
+					return nil, fmt.Errorf("watermark %s must be a number", key)
+				}
+			}
+		}
+		if timestamp, ok := w["timestamp"]; ok {
+			if req.Watermark.Timestamp, ok = timestamp.(bool); !ok {
+				return nil, errors.New("watermark timestamp must be a boolean")
+			}
+		}
+	}
+	if ramps, ok := command["speed_ramps"]; ok {
+		list, ok := ramps.([]interface{})
+		if !ok {
+			return nil, errors.New("speed_ramps must be a list")
+		}
+		for i, item := range list {
+			ramp, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("speed ramp %d must be an object", i)
+			}
+			from, to, err := parseTimeRange(ramp)
+			if err != nil {
+				return nil, fmt.Errorf("speed ramp %d: %s", i, err.Error())
+			}
+			speed, ok := ramp["speed"].(float64)
+			if !ok {
+				return nil, fmt.Errorf("speed ramp %d speed must be a number", i)
+			}
+			req.SpeedRamps = append(req.SpeedRamps, videostore.SpeedRamp{From: from, To: to, Speed: speed})
+		}
+	}
+	if boomerang, ok := command["boomerang"]; ok {
+		if req.Boomerang, ok = boomerang.(bool); !ok {
+			return nil, errors.New("boomerang must be a boolean")
+		}
+	}
+	if poster, ok := command["poster"]; ok {
+		posterStr, ok := poster.(string)
+		if !ok {
+			return nil, errors.New("poster must be a timestamp")
+		}
+		posterTime, err := videostore.ParseDateTimeString(posterStr)
+		if err != nil {
+			return nil, err
+		}
+		req.Poster = &posterTime
+	}
+	if orient, ok := command["orient"]; ok {
+		if req.Orient, ok = orient.(bool); !ok {
+			return nil, errors.New("orient must be a boolean")
+		}
+	}
+	if _, ok := command["rotation"]; ok {
+		rotation, err := parseInt(command, "rotation")
+		if err != nil {
+			return nil, err
+		}
+		req.Rotation = &rotation
+	}
+	if hls, ok := command["hls"]; ok {
+		h, ok := hls.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("hls must be an object")
+		}
+		req.HLS = &videostore.HLSOutput{}
+		if _, ok := h["segment_seconds"]; ok {
+			if req.HLS.SegmentSeconds, err = parseInt(h, "segment_seconds"); err != nil {
+				return nil, fmt.Errorf("hls %s", err.Error())
+			}
+		}
+		if v, ok := h["key_uri"]; ok {
+			if req.HLS.KeyURI, ok = v.(string); !ok {
+				return nil, errors.New("hls key_uri must be a string")
+			}
+		}
+	}
+	if telemetry, ok := command["telemetry"]; ok {
+		t, ok := telemetry.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("telemetry must be an object")
+		}
+		if req.Telemetry, err = parseTelemetry(t); err != nil {
+			return nil, err
+		}
+	}
+	if audio, ok := command["audio"]; ok {
+		a, ok := audio.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("audio must be an object")
+		}
+		req.Audio = &videostore.ExportAudio{}
+		if offset, ok := a["offset_ms"]; ok {
+			ms, ok := offset.(float64)
+			if !ok {
+				return nil, errors.New("audio offset_ms must be a number")
+			}
+			req.Audio.Offset = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	for key, field := range map[string]**videostore.Bumper{
+		"intro": &req.Intro,
+		"outro": &req.Outro,
+	} {
+		if bumper, ok := command[key]; ok {
+			b, ok := bumper.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be an object", key)
+			}
+			if *field, err = parseBumper(key, b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return req, nil
+}
+
+// parseBumper converts the intro or outro object of an export command, named key, to a
+// *videostore.Bumper.
+func parseBumper(key string, b map[string]interface{}) (*videostore.Bumper, error) {
+	bumper := &videostore.Bumper{}
+	for name, field := range map[string]*string{
+		"path":  &bumper.Path,
+		"text":  &bumper.Text,
+		"color": &bumper.Color,
+	} {
+		if v, ok := b[name]; ok {
+			if *field, ok = v.(string); !ok {
+				return nil, fmt.Errorf("%s %s must be a string", key, name)
+			}
+		}
+	}
+	if duration, ok := b["duration_seconds"]; ok {
+		seconds, ok := duration.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s duration_seconds must be a number", key)
+		}
+		bumper.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return bumper, nil
+}
+
+// parseTelemetry converts the telemetry object of an export command to a *videostore.Telemetry.
+func parseTelemetry(t map[string]interface{}) (*videostore.Telemetry, error) {
+	telemetry := &videostore.Telemetry{}
+	fields, ok := t["fields"].([]interface{})
+	if !ok {
+		return nil, errors.New("telemetry fields must be a list")
+	}
+	for i, item := range fields {
+		f, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("telemetry field %d must be an object", i)
+		}
+		field := videostore.TelemetryField{}
+		if field.Name, ok = f["name"].(string); !ok {
+			return nil, fmt.Errorf("telemetry field %d name not found", i)
+		}
+		for key, value := range map[string]*string{"label": &field.Label, "unit": &field.Unit} {
+			if v, ok := f[key]; ok {
+				if *value, ok = v.(string); !ok {
+					return nil, fmt.Errorf("telemetry field %d %s must be a string", i, key)
+				}
+			}
+		}
+		if _, ok := f["decimals"]; ok {
+			decimals, err := parseInt(f, "decimals")
+			if err != nil {
+				return nil, fmt.Errorf("telemetry field %d %s", i, err.Error())
+			}
+			field.Decimals = decimals
+		}
+		telemetry.Fields = append(telemetry.Fields, field)
+	}
+	if samples, ok := t["samples"]; ok {
+		list, ok := samples.([]interface{})
+		if !ok {
+			return nil, errors.New("telemetry samples must be a list")
+		}
+		for i, item := range list {
+			s, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("telemetry sample %d must be an object", i)
+			}
+			timeStr, ok := s["time"].(string)
+			if !ok {
+				return nil, fmt.Errorf("telemetry sample %d time not found", i)
+			}
+			sampleTime, err := videostore.ParseDateTimeString(timeStr)
+			if err != nil {
+				return nil, fmt.Errorf("telemetry sample %d: %s", i, err.Error())
+			}
+			values, ok := s["values"].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("telemetry sample %d values must be an object", i)
+			}
+			sample := videostore.TelemetrySample{Time: sampleTime, Values: map[string]float64{}}
+			for name, v := range values {
+				if sample.Values[name], ok = v.(float64); !ok {
+					return nil, fmt.Errorf("telemetry sample %d %s must be a number", i, name)
+				}
+			}
+			telemetry.Samples = append(telemetry.Samples, sample)
+		}
+	}
+	if path, ok := t["path"]; ok {
+		if telemetry.Path, ok = path.(string); !ok {
+			return nil, errors.New("telemetry path must be a string")
+		}
+	}
+	if maxGap, ok := t["max_gap_seconds"]; ok {
+		seconds, ok := maxGap.(float64)
+		if !ok {
+			return nil, errors.New("telemetry max_gap_seconds must be a number")
+		}
+		telemetry.MaxGap = time.Duration(seconds * float64(time.Second))
+	}
+	if timestamp, ok := t["timestamp"]; ok {
+		if telemetry.Timestamp, ok = timestamp.(bool); !ok {
+			return nil, errors.New("telemetry timestamp must be a boolean")
+		}
+	}
+	return telemetry, nil
+}
+
+// parseDetections converts the detections object of an export command to a *videostore.Detections.
+func parseDetections(d map[string]interface{}) (*videostore.Detections, error) {
+	detections := &videostore.Detections{}
+	if samples, ok := d["samples"]; ok {
+		list, ok := samples.([]interface{})
+		if !ok {
+			return nil, errors.New("detections samples must be a list")
+		}
+		for i, item := range list {
+			s, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("detections sample %d must be an object", i)
+			}
+			timeStr, ok := s["time"].(string)
+			if !ok {
+				return nil, fmt.Errorf("detections sample %d time not found", i)
+			}
+			sampleTime, err := videostore.ParseDateTimeString(timeStr)
+			if err != nil {
+				return nil, fmt.Errorf("detections sample %d: %s", i, err.Error())
+			}
+			sample := videostore.DetectionSample{Time: sampleTime}
+			found, ok := s["detections"].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("detections sample %d detections must be a list", i)
+			}
+			for j, item := range found {
+				o, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("detections sample %d detection %d must be an object", i, j)
+				}
+				detection := videostore.Detection{}
+				if detection.Label, ok = o["label"].(string); !ok {
+					return nil, fmt.Errorf("detections sample %d detection %d label not found", i, j)
+				}
+				if confidence, ok := o["confidence"]; ok {
+					if detection.Confidence, ok = confidence.(float64); !ok {
+						return nil, fmt.Errorf("detections sample %d detection %d confidence must be a number", i, j)
+					}
+				}
+				for key, field := range map[string]*int{
+					"x_min": &detection.XMin,
+					"y_min": &detection.YMin,
+					"x_max": &detection.XMax,
+					"y_max": &detection.YMax,
+				} {
+					n, err := parseInt(o, key)
+					if err != nil {
+						return nil, fmt.Errorf("detections sample %d detection %d %s", i, j, err.Error())
+					}
+					*field = n
+				}
+				sample.Detections = append(sample.Detections, detection)
+			}
+			detections.Samples = append(detections.Samples, sample)
+		}
+	}
+	if path, ok := d["path"]; ok {
+		if detections.Path, ok = path.(string); !ok {
+			return nil, errors.New("detections path must be a string")
+		}
+	}
+	if labels, ok := d["labels"]; ok {
+		list, ok := labels.([]interface{})
+		if !ok {
+			return nil, errors.New("detections labels must be a list")
+		}
+		for i, item := range list {
+			label, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("detections label %d must be a string", i)
+			}
+			detections.Labels = append(detections.Labels, label)
+		}
+	}
+	if confidence, ok := d["min_confidence"]; ok {
+		if detections.MinConfidence, ok = confidence.(float64); !ok {
+			return nil, errors.New("detections min_confidence must be a number")
+		}
+	}
+	if zone, ok := d["zone"]; ok {
+		region, ok := zone.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("detections zone must be an object")
+		}
+		detections.Zone = &videostore.CropRegion{}
+		for key, field := range map[string]*int{
+			"x":      &detections.Zone.X,
+			"y":      &detections.Zone.Y,
+			"width":  &detections.Zone.Width,
+			"height": &detections.Zone.Height,
+		} {
+			n, err := parseInt(region, key)
+			if err != nil {
+				return nil, fmt.Errorf("detections zone %s", err.Error())
+			}
+			*field = n
+		}
+	}
+	for key, field := range map[string]*time.Duration{
+		"padding_seconds": &detections.Padding,
+		"hold_seconds":    &detections.Hold,
+	} {
+		if v, ok := d[key]; ok {
+			seconds, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("detections %s must be a number", key)
+			}
+			*field = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	if crop, ok := d["crop"]; ok {
+		if detections.Crop, ok = crop.(bool); !ok {
+			return nil, errors.New("detections crop must be a boolean")
+		}
+	}
+	return detections, nil
+}
+
+// parseInt returns the integer at key in command.
+func parseInt(command map[string]interface{}, key string) (int, error) {
+	v, ok := command[key]
+	if !ok {
+		return 0, fmt.Errorf("%s not found", key)
+	}
+	// Numbers arrive as float64 after passing through a protobuf struct.
+	n, ok := v.(float64)
+	if !ok || n != float64(int(n)) {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+	return int(n), nil
+}
+
+// ToExportSpritesCommand converts an export-sprites do command to a *videostore.SpriteSheetRequest.
+func ToExportSpritesCommand(command map[string]interface{}) (*videostore.SpriteSheetRequest, error) {
+	req := &videostore.SpriteSheetRequest{}
+	if segment, ok := command["segment"].(string); ok {
+		req.Segment = segment
+	} else {
+		from, to, err := parseTimeRange(command)
+		if err != nil {
+			return nil, err
+		}
+		req.From = from
+		req.To = to
+	}
+	if metadata, ok := command["metadata"].(string); ok {
+		req.Metadata = metadata
+	}
+	// Numbers arrive as float64 after passing through a protobuf struct.
+	if interval, ok := command["interval_seconds"]; ok {
+		seconds, ok := interval.(float64)
+		if !ok || seconds <= 0 {
+			return nil, errors.New("interval_seconds must be a positive number")
+		}
+		req.Interval = time.Duration(seconds * float64(time.Second))
+	}
+	for key, field := range map[string]*int{
+		"thumbnail_width": &req.ThumbnailWidth,
+		"columns":         &req.Columns,
+	} {
+		v, ok := command[key]
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok || n <= 0 || n != float64(int(n)) {
+			return nil, fmt.Errorf("%s must be a positive integer", key)
+		}
+		*field = int(n)
+	}
+	return req, nil
+}
+
+// ToExportFilmstripCommand converts an export-filmstrip do command to a *videostore.FilmstripRequest.
+func ToExportFilmstripCommand(command map[string]interface{}) (*videostore.FilmstripRequest, error) {
+	from, to, err := parseTimeRange(command)
+	if err != nil {
+		return nil, err
+	}
+	req := &videostore.FilmstripRequest{From: from, To: to}
+	if metadata, ok := command["metadata"].(string); ok {
+		req.Metadata = metadata
+	}
+	// Numbers arrive as float64 after passing through a protobuf struct.
+	if interval, ok := command["interval_seconds"]; ok {
+		seconds, ok := interval.(float64)
+		if !ok || seconds <= 0 {
+			return nil, errors.New("interval_seconds must be a positive number")
+		}
+		req.Interval = time.Duration(seconds * float64(time.Second))
+	}
+	for key, field := range map[string]*int{
+		"thumbnail_height": &req.ThumbnailHeight,
+		"max_width":        &req.MaxWidth,
+	} {
+		v, ok := command[key]
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok || n <= 0 || n != float64(int(n)) {
+			return nil, fmt.Errorf("%s must be a positive integer", key)
+		}
+		*field = int(n)
+	}
+	return req, nil
+}
+
+// ToFindDuplicatesCommand converts a find-duplicates do command to the max hamming
+// distance between segment hashes considered duplicates.
+func ToFindDuplicatesCommand(command map[string]interface{}) (int, error) {
+	maxDistance, ok := command["max_distance"]
+	if !ok {
+		return videostore.DefaultMaxDuplicateDistance, nil
+	}
+	// Numbers arrive as float64 after passing through a protobuf struct.
+	d, ok := maxDistance.(float64)
+	if !ok || d != float64(int(d)) {
+		return 0, errors.New("max_distance must be an integer")
+	}
+	return int(d), nil
+}
+
+// ToValidateCommand converts a validate do command into the component attributes it should validate.
+func ToValidateCommand(command map[string]interface{}) (*Config, error) {
+	attributes, ok := command["attributes"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("attributes not found")
+	}
+	b, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ValidateAttributes returns every problem with cfg that would stop a video-store
+// component named name from recording. It has no side effects.
+func ValidateAttributes(cfg *Config, name string) ([]videostore.ConfigProblem, error) {
+	vsConfig, err := ToFrameVideoStoreVideoConfig(cfg, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Camera == "" {
+		vsConfig.Type = videostore.SourceTypeReadOnly
+	}
+
+	var problems []videostore.ConfigProblem
+	for _, p := range videostore.ValidateConfig(vsConfig) {
+		// The source camera is resolved from the component's dependencies
+		// when it is constructed, so it is never set at this point.
+		if p.Field == "camera" {
+			continue
+		}
+		problems = append(problems, p)
+	}
+
+	format := cfg.Video.Format
+	if format == "" {
+		format = defaultVideoFormat
+	}
+	codec := videostore.CodecTypeH264
+	switch cfg.Video.Codec {
+	case "", "h264":
+	case "h265":
+		codec = videostore.CodecTypeH265
+	default:
+		codec = videostore.CodecTypeUnknown
+	}
+	if err := videostore.ValidateCodecContainer(codec, format); err != nil {
+		problems = append(problems, videostore.ConfigProblem{Field: "video", Message: err.Error()})
+	} else if cfg.Camera != "" && codec != videostore.CodecTypeH264 {
+		problems = append(problems, videostore.ConfigProblem{
+			Field:   "video",
+			Message: fmt.Sprintf("codec %s is not supported when encoding frames from a camera", cfg.Video.Codec),
+		})
+	}
+	return problems, nil
 }
 
 func checkDeps(deps resource.Dependencies, config *Config, logger logging.Logger) error {
@@ -95,3 +844,24 @@ func checkDeps(deps resource.Dependencies, config *Config, logger logging.Logger
 
 	return nil
 }
+
+// stringList converts values to a list which can be returned from DoCommand.
+func stringList(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		list = append(list, v)
+	}
+	return list
+}
+
+// codecName returns the name of codec as in the video config, or "unknown".
+func codecName(codec videostore.CodecType) string {
+	switch codec {
+	case videostore.CodecTypeH264:
+		return "h264"
+	case videostore.CodecTypeH265:
+		return "h265"
+	default:
+		return "unknown"
+	}
+}
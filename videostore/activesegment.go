@@ -0,0 +1,65 @@
+package videostore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// snapshotSegment copies the part of the active segment at path which has been flushed
+// to disk, up to its last complete fragment, into a file of the same name in dir.
+// The copy is a finalized fragmented mp4 which can be concatenated like any other segment.
+// Returns the path of the copy.
+func snapshotSegment(path, dir string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	n := flushedLength(b)
+	if n == 0 {
+		return "", fmt.Errorf("%w: nothing of %s has been flushed to disk", ErrActiveSegment, filepath.Base(path))
+	}
+	snapshot := filepath.Join(dir, filepath.Base(path))
+	if err := os.WriteFile(snapshot, b[:n], 0o600); err != nil {
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// flushedLength returns the length of the prefix of the fragmented mp4 b which ends
+// after its last complete fragment, a moof box followed by its mdat box.
+// Returns 0 if b has no moov box or no complete fragment, e.g. if it isn't fragmented.
+func flushedLength(b []byte) int {
+	var end, offset int
+	var moov, moof bool
+	for offset+8 <= len(b) {
+		size := uint64(binary.BigEndian.Uint32(b[offset:]))
+		header := uint64(8)
+		if size == 1 {
+			if offset+16 > len(b) {
+				break
+			}
+			size = binary.BigEndian.Uint64(b[offset+8:])
+			header = 16
+		}
+		// A size of 0 extends to the end of the file, which is still growing.
+		if size < header || size > uint64(len(b)-offset) {
+			break
+		}
+		typ := string(b[offset+4 : offset+8])
+		offset += int(size)
+		switch typ {
+		case "moov":
+			moov = true
+		case "moof":
+			moof = true
+		case "mdat":
+			if moov && moof {
+				end = offset
+			}
+			moof = false
+		}
+	}
+	return end
+}
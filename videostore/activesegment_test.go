@@ -0,0 +1,156 @@
+package videostore
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/logging"
+	rutils "go.viam.com/rdk/utils"
+	"go.viam.com/test"
+)
+
+// mp4Box returns an mp4 box of type typ containing payload.
+func mp4Box(typ string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, typ...), payload...)
+}
+
+func TestFlushedLength(t *testing.T) {
+	header := append(mp4Box("ftyp", []byte("isom")), mp4Box("moov", nil)...)
+	fragment := append(mp4Box("moof", []byte{1, 2}), mp4Box("mdat", []byte{3, 4, 5})...)
+	complete := append(append(append([]byte{}, header...), fragment...), fragment...)
+
+	t.Run("Every complete fragment is kept", func(t *testing.T) {
+		test.That(t, flushedLength(complete), test.ShouldEqual, len(complete))
+	})
+
+	t.Run("A partially written fragment is left out", func(t *testing.T) {
+		test.That(t, flushedLength(complete[:len(complete)-2]), test.ShouldEqual, len(header)+len(fragment))
+		test.That(t, flushedLength(complete[:len(complete)-len(fragment)+4]), test.ShouldEqual, len(header)+len(fragment))
+	})
+
+	t.Run("Unfragmented video has no flushed fragments", func(t *testing.T) {
+		test.That(t, flushedLength(header), test.ShouldEqual, 0)
+		unfragmented := append(mp4Box("ftyp", []byte("isom")), mp4Box("mdat", []byte{3, 4, 5})...)
+		test.That(t, flushedLength(unfragmented), test.ShouldEqual, 0)
+	})
+}
+
+// frameCamera is a camera whose every image is frame.
+type frameCamera struct {
+	camera.Camera
+	frame []byte
+}
+
+func (c *frameCamera) Image(context.Context, string, map[string]interface{}) ([]byte, camera.ImageMetadata, error) {
+	return c.frame, camera.ImageMetadata{MimeType: rutils.MimeTypeJPEG}, nil
+}
+
+func TestFetchActiveSegment(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	var frames [][]byte
+	for i := range 6 * framerate {
+		frames = append(frames, patternJPEG(t, i/5%2))
+	}
+	packets := h264Packets(t, frames, framerate)
+
+	// newActiveStore writes every packet to a segment which is left open, as the segment
+	// being written, and returns a read only store over it along with the segment's start.
+	newActiveStore := func(t *testing.T, flush FlushPolicy) (VideoStore, time.Time) {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeReadOnly
+		storagePath := config.Storage.StoragePath
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{flush: flush}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		t.Cleanup(func() { test.That(t, rs.Close(), test.ShouldBeNil) })
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+		// Backdate the segment so its video, written all at once, is in the past.
+		start := time.Now().Add(-10 * time.Second).Truncate(time.Second)
+		active := filepath.Join(storagePath, strconv.FormatInt(start.Unix(), 10)+".mp4")
+		test.That(t, os.Rename(segments[0], active), test.ShouldBeNil)
+
+		vs, err := NewReadOnlyVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		t.Cleanup(vs.Close)
+		return vs, start
+	}
+
+	t.Run("Fetch ending now includes the flushed part of the active segment", func(t *testing.T) {
+		vs, start := newActiveStore(t, FlushPolicy{Packets: 1})
+		res, err := vs.Fetch(context.Background(), &FetchRequest{
+			From:          start,
+			To:            time.Now(),
+			ActiveSegment: ActiveSegmentSnapshot,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		fetched := filepath.Join(t.TempDir(), "fetched.mp4")
+		test.That(t, os.WriteFile(fetched, res.Video, 0o600), test.ShouldBeNil)
+		info, err := getVideoInfo(fetched)
+		test.That(t, err, test.ShouldBeNil)
+		// Only the last packets, which haven't been flushed, are missing.
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 6, 0.3)
+	})
+
+	t.Run("Active segment is excluded by default", func(t *testing.T) {
+		vs, start := newActiveStore(t, FlushPolicy{Packets: 1})
+		_, err := vs.Fetch(context.Background(), &FetchRequest{From: start, To: time.Now()})
+		test.That(t, err, test.ShouldWrap, ErrActiveSegment)
+	})
+
+	t.Run("Unflushed active segment is reported as unavailable", func(t *testing.T) {
+		vs, start := newActiveStore(t, FlushPolicy{})
+		_, err := vs.Fetch(context.Background(), &FetchRequest{
+			From:          start,
+			To:            time.Now(),
+			ActiveSegment: ActiveSegmentSnapshot,
+		})
+		test.That(t, err, test.ShouldWrap, ErrActiveSegment)
+	})
+	t.Run("Frame polling stores reject snapshots", func(t *testing.T) {
+		cam := &frameCamera{frame: frames[0]}
+		config := validRTPConfig(t)
+		config.Type = SourceTypeFrame
+		config.Encoder = EncoderConfig{Bitrate: 1000000, Preset: "ultrafast"}
+		config.FramePoller = FramePollerConfig{Framerate: framerate, Camera: cam}
+		vs, err := NewFramePollingVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		defer vs.Close()
+
+		// Wait for the encoder to write to the segment it is recording.
+		var files []fileWithDate
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			files, err = getSortedFiles(config.Storage.StoragePath)
+			test.That(t, err, test.ShouldBeNil)
+			if len(files) > 0 {
+				if info, err := os.Stat(files[0].name); err == nil && info.Size() > 0 {
+					break
+				}
+			}
+		}
+		test.That(t, len(files), test.ShouldEqual, 1)
+		// The encoder's segments aren't fragmented, so nothing of the active one is readable.
+		b, err := os.ReadFile(files[0].name)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, flushedLength(b), test.ShouldEqual, 0)
+		_, err = vs.Fetch(context.Background(), &FetchRequest{
+			From:          files[0].startTime,
+			To:            time.Now(),
+			ActiveSegment: ActiveSegmentSnapshot,
+		})
+		test.That(t, err, test.ShouldWrap, ErrActiveSegment)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "segments encoded from frames can't be snapshotted")
+	})
+}
@@ -0,0 +1,121 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultActivityThreshold is the mean luma difference between samples, out of 255,
+	// above which the video is considered active.
+	DefaultActivityThreshold = 6.0
+	// defaultActivitySampleInterval is the time between the frames compared to detect activity.
+	defaultActivitySampleInterval = 500 * time.Millisecond
+	// activityFrameWidth and activityFrameHeight are the size frames are scaled down to before
+	// being compared, which also smooths out sensor noise.
+	activityFrameWidth  = 64
+	activityFrameHeight = 48
+)
+
+// ActivityFilter trims an export down to the parts of the range where there is motion.
+type ActivityFilter struct {
+	// Threshold is the mean luma difference, out of 255, between frames SampleInterval
+	// apart above which there is activity. 0 uses DefaultActivityThreshold.
+	Threshold float64
+	// Padding is how much video to keep before and after each active interval.
+	Padding time.Duration
+	// SampleInterval is the time between the frames compared. 0 uses 500ms.
+	SampleInterval time.Duration
+}
+
+// Validate returns an error if the ActivityFilter is invalid.
+func (f *ActivityFilter) Validate() error {
+	if f.Threshold < 0 || f.Threshold > 255 {
+		return errors.New("activity threshold must be between 0 and 255")
+	}
+	if f.Padding < 0 {
+		return errors.New("activity padding can't be less than 0")
+	}
+	if f.SampleInterval < 0 {
+		return errors.New("activity sample interval can't be less than 0")
+	}
+	return nil
+}
+
+// frameDiff returns the mean absolute difference between two gray frames of the same size.
+func frameDiff(a, b []byte) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var sum int
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return float64(sum) / float64(len(a))
+}
+
+// detectActivity returns the sorted, non-overlapping ranges between from and to where
+// consecutive samples of the video in files differ by more than f's threshold, widened
+// by f's padding.
+func detectActivity(ctx context.Context, files []fileWithDate, from, to time.Time, f ActivityFilter) ([]timeRange, error) {
+	threshold := f.Threshold
+	if threshold == 0 {
+		threshold = DefaultActivityThreshold
+	}
+	interval := f.SampleInterval
+	if interval == 0 {
+		interval = defaultActivitySampleInterval
+	}
+
+	var (
+		active   []timeRange
+		previous []byte
+		infos    = videoInfoCache{}
+	)
+	for t := from; t.Before(to); t = t.Add(interval) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		segment, offset, _, ok := infos.segmentAt(files, t)
+		if !ok {
+			// Don't compare across gaps in the video.
+			previous = nil
+			continue
+		}
+		gray, err := grayFrameAt(segment, offset, activityFrameWidth, activityFrameHeight)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil && frameDiff(previous, gray) > threshold {
+			r := timeRange{from: t.Add(-interval - f.Padding), to: t.Add(f.Padding)}
+			r.from = maxTime(r.from, from)
+			r.to = minTime(r.to, to)
+			if n := len(active); n > 0 && !r.from.After(active[n-1].to) {
+				active[n-1].to = r.to
+			} else {
+				active = append(active, r)
+			}
+		}
+		previous = gray
+	}
+	return active, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
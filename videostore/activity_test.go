@@ -0,0 +1,64 @@
+package videostore
+
+import (
+	"context"
+	"image/color"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestFrameDiff(t *testing.T) {
+	test.That(t, frameDiff([]byte{0, 10, 20}, []byte{0, 10, 20}), test.ShouldEqual, 0)
+	test.That(t, frameDiff([]byte{0, 10, 20}, []byte{30, 0, 20}), test.ShouldEqual, 40.0/3)
+}
+
+func TestExportActivity(t *testing.T) {
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	white := solidJPEG(t, color.White)
+	// The video is static apart from flickering between 4s and 6s.
+	frames := make([][]byte, 12*framerate)
+	for i := range frames {
+		frames[i] = black
+		if i >= 4*framerate && i < 6*framerate && i%2 == 1 {
+			frames[i] = white
+		}
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	exportDuration := func(t *testing.T, metadata string, activity *ActivityFilter) time.Duration {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(12 * time.Second),
+			Metadata: metadata,
+			Activity: activity,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		return info.duration
+	}
+
+	t.Run("Static sections are excluded", func(t *testing.T) {
+		d := exportDuration(t, "active", &ActivityFilter{})
+		test.That(t, d.Seconds(), test.ShouldAlmostEqual, 2, 0.25)
+	})
+
+	t.Run("Padding is kept around active intervals", func(t *testing.T) {
+		d := exportDuration(t, "padded", &ActivityFilter{Padding: time.Second})
+		test.That(t, d.Seconds(), test.ShouldAlmostEqual, 4, 0.25)
+	})
+
+	t.Run("Range without activity is rejected", func(t *testing.T) {
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start.Add(7 * time.Second),
+			To:       start.Add(11 * time.Second),
+			Activity: &ActivityFilter{},
+		})
+		test.That(t, err, test.ShouldBeError, "no activity found in time range")
+	})
+}
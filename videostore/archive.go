@@ -0,0 +1,226 @@
+package videostore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// archiveInterval is how often complete days are looked for to archive.
+	archiveInterval = 10 * time.Minute
+	// archiveIndexExt is appended to an archive for its index, which is written once the
+	// archive is complete.
+	archiveIndexExt = ".index.json"
+)
+
+// archiveIndex is where each segment archived in the archive file it sits next to is.
+// Gaps between segments are not in the archive so it can't be seeked by wall clock time
+// without it.
+type archiveIndex struct {
+	Segments []archivedSegment `json:"segments"`
+}
+
+type archivedSegment struct {
+	Start    time.Time     `json:"start"`
+	Offset   time.Duration `json:"offset"`
+	Duration time.Duration `json:"duration"`
+}
+
+// archiver is a go routine that archives each complete day of segments.
+func (vs *videostore) archiver(ctx context.Context) {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := archiveDays(vs.config.Storage, vs.logger); err != nil {
+				vs.logger.Error("failed to archive segments", err)
+			}
+		}
+	}
+}
+
+// archiveDays stream copies the segments of each complete day in the storage path which
+// isn't archived yet into an archive named by the start of the day, in local time.
+// A day is complete once a segment starting on a later day is in the storage path, as
+// the last segment of the day is closed by then. Segments belong to the day they start on.
+func archiveDays(config StorageConfig, logger logging.Logger) error {
+	files, err := getSortedFiles(config.StoragePath)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	newestDay := dayOf(files[len(files)-1].startTime)
+	start := 0
+	for i := range files {
+		day := dayOf(files[i].startTime)
+		if !day.Before(newestDay) {
+			break
+		}
+		if dayOf(files[i+1].startTime).Equal(day) {
+			continue
+		}
+		if err := archiveDay(config.Archive, day, files[start:i+1], logger); err != nil {
+			return err
+		}
+		start = i + 1
+	}
+	return nil
+}
+
+// archiveDay archives the segments of day unless it is already archived. Segments which
+// can't be read or don't match the video parameters of the first are left in storage.
+func archiveDay(config ArchiveConfig, day time.Time, segments []fileWithDate, logger logging.Logger) error {
+	archivePath := filepath.Join(config.Path, day.Format(TimeFormat)+".mp4")
+	if _, err := os.Stat(archivePath + archiveIndexExt); err == nil {
+		return nil
+	}
+
+	var entries []concatFileEntry
+	var index archiveIndex
+	var first videoInfo
+	var offset time.Duration
+	for _, s := range segments {
+		info, err := getVideoInfo(s.name)
+		if err != nil {
+			logger.Warnf("not archiving segment %s, failed to get video info: %v", s.name, err)
+			continue
+		}
+		cacheFirstVid(&first, info)
+		if first.width != info.width || first.height != info.height || first.codec != info.codec {
+			logger.Warnf("not archiving segment %s, expected (width=%d, height=%d, codec=%s), got (width=%d, height=%d, codec=%s)",
+				s.name, first.width, first.height, first.codec, info.width, info.height, info.codec)
+			continue
+		}
+		entries = append(entries, concatFileEntry{filePath: s.name})
+		index.Segments = append(index.Segments, archivedSegment{Start: s.startTime, Offset: offset, Duration: info.duration})
+		offset += info.duration
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	concatFilePath := generateConcatFilePath()
+	defer os.Remove(concatFilePath)
+	if err := writeConcatFileEntries(entries, concatFilePath); err != nil {
+		return err
+	}
+	// The archive is written under a temporary name so a partial archive is never read. The
+	// extension is kept as the muxer is picked from it.
+	tmpPath := strings.TrimSuffix(archivePath, ".mp4") + tmpExt + ".mp4"
+	if err := concat(concatFilePath, tmpPath); err != nil {
+		return errors.Join(fmt.Errorf("failed to archive %s: %w", day.Format(time.DateOnly), err), removeIfExists(tmpPath))
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		return err
+	}
+	if err := writeArchiveIndex(archivePath, index); err != nil {
+		return err
+	}
+	logger.Infof("archived %d segments from %s to %s", len(entries), day.Format(time.DateOnly), archivePath)
+
+	if !config.DeleteOriginals {
+		return nil
+	}
+	for _, e := range entries {
+		if err := os.Remove(e.filePath); err != nil {
+			return err
+		}
+		if err := removeSidecar(e.filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArchiveIndex writes the index of the archive at archivePath.
+func writeArchiveIndex(archivePath string, index archiveIndex) error {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmpPath := archivePath + archiveIndexExt + tmpExt
+	if err := os.WriteFile(tmpPath, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, archivePath+archiveIndexExt)
+}
+
+// archivedFiles returns every segment in the archives at path with an index, in the
+// archive it is in.
+func archivedFiles(path string) ([]fileWithDate, error) {
+	indexes, err := filepath.Glob(filepath.Join(path, "*.mp4"+archiveIndexExt))
+	if err != nil {
+		return nil, err
+	}
+	var files []fileWithDate
+	for _, indexPath := range indexes {
+		b, err := os.ReadFile(indexPath)
+		if err != nil {
+			return nil, err
+		}
+		var index archiveIndex
+		if err := json.Unmarshal(b, &index); err != nil {
+			return nil, fmt.Errorf("invalid archive index %s: %w", indexPath, err)
+		}
+		archivePath := strings.TrimSuffix(indexPath, archiveIndexExt)
+		for _, s := range index.Segments {
+			files = append(files, fileWithDate{
+				name:      archivePath,
+				startTime: s.Start.UTC(),
+				offset:    s.Offset,
+				duration:  s.Duration,
+			})
+		}
+	}
+	return files, nil
+}
+
+// mergeArchivedFiles returns the storage files and the archived segments which aren't also
+// in storage, because originals weren't deleted, sorted by start time.
+func mergeArchivedFiles(storageFiles, archived []fileWithDate) []fileWithDate {
+	merged := slices.Clone(storageFiles)
+	for _, a := range archived {
+		if !slices.ContainsFunc(storageFiles, func(f fileWithDate) bool { return f.startTime.Equal(a.startTime) }) {
+			merged = append(merged, a)
+		}
+	}
+	sortFilesByDate(merged)
+	return merged
+}
+
+// dayOf returns the start of the local day t is on.
+func dayOf(t time.Time) time.Time {
+	t = t.Local()
+	//nolint:gosmopolitan // days are archived in local time
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// removeIfExists removes the file at path if there is one.
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// createArchiveDir creates the archive path if archiving is configured.
+func createArchiveDir(config StorageConfig) error {
+	if config.Archive.Path == "" {
+		return nil
+	}
+	return createDir(config.Archive.Path)
+}
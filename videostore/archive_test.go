@@ -0,0 +1,104 @@
+package videostore
+
+import (
+	"context"
+	"image/color"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestArchive(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	white := solidJPEG(t, color.White)
+	frames := func(frame []byte) [][]byte {
+		frames := make([][]byte, 2*framerate)
+		for i := range frames {
+			frames[i] = frame
+		}
+		return frames
+	}
+	yesterday := dayOf(time.Now()).AddDate(0, 0, -1)
+	newStore := func(t *testing.T, deleteOriginals bool) Config {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeReadOnly
+		config.Storage.Archive = ArchiveConfig{
+			Path:            filepath.Join(filepath.Dir(config.Storage.StoragePath), "archive"),
+			DeleteOriginals: deleteOriginals,
+		}
+		// Two segments an hour apart yesterday, then today's segment which completes the day.
+		storeTestSegment(t, config.Storage.StoragePath, yesterday.Add(time.Hour), frames(black), framerate)
+		storeTestSegment(t, config.Storage.StoragePath, yesterday.Add(2*time.Hour), frames(white), framerate)
+		storeInProgressSegment(t, config.Storage.StoragePath, dayOf(time.Now()))
+		test.That(t, createArchiveDir(config.Storage), test.ShouldBeNil)
+		return config
+	}
+
+	t.Run("A day of segments is archived into one seekable file", func(t *testing.T) {
+		config := newStore(t, true)
+		test.That(t, archiveDays(config.Storage, logger), test.ShouldBeNil)
+
+		archives, err := filepath.Glob(filepath.Join(config.Storage.Archive.Path, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, archives, test.ShouldResemble, []string{
+			filepath.Join(config.Storage.Archive.Path, yesterday.Format(TimeFormat)+".mp4"),
+		})
+		test.That(t, len(frameTimes(t, archives[0])), test.ShouldEqual, 4*framerate)
+		// Seeking past the first segment lands in the second.
+		gray, err := grayFrameAt(archives[0], 3*time.Second, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, slices.Min(gray), test.ShouldBeGreaterThan, 200)
+
+		// Only today's segment is left in storage.
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+	})
+
+	t.Run("Archived footage is saved by wall clock time", func(t *testing.T) {
+		config := newStore(t, true)
+		test.That(t, archiveDays(config.Storage, logger), test.ShouldBeNil)
+		vs, err := NewReadOnlyVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		defer vs.Close()
+
+		from := yesterday.Add(2*time.Hour + 500*time.Millisecond)
+		res, err := vs.Save(context.Background(), &SaveRequest{From: from, To: from.Add(time.Second)})
+		test.That(t, err, test.ShouldBeNil)
+		saved := filepath.Join(config.Storage.UploadPath, res.Filename)
+		gray, err := grayFrameAt(saved, 0, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, slices.Min(gray), test.ShouldBeGreaterThan, 200)
+		info, err := getVideoInfo(saved)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 1, 0.2)
+	})
+
+	t.Run("Kept originals are read instead of the archive", func(t *testing.T) {
+		config := newStore(t, false)
+		test.That(t, archiveDays(config.Storage, logger), test.ShouldBeNil)
+		// Archiving again doesn't rewrite the archive.
+		test.That(t, archiveDays(config.Storage, logger), test.ShouldBeNil)
+
+		c, err := newConcater(config.Storage.StoragePath, config.Storage.UploadPath, config.Storage.Archive.Path, logger)
+		test.That(t, err, test.ShouldBeNil)
+		files, err := c.files()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 3)
+		for _, f := range files {
+			test.That(t, filepath.Dir(f.name), test.ShouldEqual, config.Storage.StoragePath)
+		}
+	})
+
+	t.Run("Invalid config is rejected", func(t *testing.T) {
+		test.That(t, ArchiveConfig{Path: "/archive"}.Validate("/storage"), test.ShouldBeNil)
+		test.That(t, ArchiveConfig{Path: "/storage/"}.Validate("/storage"), test.ShouldNotBeNil)
+		test.That(t, ArchiveConfig{DeleteOriginals: true}.Validate("/storage"), test.ShouldNotBeNil)
+	})
+}
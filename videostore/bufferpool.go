@@ -0,0 +1,115 @@
+package videostore
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+// defaultPoolBufferSize is the size of pooled buffers by default, enough for the IDR
+// frames of most 1080p streams.
+const defaultPoolBufferSize = 1 << 20
+
+// BufferPoolConfig pools the C buffers packets from RTP are copied into to be written,
+// instead of allocating and freeing one per packet. This reduces allocator pressure and
+// fragmentation when recording many high bitrate streams.
+type BufferPoolConfig struct {
+	// Buffers is the most buffers kept for reuse. 0 disables the pool.
+	Buffers int
+	// BufferSize is the size of each buffer in bytes, which should be the largest packet
+	// expected. Larger packets get a buffer of their own. 0 uses 1MiB.
+	BufferSize int
+}
+
+// Validate returns an error if the BufferPoolConfig is invalid.
+func (c BufferPoolConfig) Validate() error {
+	if c.Buffers < 0 {
+		return errors.New("buffer pool buffers can't be less than 0")
+	}
+	if c.BufferSize < 0 {
+		return errors.New("buffer pool buffer size can't be less than 0")
+	}
+	return nil
+}
+
+// cBufferPool is a bounded pool of reusable C buffers which is safe for concurrent use.
+// Up to cap(free) buffers are kept. While they are all in use more are allocated, and
+// freed when they are returned to a full pool.
+type cBufferPool struct {
+	size int
+	free chan unsafe.Pointer
+	// allocs counts the C buffers allocated by get, pooled or not.
+	allocs atomic.Int64
+}
+
+// cBuffer is a C copy of a packet.
+type cBuffer struct {
+	ptr unsafe.Pointer
+	// pooled is set for buffers of the pool's size, which can be returned to it.
+	pooled bool
+}
+
+// newCBufferPool returns nil if the pool is disabled.
+func newCBufferPool(c BufferPoolConfig) *cBufferPool {
+	if c.Buffers == 0 {
+		return nil
+	}
+	size := c.BufferSize
+	if size == 0 {
+		size = defaultPoolBufferSize
+	}
+	return &cBufferPool{size: size, free: make(chan unsafe.Pointer, c.Buffers)}
+}
+
+// get returns a C copy of b, which must be released with put. A nil pool allocates a
+// buffer for each copy.
+func (p *cBufferPool) get(b []byte) cBuffer {
+	if p == nil {
+		return cBuffer{ptr: C.CBytes(b)}
+	}
+	if len(b) > p.size {
+		p.allocs.Add(1)
+		return cBuffer{ptr: C.CBytes(b)}
+	}
+	var ptr unsafe.Pointer
+	select {
+	case ptr = <-p.free:
+	default:
+		p.allocs.Add(1)
+		ptr = C.malloc(C.size_t(p.size))
+	}
+	copy(unsafe.Slice((*byte)(ptr), len(b)), b)
+	return cBuffer{ptr: ptr, pooled: true}
+}
+
+// put returns buf to the pool, or frees it if the pool is full.
+func (p *cBufferPool) put(buf cBuffer) {
+	if buf.pooled {
+		select {
+		case p.free <- buf.ptr:
+			return
+		default:
+		}
+	}
+	C.free(buf.ptr)
+}
+
+// drain frees the buffers kept in the pool. Buffers in use are freed when they are put.
+func (p *cBufferPool) drain() {
+	if p == nil {
+		return
+	}
+	for {
+		select {
+		case ptr := <-p.free:
+			C.free(ptr)
+		default:
+			return
+		}
+	}
+}
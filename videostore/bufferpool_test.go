@@ -0,0 +1,126 @@
+package videostore
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestCBufferPool(t *testing.T) {
+	const size = 1024
+	contents := func(buf cBuffer, n int) []byte {
+		return unsafe.Slice((*byte)(buf.ptr), n)
+	}
+
+	t.Run("Returned buffers are reused", func(t *testing.T) {
+		p := newCBufferPool(BufferPoolConfig{Buffers: 2, BufferSize: size})
+		defer p.drain()
+		for i := range 100 {
+			payload := bytes.Repeat([]byte{byte(i)}, size)
+			buf := p.get(payload)
+			test.That(t, contents(buf, size), test.ShouldResemble, payload)
+			p.put(buf)
+		}
+		test.That(t, p.allocs.Load(), test.ShouldEqual, 1)
+	})
+
+	t.Run("Concurrent writers don't share buffers", func(t *testing.T) {
+		p := newCBufferPool(BufferPoolConfig{Buffers: 4, BufferSize: size})
+		defer p.drain()
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for w := range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 1000 {
+					payload := bytes.Repeat([]byte{byte(w), byte(i)}, 1+(w*i)%(size/2))
+					buf := p.get(payload)
+					// Yield while holding the buffer so other writers run.
+					for range 3 {
+						runtime.Gosched()
+					}
+					if !bytes.Equal(contents(buf, len(payload)), payload) {
+						errs <- fmt.Errorf("writer %d packet %d was corrupted", w, i)
+						p.put(buf)
+						return
+					}
+					p.put(buf)
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		test.That(t, len(p.free), test.ShouldBeLessThanOrEqualTo, 4)
+	})
+
+	t.Run("Packets larger than the buffers aren't pooled", func(t *testing.T) {
+		p := newCBufferPool(BufferPoolConfig{Buffers: 1, BufferSize: size})
+		defer p.drain()
+		payload := bytes.Repeat([]byte{7}, 2*size)
+		buf := p.get(payload)
+		test.That(t, buf.pooled, test.ShouldBeFalse)
+		test.That(t, contents(buf, len(payload)), test.ShouldResemble, payload)
+		p.put(buf)
+		test.That(t, len(p.free), test.ShouldEqual, 0)
+	})
+
+	t.Run("Segments written with the pool are complete", func(t *testing.T) {
+		const framerate = 10
+		frames := make([][]byte, 2*framerate)
+		for i := range frames {
+			frames[i] = patternJPEG(t, i%2)
+		}
+		storagePath := t.TempDir()
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{bufferPool: BufferPoolConfig{Buffers: 1, BufferSize: 4096}}, logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		for _, p := range h264Packets(t, frames, framerate) {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		test.That(t, len(rs.bufferPool.free), test.ShouldEqual, 0)
+
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, segments[0])), test.ShouldEqual, len(frames))
+	})
+}
+
+// BenchmarkCBufferPool compares C allocations per packet copied with and without the pool.
+func BenchmarkCBufferPool(b *testing.B) {
+	payload := bytes.Repeat([]byte{1}, 64*1024)
+	for _, config := range []BufferPoolConfig{{}, {Buffers: 16, BufferSize: len(payload)}} {
+		name := "without pool"
+		if config.Buffers > 0 {
+			name = "with pool"
+		}
+		b.Run(name, func(b *testing.B) {
+			p := newCBufferPool(config)
+			if p == nil {
+				// A pool without buffers allocates one per copy, as no pool does, and counts them.
+				p = &cBufferPool{}
+			}
+			defer p.drain()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p.put(p.get(payload))
+				}
+			})
+			b.ReportMetric(float64(p.allocs.Load())/float64(b.N), "mallocs/op")
+		})
+	}
+}
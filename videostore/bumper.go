@@ -0,0 +1,154 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTitleCardDuration is how long a title card is shown by default.
+	defaultTitleCardDuration = 3 * time.Second
+	maxTitleCardDuration     = 30 * time.Second
+)
+
+// Bumper is a clip played before or after the footage of an export, e.g. a branded intro
+// or a standard notice at the end of incident clips. It is a video file or a generated
+// title card, which is scaled and padded to the size of the export and encoded with it.
+// One of Path or Text must be set.
+type Bumper struct {
+	// Path is the path of a video file in the export input path in a codec the module can decode.
+	// It plays in full, without its audio.
+	Path string
+	// Text is the text of a title card, centered on Color. It requires a TrueType font
+	// like annotations.
+	Text string
+	// Color is the background of a title card, an FFmpeg color name or hex code. Defaults
+	// to black.
+	Color string
+	// Duration is how long a title card is shown, at most 30 seconds. 0 uses 3 seconds.
+	Duration time.Duration
+}
+
+// Validate returns an error if the Bumper is invalid or its video can't be decoded.
+func (b *Bumper) Validate() error {
+	if (b.Path == "") == (b.Text == "") {
+		return errors.New("exactly one of bumper path or text must be set")
+	}
+	if b.Path == "" {
+		if b.Color != "" && !padColorPattern.MatchString(b.Color) {
+			return fmt.Errorf("invalid bumper color %s, must be a color name or hex code", b.Color)
+		}
+		if b.Duration < 0 || b.Duration > maxTitleCardDuration {
+			return fmt.Errorf("bumper duration must be between 0 and %s", maxTitleCardDuration)
+		}
+		return nil
+	}
+	if b.Color != "" || b.Duration != 0 {
+		return errors.New("bumper color and duration are only for title cards")
+	}
+	if _, err := os.Stat(b.Path); err != nil {
+		return fmt.Errorf("failed to open bumper: %w", err)
+	}
+	info, err := getVideoInfo(b.Path)
+	if err != nil {
+		return fmt.Errorf("bumper %s isn't a readable video: %w", b.Path, err)
+	}
+	// Bumpers are always re-encoded, so only decoding them needs support.
+	if !slices.Contains(GetCapabilities().Decoders, info.codec) {
+		return fmt.Errorf("bumper %s is %s, which can't be decoded, transcode it to h264", b.Path, info.codec)
+	}
+	if info.duration <= 0 {
+		return fmt.Errorf("bumper %s is empty", b.Path)
+	}
+	return nil
+}
+
+// duration returns how long the bumper plays for.
+func (b *Bumper) duration() time.Duration {
+	if b.Path == "" {
+		if b.Duration == 0 {
+			return defaultTitleCardDuration
+		}
+		return b.Duration
+	}
+	info, err := getVideoInfo(b.Path)
+	if err != nil {
+		return 0
+	}
+	return info.duration
+}
+
+// source returns the filters which generate the bumper as video of size, labeled label.
+// frameRate is the constant frame rate of the export, or 0 if it has none.
+func (b *Bumper) source(size image.Point, frameRate int, label string) (string, error) {
+	var filters []string
+	if b.Path == "" {
+		font, err := findFont()
+		if err != nil {
+			return "", err
+		}
+		color := b.Color
+		if color == "" {
+			color = "black"
+		}
+		card := fmt.Sprintf("color=c=%s:s=%dx%d:d=%.3f", color, size.X, size.Y, b.duration().Seconds())
+		if frameRate > 0 {
+			card += fmt.Sprintf(":r=%d", frameRate)
+		}
+		filters = append(filters, card, "drawtext="+strings.Join([]string{
+			"fontfile=" + escapeFilterValue(font),
+			"text=" + escapeFilterValue(b.Text),
+			"expansion=none",
+			"fontsize=h/12",
+			"fontcolor=white",
+			"x=(w-text_w)/2",
+			"y=(h-text_h)/2",
+		}, ":"))
+	} else {
+		// Bumpers of another aspect ratio are letterboxed or pillarboxed like Pad.
+		filters = append(filters,
+			"movie=filename="+escapeFilterValue(b.Path),
+			fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease", size.X, size.Y),
+			fmt.Sprintf("pad=w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2:color=black", size.X, size.Y))
+		if frameRate > 0 {
+			filters = append(filters, fmt.Sprintf("fps=fps=%d", frameRate))
+		}
+	}
+	// concat needs every input to have the same sample aspect ratio, and starts each
+	// input after the last without shifting its timestamps.
+	filters = append(filters, "setsar=sar=1", "setpts=PTS-STARTPTS")
+	return strings.Join(filters, ",") + "[" + label + "]", nil
+}
+
+// bumperFilter returns the filter which plays intro, then the footage of size, then
+// outro. Either bumper may be nil. Like the watermark it splits the graph, generating
+// each bumper as another input of concat.
+func bumperFilter(intro, outro *Bumper, size image.Point, frameRate int) (string, error) {
+	parts := []string{"setsar=sar=1[footage]"}
+	inputs := "[footage]"
+	for _, b := range []struct {
+		bumper *Bumper
+		label  string
+	}{{intro, "intro"}, {outro, "outro"}} {
+		if b.bumper == nil {
+			continue
+		}
+		source, err := b.bumper.source(size, frameRate, b.label)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, source)
+		if b.label == "intro" {
+			inputs = "[intro]" + inputs
+		} else {
+			inputs += "[outro]"
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%sconcat=n=%d:v=1:a=0", inputs, len(parts)))
+	return strings.Join(parts, ";"), nil
+}
@@ -0,0 +1,115 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestBumpers(t *testing.T) {
+	const framerate = 10
+	frames := make([][]byte, 4*framerate)
+	for i := range frames {
+		frames[i] = solidJPEG(t, color.Black)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	// The intro is smaller than the footage, so it's scaled up to fit.
+	small := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	draw.Draw(small, small.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, small, nil), test.ShouldBeNil)
+	white := make([][]byte, framerate)
+	gray := make([][]byte, framerate)
+	for i := range framerate {
+		white[i] = buf.Bytes()
+		gray[i] = solidJPEG(t, color.Gray{Y: 128})
+	}
+	// Bumpers are read from the export input path.
+	test.That(t, os.MkdirAll(config.Storage.ExportInputPath, 0o755), test.ShouldBeNil)
+	intro := filepath.Join(config.Storage.ExportInputPath, "intro.mp4")
+	outro := filepath.Join(config.Storage.ExportInputPath, "outro.mp4")
+	test.That(t, os.Rename(encodeFrames(t, white, framerate), intro), test.ShouldBeNil)
+	test.That(t, os.Rename(encodeFrames(t, gray, framerate), outro), test.ShouldBeNil)
+	meanLuma := func(t *testing.T, path string, offset time.Duration) float64 {
+		frame, err := grayFrameAt(path, offset, 64, 48)
+		test.That(t, err, test.ShouldBeNil)
+		var sum int
+		for _, y := range frame {
+			sum += int(y)
+		}
+		return float64(sum) / float64(len(frame))
+	}
+
+	t.Run("Bumpers play around the footage", func(t *testing.T) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(4 * time.Second),
+			Metadata: "bumpers",
+			Intro:    &Bumper{Path: "intro.mp4"},
+			Outro:    &Bumper{Path: outro},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 6, 0.3)
+		test.That(t, info.width, test.ShouldEqual, 640)
+		test.That(t, info.height, test.ShouldEqual, 480)
+		test.That(t, meanLuma(t, path, 500*time.Millisecond), test.ShouldBeGreaterThan, 200)
+		test.That(t, meanLuma(t, path, 3*time.Second), test.ShouldBeLessThan, 40)
+		test.That(t, meanLuma(t, path, 5500*time.Millisecond), test.ShouldAlmostEqual, 128, 20)
+	})
+
+	t.Run("Title cards are generated", func(t *testing.T) {
+		if _, err := findFont(); err != nil {
+			t.Skip("no TrueType font installed")
+		}
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(2 * time.Second),
+			Metadata:  "title_card",
+			FrameRate: framerate,
+			Outro:     &Bumper{Text: "End of clip", Color: "white", Duration: time.Second},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 3, 0.3)
+		test.That(t, meanLuma(t, path, time.Second), test.ShouldBeLessThan, 40)
+		test.That(t, meanLuma(t, path, 2500*time.Millisecond), test.ShouldBeGreaterThan, 200)
+	})
+
+	t.Run("Bumpers must be in the export input path", func(t *testing.T) {
+		outside := encodeFrames(t, gray, framerate)
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:  start,
+			To:    start.Add(time.Second),
+			Intro: &Bumper{Path: outside},
+		})
+		test.That(t, err, test.ShouldBeError, "export input "+outside+" is outside of the export input path")
+	})
+
+	t.Run("Invalid bumpers are rejected", func(t *testing.T) {
+		test.That(t, (&Bumper{Path: intro, Text: "intro"}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Bumper{}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Bumper{Path: intro, Duration: time.Second}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Bumper{Text: "intro", Duration: time.Minute}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Bumper{Text: "intro", Color: "not a color;"}).Validate(), test.ShouldNotBeNil)
+		notVideo := filepath.Join(t.TempDir(), "intro.mp4")
+		test.That(t, os.WriteFile(notVideo, []byte("not a video"), 0o600), test.ShouldBeNil)
+		test.That(t, (&Bumper{Path: notVideo}).Validate(), test.ShouldNotBeNil)
+		r := &ExportRequest{From: start, To: start.Add(time.Second), Intro: &Bumper{Path: notVideo}}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,97 @@
+package videostore
+
+/*
+#include <libavcodec/avcodec.h>
+#include <libavformat/avformat.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"slices"
+	"unsafe"
+)
+
+// codecDecoders are the names of the libav decoders for each CodecType.
+var codecDecoders = map[CodecType]string{
+	CodecTypeH264: "h264",
+	CodecTypeH265: "hevc",
+}
+
+// Capabilities are the parts of the linked FFmpeg build relevant to video storage.
+type Capabilities struct {
+	// Encoders are the names of the available video encoders, e.g. libx264.
+	Encoders []string
+	// Decoders are the names of the available video decoders, e.g. h264.
+	Decoders []string
+	// Muxers are the names of the available output container formats, e.g. mp4.
+	Muxers []string
+}
+
+// HasEncoder returns true if the named encoder is available.
+func (c Capabilities) HasEncoder(name string) bool {
+	return slices.Contains(c.Encoders, name)
+}
+
+// HasDecoder returns true if the named decoder is available.
+func (c Capabilities) HasDecoder(name string) bool {
+	return slices.Contains(c.Decoders, name)
+}
+
+// HasMuxer returns true if the named muxer is available.
+func (c Capabilities) HasMuxer(name string) bool {
+	return slices.Contains(c.Muxers, name)
+}
+
+// GetCapabilities queries the linked libav for the video encoders, video decoders and
+// muxers it was built with. Names are sorted.
+func GetCapabilities() Capabilities {
+	caps := Capabilities{Encoders: []string{}, Decoders: []string{}, Muxers: []string{}}
+	var opaque unsafe.Pointer
+	for codec := C.av_codec_iterate(&opaque); codec != nil; codec = C.av_codec_iterate(&opaque) {
+		if codec._type != C.AVMEDIA_TYPE_VIDEO {
+			continue
+		}
+		name := C.GoString(codec.name)
+		if C.av_codec_is_encoder(codec) != 0 {
+			caps.Encoders = append(caps.Encoders, name)
+		}
+		if C.av_codec_is_decoder(codec) != 0 {
+			caps.Decoders = append(caps.Decoders, name)
+		}
+	}
+	opaque = nil
+	for muxer := C.av_muxer_iterate(&opaque); muxer != nil; muxer = C.av_muxer_iterate(&opaque) {
+		caps.Muxers = append(caps.Muxers, C.GoString(muxer.name))
+	}
+	slices.Sort(caps.Encoders)
+	slices.Sort(caps.Decoders)
+	slices.Sort(caps.Muxers)
+	return caps
+}
+
+// validateCapabilities reports the parts of the linked libav c needs which are missing from caps.
+func validateCapabilities(c Config, caps Capabilities) []ConfigProblem {
+	var problems []ConfigProblem
+	add := func(field, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !caps.HasMuxer(videoFormat) {
+		add("format", "the %s muxer is not available in the linked FFmpeg", videoFormat)
+	}
+	for _, codec := range supportedCodecs[c.Type] {
+		if decoder := codecDecoders[codec]; !caps.HasDecoder(decoder) {
+			add("codec", "the %s decoder is not available in the linked FFmpeg", decoder)
+		}
+	}
+	if c.Type == SourceTypeFrame {
+		if !caps.HasDecoder("mjpeg") {
+			add("codec", "the mjpeg decoder is not available in the linked FFmpeg")
+		}
+		if !caps.HasEncoder(softwareEncoder) {
+			add("codec", "the %s encoder is not available in the linked FFmpeg", softwareEncoder)
+		}
+	}
+	return problems
+}
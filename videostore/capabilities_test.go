@@ -0,0 +1,34 @@
+package videostore
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestGetCapabilities(t *testing.T) {
+	caps := GetCapabilities()
+
+	t.Run("Core codecs and containers are available", func(t *testing.T) {
+		test.That(t, caps.Encoders, test.ShouldContain, "libx264")
+		test.That(t, caps.Decoders, test.ShouldContain, "h264")
+		test.That(t, caps.Decoders, test.ShouldContain, "hevc")
+		test.That(t, caps.Decoders, test.ShouldContain, "mjpeg")
+		test.That(t, caps.Muxers, test.ShouldContain, "mp4")
+	})
+
+	t.Run("Valid config has no missing capabilities", func(t *testing.T) {
+		config := validRTPConfig(t)
+		test.That(t, validateCapabilities(config, caps), test.ShouldBeEmpty)
+		config.Type = SourceTypeFrame
+		test.That(t, validateCapabilities(config, caps), test.ShouldBeEmpty)
+	})
+
+	t.Run("Missing capabilities are reported", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeFrame
+		problems := validateCapabilities(config, Capabilities{Decoders: []string{"h264"}})
+		test.That(t, problemFields(problems), test.ShouldResemble, []string{"format", "codec", "codec"})
+		test.That(t, problems[2].Message, test.ShouldEqual, "the libx264 encoder is not available in the linked FFmpeg")
+	})
+}
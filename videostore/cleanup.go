@@ -0,0 +1,104 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// defaultCleanupRetries is how many times a failed cleanup is retried before giving up until the next run.
+	defaultCleanupRetries = 2
+	// defaultCleanupRetryDelay is the time between cleanup retries.
+	defaultCleanupRetryDelay = 5 * time.Second
+)
+
+// storageCleaner deletes the oldest segments once storage is over its max size.
+// Only one cleanup runs at a time: cleanups requested while one is in progress
+// wait for it and share its result instead of racing it to delete the same files.
+type storageCleaner struct {
+	logger     logging.Logger
+	retries    int
+	retryDelay time.Duration
+	// run does a single cleanup attempt and returns the files it deleted.
+	run func() ([]string, error)
+
+	mu      sync.Mutex
+	current *cleanupRun
+}
+
+// cleanupRun is an in-progress cleanup and, once done is closed, its result.
+type cleanupRun struct {
+	done chan struct{}
+	// waiters is how many requests coalesced into the run. Guarded by storageCleaner.mu.
+	waiters int
+	deleted []string
+	err     error
+}
+
+func newStorageCleaner(config StorageConfig, readOnlyFilesystem func(path string) bool, logger logging.Logger) (*storageCleaner, error) {
+	maxStorageSize, err := sizeGBToBytes(config.SizeGB)
+	if err != nil {
+		return nil, err
+	}
+	return &storageCleaner{
+		logger:     logger,
+		retries:    defaultCleanupRetries,
+		retryDelay: defaultCleanupRetryDelay,
+		run: func() ([]string, error) {
+			return cleanupStorage(config.StoragePath, maxStorageSize, readOnlyFilesystem, logger)
+		},
+	}, nil
+}
+
+// cleanup runs a cleanup, or joins the one in progress, and returns the files it deleted.
+func (c *storageCleaner) cleanup(ctx context.Context) ([]string, error) {
+	c.mu.Lock()
+	run := c.current
+	if run != nil {
+		run.waiters++
+		c.mu.Unlock()
+		select {
+		case <-run.done:
+			return run.deleted, run.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	run = &cleanupRun{done: make(chan struct{})}
+	c.current = run
+	c.mu.Unlock()
+
+	run.deleted, run.err = c.runWithRetries(ctx)
+
+	c.mu.Lock()
+	c.current = nil
+	waiters := run.waiters
+	c.mu.Unlock()
+	close(run.done)
+	if waiters > 0 {
+		c.logger.Debugf("%d cleanup requests coalesced into a run which deleted %d files", waiters, len(run.deleted))
+	}
+	return run.deleted, run.err
+}
+
+// runWithRetries runs a cleanup, retrying up to c.retries times on failure.
+func (c *storageCleaner) runWithRetries(ctx context.Context) ([]string, error) {
+	var deleted []string
+	for attempt := 0; ; attempt++ {
+		files, err := c.run()
+		deleted = append(deleted, files...)
+		if err == nil || errors.Is(err, ErrReadOnlyStorage) || attempt == c.retries {
+			return deleted, err
+		}
+		c.logger.Warnf("cleanup attempt %d failed, retrying in %s: %v", attempt+1, c.retryDelay, err)
+		select {
+		case <-ctx.Done():
+			return deleted, ctx.Err()
+		case <-time.After(c.retryDelay):
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestStorageCleaner(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("Concurrent cleanups coalesce into a single run", func(t *testing.T) {
+		storagePath := t.TempDir()
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		var segments []string
+		for i := range 5 {
+			segment := filepath.Join(storagePath, strconv.FormatInt(start.Add(time.Duration(i)*time.Minute).Unix(), 10)+".mp4")
+			test.That(t, os.WriteFile(segment, make([]byte, 1024), 0o600), test.ShouldBeNil)
+			segments = append(segments, segment)
+		}
+
+		c, err := newStorageCleaner(StorageConfig{StoragePath: storagePath}, isReadOnlyFilesystem, logger)
+		test.That(t, err, test.ShouldBeNil)
+		var runs atomic.Int32
+		release := make(chan struct{})
+		c.run = func() ([]string, error) {
+			runs.Add(1)
+			<-release
+			return cleanupStorage(storagePath, 2560, isReadOnlyFilesystem, logger)
+		}
+
+		const callers = 3
+		var wg sync.WaitGroup
+		deleted := make([][]string, callers)
+		errs := make([]error, callers)
+		for i := range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				deleted[i], errs[i] = c.cleanup(context.Background())
+			}()
+		}
+		// Hold the first run until the other callers have joined it.
+		for {
+			c.mu.Lock()
+			joined := c.current != nil && c.current.waiters == callers-1
+			c.mu.Unlock()
+			if joined {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		wg.Wait()
+
+		test.That(t, runs.Load(), test.ShouldEqual, 1)
+		for i := range callers {
+			test.That(t, errs[i], test.ShouldBeNil)
+			test.That(t, deleted[i], test.ShouldResemble, segments[:3])
+		}
+		remaining, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(remaining), test.ShouldEqual, 2)
+		test.That(t, remaining[0].name, test.ShouldEqual, segments[3])
+
+		// The next cleanup is a new run.
+		_, err = c.cleanup(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, runs.Load(), test.ShouldEqual, 2)
+	})
+
+	t.Run("Failed cleanups are retried", func(t *testing.T) {
+		c, err := newStorageCleaner(StorageConfig{StoragePath: t.TempDir()}, isReadOnlyFilesystem, logger)
+		test.That(t, err, test.ShouldBeNil)
+		c.retryDelay = 0
+		var runs int
+		c.run = func() ([]string, error) {
+			runs++
+			if runs == 1 {
+				return []string{"a"}, errors.New("transient")
+			}
+			return []string{"b"}, nil
+		}
+		deleted, err := c.cleanup(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, deleted, test.ShouldResemble, []string{"a", "b"})
+		test.That(t, runs, test.ShouldEqual, 2)
+	})
+
+	t.Run("Retries give up after the configured count", func(t *testing.T) {
+		c, err := newStorageCleaner(StorageConfig{StoragePath: t.TempDir()}, isReadOnlyFilesystem, logger)
+		test.That(t, err, test.ShouldBeNil)
+		c.retryDelay = 0
+		var runs int
+		c.run = func() ([]string, error) {
+			runs++
+			return nil, errors.New("persistent")
+		}
+		_, err = c.cleanup(context.Background())
+		test.That(t, err, test.ShouldBeError, errors.New("persistent"))
+		test.That(t, runs, test.ShouldEqual, c.retries+1)
+	})
+}
@@ -0,0 +1,52 @@
+package videostore
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// NewClipHandler returns an http.Handler which serves the clips saved or exported to
+// uploadPath by file name, e.g. GET /<filename>. Range requests are honored with 206
+// Partial Content responses so browsers can seek within a clip without it being
+// fetched or exported again. Mount it under a prefix with http.StripPrefix.
+func NewClipHandler(uploadPath string) http.Handler {
+	return &clipHandler{uploadPath: uploadPath}
+}
+
+type clipHandler struct {
+	uploadPath string
+}
+
+func (h *clipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// Only clips directly within the upload path are served.
+	name := path.Clean("/" + r.URL.Path)[1:]
+	if name == "" || path.Base(name) != name || filepath.Ext(name) != ".mp4" {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(h.uploadPath, name))
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
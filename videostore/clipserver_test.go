@@ -0,0 +1,80 @@
+package videostore
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestClipHandler(t *testing.T) {
+	uploadPath := t.TempDir()
+	clip := make([]byte, 4096)
+	_, err := rand.Read(clip)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, os.WriteFile(filepath.Join(uploadPath, "clip.mp4"), clip, 0o600), test.ShouldBeNil)
+	test.That(t, os.WriteFile(filepath.Join(uploadPath, "notes.txt"), []byte("notes"), 0o600), test.ShouldBeNil)
+	server := httptest.NewServer(http.StripPrefix("/clips", NewClipHandler(uploadPath)))
+	defer server.Close()
+
+	get := func(t *testing.T, path, byteRange string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		test.That(t, err, test.ShouldBeNil)
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		res, err := http.DefaultClient.Do(req)
+		test.That(t, err, test.ShouldBeNil)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		test.That(t, err, test.ShouldBeNil)
+		return res, body
+	}
+
+	t.Run("Whole clip is served without a range", func(t *testing.T) {
+		res, body := get(t, "/clips/clip.mp4", "")
+		test.That(t, res.StatusCode, test.ShouldEqual, http.StatusOK)
+		test.That(t, res.Header.Get("Accept-Ranges"), test.ShouldEqual, "bytes")
+		test.That(t, res.Header.Get("Content-Type"), test.ShouldEqual, "video/mp4")
+		test.That(t, body, test.ShouldResemble, clip)
+	})
+
+	t.Run("Range returns the requested bytes", func(t *testing.T) {
+		res, body := get(t, "/clips/clip.mp4", "bytes=100-199")
+		test.That(t, res.StatusCode, test.ShouldEqual, http.StatusPartialContent)
+		test.That(t, res.Header.Get("Content-Range"), test.ShouldEqual, "bytes 100-199/4096")
+		test.That(t, res.Header.Get("Content-Length"), test.ShouldEqual, "100")
+		test.That(t, body, test.ShouldResemble, clip[100:200])
+	})
+
+	t.Run("Open ended and suffix ranges are honored", func(t *testing.T) {
+		res, body := get(t, "/clips/clip.mp4", "bytes=4000-")
+		test.That(t, res.StatusCode, test.ShouldEqual, http.StatusPartialContent)
+		test.That(t, res.Header.Get("Content-Range"), test.ShouldEqual, "bytes 4000-4095/4096")
+		test.That(t, body, test.ShouldResemble, clip[4000:])
+
+		res, body = get(t, "/clips/clip.mp4", "bytes=-10")
+		test.That(t, res.StatusCode, test.ShouldEqual, http.StatusPartialContent)
+		test.That(t, res.Header.Get("Content-Range"), test.ShouldEqual, "bytes 4086-4095/4096")
+		test.That(t, body, test.ShouldResemble, clip[4086:])
+	})
+
+	t.Run("Unsatisfiable range is rejected", func(t *testing.T) {
+		res, _ := get(t, "/clips/clip.mp4", "bytes=5000-6000")
+		test.That(t, res.StatusCode, test.ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+		test.That(t, res.Header.Get("Content-Range"), test.ShouldEqual, "bytes */"+strconv.Itoa(len(clip)))
+	})
+
+	t.Run("Only clips in the upload path are served", func(t *testing.T) {
+		for _, path := range []string{"/clips/missing.mp4", "/clips/notes.txt", "/clips/sub/clip.mp4", "/clips/"} {
+			res, _ := get(t, path, "")
+			test.That(t, res.StatusCode, test.ShouldEqual, http.StatusNotFound)
+		}
+	})
+}
@@ -27,17 +27,20 @@ type concater struct {
 	logger      logging.Logger
 	storagePath string
 	uploadPath  string
+	// archivePath, if set, is where archived segments are read from as well as storagePath.
+	archivePath string
 	segmentDur  time.Duration
 }
 
 func newConcater(
-	storagePath, uploadPath string,
+	storagePath, uploadPath, archivePath string,
 	logger logging.Logger,
 ) (*concater, error) {
 	c := &concater{
 		logger:      logger,
 		storagePath: storagePath,
 		uploadPath:  uploadPath,
+		archivePath: archivePath,
 		segmentDur:  time.Duration(segmentSeconds) * time.Second,
 	}
 	err := c.cleanupConcatTxtFiles()
@@ -50,43 +53,58 @@ func newConcater(
 // concat takes in from and to timestamps and concates the video files between them.
 // returns the path to the concated video file.
 func (c *concater) Concat(from, to time.Time, path string) error {
-	// Find the storage files that match the concat query.
-	storageFiles, err := getSortedFiles(c.storagePath)
+	concatFilePath, err := c.writeConcatFile(from, to, path)
+	defer c.removeConcatFile(concatFilePath)
 	if err != nil {
-		c.logger.Error("failed to get sorted files", err)
 		return err
 	}
-	if len(storageFiles) == 0 {
-		err := errors.New("no video data in storage")
-		c.logger.Errorf("%s, path: %s", err.Error(), path)
+	return concat(concatFilePath, path)
+}
+
+// ConcatWithActive is Concat for a range which may extend into the active segment,
+// the newest one which is still being written. The part of the active segment
+// flushed to disk so far is snapshotted into a finalized temporary file and
+// included. Returns ErrActiveSegment if none of it is readable yet.
+func (c *concater) ConcatWithActive(from, to time.Time, path string) error {
+	storageFiles, err := c.sortedFiles(path)
+	if err != nil {
 		return err
 	}
-	err = validateTimeRange(storageFiles, from, to)
+	active := storageFiles[len(storageFiles)-1]
+	if !to.After(active.startTime) {
+		return c.Concat(from, to, path)
+	}
+	if from.Before(storageFiles[0].startTime) || from.After(to) {
+		return errors.New("time range is outside of storage range")
+	}
+	snapshotDir, err := os.MkdirTemp("", "video_store_active_*")
 	if err != nil {
 		return err
 	}
-	concatEntries := matchStorageToRange(storageFiles, from, to, c.logger)
-	if len(concatEntries) == 0 {
-		return errors.New("no matching video data to save")
-	}
-
-	// Create a temporary file to store the list of files to concatenate.
-	concatFilePath := generateConcatFilePath()
-	err = writeConcatFileEntries(concatEntries, concatFilePath)
 	defer func() {
-		// Remove the concat file after the concat operation is complete.
-		if _, err := os.Stat(concatFilePath); err == nil {
-			if err := os.Remove(concatFilePath); err != nil {
-				c.logger.Error("failed to remove concat file %s, err: %s", concatFilePath, err.Error())
-			}
+		if err := os.RemoveAll(snapshotDir); err != nil {
+			c.logger.Warnf("failed to remove active segment snapshot %s: %v", snapshotDir, err)
 		}
 	}()
+	snapshot, err := snapshotSegment(active.name, snapshotDir)
 	if err != nil {
 		return err
 	}
+	storageFiles[len(storageFiles)-1] = fileWithDate{name: snapshot, startTime: active.startTime}
 
+	concatFilePath, err := c.writeConcatFileFor(storageFiles, []timeRange{{from: from, to: to}})
+	defer c.removeConcatFile(concatFilePath)
+	if err != nil {
+		return err
+	}
+	return concat(concatFilePath, path)
+}
+
+// concat calls the C function video_store_concat to concatenate the files listed
+// in the concat demuxer file at concatFilePath into outputPath.
+func concat(concatFilePath, outputPath string) error {
 	concatFilePathCStr := C.CString(concatFilePath)
-	outputPathCStr := C.CString(path)
+	outputPathCStr := C.CString(outputPath)
 	defer func() {
 		C.free(unsafe.Pointer(concatFilePathCStr))
 		C.free(unsafe.Pointer(outputPathCStr))
@@ -103,6 +121,122 @@ func (c *concater) Concat(from, to time.Time, path string) error {
 	}
 }
 
+// writeConcatFile finds the storage files between from and to and writes them to a
+// concat demuxer file for the output at path. Returns the path of the concat file
+// which the caller must remove with removeConcatFile, even on error.
+func (c *concater) writeConcatFile(from, to time.Time, path string) (string, error) {
+	concatFilePath, _, err := c.writeConcatFileRanges(from, to, []timeRange{{from: from, to: to}}, path)
+	return concatFilePath, err
+}
+
+// writeConcatFileRanges is writeConcatFile for only the parts of from to to within ranges,
+// which must be sorted and not overlap. It also returns the entries written.
+func (c *concater) writeConcatFileRanges(from, to time.Time, ranges []timeRange, path string) (string, []concatFileEntry, error) {
+	storageFiles, err := c.sortedFiles(path)
+	if err != nil {
+		return "", nil, err
+	}
+	err = validateTimeRange(storageFiles, from, to)
+	if err != nil {
+		return "", nil, err
+	}
+	entries, err := c.matchRanges(storageFiles, ranges)
+	if err != nil {
+		return "", nil, err
+	}
+	concatFilePath := generateConcatFilePath()
+	return concatFilePath, entries, writeConcatFileEntries(entries, concatFilePath)
+}
+
+// writeConcatFileGapFilled is writeConcatFile which keeps the gaps of at least minGap in
+// the video between from and to in the concatenated timeline, rather than joining the
+// video either side of them. It also returns the entries written and the gaps kept, see
+// keepGaps.
+func (c *concater) writeConcatFileGapFilled(from, to time.Time, minGap time.Duration, path string) (string, []concatFileEntry, []timeRange, error) {
+	storageFiles, err := c.sortedFiles(path)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// A leading gap before the oldest file is filled like any other.
+	err = validateTimeRange(storageFiles, maxTime(from, storageFiles[0].startTime), to)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	entries, spans := matchStorageSpans(storageFiles, from, to, videoInfoCache{}, c.logger)
+	if len(entries) == 0 {
+		return "", nil, nil, errors.New("no matching video data to save")
+	}
+	gaps := keepGaps(entries, spans, from, to, minGap)
+	concatFilePath := generateConcatFilePath()
+	return concatFilePath, entries, gaps, writeConcatFileEntries(entries, concatFilePath)
+}
+
+// sortedFiles returns the storage files sorted by start time, or an error if there
+// are none to concat into the output at path.
+func (c *concater) sortedFiles(path string) ([]fileWithDate, error) {
+	storageFiles, err := c.files()
+	if err != nil {
+		c.logger.Error("failed to get sorted files", err)
+		return nil, err
+	}
+	if len(storageFiles) == 0 {
+		err := errors.New("no video data in storage")
+		c.logger.Errorf("%s, path: %s", err.Error(), path)
+		return nil, err
+	}
+	return storageFiles, nil
+}
+
+// files returns the storage files and archived segments sorted by start time.
+func (c *concater) files() ([]fileWithDate, error) {
+	storageFiles, err := getSortedFiles(c.storagePath)
+	if err != nil || c.archivePath == "" {
+		return storageFiles, err
+	}
+	archived, err := archivedFiles(c.archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return mergeArchivedFiles(storageFiles, archived), nil
+}
+
+// writeConcatFileFor writes a concat demuxer file for the parts of storageFiles
+// within ranges.
+func (c *concater) writeConcatFileFor(storageFiles []fileWithDate, ranges []timeRange) (string, error) {
+	concatEntries, err := c.matchRanges(storageFiles, ranges)
+	if err != nil {
+		return "", err
+	}
+
+	// Create a temporary file to store the list of files to concatenate.
+	concatFilePath := generateConcatFilePath()
+	return concatFilePath, writeConcatFileEntries(concatEntries, concatFilePath)
+}
+
+// matchRanges returns the concat demuxer file entries for the parts of storageFiles within ranges.
+func (c *concater) matchRanges(storageFiles []fileWithDate, ranges []timeRange) ([]concatFileEntry, error) {
+	var concatEntries []concatFileEntry
+	for _, r := range ranges {
+		concatEntries = append(concatEntries, matchStorageToRange(storageFiles, r.from, r.to, c.logger)...)
+	}
+	if len(concatEntries) == 0 {
+		return nil, errors.New("no matching video data to save")
+	}
+	return concatEntries, nil
+}
+
+// removeConcatFile removes a concat file after the operation using it is complete.
+func (c *concater) removeConcatFile(concatFilePath string) {
+	if concatFilePath == "" {
+		return
+	}
+	if _, err := os.Stat(concatFilePath); err == nil {
+		if err := os.Remove(concatFilePath); err != nil {
+			c.logger.Error("failed to remove concat file %s, err: %s", concatFilePath, err.Error())
+		}
+	}
+}
+
 // writeConcatFileEntries writes the concat file entries to a file.
 func writeConcatFileEntries(entries []concatFileEntry, filePath string) error {
 	file, err := os.Create(filePath)
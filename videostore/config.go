@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"go.viam.com/rdk/components/camera"
 )
@@ -45,18 +47,92 @@ type Config struct {
 	Storage     StorageConfig
 	Encoder     EncoderConfig
 	FramePoller FramePollerConfig
+	// TimestampSmoothing smooths the timestamps of packets written from RTP.
+	TimestampSmoothing TimestampSmoothingConfig
+	// DuplicatePTS is how consecutive packets written from RTP with the same PTS are
+	// handled. The zero value passes them through, callers opt in to coalescing them.
+	DuplicatePTS DuplicatePTSPolicy
+	// KeyframeRequest is how recording from RTP resumes when packets arrive mid-GOP.
+	KeyframeRequest KeyframeRequestPolicy
+	// RTPHeader is how packets written from RTP which still have their RTP header are handled.
+	RTPHeader RTPHeaderPolicy
+	// MemoryPressure is how memory is shed as the process nears its cgroup memory limit.
+	MemoryPressure MemoryPressurePolicy
+	// MixedCodec is how fetching and saving a range spanning a change of codec is handled.
+	MixedCodec MixedCodecPolicy
+	// DiskFull is how an export without the disk space to be written is handled.
+	DiskFull DiskFullPolicy
+	// SegmentDuration is how the durations of segments written from RTP are reported.
+	SegmentDuration SegmentDurationPolicy
+	// SegmentTimestamps is how the start times of segments written from RTP are found.
+	SegmentTimestamps SegmentTimestampPolicy
+	// PayloadOwnership is whether payloads passed to RawSegmenter.WritePacket are copied or borrowed.
+	PayloadOwnership PayloadOwnershipPolicy
+}
+
+// DuplicatePTSPolicy is how RawSegmenter.WritePacket handles a packet with the same PTS
+// as the packet before it. Some sources split an access unit across packets, e.g. the SPS
+// and PPS ahead of an IDR, which the muxer rejects if each is written as a frame.
+type DuplicatePTSPolicy int
+
+const (
+	// DuplicatePTSPassThrough writes every packet as it arrives, leaving packets with the
+	// same PTS to the muxer.
+	DuplicatePTSPassThrough DuplicatePTSPolicy = iota
+	// DuplicatePTSCoalesce joins consecutive packets with the same PTS into one access
+	// unit, which is a keyframe if any of them is. Each access unit is written once the
+	// first packet of the next arrives, or the segmenter is closed.
+	DuplicatePTSCoalesce
+	// DuplicatePTSDrop writes the first packet with each PTS and drops the rest, for
+	// sources which repeat whole access units, e.g. on retransmission.
+	DuplicatePTSDrop
+	// DuplicatePTSReject returns ErrDuplicatePTS without writing the packet.
+	DuplicatePTSReject
+)
+
+// Validate returns an error if the DuplicatePTSPolicy is invalid.
+func (p DuplicatePTSPolicy) Validate() error {
+	if p < DuplicatePTSPassThrough || p > DuplicatePTSReject {
+		return fmt.Errorf("invalid duplicate pts policy %d", p)
+	}
+	return nil
+}
+
+// MixedCodecPolicy is how Fetch and Save handle a range spanning segments recorded in
+// different codecs, e.g. after the source switched from H.264 to H.265, which can't be
+// concatenated into one output as is.
+type MixedCodecPolicy int
+
+const (
+	// MixedCodecSplit writes a separate output for each run of segments in the same codec.
+	MixedCodecSplit MixedCodecPolicy = iota
+	// MixedCodecTranscode re-encodes the whole range to H.264 into one output. Ranges in
+	// a single codec are still concatenated as is.
+	MixedCodecTranscode
+)
+
+// Validate returns an error if the MixedCodecPolicy is invalid.
+func (p MixedCodecPolicy) Validate() error {
+	if p != MixedCodecSplit && p != MixedCodecTranscode {
+		return fmt.Errorf("invalid mixed codec policy %d", p)
+	}
+	return nil
 }
 
 // Validate returns an error if the Config is invalid.
 func (c *Config) Validate() error {
-	if c.Type == SourceTypeUnknown {
-		return fmt.Errorf("video store type can't be %s", c.Type)
+	if err := c.validateType(); err != nil {
+		return err
 	}
 
 	if err := c.Storage.Validate(); err != nil {
 		return err
 	}
 
+	if err := firstError(c.policyChecks()); err != nil {
+		return err
+	}
+
 	if c.Type == SourceTypeFrame {
 		if err := c.Encoder.Validate(); err != nil {
 			return err
@@ -70,43 +146,343 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+func (c *Config) validateType() error {
+	switch c.Type {
+	case SourceTypeFrame, SourceTypeRTP, SourceTypeReadOnly:
+		return nil
+	case SourceTypeUnknown:
+		return fmt.Errorf("video store type can't be %s", c.Type)
+	default:
+		return fmt.Errorf("unsupported video store type %d", c.Type)
+	}
+}
+
+// policyChecks returns the checks of the Config's policies.
+func (c *Config) policyChecks() []configCheck {
+	segmentDurationErr := c.SegmentDuration.Validate()
+	if segmentDurationErr == nil && c.SegmentDuration == SegmentDurationExact && c.Storage.Flush != (FlushPolicy{}) {
+		segmentDurationErr = errExactSegmentDurationFlush
+	}
+	return []configCheck{
+		{"timestamp_smoothing", c.TimestampSmoothing.Validate()},
+		{"duplicate_pts", c.DuplicatePTS.Validate()},
+		{"keyframe_request", c.KeyframeRequest.Validate()},
+		{"rtp_header", c.RTPHeader.Validate()},
+		{"memory_pressure", c.MemoryPressure.Validate()},
+		{"mixed_codec", c.MixedCodec.Validate()},
+		{"disk_full", c.DiskFull.Validate()},
+		{"segment_duration", segmentDurationErr},
+		{"segment_timestamps", c.SegmentTimestamps.Validate()},
+		{"payload_ownership", c.PayloadOwnership.Validate()},
+	}
+}
+
+// checks returns the checks of every field of the Config, in the order Validate makes them.
+func (c *Config) checks() []configCheck {
+	checks := []configCheck{{"type", c.validateType()}}
+	checks = append(checks, c.Storage.checks()...)
+	checks = append(checks, c.policyChecks()...)
+	if c.Type == SourceTypeFrame {
+		checks = append(checks, c.Encoder.checks()...)
+		checks = append(checks, c.FramePoller.checks()...)
+	}
+	return checks
+}
+
+// configCheck is the result of checking one config field. Validate methods return the
+// first failed check, while ValidateConfig reports all of them.
+type configCheck struct {
+	field string
+	err   error
+}
+
+// firstError returns the error of the first failed check, or nil if none failed.
+func firstError(checks []configCheck) error {
+	for _, check := range checks {
+		if check.err != nil {
+			return check.err
+		}
+	}
+	return nil
+}
+
+// notBlank returns an error if the value of field is blank.
+func notBlank(field, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s can't be blank", field)
+	}
+	return nil
+}
+
+// positive returns an error if the value of field is less than or equal to 0.
+func positive(field string, value int) error {
+	if value <= 0 {
+		return fmt.Errorf("%s can't be less than or equal to 0", field)
+	}
+	return nil
+}
+
+// notNegative returns an error if the value of field is less than 0.
+func notNegative(field string, value int) error {
+	if value < 0 {
+		return fmt.Errorf("%s can't be less than 0", field)
+	}
+	return nil
+}
+
 // StorageConfig is the config for storage.
 type StorageConfig struct {
 	SizeGB               int
 	OutputFileNamePrefix string
 	UploadPath           string
 	StoragePath          string
+	// Flush controls how often the segment being written from RTP packets is flushed to disk.
+	Flush FlushPolicy
+	// Live, if its Path is set, records segments to a fast path such as tmpfs and
+	// moves them to StoragePath once they are complete.
+	Live LiveStorageConfig
+	// Alerts are the thresholds at which storage growth is reported as abnormal.
+	Alerts GrowthAlertConfig
+	// OpenRetry controls how opening each segment file is retried when file descriptors run out.
+	OpenRetry OpenRetryPolicy
+	// Latency adapts writing the segment from RTP packets when storage writes are slow.
+	Latency LatencyPolicy
+	// BufferPool, if its Buffers is set, reuses the C buffers RTP packets are copied into to be written.
+	BufferPool BufferPoolConfig
+	// Remount is how often the storage and record paths are checked for a remount.
+	Remount RemountPolicy
+	// Archive, if its Path is set, stream copies each complete day of segments into one archive file.
+	Archive ArchiveConfig
+	// ExportInputPath is the directory the files exports read from the machine by path,
+	// such as subtitles, watermark images, telemetry CSVs, detections and bumpers, must
+	// be in. Their paths are relative to it, or absolute within it. Exports can't read
+	// files by path if it is blank.
+	ExportInputPath string
 }
 
-// Validate returns an error if the StorageConfig is invalid.
-func (c StorageConfig) Validate() error {
-	var zero StorageConfig
-	if c == zero {
-		return errors.New("video config can't be empty")
+// LiveStorageConfig is the config for recording to a fast live path.
+type LiveStorageConfig struct {
+	Path string
+	// SizeMB is the budget for segments in Path which haven't been moved to the
+	// storage path. 0 uses 256MB.
+	SizeMB int
+	// SyncInterval is how often completed segments are moved to the storage path.
+	// 0 uses the segment duration.
+	SyncInterval time.Duration
+}
+
+// Validate returns an error if the LiveStorageConfig is invalid.
+func (c LiveStorageConfig) Validate(storagePath string) error {
+	if c.Path == "" {
+		if c != (LiveStorageConfig{}) {
+			return errors.New("live path can't be blank when live storage is configured")
+		}
+		return nil
+	}
+	if filepath.Clean(c.Path) == filepath.Clean(storagePath) {
+		return errors.New("live path can't be the same as storage_path")
+	}
+	if c.SizeMB < 0 {
+		return errors.New("live size_mb can't be less than 0")
+	}
+	if c.SyncInterval < 0 {
+		return errors.New("live sync interval can't be less than 0")
+	}
+	return nil
+}
+
+func (c LiveStorageConfig) sizeMB() int {
+	if c.SizeMB == 0 {
+		return defaultLiveSizeMB
+	}
+	return c.SizeMB
+}
+
+func (c LiveStorageConfig) syncInterval() time.Duration {
+	if c.SyncInterval == 0 {
+		return segmentSeconds * time.Second
 	}
+	return c.SyncInterval
+}
 
-	if c.SizeGB <= 0 {
-		return errors.New("size_gb can't be less than or equal to 0")
+// ArchiveConfig is the config for archiving a day of segments into one file for cold storage.
+type ArchiveConfig struct {
+	// Path is the directory archives are written to. Archives aren't counted against
+	// SizeGB and are never deleted.
+	Path string
+	// DeleteOriginals deletes segments from the storage path once they are archived.
+	DeleteOriginals bool
+}
+
+// Validate returns an error if the ArchiveConfig is invalid.
+func (c ArchiveConfig) Validate(storagePath string) error {
+	if c.Path == "" {
+		if c.DeleteOriginals {
+			return errors.New("archive path can't be blank when delete originals is set")
+		}
+		return nil
 	}
+	if filepath.Clean(c.Path) == filepath.Clean(storagePath) {
+		return errors.New("archive path can't be the same as storage_path")
+	}
+	return nil
+}
 
-	if c.UploadPath == "" {
-		return errors.New("upload_path can't be blank")
+// recordPath returns the path new segments are written to.
+func (c StorageConfig) recordPath() string {
+	if c.Live.Path != "" {
+		return c.Live.Path
 	}
+	return c.StoragePath
+}
+
+// FlushPolicy trades durability for throughput when writing a segment.
+// At most one field may be set. By default nothing is flushed and a segment is only
+// playable once it is complete, so a crash loses the whole segment being written.
+// When a policy is set segments are written as fragmented mp4 and a crash only loses
+// what was written since the last flush.
+type FlushPolicy struct {
+	// Packets flushes after every Packets packets.
+	Packets int
+	// Interval flushes once Interval has passed since the last flush.
+	Interval time.Duration
+	// OnIDR flushes the previous GOP when each IDR frame is written.
+	OnIDR bool
+}
 
-	if c.StoragePath == "" {
-		return errors.New("storage_path can't be blank")
+// Validate returns an error if the FlushPolicy is invalid.
+func (p FlushPolicy) Validate() error {
+	if p.Packets < 0 {
+		return errors.New("flush packets can't be less than 0")
+	}
+	if p.Interval < 0 {
+		return errors.New("flush interval can't be less than 0")
+	}
+	set := 0
+	for _, isSet := range []bool{p.Packets > 0, p.Interval > 0, p.OnIDR} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("only one of flush packets, interval or on_idr can be set")
 	}
+	return nil
+}
 
-	if c.OutputFileNamePrefix == "" {
-		return errors.New("output_file_name_prefix can't be blank")
+const (
+	// defaultOpenAttempts and defaultOpenBackoff retry opening a segment for 150ms in total.
+	defaultOpenAttempts = 5
+	defaultOpenBackoff  = 10 * time.Millisecond
+)
+
+// OpenRetryPolicy retries opening a segment file, e.g. on rollover to the next segment,
+// while it fails because the process or system is out of file descriptors, rather
+// than dropping footage.
+type OpenRetryPolicy struct {
+	// Attempts is how many times opening is tried in total. 0 uses 5, 1 disables retries.
+	Attempts int
+	// Backoff is the wait before the first retry, doubled after each. 0 uses 10ms.
+	Backoff time.Duration
+}
+
+// Validate returns an error if the OpenRetryPolicy is invalid.
+func (p OpenRetryPolicy) Validate() error {
+	if p.Attempts < 0 {
+		return errors.New("open retry attempts can't be less than 0")
+	}
+	if p.Backoff < 0 {
+		return errors.New("open retry backoff can't be less than 0")
 	}
 	return nil
 }
 
+func (p OpenRetryPolicy) attempts() int {
+	if p.Attempts == 0 {
+		return defaultOpenAttempts
+	}
+	return p.Attempts
+}
+
+func (p OpenRetryPolicy) backoff() time.Duration {
+	if p.Backoff == 0 {
+		return defaultOpenBackoff
+	}
+	return p.Backoff
+}
+
+// Validate returns an error if the StorageConfig is invalid.
+func (c StorageConfig) Validate() error {
+	var zero StorageConfig
+	if c == zero {
+		return errors.New("video config can't be empty")
+	}
+	return firstError(c.checks())
+}
+
+// checks returns the checks of every field of the StorageConfig.
+func (c StorageConfig) checks() []configCheck {
+	sizeErr := positive("size_gb", c.SizeGB)
+	if sizeErr == nil {
+		_, sizeErr = sizeGBToBytes(c.SizeGB)
+	}
+	return []configCheck{
+		{"size_gb", sizeErr},
+		{"upload_path", notBlank("upload_path", c.UploadPath)},
+		{"output_file_name_prefix", notBlank("output_file_name_prefix", c.OutputFileNamePrefix)},
+		{"storage_path", notBlank("storage_path", c.StoragePath)},
+		{"flush", c.Flush.Validate()},
+		{"open_retry", c.OpenRetry.Validate()},
+		{"latency", c.Latency.Validate()},
+		{"buffer_pool", c.BufferPool.Validate()},
+		{"remount", c.Remount.Validate()},
+		{"live", c.Live.Validate(c.StoragePath)},
+		{"archive", c.Archive.Validate(c.StoragePath)},
+		{"alerts", c.Alerts.Validate()},
+	}
+}
+
+// DefaultThreads is how many threads each FFmpeg codec context uses when EncoderConfig.Threads
+// is 0. It is kept low so recording doesn't starve other processes on shared hardware.
+const DefaultThreads = 2
+
+// maxSceneCutThreshold is the largest x264 scenecut threshold, which places a keyframe on nearly every frame.
+const maxSceneCutThreshold = 100
+
 // EncoderConfig is the config for the video encoder.
 type EncoderConfig struct {
 	Bitrate int
 	Preset  string
+	// SceneCutThreshold enables keyframes on scene changes when greater than 0.
+	// Keyframes are then only forced at segment boundaries instead of every second.
+	SceneCutThreshold int
+	// Threads is how many threads each FFmpeg decoder, encoder and filter graph used
+	// to encode and transcode video may use. 0 uses DefaultThreads.
+	Threads int
+	// HardwareAccel, if set, exports with a hardware encoder: one of hardwareAccels.
+	// Exports fall back to libx264 if the hardware can't be set up.
+	HardwareAccel string
+	// HardwareDevice optionally names the device HardwareAccel opens, e.g. /dev/dri/renderD128.
+	HardwareDevice string
+}
+
+// hardwareAccels are the supported EncoderConfig.HardwareAccel values. "auto" tries
+// VAAPI, then NVENC through CUDA, then Quick Sync.
+var hardwareAccels = []string{"auto", "vaapi", "cuda", "qsv"}
+
+func validateHardwareAccel(hwAccel string) error {
+	if hwAccel != "" && !slices.Contains(hardwareAccels, hwAccel) {
+		return fmt.Errorf("invalid hardware_accel %s, must be one of: %s", hwAccel, strings.Join(hardwareAccels, ", "))
+	}
+	return nil
+}
+
+// threads returns the thread count to give FFmpeg codec contexts.
+func (c EncoderConfig) threads() int {
+	if c.Threads == 0 {
+		return DefaultThreads
+	}
+	return c.Threads
 }
 
 // Validate returns an error if the EncoderConfig is invalid.
@@ -115,13 +491,30 @@ func (c EncoderConfig) Validate() error {
 	if c == zero {
 		return errors.New("video config can't be empty")
 	}
+	return firstError(c.checks())
+}
 
-	if c.Bitrate <= 0 {
-		return errors.New("bitrate can't be less than or equal to 0")
+// checks returns the checks of every field of the EncoderConfig.
+func (c EncoderConfig) checks() []configCheck {
+	return []configCheck{
+		{"bitrate", positive("bitrate", c.Bitrate)},
+		{"preset", validatePreset(c.Preset)},
+		{"scenecut_threshold", validateSceneCutThreshold(c.SceneCutThreshold)},
+		{"threads", notNegative("threads", c.Threads)},
+		{"hardware_accel", validateHardwareAccel(c.HardwareAccel)},
 	}
+}
+
+func validatePreset(preset string) error {
+	if _, ok := presets[preset]; !ok {
+		return fmt.Errorf("preset invalid: value: %s, must be one of: %s", preset, strings.Join(slices.Sorted(maps.Keys(presets)), ", "))
+	}
+	return nil
+}
 
-	if _, ok := presets[c.Preset]; !ok {
-		return fmt.Errorf("preset invalid: value: %s, must be one of: %s", c.Preset, strings.Join(slices.Sorted(maps.Keys(presets)), ", "))
+func validateSceneCutThreshold(threshold int) error {
+	if threshold < 0 || threshold > maxSceneCutThreshold {
+		return fmt.Errorf("scenecut_threshold must be between 0 and %d", maxSceneCutThreshold)
 	}
 	return nil
 }
@@ -139,14 +532,123 @@ func (c FramePollerConfig) Validate() error {
 	if c == zero {
 		return errors.New("frame_poller config can't be empty")
 	}
+	return firstError(c.checks())
+}
+
+// checks returns the checks of every field of the FramePollerConfig.
+func (c FramePollerConfig) checks() []configCheck {
+	var cameraErr error
+	if c.Camera == nil {
+		cameraErr = errors.New("camera must be provided")
+	}
+	return []configCheck{
+		{"framerate", positive("framerate", c.Framerate)},
+		{"camera", cameraErr},
+	}
+}
+
+// ConfigProblem is a single misconfiguration found by ValidateConfig.
+type ConfigProblem struct {
+	Field   string
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Field, p.Message)
+}
+
+// supportedCodecs are the codecs the segmenters can write into the videoFormat container.
+var supportedCodecs = map[SourceType][]CodecType{
+	SourceTypeFrame: {CodecTypeH264},
+	SourceTypeRTP:   {CodecTypeH264, CodecTypeH265},
+}
 
-	if c.Framerate <= 0 {
-		return errors.New("framerate can't be less than or equal to 0")
+// ValidateConfig checks the Config against the host it would run on and returns every
+// problem it finds rather than stopping at the first one: each check Validate makes,
+// then those of the host.
+// It has no side effects: directories are not created and nothing is written to disk.
+func ValidateConfig(c Config) []ConfigProblem {
+	var problems []ConfigProblem
+	add := func(field, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Field: field, Message: fmt.Sprintf(format, args...)})
 	}
 
-	if c.Camera == nil {
-		return errors.New("camera must be provided")
+	for _, check := range c.checks() {
+		if check.err != nil {
+			add(check.field, "%s", check.err.Error())
+		}
 	}
 
+	if c.Storage.StoragePath != "" {
+		problems = append(problems, validateStoragePath(c.Storage.StoragePath, c.Storage.SizeGB)...)
+	}
+	if c.Type == SourceTypeFrame && c.Encoder.Bitrate > 0 {
+		if maxStorageSize, err := sizeGBToBytes(c.Storage.SizeGB); err == nil && c.Storage.SizeGB > 0 {
+			segmentSize := int64(c.Encoder.Bitrate) / 8 * segmentSeconds
+			if segmentSize > maxStorageSize {
+				add("segment_seconds", "a %ds segment at %d bps (%d bytes) does not fit in size_gb %d",
+					segmentSeconds, c.Encoder.Bitrate, segmentSize, c.Storage.SizeGB)
+			}
+		}
+	}
+	if codecs, ok := supportedCodecs[c.Type]; ok {
+		for _, codec := range codecs {
+			if err := ValidateCodecContainer(codec, videoFormat); err != nil {
+				add("codec", "%s", err.Error())
+			}
+		}
+	}
+	problems = append(problems, validateCapabilities(c, GetCapabilities())...)
+	return problems
+}
+
+// ValidateCodecContainer returns an error if codec can't be stored in the container format.
+func ValidateCodecContainer(codec CodecType, container string) error {
+	if container != videoFormat {
+		return fmt.Errorf("container %s is not supported, must be %s", container, videoFormat)
+	}
+	if codec != CodecTypeH264 && codec != CodecTypeH265 {
+		return fmt.Errorf("codec %s can't be stored in %s", codec, container)
+	}
+	return nil
+}
+
+// validateStoragePath reports whether storagePath can be created and written to
+// and whether its filesystem has room for sizeGB of video.
+func validateStoragePath(storagePath string, sizeGB int) []ConfigProblem {
+	dir, err := nearestExistingDir(storagePath)
+	if err != nil {
+		return []ConfigProblem{{Field: "storage_path", Message: err.Error()}}
+	}
+	if err := isWritable(dir); err != nil {
+		return []ConfigProblem{{Field: "storage_path", Message: fmt.Sprintf("%s is not writable: %s", dir, err.Error())}}
+	}
+	// Invalid sizes are reported on their own.
+	maxStorageSize, err := sizeGBToBytes(sizeGB)
+	if sizeGB <= 0 || err != nil {
+		return nil
+	}
+	free, err := getFreeDiskSpace(dir)
+	if err != nil {
+		return []ConfigProblem{{Field: "storage_path", Message: fmt.Sprintf("failed to get free space of %s: %s", dir, err.Error())}}
+	}
+	// Video already in the storage path counts towards size_gb.
+	var used int64
+	if dir == filepath.Clean(storagePath) {
+		if used, err = getDirectorySize(dir); err != nil {
+			return []ConfigProblem{{Field: "storage_path", Message: fmt.Sprintf("failed to get size of %s: %s", dir, err.Error())}}
+		}
+	}
+	available, err := addSize(free, used)
+	if err != nil {
+		// More space than fits in an int64 is enough for any size_gb.
+		return nil
+	}
+	if available < maxStorageSize {
+		return []ConfigProblem{{
+			Field:   "size_gb",
+			Message: fmt.Sprintf("%d bytes are free at %s, size_gb %d needs %d bytes", available, dir, sizeGB, maxStorageSize),
+		}}
+	}
 	return nil
 }
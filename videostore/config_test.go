@@ -0,0 +1,144 @@
+package videostore
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func validRTPConfig(t *testing.T) Config {
+	dir := t.TempDir()
+	return Config{
+		Type: SourceTypeRTP,
+		Storage: StorageConfig{
+			SizeGB:               1,
+			OutputFileNamePrefix: "test",
+			UploadPath:           filepath.Join(dir, "upload"),
+			StoragePath:          filepath.Join(dir, "storage"),
+			ExportInputPath:      filepath.Join(dir, "inputs"),
+		},
+	}
+}
+
+// problemFields returns the Field of every problem.
+func problemFields(problems []ConfigProblem) []string {
+	fields := []string{}
+	for _, p := range problems {
+		fields = append(fields, p.Field)
+	}
+	return fields
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("Valid config has no problems", func(t *testing.T) {
+		config := validRTPConfig(t)
+		test.That(t, ValidateConfig(config), test.ShouldBeEmpty)
+		// No side effects
+		_, err := os.Stat(config.Storage.StoragePath)
+		test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+	})
+
+	t.Run("Unknown source type is reported", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeUnknown
+		test.That(t, problemFields(ValidateConfig(config)), test.ShouldContain, "type")
+	})
+
+	t.Run("Unwritable storage path is reported", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to any directory")
+		}
+		config := validRTPConfig(t)
+		dir := filepath.Join(t.TempDir(), "read-only")
+		test.That(t, os.Mkdir(dir, 0o500), test.ShouldBeNil)
+		config.Storage.StoragePath = filepath.Join(dir, "storage")
+		problems := ValidateConfig(config)
+		test.That(t, problemFields(problems), test.ShouldResemble, []string{"storage_path"})
+		test.That(t, problems[0].Message, test.ShouldEqual, dir+" is not writable: permission denied")
+	})
+
+	t.Run("Storage path under a file is reported", func(t *testing.T) {
+		config := validRTPConfig(t)
+		file := filepath.Join(t.TempDir(), "file")
+		test.That(t, os.WriteFile(file, []byte("not a dir"), 0o600), test.ShouldBeNil)
+		config.Storage.StoragePath = filepath.Join(file, "storage")
+		problems := ValidateConfig(config)
+		test.That(t, problemFields(problems), test.ShouldResemble, []string{"storage_path"})
+		test.That(t, problems[0].Message, test.ShouldContainSubstring, "not a directory")
+	})
+
+	t.Run("Insufficient free space is reported", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Storage.SizeGB = 1 << 30
+		test.That(t, problemFields(ValidateConfig(config)), test.ShouldResemble, []string{"size_gb"})
+	})
+
+	t.Run("Segment larger than storage is reported", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeFrame
+		config.Encoder = EncoderConfig{Bitrate: 1 << 30, Preset: "medium"}
+		config.FramePoller = FramePollerConfig{Framerate: 20}
+		test.That(t, problemFields(ValidateConfig(config)), test.ShouldResemble, []string{"camera", "segment_seconds"})
+	})
+
+	t.Run("Exact segment durations with a flush policy are reported", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.SegmentDuration = SegmentDurationExact
+		config.Storage.Flush = FlushPolicy{Packets: 10}
+		problems := ValidateConfig(config)
+		test.That(t, problemFields(problems), test.ShouldResemble, []string{"segment_duration"})
+		test.That(t, problems[0].Message, test.ShouldEqual, config.Validate().Error())
+	})
+
+	t.Run("All problems are reported at once", func(t *testing.T) {
+		problems := ValidateConfig(Config{Type: SourceTypeFrame})
+		test.That(t, problemFields(problems), test.ShouldResemble, []string{
+			"size_gb", "upload_path", "output_file_name_prefix", "storage_path",
+			"bitrate", "preset", "framerate", "camera",
+		})
+	})
+}
+
+func TestValidateCodecContainer(t *testing.T) {
+	test.That(t, ValidateCodecContainer(CodecTypeH264, "mp4"), test.ShouldBeNil)
+	test.That(t, ValidateCodecContainer(CodecTypeH265, "mp4"), test.ShouldBeNil)
+	test.That(t, ValidateCodecContainer(CodecTypeUnknown, "mp4"), test.ShouldNotBeNil)
+	test.That(t, ValidateCodecContainer(CodecTypeH264, "mkv"), test.ShouldNotBeNil)
+}
+
+func TestStorageSizeOverflow(t *testing.T) {
+	t.Run("Largest size_gb fits in an int64", func(t *testing.T) {
+		size, err := sizeGBToBytes(maxSizeGB)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, size, test.ShouldEqual, int64(maxSizeGB)*gigabyte)
+		test.That(t, size, test.ShouldBeLessThanOrEqualTo, int64(math.MaxInt64))
+
+		config := validRTPConfig(t)
+		config.Storage.SizeGB = maxSizeGB
+		test.That(t, config.Validate(), test.ShouldBeNil)
+	})
+
+	t.Run("size_gb which would overflow is rejected", func(t *testing.T) {
+		_, err := sizeGBToBytes(maxSizeGB + 1)
+		test.That(t, err, test.ShouldNotBeNil)
+
+		config := validRTPConfig(t)
+		config.Storage.SizeGB = maxSizeGB + 1
+		test.That(t, config.Validate(), test.ShouldBeError, err)
+		test.That(t, problemFields(ValidateConfig(config)), test.ShouldResemble, []string{"size_gb"})
+		_, err = NewRTPVideoStore(config, logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("Size accumulation which would overflow is rejected", func(t *testing.T) {
+		total, err := addSize(math.MaxInt64-10, 10)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, total, test.ShouldEqual, int64(math.MaxInt64))
+		_, err = addSize(math.MaxInt64-10, 11)
+		test.That(t, err, test.ShouldBeError, "size overflows int64")
+	})
+}
@@ -0,0 +1,78 @@
+package videostore
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// dataChannelChunkSize is the largest message sent over a data channel.
+	// Messages above 16KiB are not reliably delivered across browsers.
+	dataChannelChunkSize = 16 * 1024
+	// dataChannelMaxBuffered is how many bytes may be queued on a data channel before sends block.
+	dataChannelMaxBuffered = 1024 * 1024
+	// dataChannelLowThreshold is the buffered amount at which blocked sends resume.
+	dataChannelLowThreshold = 256 * 1024
+)
+
+// DataChannel is the subset of a WebRTC data channel (e.g. *webrtc.DataChannel from pion)
+// needed to send video to a remote peer.
+type DataChannel interface {
+	Send(data []byte) error
+	BufferedAmount() uint64
+	SetBufferedAmountLowThreshold(th uint64)
+	OnBufferedAmountLow(f func())
+}
+
+// FetchToDataChannel streams the video matching r over an open data channel in chunks.
+// Sending pauses while the channel's buffered amount is above dataChannelMaxBuffered
+// and resumes once the remote peer has drained it below dataChannelLowThreshold.
+// The caller owns dc and is responsible for signalling the end of the clip, e.g. by closing it.
+func FetchToDataChannel(ctx context.Context, vs VideoStore, r *FetchRequest, dc DataChannel) error {
+	return vs.FetchClipTo(ctx, r, newDataChannelWriter(ctx, dc))
+}
+
+// dataChannelWriter is an io.Writer which sends its input over a DataChannel.
+type dataChannelWriter struct {
+	ctx context.Context
+	dc  DataChannel
+	low chan struct{}
+}
+
+func newDataChannelWriter(ctx context.Context, dc DataChannel) *dataChannelWriter {
+	w := &dataChannelWriter{
+		ctx: ctx,
+		dc:  dc,
+		low: make(chan struct{}, 1),
+	}
+	dc.SetBufferedAmountLowThreshold(dataChannelLowThreshold)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case w.low <- struct{}{}:
+		default:
+		}
+	})
+	return w
+}
+
+// Write splits p into chunks of at most dataChannelChunkSize and sends them in order,
+// blocking while the data channel is backed up.
+func (w *dataChannelWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		for w.dc.BufferedAmount() > dataChannelMaxBuffered {
+			select {
+			case <-w.ctx.Done():
+				return written, w.ctx.Err()
+			case <-w.low:
+			}
+		}
+		n := min(len(p), dataChannelChunkSize)
+		if err := w.dc.Send(p[:n]); err != nil {
+			return written, fmt.Errorf("failed to send on data channel: %w", err)
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,130 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+var _ DataChannel = (*webrtc.DataChannel)(nil)
+
+// recordingDataChannel records the largest buffered amount seen before each send.
+type recordingDataChannel struct {
+	*webrtc.DataChannel
+	maxBufferedBeforeSend uint64
+}
+
+func (dc *recordingDataChannel) Send(data []byte) error {
+	dc.maxBufferedBeforeSend = max(dc.maxBufferedBeforeSend, dc.BufferedAmount())
+	return dc.DataChannel.Send(data)
+}
+
+// stuckDataChannel never drains its buffer.
+type stuckDataChannel struct{}
+
+func (stuckDataChannel) Send([]byte) error                    { return nil }
+func (stuckDataChannel) BufferedAmount() uint64               { return dataChannelMaxBuffered + 1 }
+func (stuckDataChannel) SetBufferedAmountLowThreshold(uint64) {}
+func (stuckDataChannel) OnBufferedAmountLow(func())           {}
+
+// newLoopbackDataChannel connects two peer connections in process and returns an
+// open data channel on the sending side along with every message the receiving side gets.
+func newLoopbackDataChannel(t *testing.T) (*webrtc.DataChannel, func() [][]byte) {
+	sender, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { sender.Close() })
+	receiver, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { receiver.Close() })
+
+	var (
+		mu       sync.Mutex
+		messages [][]byte
+	)
+	receiver.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			messages = append(messages, msg.Data)
+		})
+	})
+
+	dc, err := sender.CreateDataChannel("video", nil)
+	test.That(t, err, test.ShouldBeNil)
+	opened := make(chan struct{})
+	dc.OnOpen(func() { close(opened) })
+
+	offer, err := sender.CreateOffer(nil)
+	test.That(t, err, test.ShouldBeNil)
+	senderGathered := webrtc.GatheringCompletePromise(sender)
+	test.That(t, sender.SetLocalDescription(offer), test.ShouldBeNil)
+	<-senderGathered
+	test.That(t, receiver.SetRemoteDescription(*sender.LocalDescription()), test.ShouldBeNil)
+
+	answer, err := receiver.CreateAnswer(nil)
+	test.That(t, err, test.ShouldBeNil)
+	receiverGathered := webrtc.GatheringCompletePromise(receiver)
+	test.That(t, receiver.SetLocalDescription(answer), test.ShouldBeNil)
+	<-receiverGathered
+	test.That(t, sender.SetRemoteDescription(*receiver.LocalDescription()), test.ShouldBeNil)
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for data channel to open")
+	}
+	return dc, func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return messages
+	}
+}
+
+func TestDataChannelWriter(t *testing.T) {
+	t.Run("Clip is sent in order in bounded chunks", func(t *testing.T) {
+		dc, received := newLoopbackDataChannel(t)
+		clip := make([]byte, 8*dataChannelMaxBuffered+123)
+		_, err := rand.Read(clip)
+		test.That(t, err, test.ShouldBeNil)
+
+		recorder := &recordingDataChannel{DataChannel: dc}
+		w := newDataChannelWriter(context.Background(), recorder)
+		n, err := w.Write(clip)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, n, test.ShouldEqual, len(clip))
+		test.That(t, recorder.maxBufferedBeforeSend, test.ShouldBeLessThanOrEqualTo, dataChannelMaxBuffered)
+
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			var got []byte
+			messages := received()
+			for _, msg := range messages {
+				test.That(t, len(msg), test.ShouldBeLessThanOrEqualTo, dataChannelChunkSize)
+				got = append(got, msg...)
+			}
+			if len(got) == len(clip) {
+				test.That(t, bytes.Equal(got, clip), test.ShouldBeTrue)
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("received %d of %d bytes", len(got), len(clip))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("Blocked send returns when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		w := newDataChannelWriter(ctx, stuckDataChannel{})
+		n, err := w.Write([]byte("clip"))
+		test.That(t, err, test.ShouldBeError, context.DeadlineExceeded)
+		test.That(t, n, test.ShouldEqual, 0)
+	})
+}
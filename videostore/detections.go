@@ -0,0 +1,207 @@
+package videostore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"slices"
+	"time"
+)
+
+// defaultDetectionHold is how long a detection lasts by default when no sample follows it.
+const defaultDetectionHold = time.Second
+
+// Detections trims an export down to the intervals where external object detections,
+// e.g. those of an ML vision service run over the video, found an object of interest,
+// and optionally crops it to the objects. One of Samples or Path must be set.
+type Detections struct {
+	// Samples are the detections in time order, one per frame the detector ran on. A
+	// sample without detections marks the frame as having nothing in it.
+	Samples []DetectionSample
+	// Path is the path of a JSON file in the export input path holding a list of samples, in the
+	// schema of DetectionSample with RFC 3339 times.
+	Path string
+	// Labels are the labels of the objects of interest. Empty matches every label.
+	Labels []string
+	// MinConfidence is the confidence, from 0 to 1, below which detections are ignored.
+	MinConfidence float64
+	// Zone, if set, only matches detections whose box overlaps it, in pixels of the
+	// source frame.
+	Zone *CropRegion
+	// Padding is how much video to keep before and after each interval.
+	Padding time.Duration
+	// Hold is how long a matching sample lasts when the next sample is further away or
+	// there is none. 0 uses 1 second.
+	Hold time.Duration
+	// Crop, if set, crops the export to the smallest region holding every matching box
+	// in the exported intervals.
+	Crop bool
+}
+
+// DetectionSample is the objects a detector found in the frame at Time.
+type DetectionSample struct {
+	Time       time.Time   `json:"time"`
+	Detections []Detection `json:"detections"`
+}
+
+// Detection is an object found in a frame and its bounding box, in pixels of the source
+// frame from the top left corner.
+type Detection struct {
+	Label      string  `json:"label"`
+	Confidence float64 `json:"confidence"`
+	XMin       int     `json:"x_min"`
+	YMin       int     `json:"y_min"`
+	XMax       int     `json:"x_max"`
+	YMax       int     `json:"y_max"`
+}
+
+func (d Detection) box() image.Rectangle {
+	return image.Rect(d.XMin, d.YMin, d.XMax, d.YMax)
+}
+
+// Validate returns an error if the Detections are invalid.
+func (f *Detections) Validate() error {
+	if (len(f.Samples) == 0) == (f.Path == "") {
+		return errors.New("exactly one of detections samples or path must be set")
+	}
+	for i, label := range f.Labels {
+		if label == "" {
+			return fmt.Errorf("detections label %d can't be blank", i)
+		}
+	}
+	if f.MinConfidence < 0 || f.MinConfidence > 1 {
+		return errors.New("detections min confidence must be between 0 and 1")
+	}
+	if z := f.Zone; z != nil {
+		if z.X < 0 || z.Y < 0 {
+			return errors.New("detections zone x and y can't be less than 0")
+		}
+		if z.Width <= 0 || z.Height <= 0 {
+			return errors.New("detections zone width and height must be greater than 0")
+		}
+	}
+	if f.Padding < 0 {
+		return errors.New("detections padding can't be less than 0")
+	}
+	if f.Hold < 0 {
+		return errors.New("detections hold can't be less than 0")
+	}
+	if len(f.Samples) > 0 {
+		return validateDetectionSamples(f.Samples)
+	}
+	return nil
+}
+
+// validateDetectionSamples returns an error if samples are out of order or hold boxes
+// which aren't in a frame.
+func validateDetectionSamples(samples []DetectionSample) error {
+	for i, s := range samples {
+		if i > 0 && !s.Time.After(samples[i-1].Time) {
+			return fmt.Errorf("detections sample %d is not after the previous sample", i)
+		}
+		for j, d := range s.Detections {
+			if d.XMin < 0 || d.YMin < 0 || d.XMin >= d.XMax || d.YMin >= d.YMax {
+				return fmt.Errorf("detections sample %d detection %d has an invalid box", i, j)
+			}
+			if d.Confidence < 0 || d.Confidence > 1 {
+				return fmt.Errorf("detections sample %d detection %d confidence must be between 0 and 1", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// samples returns the Samples, or those read from the JSON file at Path.
+func (f *Detections) samples() ([]DetectionSample, error) {
+	if f.Path == "" {
+		return f.Samples, nil
+	}
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read detections: %w", err)
+	}
+	var samples []DetectionSample
+	if err := json.Unmarshal(b, &samples); err != nil {
+		return nil, fmt.Errorf("failed to parse detections: %w", err)
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("detections file has no samples")
+	}
+	if err := validateDetectionSamples(samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// matches returns whether d is an object of interest.
+func (f *Detections) matches(d Detection) bool {
+	if len(f.Labels) > 0 && !slices.Contains(f.Labels, d.Label) {
+		return false
+	}
+	if d.Confidence < f.MinConfidence {
+		return false
+	}
+	if z := f.Zone; z != nil {
+		return d.box().Overlaps(image.Rect(z.X, z.Y, z.X+z.Width, z.Y+z.Height))
+	}
+	return true
+}
+
+// detectionRanges returns the sorted, non-overlapping ranges between from and to where
+// samples have a matching detection, widened by f's padding, and the smallest box holding
+// the matching detections in them. A matching sample lasts until the next sample, or at
+// most f's hold.
+func detectionRanges(samples []DetectionSample, from, to time.Time, f Detections) ([]timeRange, image.Rectangle) {
+	hold := f.Hold
+	if hold == 0 {
+		hold = defaultDetectionHold
+	}
+	var (
+		ranges []timeRange
+		box    image.Rectangle
+	)
+	for i, s := range samples {
+		var boxes []image.Rectangle
+		for _, d := range s.Detections {
+			if f.matches(d) {
+				boxes = append(boxes, d.box())
+			}
+		}
+		if len(boxes) == 0 {
+			continue
+		}
+		end := s.Time.Add(hold)
+		if i+1 < len(samples) {
+			end = minTime(end, samples[i+1].Time)
+		}
+		r := timeRange{from: maxTime(s.Time.Add(-f.Padding), from), to: minTime(end.Add(f.Padding), to)}
+		if !r.from.Before(r.to) {
+			continue
+		}
+		for _, b := range boxes {
+			box = box.Union(b)
+		}
+		if n := len(ranges); n > 0 && !r.from.After(ranges[n-1].to) {
+			ranges[n-1].to = maxTime(ranges[n-1].to, r.to)
+		} else {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges, box
+}
+
+// detectionCrop returns the crop region of box in a frame of size, widened to even
+// coordinates as exports are encoded as yuv420p.
+func detectionCrop(box image.Rectangle, size image.Point) (CropRegion, error) {
+	box = box.Intersect(image.Rect(0, 0, size.X&^1, size.Y&^1))
+	box.Min.X &^= 1
+	box.Min.Y &^= 1
+	box.Max.X = min((box.Max.X+1)&^1, size.X&^1)
+	box.Max.Y = min((box.Max.Y+1)&^1, size.Y&^1)
+	if box.Empty() {
+		return CropRegion{}, fmt.Errorf("detections are outside of the %dx%d frame", size.X, size.Y)
+	}
+	return CropRegion{X: box.Min.X, Y: box.Min.Y, Width: box.Dx(), Height: box.Dy()}, nil
+}
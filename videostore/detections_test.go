@@ -0,0 +1,144 @@
+package videostore
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestDetections(t *testing.T) {
+	const framerate = 10
+	frames := make([][]byte, 10*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(seconds float64) time.Time {
+		return start.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	// The detector ran twice a second. A person is seen from 2s to 4s, a car from 6s to
+	// 7s, and a car it isn't sure of from 8s to 9s.
+	person := Detection{Label: "person", Confidence: 0.9, XMin: 101, YMin: 50, XMax: 301, YMax: 251}
+	car := Detection{Label: "car", Confidence: 0.8, XMin: 400, YMin: 300, XMax: 500, YMax: 400}
+	unsure := Detection{Label: "car", Confidence: 0.3, XMin: 400, YMin: 300, XMax: 500, YMax: 400}
+	var samples []DetectionSample
+	for i := range 20 {
+		s := DetectionSample{Time: at(float64(i) / 2)}
+		switch {
+		case i >= 4 && i < 8:
+			s.Detections = []Detection{person}
+		case i >= 12 && i < 14:
+			s.Detections = []Detection{car}
+		case i >= 16 && i < 18:
+			s.Detections = []Detection{unsure}
+		}
+		samples = append(samples, s)
+	}
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	t.Run("Intervals with matching detections are selected", func(t *testing.T) {
+		ranges, box := detectionRanges(samples, start, at(10), Detections{Labels: []string{"person"}})
+		test.That(t, ranges, test.ShouldResemble, []timeRange{{from: at(2), to: at(4)}})
+		test.That(t, box, test.ShouldResemble, person.box())
+
+		ranges, _ = detectionRanges(samples, start, at(10), Detections{MinConfidence: 0.5})
+		test.That(t, ranges, test.ShouldResemble, []timeRange{{from: at(2), to: at(4)}, {from: at(6), to: at(7)}})
+
+		ranges, _ = detectionRanges(samples, start, at(10), Detections{})
+		test.That(t, len(ranges), test.ShouldEqual, 3)
+
+		ranges, box = detectionRanges(samples, start, at(10), Detections{Zone: &CropRegion{X: 450, Y: 350, Width: 10, Height: 10}})
+		test.That(t, ranges, test.ShouldResemble, []timeRange{{from: at(6), to: at(7)}, {from: at(8), to: at(9)}})
+		test.That(t, box, test.ShouldResemble, car.box())
+	})
+
+	t.Run("Padding widens and joins intervals within the range", func(t *testing.T) {
+		ranges, _ := detectionRanges(samples, at(1.5), at(10), Detections{MinConfidence: 0.5, Padding: time.Second})
+		// The person's interval ends at 5s once padded, where the car's padded interval starts.
+		test.That(t, ranges, test.ShouldResemble, []timeRange{{from: at(1.5), to: at(8)}})
+	})
+
+	t.Run("The last sample lasts for the hold", func(t *testing.T) {
+		last := []DetectionSample{{Time: at(1), Detections: []Detection{person}}}
+		ranges, _ := detectionRanges(last, start, at(10), Detections{})
+		test.That(t, ranges, test.ShouldResemble, []timeRange{{from: at(1), to: at(2)}})
+		ranges, _ = detectionRanges(last, start, at(10), Detections{Hold: 3 * time.Second})
+		test.That(t, ranges, test.ShouldResemble, []timeRange{{from: at(1), to: at(4)}})
+	})
+
+	t.Run("Only intervals with detections are exported", func(t *testing.T) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:       start,
+			To:         at(10),
+			Metadata:   "detections",
+			Detections: &Detections{Samples: samples, MinConfidence: 0.5},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 3, 0.25)
+		test.That(t, info.width, test.ShouldEqual, 640)
+	})
+
+	t.Run("Exports are cropped to the detected objects", func(t *testing.T) {
+		b, err := json.Marshal(samples)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, os.MkdirAll(config.Storage.ExportInputPath, 0o755), test.ShouldBeNil)
+		path := filepath.Join(config.Storage.ExportInputPath, "detections.json")
+		test.That(t, os.WriteFile(path, b, 0o600), test.ShouldBeNil)
+		r := &ExportRequest{
+			From:       start,
+			To:         at(10),
+			Metadata:   "detections_crop",
+			Detections: &Detections{Path: "detections.json", Labels: []string{"person"}, Crop: true},
+		}
+		res, err := vs.Export(context.Background(), r)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, r.Crop, test.ShouldBeNil)
+		info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 2, 0.25)
+		// The box is widened to even coordinates, from 100,50 to 302,252.
+		test.That(t, info.width, test.ShouldEqual, 202)
+		test.That(t, info.height, test.ShouldEqual, 202)
+	})
+
+	t.Run("Detections files must be in the export input path", func(t *testing.T) {
+		outside := filepath.Join(t.TempDir(), "detections.json")
+		test.That(t, os.WriteFile(outside, []byte("[]"), 0o600), test.ShouldBeNil)
+		_, err := vs.Export(context.Background(), &ExportRequest{From: start, To: at(10), Detections: &Detections{Path: outside}})
+		test.That(t, err, test.ShouldBeError, "export input "+outside+" is outside of the export input path")
+	})
+
+	t.Run("Ranges without detections are rejected", func(t *testing.T) {
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:       start,
+			To:         at(10),
+			Detections: &Detections{Samples: samples, Labels: []string{"dog"}},
+		})
+		test.That(t, err, test.ShouldBeError, "no detections found in time range")
+	})
+
+	t.Run("Invalid detections are rejected", func(t *testing.T) {
+		r := &ExportRequest{From: start, To: at(10), Detections: &Detections{Samples: samples, Path: "detections.json"}}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.Detections = &Detections{Samples: samples, Crop: true}
+		r.Crop = &CropRegion{Width: 2, Height: 2}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		poster := at(1)
+		r = &ExportRequest{From: start, To: at(10), Detections: &Detections{Samples: samples}, Poster: &poster}
+		test.That(t, r.Validate(), test.ShouldBeError, "detections can't be combined with a poster")
+		backwards := []DetectionSample{samples[1], samples[0]}
+		test.That(t, (&Detections{Samples: backwards}).Validate(), test.ShouldNotBeNil)
+		badBox := []DetectionSample{{Time: start, Detections: []Detection{{Label: "person", XMin: 10, XMax: 5, YMax: 5}}}}
+		test.That(t, (&Detections{Samples: badBox}).Validate(), test.ShouldNotBeNil)
+		_, err := detectionCrop(image.Rect(700, 500, 800, 600), image.Pt(640, 480))
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
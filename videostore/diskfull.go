@@ -0,0 +1,124 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DiskFullPolicy is how Export handles an upload path without the disk space for an
+// export, either estimated before it starts or found when a write fails with ENOSPC.
+// Either way the export fails with ErrDiskFull and its partial output is removed.
+type DiskFullPolicy int
+
+const (
+	// DiskFullFail fails the export.
+	DiskFullFail DiskFullPolicy = iota
+	// DiskFullCleanup first runs a storage cleanup, deleting the oldest segments over
+	// size_gb, to reclaim space. An export which doesn't fit before it starts is then
+	// checked again, one which ran out of space while written still fails but can be
+	// retried. Read-only stores, which never delete segments, fail instead.
+	DiskFullCleanup
+)
+
+// Validate returns an error if the DiskFullPolicy is invalid.
+func (p DiskFullPolicy) Validate() error {
+	if p != DiskFullFail && p != DiskFullCleanup {
+		return fmt.Errorf("invalid disk full policy %d", p)
+	}
+	return nil
+}
+
+// exportSize estimates the bytes an export of entries lasting duration takes to write,
+// from the encoder's bitrate or, without one, the bitrate the entries' segments were
+// stored at.
+func exportSize(entries []concatFileEntry, duration time.Duration, bitrate int) (int64, error) {
+	if bitrate > 0 {
+		return int64(float64(bitrate) / 8 * duration.Seconds()), nil
+	}
+	var (
+		bytes   int64
+		seconds float64
+	)
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if seen[e.filePath] {
+			continue
+		}
+		seen[e.filePath] = true
+		stat, err := os.Stat(e.filePath)
+		if err != nil {
+			return 0, err
+		}
+		info, err := getVideoInfo(e.filePath)
+		if err != nil {
+			return 0, err
+		}
+		bytes += stat.Size()
+		seconds += info.duration.Seconds()
+	}
+	if seconds <= 0 {
+		return 0, nil
+	}
+	return int64(float64(bytes) / seconds * duration.Seconds()), nil
+}
+
+// checkExportSpace returns ErrDiskFull if the upload path doesn't have room for an export
+// of size bytes, with a fifth more as the encoder doesn't keep to its bitrate exactly.
+// With DiskFullCleanup a cleanup runs first if it doesn't.
+func (vs *videostore) checkExportSpace(ctx context.Context, size int64) error {
+	needed := size + size/5
+	free, err := vs.uploadFreeSpace()
+	if err != nil {
+		return fmt.Errorf("failed to check free space for export: %w", err)
+	}
+	if free >= needed {
+		return nil
+	}
+	if vs.config.DiskFull == DiskFullCleanup && vs.cleaner != nil {
+		deleted, err := vs.cleaner.cleanup(ctx)
+		if err != nil {
+			vs.logger.Warnf("failed to clean up storage for export: %v", err)
+		}
+		vs.logger.Infof("export needs %d bytes but %d are free, cleanup deleted %d segments", needed, free, len(deleted))
+		if free, err = vs.uploadFreeSpace(); err != nil {
+			return fmt.Errorf("failed to check free space for export: %w", err)
+		}
+		if free >= needed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: export needs about %d bytes but %d are free at %s",
+		ErrDiskFull, needed, free, vs.config.Storage.UploadPath)
+}
+
+// uploadFreeSpace returns the bytes free on the upload path's filesystem.
+func (vs *videostore) uploadFreeSpace() (int64, error) {
+	if vs.freeDiskSpace != nil {
+		return vs.freeDiskSpace(vs.config.Storage.UploadPath)
+	}
+	return getFreeDiskSpace(vs.config.Storage.UploadPath)
+}
+
+// exportDiskFull returns err, from writing an export, as ErrDiskFull if it failed
+// because the disk is full, after running a cleanup with DiskFullCleanup so a retry can
+// fit.
+func (vs *videostore) exportDiskFull(ctx context.Context, err error) error {
+	if !errors.Is(err, unix.ENOSPC) {
+		return err
+	}
+	if vs.config.DiskFull == DiskFullCleanup && vs.cleaner != nil {
+		deleted, cleanupErr := vs.cleaner.cleanup(ctx)
+		if cleanupErr != nil {
+			vs.logger.Warnf("failed to clean up storage after export ran out of space: %v", cleanupErr)
+		} else {
+			return fmt.Errorf("%w: disk filled while writing, cleanup deleted %d segments to retry with",
+				ErrDiskFull, len(deleted))
+		}
+	}
+	return fmt.Errorf("%w: disk filled while writing: %w", ErrDiskFull, err)
+}
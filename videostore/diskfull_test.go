@@ -0,0 +1,95 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestExportDiskFull(t *testing.T) {
+	const framerate = 10
+	frames := make([][]byte, 4*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	request := func(metadata string) *ExportRequest {
+		return &ExportRequest{From: start, To: start.Add(3 * time.Second), Metadata: metadata}
+	}
+	outputPath := func(config Config, metadata string) string {
+		return generateOutputFilePath(config.Storage.OutputFileNamePrefix, start, metadata, config.Storage.UploadPath)
+	}
+	// newFullStore returns a store of its own whose upload path has free() bytes free.
+	newFullStore := func(t *testing.T, free func() int64) (*videostore, Config) {
+		vs, config := newTestExportStore(t, start, frames, framerate)
+		vs.freeDiskSpace = func(string) (int64, error) { return free(), nil }
+		return vs, config
+	}
+
+	t.Run("A write failing with ENOSPC leaves no partial file", func(t *testing.T) {
+		if _, err := os.Stat("/dev/full"); err != nil {
+			t.Skip("no /dev/full to fail writes with ENOSPC")
+		}
+		// Every write to /dev/full fails with ENOSPC, as if the disk filled.
+		path := outputPath(config, "enospc")
+		test.That(t, os.Symlink("/dev/full", path), test.ShouldBeNil)
+		_, err := vs.Export(context.Background(), request("enospc"))
+		test.That(t, errors.Is(err, ErrDiskFull), test.ShouldBeTrue)
+		_, err = os.Lstat(path)
+		test.That(t, errors.Is(err, os.ErrNotExist), test.ShouldBeTrue)
+		_, err = os.Stat(path + manifestExt)
+		test.That(t, errors.Is(err, os.ErrNotExist), test.ShouldBeTrue)
+	})
+
+	t.Run("An export which doesn't fit isn't started", func(t *testing.T) {
+		vs, config := newFullStore(t, func() int64 { return 1000 })
+		_, err := vs.Export(context.Background(), request("no_space"))
+		test.That(t, errors.Is(err, ErrDiskFull), test.ShouldBeTrue)
+		_, err = os.Stat(outputPath(config, "no_space"))
+		test.That(t, errors.Is(err, os.ErrNotExist), test.ShouldBeTrue)
+	})
+
+	t.Run("Cleanup reclaims space before the export is checked again", func(t *testing.T) {
+		var free int64 = 1000
+		vs, config := newFullStore(t, func() int64 { return free })
+		cleanups := 0
+		// The read-only store has no cleaner of its own.
+		vs.config.DiskFull = DiskFullCleanup
+		vs.cleaner = &storageCleaner{logger: vs.logger, run: func() ([]string, error) {
+			cleanups++
+			free = 1 << 40
+			return []string{"oldest.mp4"}, nil
+		}}
+		res, err := vs.Export(context.Background(), request("cleaned_up"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cleanups, test.ShouldEqual, 1)
+		_, err = os.Stat(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("The estimate follows the stored bitrate without an encoder bitrate", func(t *testing.T) {
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		stat, err := os.Stat(files[0].name)
+		test.That(t, err, test.ShouldBeNil)
+		info, err := getVideoInfo(files[0].name)
+		test.That(t, err, test.ShouldBeNil)
+		entries := []concatFileEntry{{filePath: files[0].name}}
+		size, err := exportSize(entries, info.duration/2, 0)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, float64(size), test.ShouldAlmostEqual, float64(stat.Size())/2, float64(stat.Size())/100)
+		size, err = exportSize(entries, 2*time.Second, 8000)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, size, test.ShouldEqual, 2000)
+	})
+
+	t.Run("Invalid policies are rejected", func(t *testing.T) {
+		test.That(t, DiskFullPolicy(2).Validate(), test.ShouldNotBeNil)
+	})
+}
@@ -1,4 +1,5 @@
 #include "encoder.h"
+#include "utils.h"
 #include "libavcodec/packet.h"
 #include "libavutil/log.h"
 #include "libavutil/rational.h"
@@ -6,6 +7,17 @@
 #include <libavutil/opt.h>
 #include <stdlib.h>
 // BEGIN internal functions
+// encoder_io_open opens each segment the segment muxer starts, retrying while
+// file descriptors are exhausted.
+static int encoder_io_open(struct AVFormatContext *s, AVIOContext **pb,
+                           const char *url, int flags, AVDictionary **options) {
+  struct video_store_h264_encoder *e =
+      (struct video_store_h264_encoder *)s->opaque;
+  return video_store_io_open_retry(e->ioOpen, s, pb, url, flags, options,
+                                   e->openAttempts, e->openBackoffMicroseconds,
+                                   NULL, 0);
+}
+
 int setup_encoder_segmenter(struct video_store_h264_encoder *e, // OUT
                             const int width,                    // IN
                             const int height                    // IN
@@ -40,6 +52,14 @@ int setup_encoder_segmenter(struct video_store_h264_encoder *e, // OUT
   // TODO(seanp): Do we want b frames? This could make it more complicated to
   // split clips.
   encoderCtx->max_b_frames = 0;
+  encoderCtx->thread_count = e->threads;
+  if (e->sceneCutThreshold > 0) {
+    // Only segment boundaries are forced keyframes, everything in between is
+    // left to scene change detection. Scene changes closer than a second to
+    // the previous keyframe don't get an IDR frame.
+    encoderCtx->gop_size = e->targetFrameRate * e->segmentSeconds;
+    encoderCtx->keyint_min = e->targetFrameRate;
+  }
 
   ret = av_dict_set(&encoderOpts, "preset", e->preset, 0);
   if (ret < 0) {
@@ -57,6 +77,31 @@ int setup_encoder_segmenter(struct video_store_h264_encoder *e, // OUT
     goto cleanup;
   }
 
+  if (e->sceneCutThreshold > 0) {
+    char stackX264ParamsStr[30];
+    snprintf(stackX264ParamsStr, sizeof(stackX264ParamsStr), "scenecut=%d",
+             e->sceneCutThreshold);
+    ret = av_dict_set(&encoderOpts, "x264-params", stackX264ParamsStr, 0);
+    if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "setup_encoder_segmenter failed to set encoder x264-params opt: "
+             "%s\n",
+             av_err2str(ret));
+      goto cleanup;
+    }
+
+    // Scene changes move the start of the GOP, so keyframes forced at segment
+    // boundaries need to be IDR frames rather than plain I frames.
+    ret = av_dict_set(&encoderOpts, "forced-idr", "1", 0);
+    if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "setup_encoder_segmenter failed to set encoder forced-idr opt: "
+             "%s\n",
+             av_err2str(ret));
+      goto cleanup;
+    }
+  }
+
   ret = avcodec_open2(encoderCtx, e->encoderCodec, &encoderOpts);
   if (ret < 0) {
     av_log(NULL, AV_LOG_ERROR,
@@ -160,6 +205,11 @@ int setup_encoder_segmenter(struct video_store_h264_encoder *e, // OUT
   // consistent both in the first and subsequent segments
   // mp4 files with different time bases can't be concatenated together
   segmenterStream->time_base = encoderCtx->time_base;
+  // The segment muxer opens each segment with io_open, wrap it to retry
+  // opening.
+  segmenterCtx->opaque = e;
+  e->ioOpen = segmenterCtx->io_open;
+  segmenterCtx->io_open = encoder_io_open;
   ret = avformat_write_header(segmenterCtx, &segmenterOpts);
   if (ret < 0) {
     av_log(NULL, AV_LOG_ERROR,
@@ -234,7 +284,11 @@ int video_store_h264_encoder_init(struct video_store_h264_encoder **ppE, // OUT
                                   const char *outputPattern,             // IN
                                   const int64_t bitrate,                 // IN
                                   const int targetFrameRate,             // IN
-                                  const char *preset                     // IN
+                                  const char *preset,                    // IN
+                                  const int sceneCutThreshold,           // IN
+                                  const int threads,                     // IN
+                                  const int openAttempts,                // IN
+                                  const int64_t openBackoffMicroseconds  // IN
 
 ) {
   struct video_store_h264_encoder *e = NULL;
@@ -270,6 +324,7 @@ int video_store_h264_encoder_init(struct video_store_h264_encoder **ppE, // OUT
   }
 
   decoderCtx->pix_fmt = AV_PIX_FMT_YUV420P;
+  decoderCtx->thread_count = threads;
   ret = avcodec_open2(decoderCtx, decoderCodec, NULL);
   if (ret < 0) {
     av_log(NULL, AV_LOG_ERROR,
@@ -337,6 +392,10 @@ int video_store_h264_encoder_init(struct video_store_h264_encoder **ppE, // OUT
   e->outputPattern = outputPatternStr;
   e->encoderPkt = encoderPkt;
   e->frameCount = 0;
+  e->sceneCutThreshold = sceneCutThreshold;
+  e->threads = threads;
+  e->openAttempts = openAttempts;
+  e->openBackoffMicroseconds = openBackoffMicroseconds;
 
   *ppE = e;
   ret = VIDEO_STORE_ENCODER_RESP_OK;
@@ -431,7 +490,13 @@ int video_store_h264_encoder_write(struct video_store_h264_encoder *e, // IN
   // gop_size or other encoder settings. This is necessary for the  segmenter
   // to split the video files at keyframe boundaries.
   // if it has been a second or more, add an iframe
-  if (e->decoderFrame->pts % e->encoderCtx->time_base.den == 0) {
+  // With scene change detection enabled only the segment boundaries are
+  // forced.
+  int64_t keyframeInterval = e->encoderCtx->time_base.den;
+  if (e->sceneCutThreshold > 0) {
+    keyframeInterval *= e->segmentSeconds;
+  }
+  if (e->decoderFrame->pts % keyframeInterval == 0) {
     e->decoderFrame->key_frame = 1;
     e->decoderFrame->pict_type = AV_PICTURE_TYPE_I;
   } else {
@@ -473,6 +538,15 @@ cleanup:
   return ret;
 }
 
+int video_store_h264_encoder_thread_count(
+    struct video_store_h264_encoder *pE // IN
+) {
+  if (pE == NULL || pE->encoderCtx == NULL) {
+    return -1;
+  }
+  return pE->encoderCtx->thread_count;
+}
+
 int video_store_h264_encoder_close(struct video_store_h264_encoder **ppE // OUT
 ) {
   if (ppE == NULL) {
@@ -8,6 +8,7 @@ import "C"
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"unsafe"
 
@@ -25,6 +26,9 @@ type encoder struct {
 	preset         string
 	storagePath    string
 	segmentSeconds int
+	sceneCut       int
+	threads        int
+	openRetry      OpenRetryPolicy
 
 	cEncoderMu sync.Mutex
 	cEncoder   *C.video_store_h264_encoder
@@ -39,6 +43,7 @@ func newEncoder(
 	encoderConfig EncoderConfig,
 	framerate int,
 	storagePath string,
+	openRetry OpenRetryPolicy,
 	logger logging.Logger,
 ) (*encoder, error) {
 	enc := &encoder{
@@ -48,6 +53,9 @@ func newEncoder(
 		preset:         encoderConfig.Preset,
 		storagePath:    storagePath,
 		segmentSeconds: segmentSeconds,
+		sceneCut:       encoderConfig.SceneCutThreshold,
+		threads:        encoderConfig.threads(),
+		openRetry:      openRetry,
 	}
 
 	return enc, nil
@@ -74,6 +82,10 @@ func (e *encoder) initialize() error {
 		C.int64_t(e.bitrate),
 		C.int(e.framerate),
 		presetCStr,
+		C.int(e.sceneCut),
+		C.int(e.threads),
+		C.int(e.openRetry.attempts()),
+		C.int64_t(e.openRetry.backoff().Microseconds()),
 	)
 
 	if ret != C.VIDEO_STORE_ENCODER_RESP_OK {
@@ -89,15 +101,15 @@ func (e *encoder) initialize() error {
 // handles changing frame sizes
 // If the polling loop is not running at the source framerate, the
 // PTS will lag behind actual run time.
-func (e *encoder) encode(frame []byte) {
+// ErrTooManyOpenFiles is returned if a segment file couldn't be opened.
+func (e *encoder) encode(frame []byte) error {
 	payloadC := C.CBytes(frame)
 	defer C.free(payloadC)
 
 	e.cEncoderMu.Lock()
 	defer e.cEncoderMu.Unlock()
 	if e.cEncoder == nil {
-		e.logger.Errorf("encode called before init")
-		return
+		return errors.New("encode called before init")
 	}
 	ret := C.video_store_h264_encoder_write(
 		e.cEncoder,
@@ -105,10 +117,31 @@ func (e *encoder) encode(frame []byte) {
 		C.size_t(len(frame)),
 	)
 	if ret != C.VIDEO_STORE_ENCODER_RESP_OK {
-		err := errors.New("failed to write packet to encoder")
-		e.logger.Errorf("%s: %d", err.Error(), ret)
-		return
+		err := fmt.Errorf("failed to write packet to encoder: %d", ret)
+		if isOutOfFileDescriptors(ret) {
+			return fmt.Errorf("%s: %w", err.Error(), ErrTooManyOpenFiles)
+		}
+		return err
 	}
+	return nil
+}
+
+// threadCount returns the thread count of the open encoder context,
+// or -1 if nothing has been encoded yet.
+func (e *encoder) threadCount() int {
+	e.cEncoderMu.Lock()
+	defer e.cEncoderMu.Unlock()
+	if e.cEncoder == nil {
+		return -1
+	}
+	return int(C.video_store_h264_encoder_thread_count(e.cEncoder))
+}
+
+// reopen closes the encoder's segment and starts encoding a new one.
+func (e *encoder) reopen() error {
+	e.close()
+	waitForUnusedSegmentName(e.storagePath)
+	return e.initialize()
 }
 
 func (e *encoder) close() {
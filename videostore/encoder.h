@@ -17,6 +17,8 @@ typedef struct video_store_h264_encoder {
 
   // segmenter
   AVFormatContext *segmenterCtx;
+  int (*ioOpen)(struct AVFormatContext *s, AVIOContext **pb, const char *url,
+                int flags, AVDictionary **options);
 
   // static config
   const AVCodec *encoderCodec;
@@ -25,15 +27,31 @@ typedef struct video_store_h264_encoder {
   int64_t bitrate;
   int targetFrameRate;
   const char *preset;
+  // 0 forces a keyframe every second, otherwise keyframes are placed on scene
+  // changes past this x264 scenecut threshold and at each segment boundary
+  int sceneCutThreshold;
+  // number of threads the decoder and encoder may each use, 0 lets FFmpeg pick
+  // based on the number of cores
+  int threads;
+  // how opening each segment file is retried when file descriptors run out
+  int openAttempts;
+  int64_t openBackoffMicroseconds;
 } video_store_h264_encoder;
 
 // video_store_h264_encoder_init initializes the encoder
+// Opening each segment file is tried up to openAttempts times while file
+// descriptors are exhausted, waiting openBackoffMicroseconds before the first
+// retry and doubling after each.
 int video_store_h264_encoder_init(struct video_store_h264_encoder **ppE, // OUT
                                   const int segmentSeconds,              // IN
                                   const char *outputPattern,             // IN
                                   const int64_t bitrate,                 // IN
                                   const int frameRate,                   // IN
-                                  const char *preset                     // IN
+                                  const char *preset,                    // IN
+                                  const int sceneCutThreshold,           // IN
+                                  const int threads,                     // IN
+                                  const int openAttempts,                // IN
+                                  const int64_t openBackoffMicroseconds  // IN
 );
 
 // video_store_h264_encoder_write writes the payload frame to the encoder
@@ -44,6 +62,13 @@ int video_store_h264_encoder_write(struct video_store_h264_encoder *pE, // IN
                                    size_t payloadSize                   // IN
 );
 
+// video_store_h264_encoder_thread_count returns the thread count of the
+// encoder context, or -1 if it isn't open yet as it is opened on the first
+// write
+int video_store_h264_encoder_thread_count(
+    struct video_store_h264_encoder *pE // IN
+);
+
 // video_store_h264_encoder_close stops and frees the encoder resources
 int video_store_h264_encoder_close(struct video_store_h264_encoder **ppE // OUT
 );
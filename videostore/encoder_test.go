@@ -0,0 +1,114 @@
+package videostore
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// solidJPEG returns a 640x480 JPEG filled with c.
+func solidJPEG(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, img, nil), test.ShouldBeNil)
+	return buf.Bytes()
+}
+
+// keyframeIndexes returns the indexes of the key frames in the first video stream of path.
+func keyframeIndexes(t *testing.T, path string) []int {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "frame=key_frame", "-of", "csv=p=0", path).Output()
+	test.That(t, err, test.ShouldBeNil)
+	indexes := []int{}
+	for i, line := range strings.Fields(string(out)) {
+		if line == "1" {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func TestEncoderKeyframes(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	white := solidJPEG(t, color.White)
+
+	// encodeSceneChange encodes 2 seconds of black followed by 2 seconds of white
+	// and returns the path of the segment written.
+	encodeSceneChange := func(t *testing.T, sceneCutThreshold int) string {
+		storagePath := t.TempDir()
+		enc, err := newEncoder(
+			EncoderConfig{Bitrate: 1000000, Preset: "veryfast", SceneCutThreshold: sceneCutThreshold},
+			framerate,
+			storagePath,
+			OpenRetryPolicy{},
+			logger,
+		)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, enc.initialize(), test.ShouldBeNil)
+		for i := range 4 * framerate {
+			if i < 2*framerate {
+				test.That(t, enc.encode(black), test.ShouldBeNil)
+			} else {
+				test.That(t, enc.encode(white), test.ShouldBeNil)
+			}
+		}
+		enc.close()
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+		return segments[0]
+	}
+
+	t.Run("Keyframes are forced every second by default", func(t *testing.T) {
+		segment := encodeSceneChange(t, 0)
+		test.That(t, keyframeIndexes(t, segment), test.ShouldResemble, []int{0, 10, 20, 30})
+	})
+
+	t.Run("Keyframes are placed on scene changes when enabled", func(t *testing.T) {
+		segment := encodeSceneChange(t, 40)
+		test.That(t, keyframeIndexes(t, segment), test.ShouldResemble, []int{0, 20})
+	})
+}
+
+func TestEncoderThreads(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	frame := solidJPEG(t, color.Black)
+
+	// encoderThreadCount encodes a frame with threads configured and returns the
+	// thread count of the resulting encoder context.
+	encoderThreadCount := func(t *testing.T, threads int) int {
+		enc, err := newEncoder(
+			EncoderConfig{Bitrate: 1000000, Preset: "ultrafast", Threads: threads},
+			10,
+			t.TempDir(),
+			OpenRetryPolicy{},
+			logger,
+		)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, enc.initialize(), test.ShouldBeNil)
+		defer enc.close()
+		test.That(t, enc.threadCount(), test.ShouldEqual, -1)
+		test.That(t, enc.encode(frame), test.ShouldBeNil)
+		return enc.threadCount()
+	}
+
+	t.Run("Configured thread count is applied to the encoder context", func(t *testing.T) {
+		test.That(t, encoderThreadCount(t, 3), test.ShouldEqual, 3)
+	})
+
+	t.Run("Thread count defaults to a conservative value", func(t *testing.T) {
+		test.That(t, encoderThreadCount(t, 0), test.ShouldEqual, DefaultThreads)
+	})
+}
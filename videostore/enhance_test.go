@@ -0,0 +1,114 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+// noisyJPEG returns a mid gray frame with strong per pixel noise, like a cheap camera's
+// footage in low light.
+func noisyJPEG(t *testing.T, rng *rand.Rand) []byte {
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(128 + rng.Intn(81) - 40)
+	}
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}), test.ShouldBeNil)
+	return buf.Bytes()
+}
+
+// roughness returns the mean difference in luma between horizontally adjacent pixels of
+// the frame at offset into the video at path, which noise raises and denoising lowers.
+func roughness(t *testing.T, path string, offset time.Duration) float64 {
+	const width, height = 640, 480
+	gray, err := grayFrameAt(path, offset, width, height)
+	test.That(t, err, test.ShouldBeNil)
+	var sum int
+	for y := range height {
+		for x := 1; x < width; x++ {
+			d := int(gray[y*width+x]) - int(gray[y*width+x-1])
+			sum += max(d, -d)
+		}
+	}
+	return float64(sum) / float64(height*(width-1))
+}
+
+func TestExportEnhance(t *testing.T) {
+	const framerate = 10
+	rng := rand.New(rand.NewSource(1))
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = noisyJPEG(t, rng)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	export := func(t *testing.T, metadata string, enhance *Enhance) (string, *ExportResponse) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(2 * time.Second),
+			Metadata: metadata,
+			Enhance:  enhance,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 2, 0.25)
+		test.That(t, info.width, test.ShouldEqual, 640)
+		return path, res
+	}
+	plain, _ := export(t, "plain", nil)
+	noise := roughness(t, plain, time.Second)
+
+	t.Run("Denoising smooths away noise", func(t *testing.T) {
+		enhance := &Enhance{Denoise: "hqdn3d", DenoiseStrength: 1}
+		path, res := export(t, "hqdn3d", enhance)
+		test.That(t, roughness(t, path, time.Second), test.ShouldBeLessThan, 0.7*noise)
+
+		b, err := os.ReadFile(filepath.Join(config.Storage.UploadPath, res.Manifest))
+		test.That(t, err, test.ShouldBeNil)
+		var m exportManifest
+		test.That(t, json.Unmarshal(b, &m), test.ShouldBeNil)
+		test.That(t, m.Request.Filters, test.ShouldResemble, []string{"hqdn3d=luma_spatial=8.00"})
+	})
+
+	t.Run("nlmeans denoises too", func(t *testing.T) {
+		path, _ := export(t, "nlmeans", &Enhance{Denoise: "nlmeans"})
+		test.That(t, roughness(t, path, time.Second), test.ShouldBeLessThan, noise)
+	})
+
+	t.Run("Sharpening follows denoising", func(t *testing.T) {
+		enhance := &Enhance{Denoise: "hqdn3d", Sharpen: 0.5}
+		test.That(t, enhance.filters(), test.ShouldResemble, []string{
+			"hqdn3d=luma_spatial=4.00",
+			"unsharp=luma_msize_x=5:luma_msize_y=5:luma_amount=1.00",
+		})
+		path, _ := export(t, "sharpened", &Enhance{Sharpen: 1})
+		test.That(t, roughness(t, path, time.Second), test.ShouldBeGreaterThan, noise)
+		export(t, "denoised_sharpened", enhance)
+	})
+
+	t.Run("Invalid enhancements are rejected", func(t *testing.T) {
+		for _, e := range []Enhance{
+			{},
+			{Denoise: "median"},
+			{Denoise: "hqdn3d", DenoiseStrength: 1.5},
+			{DenoiseStrength: 0.5, Sharpen: 0.5},
+			{Sharpen: -1},
+		} {
+			test.That(t, e.Validate(), test.ShouldNotBeNil)
+		}
+		r := &ExportRequest{From: start, To: start.Add(time.Second), Enhance: &Enhance{}}
+		test.That(t, r.Validate(), test.ShouldBeError, "enhance must denoise or sharpen")
+	})
+}
@@ -0,0 +1,970 @@
+#include "export.h"
+#include <libavcodec/avcodec.h>
+#include <libavfilter/avfilter.h>
+#include <libavfilter/buffersink.h>
+#include <libavfilter/buffersrc.h>
+#include <libavformat/avformat.h>
+#include <libavutil/avstring.h>
+#include <libavutil/dict.h>
+#include <libavutil/error.h>
+#include <libavutil/hwcontext.h>
+#include <libavutil/log.h>
+#include <libavutil/opt.h>
+#include <stdatomic.h>
+#include <stdio.h>
+#include <string.h>
+#include <time.h>
+
+#define FILTER_ARGS_SIZE 512
+// ERROR_NO_AUDIO is returned while exporting audio from input without any,
+// and becomes VIDEO_STORE_EXPORT_RESP_NO_AUDIO.
+#define ERROR_NO_AUDIO FFERRTAG('N', 'A', 'U', 'D')
+
+struct video_store_export_progress {
+  atomic_int_fast64_t frames;
+  atomic_int_fast64_t bytes;
+  atomic_int_fast64_t outputMicroseconds;
+  atomic_int canceled;
+};
+
+video_store_export_progress *video_store_export_progress_alloc(void) {
+  video_store_export_progress *progress = av_mallocz(sizeof(*progress));
+  if (progress == NULL) {
+    return NULL;
+  }
+  atomic_init(&progress->frames, 0);
+  atomic_init(&progress->bytes, 0);
+  atomic_init(&progress->outputMicroseconds, 0);
+  atomic_init(&progress->canceled, 0);
+  return progress;
+}
+
+void video_store_export_progress_free(video_store_export_progress **progress // IN
+) {
+  av_freep(progress);
+}
+
+void video_store_export_progress_get(
+    video_store_export_progress *progress, // IN
+    int64_t *frames,                       // OUT
+    int64_t *bytes,                        // OUT
+    int64_t *outputMicroseconds            // OUT
+) {
+  *frames = atomic_load(&progress->frames);
+  *bytes = atomic_load(&progress->bytes);
+  *outputMicroseconds = atomic_load(&progress->outputMicroseconds);
+}
+
+void video_store_export_progress_cancel(
+    video_store_export_progress *progress // IN
+) {
+  atomic_store(&progress->canceled, 1);
+}
+
+// hw_accel is a hardware encoder an export can use in place of libx264.
+typedef struct hw_accel {
+  const char *name;
+  enum AVHWDeviceType deviceType;
+  const char *encoder;
+  // pixel format of the frames the encoder takes
+  enum AVPixelFormat pixFmt;
+  // filters appended to the graph to convert and upload software frames to
+  // the device, NULL if the encoder takes software frames
+  const char *upload;
+} hw_accel;
+
+// hwAccels are tried in order for "auto".
+static const hw_accel hwAccels[] = {
+    {"vaapi", AV_HWDEVICE_TYPE_VAAPI, "h264_vaapi", AV_PIX_FMT_VAAPI,
+     "format=nv12,hwupload"},
+    {"cuda", AV_HWDEVICE_TYPE_CUDA, "h264_nvenc", AV_PIX_FMT_YUV420P, NULL},
+    {"qsv", AV_HWDEVICE_TYPE_QSV, "h264_qsv", AV_PIX_FMT_NV12, NULL},
+};
+
+typedef struct exporter {
+  // input
+  AVFormatContext *inCtx;
+  AVCodecContext *decCtx;
+  int streamIndex;
+  // index of the audio stream copied to the output, -1 for none
+  int audioIndex;
+
+  // filter
+  AVFilterGraph *graph;
+  AVFilterContext *srcCtx;
+  AVFilterContext *sinkCtx;
+
+  // output
+  AVCodecContext *encCtx;
+  AVFormatContext *outCtx;
+  AVStream *outStream;
+  AVStream *audioOutStream;
+
+  AVPacket *pkt;
+  AVFrame *frame;
+  AVFrame *filtFrame;
+
+  // pts of the last frame filtered, later frames at or before it are dropped
+  int64_t lastPts;
+
+  // hardware encoder and its device, NULL to encode with libx264
+  const hw_accel *hw;
+  AVBufferRef *hwDeviceCtx;
+
+  // telemetry set as the metadata of each frame, NULL for none
+  const video_store_export_hud *hud;
+
+  // time of the poster frame in the filtered video, negative for none
+  int64_t posterMicroseconds;
+  // while capturing, filtered frames are kept as the poster instead of being
+  // encoded, until one at or after posterMicroseconds is
+  int capturing;
+  int posterCaptured;
+  AVFrame *poster;
+  // added to the pts of each filtered frame encoded after the poster
+  int64_t ptsOffset;
+
+  // HLS output in place of an mp4, NULL for an mp4
+  const video_store_export_hls *hls;
+
+  // whether the audio is copied, and how far it is delayed
+  int audio;
+  int64_t audioOffsetMicroseconds;
+  // dts of the last audio packet written, later packets at or before it
+  // overlap the previous file and are dropped
+  int64_t lastAudioDts;
+
+  // updated as each packet is written and checked for cancellation, NULL for
+  // neither
+  video_store_export_progress *progress;
+
+  // static config
+  // number of threads each codec context and the filter graph may use
+  int threads;
+} exporter;
+
+static int open_input(exporter *e, const char *concatFilePath) {
+  AVDictionary *opts = NULL;
+  const AVCodec *dec = NULL;
+  int ret = av_dict_set(&opts, "safe", "0", 0);
+  if (ret < 0) {
+    goto cleanup;
+  }
+  if ((ret = avformat_open_input(&e->inCtx, concatFilePath,
+                                 av_find_input_format("concat"), &opts)) < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to open input: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  if ((ret = avformat_find_stream_info(e->inCtx, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to find stream info: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  e->streamIndex =
+      av_find_best_stream(e->inCtx, AVMEDIA_TYPE_VIDEO, -1, -1, &dec, 0);
+  if (e->streamIndex < 0) {
+    ret = e->streamIndex;
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to find video stream: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  e->audioIndex = -1;
+  if (e->audio) {
+    e->audioIndex = av_find_best_stream(e->inCtx, AVMEDIA_TYPE_AUDIO, -1,
+                                        e->streamIndex, NULL, 0);
+    if (e->audioIndex < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export found no audio stream to export\n");
+      ret = ERROR_NO_AUDIO;
+      goto cleanup;
+    }
+  }
+  AVStream *stream = e->inCtx->streams[e->streamIndex];
+  e->decCtx = avcodec_alloc_context3(dec);
+  if (e->decCtx == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to allocate decoder context\n");
+    ret = AVERROR(ENOMEM);
+    goto cleanup;
+  }
+  if ((ret = avcodec_parameters_to_context(e->decCtx, stream->codecpar)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to copy codec parameters: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  e->decCtx->pkt_timebase = stream->time_base;
+  e->decCtx->thread_count = e->threads;
+  if ((ret = avcodec_open2(e->decCtx, dec, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to open decoder: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+cleanup:
+  av_dict_free(&opts);
+  return ret;
+}
+
+static int open_hw_device(exporter *e, const char *hwDevice) {
+  if (hwDevice != NULL && hwDevice[0] == '\0') {
+    hwDevice = NULL;
+  }
+  int ret =
+      av_hwdevice_ctx_create(&e->hwDeviceCtx, e->hw->deviceType, hwDevice, NULL, 0);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_WARNING,
+           "video_store_export failed to open %s device: %s\n", e->hw->name,
+           av_err2str(ret));
+  }
+  return ret;
+}
+
+static int open_filter(exporter *e, const char *filterSpec) {
+  AVFilterInOut *outputs = avfilter_inout_alloc();
+  AVFilterInOut *inputs = avfilter_inout_alloc();
+  char *spec = NULL;
+  int ret = 0;
+  e->graph = avfilter_graph_alloc();
+  if (outputs == NULL || inputs == NULL || e->graph == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to allocate filter graph\n");
+    ret = AVERROR(ENOMEM);
+    goto cleanup;
+  }
+  e->graph->nb_threads = e->threads;
+
+  AVStream *stream = e->inCtx->streams[e->streamIndex];
+  AVRational sar = e->decCtx->sample_aspect_ratio;
+  if (sar.num == 0) {
+    sar = (AVRational){1, 1};
+  }
+  char args[FILTER_ARGS_SIZE];
+  snprintf(args, sizeof(args),
+           "video_size=%dx%d:pix_fmt=%d:time_base=%d/%d:pixel_aspect=%d/%d",
+           e->decCtx->width, e->decCtx->height, e->decCtx->pix_fmt,
+           stream->time_base.num, stream->time_base.den, sar.num, sar.den);
+  if ((ret = avfilter_graph_create_filter(&e->srcCtx,
+                                          avfilter_get_by_name("buffer"), "in",
+                                          args, NULL, e->graph)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to create buffer source: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  if ((ret = avfilter_graph_create_filter(
+           &e->sinkCtx, avfilter_get_by_name("buffersink"), "out", NULL, NULL,
+           e->graph)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to create buffer sink: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  enum AVPixelFormat pixFmts[] = {
+      e->hw != NULL ? e->hw->pixFmt : AV_PIX_FMT_YUV420P, AV_PIX_FMT_NONE};
+  if ((ret = av_opt_set_int_list(e->sinkCtx, "pix_fmts", pixFmts,
+                                 AV_PIX_FMT_NONE, AV_OPT_SEARCH_CHILDREN)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to set output pixel format: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+
+  outputs->name = av_strdup("in");
+  outputs->filter_ctx = e->srcCtx;
+  outputs->pad_idx = 0;
+  outputs->next = NULL;
+  inputs->name = av_strdup("out");
+  inputs->filter_ctx = e->sinkCtx;
+  inputs->pad_idx = 0;
+  inputs->next = NULL;
+  if (filterSpec == NULL || filterSpec[0] == '\0') {
+    filterSpec = "null";
+  }
+  if (e->hw != NULL && e->hw->upload != NULL) {
+    spec = av_asprintf("%s,%s", filterSpec, e->hw->upload);
+    if (spec == NULL) {
+      ret = AVERROR(ENOMEM);
+      goto cleanup;
+    }
+    filterSpec = spec;
+  }
+  if ((ret = avfilter_graph_parse_ptr(e->graph, filterSpec, &inputs, &outputs,
+                                      NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to parse filter graph: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  // Upload filters need the device to upload to.
+  for (unsigned i = 0; e->hwDeviceCtx != NULL && i < e->graph->nb_filters;
+       i++) {
+    e->graph->filters[i]->hw_device_ctx = av_buffer_ref(e->hwDeviceCtx);
+    if (e->graph->filters[i]->hw_device_ctx == NULL) {
+      ret = AVERROR(ENOMEM);
+      goto cleanup;
+    }
+  }
+  if ((ret = avfilter_graph_config(e->graph, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to configure filter graph: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+cleanup:
+  av_free(spec);
+  avfilter_inout_free(&inputs);
+  avfilter_inout_free(&outputs);
+  return ret;
+}
+
+static int open_output(exporter *e, const char *outputPath,
+                       const int64_t bitrate, const char *preset) {
+  AVDictionary *opts = NULL;
+  AVDictionary *muxerOpts = NULL;
+  int ret = 0;
+  const char *encName = e->hw != NULL ? e->hw->encoder : "libx264";
+  const AVCodec *enc = avcodec_find_encoder_by_name(encName);
+  if (enc == NULL) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to find %s\n",
+           encName);
+    ret = AVERROR_ENCODER_NOT_FOUND;
+    goto cleanup;
+  }
+  e->encCtx = avcodec_alloc_context3(enc);
+  if (e->encCtx == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to allocate encoder context\n");
+    ret = AVERROR(ENOMEM);
+    goto cleanup;
+  }
+  e->encCtx->width = av_buffersink_get_w(e->sinkCtx);
+  e->encCtx->height = av_buffersink_get_h(e->sinkCtx);
+  e->encCtx->pix_fmt = av_buffersink_get_format(e->sinkCtx);
+  e->encCtx->sample_aspect_ratio =
+      av_buffersink_get_sample_aspect_ratio(e->sinkCtx);
+  e->encCtx->time_base = av_buffersink_get_time_base(e->sinkCtx);
+  AVRational frameRate = av_buffersink_get_frame_rate(e->sinkCtx);
+  if (frameRate.num == 0) {
+    frameRate = e->inCtx->streams[e->streamIndex]->avg_frame_rate;
+  }
+  e->encCtx->framerate = frameRate;
+  e->encCtx->thread_count = e->threads;
+  // HLS segments can only start at a keyframe.
+  if (e->hls != NULL && frameRate.num > 0 && frameRate.den > 0) {
+    e->encCtx->gop_size =
+        (int)av_rescale(e->hls->segmentSeconds, frameRate.num, frameRate.den);
+  }
+  if (bitrate > 0) {
+    e->encCtx->bit_rate = bitrate;
+  }
+  if (e->hw != NULL) {
+    AVBufferRef *framesCtx = av_buffersink_get_hw_frames_ctx(e->sinkCtx);
+    if (framesCtx != NULL) {
+      e->encCtx->hw_frames_ctx = av_buffer_ref(framesCtx);
+    } else {
+      e->encCtx->hw_device_ctx = av_buffer_ref(e->hwDeviceCtx);
+    }
+    if (e->encCtx->hw_frames_ctx == NULL && e->encCtx->hw_device_ctx == NULL) {
+      ret = AVERROR(ENOMEM);
+      goto cleanup;
+    }
+  }
+
+  const char *format = e->hls != NULL ? "hls" : "mp4";
+  if ((ret = avformat_alloc_output_context2(&e->outCtx, NULL, format,
+                                            outputPath)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to allocate output context: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  if (e->outCtx->oformat->flags & AVFMT_GLOBALHEADER) {
+    e->encCtx->flags |= AV_CODEC_FLAG_GLOBAL_HEADER;
+  }
+  // Hardware encoders each have their own presets, which x264's don't map to.
+  if (e->hw == NULL && preset != NULL && preset[0] != '\0' &&
+      (ret = av_dict_set(&opts, "preset", preset, 0)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = avcodec_open2(e->encCtx, enc, &opts)) < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to open encoder: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+
+  e->outStream = avformat_new_stream(e->outCtx, NULL);
+  if (e->outStream == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to create output stream\n");
+    ret = AVERROR(ENOMEM);
+    goto cleanup;
+  }
+  if ((ret = avcodec_parameters_from_context(e->outStream->codecpar,
+                                             e->encCtx)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to copy encoder parameters: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  e->outStream->time_base = e->encCtx->time_base;
+  if (e->audioIndex >= 0) {
+    AVStream *audio = e->inCtx->streams[e->audioIndex];
+    e->audioOutStream = avformat_new_stream(e->outCtx, NULL);
+    if (e->audioOutStream == NULL) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export failed to create audio output stream\n");
+      ret = AVERROR(ENOMEM);
+      goto cleanup;
+    }
+    if ((ret = avcodec_parameters_copy(e->audioOutStream->codecpar,
+                                       audio->codecpar)) < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export failed to copy audio parameters: %s\n",
+             av_err2str(ret));
+      goto cleanup;
+    }
+    // The tag of the input container may not be valid in the output's.
+    e->audioOutStream->codecpar->codec_tag = 0;
+    e->audioOutStream->time_base = audio->time_base;
+  }
+  // The HLS muxer opens the playlist and each segment itself.
+  if (!(e->outCtx->oformat->flags & AVFMT_NOFILE) &&
+      (ret = avio_open(&e->outCtx->pb, outputPath, AVIO_FLAG_WRITE)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to open output file: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  // Custom keys are only written to mp4 with use_metadata_tags.
+  if (e->poster != NULL &&
+      ((ret = av_dict_set(&e->outCtx->metadata,
+                          VIDEO_STORE_EXPORT_POSTER_METADATA_KEY, "0.000000",
+                          0)) < 0 ||
+       (ret = av_dict_set(&muxerOpts, "movflags", "+use_metadata_tags", 0)) <
+           0)) {
+    goto cleanup;
+  }
+  if (e->hls != NULL) {
+    char segmentSeconds[16];
+    snprintf(segmentSeconds, sizeof(segmentSeconds), "%d",
+             e->hls->segmentSeconds);
+    // A VOD playlist of independently decodable segments plays in every
+    // HLS player, including Safari and hls.js.
+    if ((ret = av_dict_set(&muxerOpts, "hls_time", segmentSeconds, 0)) < 0 ||
+        (ret = av_dict_set(&muxerOpts, "hls_playlist_type", "vod", 0)) < 0 ||
+        (ret = av_dict_set(&muxerOpts, "hls_flags", "independent_segments",
+                           0)) < 0 ||
+        (ret = av_dict_set(&muxerOpts, "hls_segment_filename",
+                           e->hls->segmentPattern, 0)) < 0 ||
+        (ret = av_dict_set(&muxerOpts, "hls_key_info_file",
+                           e->hls->keyInfoPath, 0)) < 0) {
+      goto cleanup;
+    }
+  }
+  if ((ret = avformat_write_header(e->outCtx, &muxerOpts)) < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to write header: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+cleanup:
+  av_dict_free(&muxerOpts);
+  av_dict_free(&opts);
+  return ret;
+}
+
+// hud_value interpolates field to timeMicroseconds. It returns 0 if the field
+// has no samples on both sides of the time, or they are further apart than
+// maxGapMicroseconds.
+static int hud_value(const video_store_export_hud_field *field,
+                     const int64_t timeMicroseconds,
+                     const int64_t maxGapMicroseconds, double *value) {
+  const int64_t *times = field->timesMicroseconds;
+  int n = field->sampleCount;
+  if (n == 0 || timeMicroseconds < times[0] ||
+      timeMicroseconds > times[n - 1]) {
+    return 0;
+  }
+  // find the last sample at or before the time
+  int lo = 0;
+  int hi = n - 1;
+  while (lo < hi) {
+    int mid = (lo + hi + 1) / 2;
+    if (times[mid] <= timeMicroseconds) {
+      lo = mid;
+    } else {
+      hi = mid - 1;
+    }
+  }
+  if (times[lo] == timeMicroseconds) {
+    *value = field->values[lo];
+    return 1;
+  }
+  int64_t gap = times[lo + 1] - times[lo];
+  if (maxGapMicroseconds > 0 && gap > maxGapMicroseconds) {
+    return 0;
+  }
+  double fraction = (double)(timeMicroseconds - times[lo]) / (double)gap;
+  *value = field->values[lo] +
+           fraction * (field->values[lo + 1] - field->values[lo]);
+  return 1;
+}
+
+void video_store_export_hud_text(const video_store_export_hud *hud, // IN
+                                 const int64_t timeMicroseconds,   // IN
+                                 char *text,                       // OUT
+                                 const int textSize                // IN
+) {
+  int len = 0;
+  text[0] = '\0';
+  if (hud->timestamp) {
+    int64_t micros = hud->startUnixMicroseconds + timeMicroseconds;
+    time_t seconds = (time_t)(micros / 1000000);
+    int millis = (int)(micros % 1000000) / 1000;
+    if (millis < 0) {
+      seconds--;
+      millis += 1000;
+    }
+    struct tm tm;
+    localtime_r(&seconds, &tm);
+    len = (int)strftime(text, textSize, "%Y-%m-%d %H:%M:%S", &tm);
+    len += snprintf(text + len, textSize - len, ".%03d", millis);
+  }
+  for (int i = 0; i < hud->fieldCount && len < textSize; i++) {
+    const video_store_export_hud_field *field = &hud->fields[i];
+    const char *separator = len > 0 ? "\n" : "";
+    double value;
+    if (hud_value(field, timeMicroseconds, hud->maxGapMicroseconds, &value)) {
+      len += snprintf(text + len, textSize - len, "%s%s%.*f%s", separator,
+                      field->prefix, field->decimals, value, field->suffix);
+    } else {
+      len += snprintf(text + len, textSize - len, "%s%s--%s", separator,
+                      field->prefix, field->suffix);
+    }
+  }
+}
+
+// set_hud_metadata sets the HUD text of frame, with pts in the time base of
+// the input stream, as its metadata.
+static int set_hud_metadata(exporter *e, AVFrame *frame) {
+  char text[VIDEO_STORE_EXPORT_HUD_TEXT_LEN];
+  int64_t timeMicroseconds =
+      av_rescale_q(frame->pts, e->inCtx->streams[e->streamIndex]->time_base,
+                   AV_TIME_BASE_Q);
+  video_store_export_hud_text(e->hud, timeMicroseconds, text, sizeof(text));
+  int ret = av_dict_set(&frame->metadata, VIDEO_STORE_EXPORT_HUD_METADATA_KEY,
+                        text, 0);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to set hud metadata: %s\n",
+           av_err2str(ret));
+  }
+  return ret;
+}
+
+// canceled returns AVERROR_EXIT if the export has been canceled, 0 otherwise.
+static int canceled(exporter *e) {
+  if (e->progress != NULL && atomic_load(&e->progress->canceled)) {
+    return AVERROR_EXIT;
+  }
+  return 0;
+}
+
+// update_progress adds the packet about to be written to the progress.
+static void update_progress(exporter *e, const AVPacket *pkt) {
+  if (e->progress == NULL) {
+    return;
+  }
+  atomic_fetch_add(&e->progress->frames, 1);
+  atomic_fetch_add(&e->progress->bytes, pkt->size);
+  if (pkt->pts != AV_NOPTS_VALUE) {
+    int64_t end = av_rescale_q(pkt->pts + pkt->duration,
+                               e->outStream->time_base, AV_TIME_BASE_Q);
+    // Packets are written in decode order, which differs from presentation
+    // order with B-frames.
+    if (end > atomic_load(&e->progress->outputMicroseconds)) {
+      atomic_store(&e->progress->outputMicroseconds, end);
+    }
+  }
+}
+
+// encode sends frame, or NULL to flush, to the encoder and writes every
+// packet it produces.
+static int encode(exporter *e, AVFrame *frame) {
+  int ret = avcodec_send_frame(e->encCtx, frame);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to encode frame: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  while ((ret = avcodec_receive_packet(e->encCtx, e->pkt)) >= 0) {
+    av_packet_rescale_ts(e->pkt, e->encCtx->time_base,
+                         e->outStream->time_base);
+    e->pkt->stream_index = e->outStream->index;
+    update_progress(e, e->pkt);
+    ret = av_interleaved_write_frame(e->outCtx, e->pkt);
+    av_packet_unref(e->pkt);
+    if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export failed to write packet: %s\n",
+             av_err2str(ret));
+      return ret;
+    }
+  }
+  if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+    return 0;
+  }
+  return ret;
+}
+
+// capture keeps the filtered frame as the poster until one at or after
+// posterMicroseconds has been kept.
+static void capture(exporter *e) {
+  if (e->posterCaptured) {
+    av_frame_unref(e->filtFrame);
+    return;
+  }
+  int64_t micros =
+      av_rescale_q(e->filtFrame->pts, av_buffersink_get_time_base(e->sinkCtx),
+                   AV_TIME_BASE_Q);
+  av_frame_unref(e->poster);
+  av_frame_move_ref(e->poster, e->filtFrame);
+  e->posterCaptured = micros >= e->posterMicroseconds;
+}
+
+// filter sends frame, or NULL to flush, through the filter graph and encodes
+// every frame it produces.
+static int filter(exporter *e, AVFrame *frame) {
+  int ret = av_buffersrc_add_frame_flags(e->srcCtx, frame,
+                                         AV_BUFFERSRC_FLAG_KEEP_REF);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to filter frame: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  while ((ret = av_buffersink_get_frame(e->sinkCtx, e->filtFrame)) >= 0) {
+    // Flushing filters which hold the whole range, e.g. reverse, produce
+    // every frame at once.
+    if ((ret = canceled(e)) < 0) {
+      av_frame_unref(e->filtFrame);
+      return ret;
+    }
+    if (e->capturing) {
+      capture(e);
+      continue;
+    }
+    e->filtFrame->pict_type = AV_PICTURE_TYPE_NONE;
+    e->filtFrame->pts += e->ptsOffset;
+    ret = encode(e, e->filtFrame);
+    av_frame_unref(e->filtFrame);
+    if (ret < 0) {
+      return ret;
+    }
+  }
+  if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+    return 0;
+  }
+  return ret;
+}
+
+// decode sends pkt, or NULL to flush, to the decoder and filters every frame
+// in the exported range.
+static int decode(exporter *e, AVPacket *pkt) {
+  int ret = avcodec_send_packet(e->decCtx, pkt);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to decode packet: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  while ((ret = avcodec_receive_frame(e->decCtx, e->frame)) >= 0) {
+    int64_t pts = e->frame->best_effort_timestamp;
+    // The concat demuxer starts the range at 0, earlier frames are only
+    // there to decode from the previous keyframe. The same goes for frames
+    // before the inpoint of later files, which overlap the previous file.
+    if (pts == AV_NOPTS_VALUE || pts < 0 ||
+        (e->lastPts != AV_NOPTS_VALUE && pts <= e->lastPts)) {
+      av_frame_unref(e->frame);
+      continue;
+    }
+    e->frame->pts = pts;
+    e->lastPts = pts;
+    if (e->hud != NULL && (ret = set_hud_metadata(e, e->frame)) < 0) {
+      av_frame_unref(e->frame);
+      return ret;
+    }
+    ret = filter(e, e->frame);
+    av_frame_unref(e->frame);
+    if (ret < 0) {
+      return ret;
+    }
+  }
+  if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+    return 0;
+  }
+  return ret;
+}
+
+// write_audio writes the audio packet pkt to the output, shifted by the audio
+// offset and the delay of the video after the poster.
+static int write_audio(exporter *e, AVPacket *pkt) {
+  AVRational tb = e->inCtx->streams[e->audioIndex]->time_base;
+  int64_t shift =
+      av_rescale_q(e->audioOffsetMicroseconds, AV_TIME_BASE_Q, tb) +
+      av_rescale_q(e->ptsOffset, e->encCtx->time_base, tb);
+  if (pkt->pts == AV_NOPTS_VALUE || pkt->dts == AV_NOPTS_VALUE) {
+    return 0;
+  }
+  pkt->pts += shift;
+  pkt->dts += shift;
+  // Like frames, audio before the start of the range, or shifted before it,
+  // isn't exported.
+  if (pkt->pts < 0 || pkt->dts < 0 ||
+      (e->lastAudioDts != AV_NOPTS_VALUE && pkt->dts <= e->lastAudioDts)) {
+    return 0;
+  }
+  e->lastAudioDts = pkt->dts;
+  av_packet_rescale_ts(pkt, tb, e->audioOutStream->time_base);
+  pkt->stream_index = e->audioOutStream->index;
+  pkt->pos = -1;
+  int ret = av_interleaved_write_frame(e->outCtx, pkt);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to write audio packet: %s\n",
+           av_err2str(ret));
+  }
+  return ret;
+}
+
+// read_input decodes and filters packets until the input ends, or the poster
+// is captured while capturing.
+static int read_input(exporter *e) {
+  int ret;
+  while ((ret = av_read_frame(e->inCtx, e->pkt)) >= 0) {
+    if ((ret = canceled(e)) < 0) {
+      av_packet_unref(e->pkt);
+      return ret;
+    }
+    if (e->pkt->stream_index == e->audioIndex && !e->capturing) {
+      ret = write_audio(e, e->pkt);
+      av_packet_unref(e->pkt);
+      if (ret < 0) {
+        return ret;
+      }
+      continue;
+    }
+    if (e->pkt->stream_index != e->streamIndex) {
+      av_packet_unref(e->pkt);
+      continue;
+    }
+    ret = decode(e, e->pkt);
+    av_packet_unref(e->pkt);
+    if (ret < 0) {
+      return ret;
+    }
+    if (e->capturing && e->posterCaptured) {
+      return 0;
+    }
+  }
+  if (ret != AVERROR_EOF) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export failed to read frame: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  if ((ret = decode(e, NULL)) < 0) {
+    return ret;
+  }
+  return filter(e, NULL);
+}
+
+// capture_poster filters the video up to the poster and keeps it, then opens
+// the input and filter graph again to export from the start.
+static int capture_poster(exporter *e, const char *concatFilePath,
+                          const char *filterSpec) {
+  int ret;
+  e->capturing = 1;
+  if ((ret = read_input(e)) < 0) {
+    return ret;
+  }
+  e->capturing = 0;
+  if (e->poster->buf[0] == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export found no frame to use as the poster\n");
+    return AVERROR(EINVAL);
+  }
+  avfilter_graph_free(&e->graph);
+  avcodec_free_context(&e->decCtx);
+  avformat_close_input(&e->inCtx);
+  e->lastPts = AV_NOPTS_VALUE;
+  if ((ret = open_input(e, concatFilePath)) < 0) {
+    return ret;
+  }
+  return open_filter(e, filterSpec);
+}
+
+// encode_poster encodes the poster at time 0 and delays the rest of the
+// export by a frame to follow it.
+static int encode_poster(exporter *e) {
+  AVRational frameRate = e->encCtx->framerate;
+  if (frameRate.num > 0 && frameRate.den > 0) {
+    e->ptsOffset = av_rescale_q(1, av_inv_q(frameRate), e->encCtx->time_base);
+  }
+  if (e->ptsOffset <= 0) {
+    e->ptsOffset = e->poster->duration > 0 ? e->poster->duration : 1;
+  }
+  e->poster->pts = 0;
+  e->poster->pict_type = AV_PICTURE_TYPE_I;
+  return encode(e, e->poster);
+}
+
+// export_with exports with the hardware encoder hw, or libx264 if it is NULL.
+// *started is set once the output header has been written, before which a
+// failure leaves nothing behind and the export can be retried.
+static int export_with(const char *concatFilePath, const char *outputPath,
+                       const char *filterSpec, const int64_t bitrate,
+                       const char *preset, const int threads,
+                       const hw_accel *hw, const char *hwDevice,
+                       const video_store_export_hud *hud,
+                       const int64_t posterMicroseconds,
+                       const video_store_export_hls *hls, const int audio,
+                       const int64_t audioOffsetMicroseconds,
+                       video_store_export_progress *progress,
+                       char *encoderName, int *started) {
+  exporter e = {0};
+  int ret = 0;
+  e.threads = threads;
+  e.hls = hls;
+  e.audio = audio;
+  e.audioOffsetMicroseconds = audioOffsetMicroseconds;
+  e.lastAudioDts = AV_NOPTS_VALUE;
+  e.progress = progress;
+  e.hw = hw;
+  e.hud = hud;
+  e.posterMicroseconds = posterMicroseconds;
+  e.lastPts = AV_NOPTS_VALUE;
+  e.pkt = av_packet_alloc();
+  e.frame = av_frame_alloc();
+  e.filtFrame = av_frame_alloc();
+  if (posterMicroseconds >= 0) {
+    e.poster = av_frame_alloc();
+  }
+  if (e.pkt == NULL || e.frame == NULL || e.filtFrame == NULL ||
+      (posterMicroseconds >= 0 && e.poster == NULL)) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to allocate packet or frames\n");
+    ret = VIDEO_STORE_EXPORT_RESP_ERROR;
+    goto cleanup;
+  }
+  if (e.hw != NULL && (ret = open_hw_device(&e, hwDevice)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = open_input(&e, concatFilePath)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = open_filter(&e, filterSpec)) < 0) {
+    goto cleanup;
+  }
+  if (e.poster != NULL &&
+      (ret = capture_poster(&e, concatFilePath, filterSpec)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = open_output(&e, outputPath, bitrate, preset)) < 0) {
+    goto cleanup;
+  }
+  *started = 1;
+  snprintf(encoderName, VIDEO_STORE_EXPORT_ENCODER_NAME_LEN, "%s",
+           e.encCtx->codec->name);
+
+  if (e.poster != NULL && (ret = encode_poster(&e)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = read_input(&e)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = encode(&e, NULL)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = av_write_trailer(e.outCtx)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export failed to write trailer: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  ret = VIDEO_STORE_EXPORT_RESP_OK;
+
+cleanup:
+  if (ret == AVERROR_EXIT && canceled(&e) < 0) {
+    ret = VIDEO_STORE_EXPORT_RESP_CANCELED;
+  }
+  if (ret == ERROR_NO_AUDIO) {
+    ret = VIDEO_STORE_EXPORT_RESP_NO_AUDIO;
+  }
+  if (e.outCtx != NULL) {
+    // Closing flushes what is left of the output, which fails with ENOSPC if
+    // the disk fills, so an export which had succeeded until then fails too.
+    int err = 0;
+    if (e.outCtx->pb != NULL && (err = avio_closep(&e.outCtx->pb)) < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export failed to close output file: %s\n",
+             av_err2str(err));
+      if (ret == VIDEO_STORE_EXPORT_RESP_OK) {
+        ret = err;
+      }
+    }
+    avformat_free_context(e.outCtx);
+  }
+  avcodec_free_context(&e.encCtx);
+  avfilter_graph_free(&e.graph);
+  avcodec_free_context(&e.decCtx);
+  avformat_close_input(&e.inCtx);
+  av_frame_free(&e.poster);
+  av_frame_free(&e.filtFrame);
+  av_frame_free(&e.frame);
+  av_packet_free(&e.pkt);
+  av_buffer_unref(&e.hwDeviceCtx);
+  return ret;
+}
+
+int video_store_export(const char *concatFilePath,            // IN
+                       const char *outputPath,                // IN
+                       const char *filterSpec,                // IN
+                       const int64_t bitrate,                 // IN
+                       const char *preset,                    // IN
+                       const int threads,                     // IN
+                       const char *hwAccel,                   // IN
+                       const char *hwDevice,                  // IN
+                       const video_store_export_hud *hud,     // IN
+                       const int64_t posterMicroseconds,      // IN
+                       const video_store_export_hls *hls,     // IN
+                       const int audio,                       // IN
+                       const int64_t audioOffsetMicroseconds, // IN
+                       video_store_export_progress *progress, // IN
+                       char *encoderName                      // OUT
+) {
+  int started = 0;
+  int ret;
+  int count = sizeof(hwAccels) / sizeof(hwAccels[0]);
+  int automatic = hwAccel != NULL && strcmp(hwAccel, "auto") == 0;
+  for (int i = 0; hwAccel != NULL && hwAccel[0] != '\0' && i < count; i++) {
+    if (!automatic && strcmp(hwAccel, hwAccels[i].name) != 0) {
+      continue;
+    }
+    ret = export_with(concatFilePath, outputPath, filterSpec, bitrate, preset,
+                      threads, &hwAccels[i], hwDevice, hud, posterMicroseconds,
+                      hls, audio, audioOffsetMicroseconds, progress,
+                      encoderName, &started);
+    // A canceled export, or one without audio, isn't retried with the next
+    // encoder.
+    if (started || ret == VIDEO_STORE_EXPORT_RESP_CANCELED ||
+        ret == VIDEO_STORE_EXPORT_RESP_NO_AUDIO) {
+      return ret;
+    }
+    av_log(NULL, AV_LOG_WARNING,
+           "video_store_export failed to set up %s encoding: %s\n",
+           hwAccels[i].name, av_err2str(ret));
+  }
+  return export_with(concatFilePath, outputPath, filterSpec, bitrate, preset,
+                     threads, NULL, NULL, hud, posterMicroseconds, hls, audio,
+                     audioOffsetMicroseconds, progress, encoderName, &started);
+}
@@ -0,0 +1,985 @@
+package videostore
+
+/*
+#include "export.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// padColorPattern matches the FFmpeg color names and hex codes accepted as pad colors.
+var padColorPattern = regexp.MustCompile(`^([a-zA-Z]+|(#|0x)[0-9a-fA-F]{6}([0-9a-fA-F]{2})?)$`)
+
+// exportPreset is the x264 preset used to encode exports from stores without an encoder config.
+const exportPreset = "medium"
+
+// maxBoomerangDuration is the longest range a boomerang can be made of. The reverse
+// filter holds every decoded frame of the range in memory.
+const maxBoomerangDuration = 10 * time.Second
+
+// maxAudioOffset bounds ExportAudio.Offset. Cameras' A/V offsets are a fraction of a
+// second, larger ones are more likely a mistaken unit.
+const maxAudioOffset = 2 * time.Second
+
+// minRampSpeed and maxRampSpeed bound SpeedRamp.Speed.
+const (
+	minRampSpeed = 0.1
+	maxRampSpeed = 10
+)
+
+// noPoster is passed to export for exports without a poster frame.
+const noPoster time.Duration = -1
+
+// exportProgressInterval is how often the progress of an export is reported.
+const exportProgressInterval = 250 * time.Millisecond
+
+// maxRunningFraction bounds the fraction reported while an export is running, as the
+// estimate of its length can be short, so only a finished export reports 1.
+const maxRunningFraction = 0.99
+
+// softwareEncoder is the encoder exports use without hardware acceleration.
+const softwareEncoder = "libx264"
+
+// fontPaths are searched in order for the font used to draw text on exports.
+var fontPaths = []string{
+	"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/TTF/DejaVuSans.ttf",
+	"/usr/share/fonts/truetype/liberation/LiberationSans-Regular.ttf",
+	"/System/Library/Fonts/Supplemental/Arial.ttf",
+	"/Library/Fonts/Arial.ttf",
+}
+
+// ExportRequest is the request to the Export method.
+// Unlike Save, Export re-encodes the video so it can be changed on the way out.
+type ExportRequest struct {
+	From     time.Time
+	To       time.Time
+	Metadata string
+	// Annotations are drawn on the video as captions during their intervals.
+	Annotations []Annotation
+	// Crop, if set, cuts the video down to a region of the frame.
+	Crop *CropRegion
+	// SmartCrop, if set, crops the video to an aspect ratio around the activity in it.
+	SmartCrop *SmartCrop
+	// Pad, if set, letterboxes or pillarboxes the (cropped) video to an aspect ratio.
+	Pad *Pad
+	// Enhance, if set, denoises and sharpens the (cropped) video, at a large CPU cost.
+	Enhance *Enhance
+	// Subtitles, if set, are rendered on the video as captions with their SRT styling.
+	Subtitles *Subtitles
+	// Activity, if set, skips the parts of the range without motion so only the
+	// active intervals are exported, back to back.
+	Activity *ActivityFilter
+	// Detections, if set, skips the parts of the range without a detection of interest
+	// so only the intervals with one are exported, back to back, and can crop the video
+	// to the detected objects.
+	Detections *Detections
+	// GapFill, if set, fills the gaps in recording across the range, so the export plays
+	// as one continuous timeline rather than jumping over them.
+	GapFill *GapFill
+	// FrameRate, if set, normalizes the export to a constant frame rate by duplicating
+	// or dropping frames, for players which assume one. 0 keeps the stored timestamps,
+	// which are variable when the source dropped frames or sent them irregularly.
+	FrameRate int
+	// Watermark, if set, overlays an image such as a logo on the video.
+	Watermark *Watermark
+	// Telemetry, if set, is drawn on the video as a HUD synced to each frame.
+	Telemetry *Telemetry
+	// SpeedRamps change the playback speed of intervals, e.g. to slow down key moments,
+	// while the rest plays at normal speed.
+	SpeedRamps []SpeedRamp
+	// Boomerang, if set, plays the (changed) video forward then in reverse so it loops
+	// seamlessly, doubling its length. The range can be at most maxBoomerangDuration.
+	Boomerang bool
+	// Poster, if set, is the time of a frame shown first, before the export plays from
+	// the start, so players and previews open on it. It is marked as the poster in the
+	// container metadata.
+	Poster *time.Time
+	// Orient, if set, rotates the frames by the rotation metadata of the source so the
+	// export is upright in every player, including those which ignore the metadata.
+	// Exports never carry rotation metadata, as the frames are re-encoded.
+	Orient bool
+	// Rotation, if set, is the clockwise rotation in degrees, 0, 90, 180 or 270, to
+	// orient the video by instead of the source's rotation metadata.
+	Rotation *int
+	// Progress, if set, is called with the progress of the export while it is written,
+	// at most every exportProgressInterval, and with a Fraction of 1 once it is done. It
+	// is called from one goroutine at a time and Export returns after the last call, so it
+	// shouldn't block.
+	Progress func(ExportProgress)
+	// HLS, if set, writes the export as encrypted HLS to a directory instead of an mp4.
+	HLS *HLSOutput
+	// Intro and Outro, if set, are played before and after the (changed) video.
+	Intro *Bumper
+	Outro *Bumper
+	// Audio, if set, keeps the audio track of the stored video, which is dropped
+	// otherwise.
+	Audio *ExportAudio
+}
+
+// ExportAudio is the audio track of an export. It is copied from the stored video as is
+// rather than re-encoded, so it can't be retimed like the video.
+type ExportAudio struct {
+	// Offset delays the audio relative to the video, or advances it if negative, to
+	// correct a camera's fixed A/V offset. It is at most 2 seconds either way. Audio
+	// advanced before the start of the export is cut.
+	Offset time.Duration
+}
+
+// ExportProgress is how much of an export has been written.
+type ExportProgress struct {
+	// Fraction is the estimated fraction of the export written, from 0 to 1.
+	Fraction float64
+	Frames   int64
+	Bytes    int64
+}
+
+// CropRegion is a rectangle of the frame in pixels from the top left corner.
+type CropRegion struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// Pad is a target aspect ratio to pad video to, e.g. 16:9, centering the video
+// on a background of Color.
+type Pad struct {
+	AspectWidth  int
+	AspectHeight int
+	// Color is an FFmpeg color name or hex code, e.g. "black" or "#1a1a1a". Defaults to black.
+	Color string
+}
+
+// Annotation is text that applies to an interval of video.
+type Annotation struct {
+	From time.Time
+	To   time.Time
+	Text string
+}
+
+// SpeedRamp plays an interval of an export at Speed times normal speed, e.g. 0.25 for
+// slow motion or 4 to fast forward. Frames are retimed, not interpolated or dropped.
+type SpeedRamp struct {
+	From  time.Time
+	To    time.Time
+	Speed float64
+}
+
+// ExportResponse is the response to the Export method.
+type ExportResponse struct {
+	Filename string
+	// Encoder is the encoder the export was encoded with, e.g. libx264, or h264_vaapi
+	// when it was hardware accelerated.
+	Encoder string
+	// Manifest is the name of the JSON manifest written next to the export, which lists
+	// the source segments and their hashes and how the export was made.
+	Manifest string
+	// Key is the hex AES-128 key the segments of an HLS export are encrypted with, empty
+	// for an mp4. Filename is then the playlist, in the export's directory.
+	Key string
+}
+
+// Validate returns an error if the ExportRequest is invalid.
+func (r *ExportRequest) Validate() error {
+	if r.From.After(r.To) {
+		return errors.New("'from' timestamp is after 'to' timestamp")
+	}
+	if r.To.After(time.Now()) {
+		return errors.New("'to' timestamp is in the future")
+	}
+	for i, a := range r.Annotations {
+		if a.From.After(a.To) {
+			return fmt.Errorf("annotation %d 'from' timestamp is after 'to' timestamp", i)
+		}
+		if a.Text == "" {
+			return fmt.Errorf("annotation %d text can't be blank", i)
+		}
+	}
+	if c := r.Crop; c != nil {
+		if c.X < 0 || c.Y < 0 {
+			return errors.New("crop x and y can't be less than 0")
+		}
+		// Exports are encoded as yuv420p which subsamples chroma by 2 in each direction.
+		if c.Width <= 0 || c.Height <= 0 || c.Width%2 != 0 || c.Height%2 != 0 {
+			return errors.New("crop width and height must be even and greater than 0")
+		}
+	}
+	if c := r.SmartCrop; c != nil {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if r.Crop != nil || (r.Detections != nil && r.Detections.Crop) {
+			return errors.New("smart crop can't be combined with crop or detections crop")
+		}
+		// Activity is found in the source frame, which the crop would be applied after rotating.
+		if r.Orient || r.Rotation != nil {
+			return errors.New("smart crop can't be combined with orient or rotation")
+		}
+	}
+	if r.Enhance != nil {
+		if err := r.Enhance.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Subtitles != nil {
+		if err := r.Subtitles.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Activity != nil {
+		if err := r.Activity.Validate(); err != nil {
+			return err
+		}
+	}
+	if d := r.Detections; d != nil {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		if r.Activity != nil {
+			return errors.New("activity can't be combined with detections")
+		}
+		if d.Crop && r.Crop != nil {
+			return errors.New("detections crop can't be combined with crop")
+		}
+		// Boxes are in the source frame, which the crop would be applied after rotating.
+		if d.Crop && (r.Orient || r.Rotation != nil) {
+			return errors.New("detections crop can't be combined with orient or rotation")
+		}
+	}
+	trim := r.trimmedBy()
+	if r.GapFill != nil {
+		if err := r.GapFill.Validate(); err != nil {
+			return err
+		}
+		// The gaps to fill are between the video for the whole range, which trimming skips.
+		if trim != "" {
+			return fmt.Errorf("%s can't be combined with gap fill", trim)
+		}
+	}
+	// Captions are timed against the whole range, which trimming condenses.
+	if trim != "" && (len(r.Annotations) > 0 || r.Subtitles != nil) {
+		return fmt.Errorf("%s can't be combined with annotations or subtitles", trim)
+	}
+	if r.FrameRate < 0 {
+		return errors.New("frame rate can't be less than 0")
+	}
+	if r.Watermark != nil {
+		if err := r.Watermark.Validate(); err != nil {
+			return err
+		}
+		// The timestamp is drawn from the time into the export, which trimming condenses.
+		if r.Watermark.Timestamp && trim != "" {
+			return fmt.Errorf("%s can't be combined with a watermark timestamp", trim)
+		}
+	}
+	if r.Telemetry != nil {
+		if err := r.Telemetry.Validate(); err != nil {
+			return err
+		}
+		// Frames are matched to telemetry by their time into the export, which trimming condenses.
+		if trim != "" {
+			return fmt.Errorf("%s can't be combined with telemetry", trim)
+		}
+	}
+	if len(r.SpeedRamps) > 0 {
+		ramps := slices.Clone(r.SpeedRamps)
+		slices.SortFunc(ramps, func(a, b SpeedRamp) int { return a.From.Compare(b.From) })
+		for i, ramp := range ramps {
+			if !ramp.From.Before(ramp.To) {
+				return errors.New("speed ramp 'from' timestamp must be before 'to' timestamp")
+			}
+			if ramp.From.Before(r.From) || ramp.To.After(r.To) {
+				return fmt.Errorf("speed ramp from %s to %s is outside of the export range", ramp.From, ramp.To)
+			}
+			if i > 0 && ramp.From.Before(ramps[i-1].To) {
+				return fmt.Errorf("speed ramp from %s to %s overlaps another ramp", ramp.From, ramp.To)
+			}
+			if ramp.Speed < minRampSpeed || ramp.Speed > maxRampSpeed {
+				return fmt.Errorf("speed ramp speed must be between %g and %g", minRampSpeed, maxRampSpeed)
+			}
+		}
+		// Ramps are timed against the whole range, which trimming condenses.
+		if trim != "" {
+			return fmt.Errorf("%s can't be combined with speed ramps", trim)
+		}
+	}
+	if r.Boomerang && r.To.Sub(r.From) > maxBoomerangDuration {
+		return fmt.Errorf("boomerang range can be at most %s", maxBoomerangDuration)
+	}
+	if r.Poster != nil {
+		if r.Poster.Before(r.From) || r.Poster.After(r.To) {
+			return fmt.Errorf("poster %s is outside of the export range", r.Poster)
+		}
+		// The poster is found by its time into the export, which trimming condenses.
+		if trim != "" {
+			return fmt.Errorf("%s can't be combined with a poster", trim)
+		}
+		// The poster is only marked in mp4 metadata.
+		if r.HLS != nil {
+			return errors.New("hls can't be combined with a poster")
+		}
+	}
+	if r.HLS != nil {
+		if err := r.HLS.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Intro != nil {
+		if err := r.Intro.Validate(); err != nil {
+			return fmt.Errorf("intro: %w", err)
+		}
+	}
+	if r.Outro != nil {
+		if err := r.Outro.Validate(); err != nil {
+			return fmt.Errorf("outro: %w", err)
+		}
+	}
+	if a := r.Audio; a != nil {
+		if a.Offset < -maxAudioOffset || a.Offset > maxAudioOffset {
+			return fmt.Errorf("audio offset must be between -%s and %s", maxAudioOffset, maxAudioOffset)
+		}
+		// The audio is copied as is, so it can't follow changes to the video's timing.
+		switch {
+		case len(r.SpeedRamps) > 0:
+			return errors.New("audio can't be combined with speed ramps")
+		case r.Boomerang:
+			return errors.New("audio can't be combined with boomerang")
+		case r.Intro != nil || r.Outro != nil:
+			return errors.New("audio can't be combined with an intro or outro")
+		case r.HLS != nil:
+			return errors.New("audio can't be combined with hls")
+		}
+	}
+	if r.Rotation != nil && *r.Rotation != 0 && *r.Rotation != 90 && *r.Rotation != 180 && *r.Rotation != 270 {
+		return fmt.Errorf("invalid rotation %d, must be 0, 90, 180 or 270", *r.Rotation)
+	}
+	if p := r.Pad; p != nil {
+		if p.AspectWidth <= 0 || p.AspectHeight <= 0 {
+			return errors.New("pad aspect ratio must be greater than 0")
+		}
+		if p.Color != "" && !padColorPattern.MatchString(p.Color) {
+			return fmt.Errorf("invalid pad color %s, must be a color name or hex code", p.Color)
+		}
+	}
+	return nil
+}
+
+// trimmedBy returns the name of the option which trims the export down to intervals of
+// its range, or "" if it is exported whole.
+func (r *ExportRequest) trimmedBy() string {
+	switch {
+	case r.Activity != nil:
+		return "activity"
+	case r.Detections != nil:
+		return "detections"
+	default:
+		return ""
+	}
+}
+
+// Export re-encodes the video between r.From and r.To with the requested changes
+// and writes it to the upload path. If ctx is done before the export is, it stops and
+// the partial output is removed, as it is when the upload path is out of space, which
+// returns ErrDiskFull.
+func (vs *videostore) Export(ctx context.Context, r *ExportRequest) (*ExportResponse, error) {
+	// Convert incoming local times to UTC for consistent timestamp handling
+	r.From = r.From.UTC()
+	r.To = r.To.UTC()
+	if r.Poster != nil {
+		poster := r.Poster.UTC()
+		r.Poster = &poster
+	}
+	if err := r.resolveExportInputs(vs.config.Storage.ExportInputPath); err != nil {
+		return nil, err
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	vs.logger.Debug("export command received and validated")
+
+	// Crop, pad, watermark and bumper sizes depend on the size of the stored video, and
+	// orientation on its rotation, and gap fills on its frame rate.
+	var source videoInfo
+	cropDetections := r.Detections != nil && r.Detections.Crop
+	bumpers := r.Intro != nil || r.Outro != nil
+	if r.Crop != nil || cropDetections || r.SmartCrop != nil || r.GapFill.fills() || r.Pad != nil || r.Watermark != nil || bumpers || r.Orient || r.Rotation != nil {
+		var err error
+		if source, err = vs.sourceVideoInfo(r.From, r.To); err != nil {
+			return nil, err
+		}
+	}
+	rotation := 0
+	if r.Rotation != nil {
+		rotation = *r.Rotation
+	} else if r.Orient {
+		rotation = source.rotation
+	}
+	ranges := []timeRange{{from: r.From, to: r.To}}
+	if r.Detections != nil {
+		var err error
+		// The crop is found with the ranges and applied like a requested one.
+		if ranges, r, err = vs.detectedRanges(r, image.Pt(source.width, source.height)); err != nil {
+			return nil, err
+		}
+	}
+	if r.SmartCrop != nil {
+		var err error
+		if r, err = vs.smartCropped(ctx, r, image.Pt(source.width, source.height)); err != nil {
+			return nil, err
+		}
+	}
+	uploadFilePath := generateOutputFilePath(
+		vs.config.Storage.OutputFileNamePrefix,
+		r.From,
+		r.Metadata,
+		vs.config.Storage.UploadPath,
+	)
+	var err error
+	if r.Activity != nil {
+		if ranges, err = vs.activeRanges(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+	var concatFilePath string
+	var entries []concatFileEntry
+	var gaps []timeRange
+	if r.GapFill.fills() {
+		concatFilePath, entries, gaps, err = vs.concater.writeConcatFileGapFilled(r.From, r.To, r.GapFill.minGap(), uploadFilePath)
+	} else {
+		concatFilePath, entries, err = vs.concater.writeConcatFileRanges(r.From, r.To, ranges, uploadFilePath)
+	}
+	defer vs.concater.removeConcatFile(concatFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(gaps) > 0 && r.FrameRate == 0 {
+		vs.logger.Debugf("filling %d gaps in the export", len(gaps))
+		// Gaps are filled with frames at the source's frame rate.
+		gapFilled := *r
+		gapFilled.FrameRate = gapFillFrameRate(source.frameRate)
+		r = &gapFilled
+	}
+	var subtitlesPath string
+	if r.Subtitles != nil {
+		if subtitlesPath, err = r.Subtitles.writeSRT(r.From); err != nil {
+			return nil, err
+		}
+		defer os.Remove(subtitlesPath)
+	}
+	filters, err := exportFilters(r, image.Pt(source.width, source.height), rotation, gaps, subtitlesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var hud *cHUD
+	if r.Telemetry != nil {
+		if hud, err = r.Telemetry.hud(r.From); err != nil {
+			return nil, err
+		}
+		defer hud.free()
+	}
+
+	poster := noPoster
+	if r.Poster != nil {
+		poster = rampedOffset(r.SpeedRamps, r.From, *r.Poster)
+		if r.Intro != nil {
+			poster += r.Intro.duration()
+		}
+	}
+	duration := exportDuration(r, ranges)
+	encoderConfig := vs.exportEncoderConfig()
+	size, err := exportSize(entries, duration, encoderConfig.Bitrate)
+	if err != nil {
+		return nil, err
+	}
+	if err := vs.checkExportSpace(ctx, size); err != nil {
+		return nil, err
+	}
+	var progress *exportProgress
+	if r.Progress != nil {
+		progress = &exportProgress{report: r.Progress, duration: duration}
+	}
+	outputPath := uploadFilePath
+	var hls *hlsExport
+	if r.HLS != nil {
+		if hls, err = newHLSExport(uploadFilePath, r.HLS, vs.logger); err != nil {
+			return nil, err
+		}
+		defer hls.free()
+		outputPath = hls.playlistPath()
+	}
+	// removeExport removes the export and its manifest, which for HLS are in its directory.
+	removeExport := func() {
+		paths, remove := []string{outputPath, outputPath + manifestExt}, os.Remove
+		if hls != nil {
+			paths, remove = []string{hls.dir}, os.RemoveAll
+		}
+		for _, path := range paths {
+			if err := remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				vs.logger.Warnf("failed to remove export %s: %v", path, err)
+			}
+		}
+	}
+	encoder, err := export(ctx, concatFilePath, outputPath, strings.Join(filters, ","), encoderConfig, hud, poster, hls, r.Audio, progress)
+	if err != nil {
+		vs.logger.Error("failed to export ", err)
+		// Don't leave a partial export to be uploaded.
+		removeExport()
+		return nil, vs.exportDiskFull(ctx, err)
+	}
+	if encoderConfig.HardwareAccel != "" && encoder == softwareEncoder {
+		vs.logger.Warnf("hardware_accel %s is unavailable, exported with %s", encoderConfig.HardwareAccel, encoder)
+	}
+	var parts []string
+	if hls != nil {
+		if parts, err = hls.segments(); err != nil {
+			removeExport()
+			return nil, err
+		}
+	}
+	if err := vs.writeExportManifest(outputPath, parts, r, filters, entries, encoderConfig, encoder); err != nil {
+		vs.logger.Error("failed to write export manifest ", err)
+		// An export is only uploaded with its manifest.
+		removeExport()
+		return nil, vs.exportDiskFull(ctx, err)
+	}
+	filename, err := filepath.Rel(vs.config.Storage.UploadPath, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	res := &ExportResponse{
+		Filename: filename,
+		Encoder:  encoder,
+		Manifest: filename + manifestExt,
+	}
+	if hls != nil {
+		res.Key = hex.EncodeToString(hls.key)
+	}
+	return res, nil
+}
+
+// exportEncoderConfig returns the encoder config video is re-encoded with.
+func (vs *videostore) exportEncoderConfig() EncoderConfig {
+	config := vs.config.Encoder
+	if config.Preset == "" {
+		config.Preset = exportPreset
+	}
+	return config
+}
+
+// activeRanges returns the ranges with activity between r.From and r.To.
+func (vs *videostore) activeRanges(ctx context.Context, r *ExportRequest) ([]timeRange, error) {
+	files, err := getSortedFiles(vs.config.Storage.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(files, r.From, r.To); err != nil {
+		return nil, err
+	}
+	ranges, err := detectActivity(ctx, files, r.From, r.To, *r.Activity)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no activity found in time range")
+	}
+	vs.logger.Debugf("exporting %d active intervals", len(ranges))
+	return ranges, nil
+}
+
+// detectedRanges returns the ranges with a detection of interest between r.From and
+// r.To, and r with the crop to the detected objects if r.Detections.Crop is set. r itself
+// isn't changed.
+func (vs *videostore) detectedRanges(r *ExportRequest, size image.Point) ([]timeRange, *ExportRequest, error) {
+	samples, err := r.Detections.samples()
+	if err != nil {
+		return nil, nil, err
+	}
+	ranges, box := detectionRanges(samples, r.From, r.To, *r.Detections)
+	if len(ranges) == 0 {
+		return nil, nil, errors.New("no detections found in time range")
+	}
+	vs.logger.Debugf("exporting %d intervals with detections", len(ranges))
+	if !r.Detections.Crop {
+		return ranges, r, nil
+	}
+	crop, err := detectionCrop(box, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	cropped := *r
+	cropped.Crop = &crop
+	return ranges, &cropped, nil
+}
+
+// sourceVideoInfo returns the video info of the first stored segment with video between
+// from and to, which is after from when the range starts in a gap.
+func (vs *videostore) sourceVideoInfo(from, to time.Time) (videoInfo, error) {
+	files, err := vs.concater.files()
+	if err != nil {
+		return videoInfo{}, err
+	}
+	entries := matchStorageToRange(files, from, to, vs.logger)
+	if len(entries) == 0 {
+		return videoInfo{}, fmt.Errorf("no video found between %s and %s", from, to)
+	}
+	return getVideoInfo(entries[0].filePath)
+}
+
+// exportFilters returns the libavfilter filters which apply the changes in r, in order,
+// to video with frames of size, first rotating it clockwise by rotation degrees. gaps are
+// those kept to be filled, and subtitlesPath is the SRT file written for r.Subtitles.
+func exportFilters(r *ExportRequest, size image.Point, rotation int, gaps []timeRange, subtitlesPath string) ([]string, error) {
+	var filters []string
+	// The other changes apply to the upright video.
+	switch rotation {
+	case 90:
+		filters = append(filters, "transpose=dir=clock")
+		size = image.Pt(size.Y, size.X)
+	case 180:
+		filters = append(filters, "hflip", "vflip")
+	case 270:
+		filters = append(filters, "transpose=dir=cclock")
+		size = image.Pt(size.Y, size.X)
+	}
+	// Gaps are filled first, so the other changes apply to the fill as to the video.
+	if len(gaps) > 0 {
+		fill, err := r.GapFill.filters(gaps, r.From, r.To, r.FrameRate)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, fill...)
+	}
+	// Retimed video is normalized after it is retimed, so slowed intervals are filled in.
+	// Gap filled video has already been normalized.
+	if r.FrameRate > 0 && len(r.SpeedRamps) == 0 && len(gaps) == 0 {
+		filters = append(filters, fmt.Sprintf("fps=fps=%d", r.FrameRate))
+	}
+	if c := r.Crop; c != nil {
+		region := image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height)
+		if !region.In(image.Rect(0, 0, size.X, size.Y)) {
+			return nil, fmt.Errorf("crop region %v is outside of the %dx%d frame", region, size.X, size.Y)
+		}
+		filters = append(filters, fmt.Sprintf("crop=w=%d:h=%d:x=%d:y=%d", c.Width, c.Height, c.X, c.Y))
+		size = region.Size()
+	}
+	// Only the cropped frame is enhanced, before padding adds flat borders and overlays
+	// are drawn, which would be blurred or sharpened with it.
+	if r.Enhance != nil {
+		filters = append(filters, r.Enhance.filters()...)
+	}
+	if p := r.Pad; p != nil {
+		width, height := padSize(size, p.AspectWidth, p.AspectHeight)
+		color := p.Color
+		if color == "" {
+			color = "black"
+		}
+		filters = append(filters, fmt.Sprintf("pad=w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2:color=%s", width, height, color))
+		size = image.Pt(width, height)
+	}
+	if r.Watermark != nil {
+		watermark, err := r.Watermark.filter(size, r.From)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, watermark)
+	}
+	if r.Telemetry != nil {
+		font, err := findFont()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, hudFilter(font))
+	}
+	if annotations := r.Annotations; len(annotations) > 0 {
+		font, err := findFont()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range annotations {
+			// Times in the filter graph are relative to the start of the export.
+			start := max(a.From.Sub(r.From), 0).Seconds()
+			end := a.To.Sub(r.From).Seconds()
+			if end < 0 || a.From.After(r.To) {
+				continue
+			}
+			filters = append(filters, annotationFilter(font, a.Text, start, end))
+		}
+	}
+	if subtitlesPath != "" {
+		// Without a font of its own libass falls back to the fonts it finds on the system.
+		font, _ := findFont()
+		filters = append(filters, subtitlesFilter(subtitlesPath, font))
+	}
+	if len(r.SpeedRamps) > 0 {
+		// Overlays are drawn before retiming so they stay in sync with the video.
+		filters = append(filters, speedRampFilter(r.SpeedRamps, r.From))
+		if r.FrameRate > 0 {
+			filters = append(filters, fmt.Sprintf("fps=fps=%d", r.FrameRate))
+		}
+	}
+	if r.Boomerang {
+		// Like the watermark this splits the graph, appending a reversed copy to the video.
+		// The reversed copy starts from the frame before the last so the turn isn't a
+		// repeated frame, and is rebased to 0 as concat expects.
+		filters = append(filters, "split[forward][backward];"+
+			"[backward]reverse,trim=start_frame=1,setpts=PTS-STARTPTS[reversed];"+
+			"[forward][reversed]concat=n=2:v=1:a=0")
+	}
+	// Bumpers play around the finished footage, so they are neither retimed nor looped.
+	if r.Intro != nil || r.Outro != nil {
+		bumpers, err := bumperFilter(r.Intro, r.Outro, size, r.FrameRate)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, bumpers)
+	}
+	return filters, nil
+}
+
+// exportDuration returns the expected duration of the export of ranges with the changes
+// in r, which is used to estimate its progress.
+func exportDuration(r *ExportRequest, ranges []timeRange) time.Duration {
+	var d time.Duration
+	for _, tr := range ranges {
+		d += tr.to.Sub(tr.from)
+	}
+	// With activity the ramps retime the active intervals back to back, like the filter.
+	d = rampedOffset(r.SpeedRamps, r.From, r.From.Add(d))
+	if r.Boomerang {
+		d *= 2
+	}
+	for _, b := range []*Bumper{r.Intro, r.Outro} {
+		if b != nil {
+			d += b.duration()
+		}
+	}
+	return d
+}
+
+// padSize returns the smallest even frame size with the aspect ratio aspectWidth:aspectHeight
+// which contains a frame of size.
+func padSize(size image.Point, aspectWidth, aspectHeight int) (int, int) {
+	width, height := size.X, size.Y
+	if width*aspectHeight < height*aspectWidth {
+		width = (height*aspectWidth + aspectHeight - 1) / aspectHeight
+	} else {
+		height = (width*aspectHeight + aspectWidth - 1) / aspectWidth
+	}
+	return (width + 1) &^ 1, (height + 1) &^ 1
+}
+
+// annotationFilter returns a drawtext filter which draws text as a caption between start and end seconds.
+func annotationFilter(font, text string, start, end float64) string {
+	return "drawtext=" + strings.Join([]string{
+		"fontfile=" + escapeFilterValue(font),
+		"text=" + escapeFilterValue(text),
+		"expansion=none",
+		"fontsize=h/18",
+		"fontcolor=white",
+		"box=1",
+		"boxcolor=black@0.6",
+		"boxborderw=10",
+		"x=(w-text_w)/2",
+		"y=h-text_h-h/12",
+		fmt.Sprintf("enable=between(t\\,%.3f\\,%.3f)", start, end),
+	}, ":")
+}
+
+// speedRampFilter returns a setpts filter which plays each ramp at its speed in an export
+// starting at from. Each frame is delayed by the extra time the ramps before it take, the
+// time into each ramp is clipped to its length and scaled by 1/speed - 1.
+func speedRampFilter(ramps []SpeedRamp, from time.Time) string {
+	expr := "T"
+	for _, ramp := range ramps {
+		start := ramp.From.Sub(from).Seconds()
+		length := ramp.To.Sub(ramp.From).Seconds()
+		expr += fmt.Sprintf("+%.6f*clip(T-%.6f\\,0\\,%.6f)", 1/ramp.Speed-1, start, length)
+	}
+	return fmt.Sprintf("setpts=(%s)/TB", expr)
+}
+
+// rampedOffset returns the time into an export starting at from that t plays at once
+// the ramps have retimed it, like speedRampFilter.
+func rampedOffset(ramps []SpeedRamp, from, t time.Time) time.Duration {
+	offset := t.Sub(from).Seconds()
+	retimed := offset
+	for _, ramp := range ramps {
+		start := ramp.From.Sub(from).Seconds()
+		length := ramp.To.Sub(ramp.From).Seconds()
+		retimed += (1/ramp.Speed - 1) * min(max(offset-start, 0), length)
+	}
+	return time.Duration(retimed * float64(time.Second))
+}
+
+// escapeFilterValue escapes s for use as a filter option value in a filter graph.
+// The value is unescaped once when the graph is split into filters and once more
+// when each filter's options are parsed.
+func escapeFilterValue(s string) string {
+	quoted := "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	var b strings.Builder
+	for _, r := range quoted {
+		if strings.ContainsRune(`\'[],;`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// findFont returns the first font in fontPaths which exists.
+func findFont() (string, error) {
+	for _, path := range fontPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no font found to draw text, looked in: %s", strings.Join(fontPaths, ", "))
+}
+
+// exportProgress reports the progress of an export to report, estimating the fraction
+// written from the expected duration of the output.
+type exportProgress struct {
+	report   func(ExportProgress)
+	duration time.Duration
+}
+
+// read returns the progress of the export updating p, with the fraction bounded by
+// maxRunningFraction.
+func (e *exportProgress) read(p *C.video_store_export_progress) ExportProgress {
+	var frames, bytes, micros C.int64_t
+	C.video_store_export_progress_get(p, &frames, &bytes, &micros)
+	progress := ExportProgress{Frames: int64(frames), Bytes: int64(bytes)}
+	if e.duration > 0 {
+		written := time.Duration(micros) * time.Microsecond
+		progress.Fraction = min(written.Seconds()/e.duration.Seconds(), maxRunningFraction)
+	}
+	return progress
+}
+
+// export re-encodes the video listed in the concat file at concatFilePath through
+// the filter graph filterSpec to outputPath with config, and returns the name of the
+// encoder used. hud, if not nil, is set on each frame for the filter graph to draw.
+// poster, unless it is noPoster, is the time into the filtered video of a frame shown first.
+// hls, if not nil, writes the export as HLS with outputPath its playlist.
+// progress, if not nil, is reported while the export runs. If ctx is done first the
+// export stops, returning ctx's error, and outputPath is left partially written.
+func export(
+	ctx context.Context,
+	concatFilePath, outputPath, filterSpec string,
+	config EncoderConfig,
+	hud *cHUD,
+	poster time.Duration,
+	hls *hlsExport,
+	audio *ExportAudio,
+	progress *exportProgress,
+) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	cProgress := C.video_store_export_progress_alloc()
+	if cProgress == nil {
+		return "", errors.New("failed to allocate export progress")
+	}
+	defer C.video_store_export_progress_free(&cProgress)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(exportProgressInterval)
+		defer ticker.Stop()
+		var last ExportProgress
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				C.video_store_export_progress_cancel(cProgress)
+				return
+			case <-ticker.C:
+				if progress == nil {
+					continue
+				}
+				if p := progress.read(cProgress); p != last {
+					last = p
+					progress.report(p)
+				}
+			}
+		}
+	}()
+
+	concatFilePathCStr := C.CString(concatFilePath)
+	outputPathCStr := C.CString(outputPath)
+	filterSpecCStr := C.CString(filterSpec)
+	presetCStr := C.CString(config.Preset)
+	hwAccelCStr := C.CString(config.HardwareAccel)
+	hwDeviceCStr := C.CString(config.HardwareDevice)
+	defer func() {
+		C.free(unsafe.Pointer(concatFilePathCStr))
+		C.free(unsafe.Pointer(outputPathCStr))
+		C.free(unsafe.Pointer(filterSpecCStr))
+		C.free(unsafe.Pointer(presetCStr))
+		C.free(unsafe.Pointer(hwAccelCStr))
+		C.free(unsafe.Pointer(hwDeviceCStr))
+	}()
+
+	var cHUD *C.video_store_export_hud
+	if hud != nil {
+		cHUD = hud.hud
+	}
+	var cHLS *C.video_store_export_hls
+	if hls != nil {
+		cHLS = hls.c
+	}
+	var cAudio C.int
+	var audioOffset time.Duration
+	if audio != nil {
+		cAudio, audioOffset = 1, audio.Offset
+	}
+	var encoderName [C.VIDEO_STORE_EXPORT_ENCODER_NAME_LEN]C.char
+	ret := C.video_store_export(concatFilePathCStr, outputPathCStr, filterSpecCStr,
+		C.int64_t(config.Bitrate), presetCStr, C.int(config.threads()),
+		hwAccelCStr, hwDeviceCStr, cHUD, C.int64_t(poster.Microseconds()), cHLS,
+		cAudio, C.int64_t(audioOffset.Microseconds()), cProgress, &encoderName[0])
+	close(done)
+	wg.Wait()
+	switch ret {
+	case C.VIDEO_STORE_EXPORT_RESP_OK:
+		if progress != nil {
+			final := progress.read(cProgress)
+			final.Fraction = 1
+			progress.report(final)
+		}
+		return C.GoString(&encoderName[0]), nil
+	case C.VIDEO_STORE_EXPORT_RESP_CANCELED:
+		return "", fmt.Errorf("export canceled: %w", ctx.Err())
+	case C.VIDEO_STORE_EXPORT_RESP_ERROR:
+		return "", errors.New("failed to export video")
+	case C.VIDEO_STORE_EXPORT_RESP_NO_AUDIO:
+		return "", errors.New("stored video has no audio track to export")
+	case -C.int(unix.ENOSPC):
+		return "", fmt.Errorf("failed to export video: %w", unix.ENOSPC)
+	default:
+		return "", fmt.Errorf("failed to export video: error: %s", ffmpegError(ret))
+	}
+}
@@ -0,0 +1,138 @@
+#ifndef VIAM_VIDEOSTORE_EXPORT_H
+#define VIAM_VIDEOSTORE_EXPORT_H
+#include <stdint.h>
+#define VIDEO_STORE_EXPORT_RESP_OK 0
+#define VIDEO_STORE_EXPORT_RESP_ERROR 1
+#define VIDEO_STORE_EXPORT_RESP_CANCELED 2
+#define VIDEO_STORE_EXPORT_RESP_NO_AUDIO 3
+#define VIDEO_STORE_EXPORT_ENCODER_NAME_LEN 32
+// VIDEO_STORE_EXPORT_HUD_METADATA_KEY is the frame metadata key the HUD text
+// of each frame is set at, for a drawtext filter to draw with
+// %{metadata:video_store.hud}.
+#define VIDEO_STORE_EXPORT_HUD_METADATA_KEY "video_store.hud"
+#define VIDEO_STORE_EXPORT_HUD_TEXT_LEN 1024
+// VIDEO_STORE_EXPORT_POSTER_METADATA_KEY is the container metadata key set to
+// the time in seconds of the poster frame of an export made with one.
+#define VIDEO_STORE_EXPORT_POSTER_METADATA_KEY "poster_time"
+
+// video_store_export_hud_field is a telemetry value drawn as one line of a
+// HUD, interpolated linearly between its samples to the time of each frame.
+typedef struct video_store_export_hud_field {
+  // text drawn before and after the value, e.g. "Speed: " and " m/s"
+  const char *prefix;
+  const char *suffix;
+  int decimals;
+  // sample times relative to the start of the export, ascending
+  const int64_t *timesMicroseconds;
+  const double *values;
+  int sampleCount;
+} video_store_export_hud_field;
+
+// video_store_export_hud is the telemetry drawn on each frame of an export.
+typedef struct video_store_export_hud {
+  const video_store_export_hud_field *fields;
+  int fieldCount;
+  // samples further apart than maxGapMicroseconds aren't interpolated
+  // between, 0 interpolates across any gap
+  int64_t maxGapMicroseconds;
+  // if timestamp is set the wall clock time of the frame, from the start of
+  // the export at startUnixMicroseconds, is drawn as the first line
+  int timestamp;
+  int64_t startUnixMicroseconds;
+} video_store_export_hud;
+
+// video_store_export_hud_text writes the HUD text of the frame
+// timeMicroseconds into the export to text, which holds textSize bytes.
+// Fields without samples on both sides of the frame are drawn as "--".
+void video_store_export_hud_text(const video_store_export_hud *hud, // IN
+                                 const int64_t timeMicroseconds,   // IN
+                                 char *text,                       // OUT
+                                 const int textSize                // IN
+);
+
+// video_store_export_hls is the HLS output of an export, which is written in
+// place of an mp4. The segments are written to segmentPattern, a printf
+// pattern numbering them, and are encrypted with AES-128 as set out in the
+// key info file at keyInfoPath: the key URI written to the playlist, the path
+// of the key file and the IV in hex. A keyframe starts every segment of
+// segmentSeconds.
+typedef struct video_store_export_hls {
+  const char *segmentPattern;
+  const char *keyInfoPath;
+  int segmentSeconds;
+} video_store_export_hls;
+
+// video_store_export_progress is how much of an export has been written, and
+// whether it has been asked to stop. It is updated by the export as each
+// packet is written and read and canceled from other threads.
+typedef struct video_store_export_progress video_store_export_progress;
+
+// video_store_export_progress_alloc returns a zeroed progress, or NULL if it
+// can't be allocated. It is freed with video_store_export_progress_free.
+video_store_export_progress *video_store_export_progress_alloc(void);
+void video_store_export_progress_free(video_store_export_progress **progress // IN
+);
+
+// video_store_export_progress_get reads the number of frames and bytes
+// written and the end time of the last frame written in the output.
+void video_store_export_progress_get(
+    video_store_export_progress *progress, // IN
+    int64_t *frames,                       // OUT
+    int64_t *bytes,                        // OUT
+    int64_t *outputMicroseconds            // OUT
+);
+
+// video_store_export_progress_cancel asks the export to stop, which it does
+// at the next packet or frame, returning VIDEO_STORE_EXPORT_RESP_CANCELED.
+void video_store_export_progress_cancel(
+    video_store_export_progress *progress // IN
+);
+
+// video_store_export decodes the video listed in the concat demuxer file at
+// concatFilePath, passes it through the libavfilter graph described by
+// filterSpec and encodes the result with libx264 to an mp4 at outputPath.
+// Frames before the first file's inpoint are decoded but not exported, so
+// output time 0 is the start of the requested range.
+// An empty filterSpec exports the video unchanged. A bitrate of 0 uses the
+// encoder's default rate control.
+// The decoder, filter graph and encoder each use up to threads threads, 0 lets
+// FFmpeg pick based on the number of cores.
+// hwAccel, if not empty, encodes with a hardware encoder instead: "vaapi",
+// "cuda" (NVENC) or "qsv", or "auto" to try each in turn. hwDevice optionally
+// names the device to open, e.g. /dev/dri/renderD128 for VAAPI. If the
+// hardware can't be set up the export falls back to libx264. The name of the
+// encoder used is written to encoderName, which must hold
+// VIDEO_STORE_EXPORT_ENCODER_NAME_LEN bytes.
+// hud, if not NULL, is set as the VIDEO_STORE_EXPORT_HUD_METADATA_KEY metadata
+// of each decoded frame before it is filtered.
+// posterMicroseconds, if not negative, is the time in the filtered video of a
+// poster frame shown first, before the rest of the export which is delayed by
+// a frame. The poster is the first filtered frame at or after the time, or the
+// last frame if there are none, and is marked at time 0 with the
+// VIDEO_STORE_EXPORT_POSTER_METADATA_KEY metadata. The video up to the poster
+// is decoded and filtered twice to find it.
+// hls, if not NULL, writes the export as HLS, with outputPath the playlist.
+// audio, if set, copies the first audio stream of the input to the output as
+// is, delayed by audioOffsetMicroseconds, or advanced if it is negative. Audio
+// shifted before time 0 is dropped. Returns VIDEO_STORE_EXPORT_RESP_NO_AUDIO
+// if the input has no audio stream.
+// progress, if not NULL, is updated as the export is written, and the export
+// stops and returns VIDEO_STORE_EXPORT_RESP_CANCELED once it is canceled.
+// The output is left partially written.
+int video_store_export(const char *concatFilePath,            // IN
+                       const char *outputPath,                // IN
+                       const char *filterSpec,                // IN
+                       const int64_t bitrate,                 // IN
+                       const char *preset,                    // IN
+                       const int threads,                     // IN
+                       const char *hwAccel,                   // IN
+                       const char *hwDevice,                  // IN
+                       const video_store_export_hud *hud,     // IN
+                       const int64_t posterMicroseconds,      // IN
+                       const video_store_export_hls *hls,     // IN
+                       const int audio,                       // IN
+                       const int64_t audioOffsetMicroseconds, // IN
+                       video_store_export_progress *progress, // IN
+                       char *encoderName                      // OUT
+);
+#endif /* VIAM_VIDEOSTORE_EXPORT_H */
@@ -0,0 +1,743 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// newTestExportStore returns a read only video store with a segment of frames starting at start.
+func newTestExportStore(t *testing.T, start time.Time, frames [][]byte, framerate int) (*videostore, Config) {
+	config := validRTPConfig(t)
+	config.Type = SourceTypeReadOnly
+	storeTestSegment(t, config.Storage.StoragePath, start, frames, framerate)
+	storeInProgressSegment(t, config.Storage.StoragePath, start.Add(time.Minute))
+	vs, err := NewReadOnlyVideoStore(config, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(vs.Close)
+	return vs.(*videostore), config
+}
+
+// maxBrightness returns the brightest pixel in the bottom third of the frame at offset into the video at path.
+func maxBrightness(t *testing.T, path string, offset time.Duration) byte {
+	const width, height = 64, 48
+	gray, err := grayFrameAt(path, offset, width, height)
+	test.That(t, err, test.ShouldBeNil)
+	return slices.Max(gray[width*height*2/3:])
+}
+
+func TestExportAnnotations(t *testing.T) {
+	if _, err := findFont(); err != nil {
+		t.Skip(err)
+	}
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 6*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	res, err := vs.Export(context.Background(), &ExportRequest{
+		From: start,
+		To:   start.Add(6 * time.Second),
+		Annotations: []Annotation{
+			{From: start.Add(2 * time.Second), To: start.Add(4 * time.Second), Text: "it's: [annotated], really"},
+		},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	exported := filepath.Join(config.Storage.UploadPath, res.Filename)
+
+	t.Run("Annotation is not on screen before its interval", func(t *testing.T) {
+		test.That(t, maxBrightness(t, exported, time.Second), test.ShouldBeLessThan, 40)
+	})
+
+	t.Run("Annotation is on screen during its interval", func(t *testing.T) {
+		test.That(t, maxBrightness(t, exported, 3*time.Second), test.ShouldBeGreaterThan, 200)
+	})
+
+	t.Run("Annotation is not on screen after its interval", func(t *testing.T) {
+		test.That(t, maxBrightness(t, exported, 5*time.Second), test.ShouldBeLessThan, 40)
+	})
+}
+
+func TestExportCropPad(t *testing.T) {
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	// exportSize exports the stored video with crop and pad and returns the exported frame size.
+	exportSize := func(t *testing.T, metadata string, crop *CropRegion, pad *Pad) (int, int) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(2 * time.Second),
+			Metadata: metadata,
+			Crop:     crop,
+			Pad:      pad,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		return info.width, info.height
+	}
+
+	t.Run("Crop exports the region", func(t *testing.T) {
+		width, height := exportSize(t, "crop", &CropRegion{X: 100, Y: 60, Width: 320, Height: 240}, nil)
+		test.That(t, width, test.ShouldEqual, 320)
+		test.That(t, height, test.ShouldEqual, 240)
+	})
+
+	t.Run("Pad letterboxes to the aspect ratio", func(t *testing.T) {
+		width, height := exportSize(t, "pad", nil, &Pad{AspectWidth: 16, AspectHeight: 9, Color: "#202020"})
+		test.That(t, width, test.ShouldEqual, 854)
+		test.That(t, height, test.ShouldEqual, 480)
+	})
+
+	t.Run("Crop then pad pads the cropped region", func(t *testing.T) {
+		width, height := exportSize(t, "croppad", &CropRegion{Width: 400, Height: 400}, &Pad{AspectWidth: 9, AspectHeight: 16})
+		test.That(t, width, test.ShouldEqual, 400)
+		test.That(t, height, test.ShouldEqual, 712)
+	})
+
+	t.Run("Crop outside of the frame is rejected", func(t *testing.T) {
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From: start,
+			To:   start.Add(2 * time.Second),
+			Crop: &CropRegion{X: 400, Y: 0, Width: 320, Height: 240},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "outside of the 640x480 frame")
+	})
+}
+
+// frameTimes returns the presentation time of every frame in the first video stream of path.
+func frameTimes(t *testing.T, path string) []float64 {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "frame=best_effort_timestamp_time", "-of", "csv=p=0", path).Output()
+	test.That(t, err, test.ShouldBeNil)
+	times := []float64{}
+	for _, line := range strings.Fields(string(out)) {
+		f, err := strconv.ParseFloat(line, 64)
+		test.That(t, err, test.ShouldBeNil)
+		times = append(times, f)
+	}
+	return times
+}
+
+func TestExportFrameRate(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 25)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	// The first 2 seconds are at 10fps, the source then slows to 2.5fps.
+	packets := h264Packets(t, frames, framerate)
+	var pts []int64
+	for i := range packets {
+		if i < 20 {
+			pts = append(pts, int64(i)*9000)
+		} else {
+			pts = append(pts, 180000+int64(i-20)*36000)
+		}
+	}
+	segmentPath := t.TempDir()
+	rs, err := newRawSegmenter(segmentPath, rawSegmenterOptions{}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+	for i, p := range packets {
+		test.That(t, rs.WritePacket(p.payload, pts[i], pts[i], p.isIDR), test.ShouldBeNil)
+	}
+	test.That(t, rs.Close(), test.ShouldBeNil)
+	segments, err := filepath.Glob(filepath.Join(segmentPath, "*.mp4"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(segments), test.ShouldEqual, 1)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	config := validRTPConfig(t)
+	config.Type = SourceTypeReadOnly
+	test.That(t, os.MkdirAll(config.Storage.StoragePath, 0o755), test.ShouldBeNil)
+	stored := filepath.Join(config.Storage.StoragePath, strconv.FormatInt(start.Unix(), 10)+".mp4")
+	test.That(t, os.Rename(segments[0], stored), test.ShouldBeNil)
+	storeInProgressSegment(t, config.Storage.StoragePath, start.Add(time.Minute))
+	vs, err := NewReadOnlyVideoStore(config, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer vs.Close()
+
+	exportFrameTimes := func(t *testing.T, metadata string, frameRate int) []float64 {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(4 * time.Second),
+			Metadata:  metadata,
+			FrameRate: frameRate,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		return frameTimes(t, filepath.Join(config.Storage.UploadPath, res.Filename))
+	}
+
+	t.Run("Variable timing is preserved by default", func(t *testing.T) {
+		times := exportFrameTimes(t, "vfr", 0)
+		test.That(t, len(times), test.ShouldEqual, len(packets))
+		test.That(t, times[1]-times[0], test.ShouldAlmostEqual, 0.1, 0.01)
+		test.That(t, times[21]-times[20], test.ShouldAlmostEqual, 0.4, 0.01)
+	})
+
+	t.Run("Frame rate normalizes to constant frame rate", func(t *testing.T) {
+		times := exportFrameTimes(t, "cfr", 30)
+		// 3.6s to the last source frame at 30fps, plus the frames its duration covers.
+		test.That(t, len(times), test.ShouldBeGreaterThanOrEqualTo, 108)
+		for i := 1; i < len(times); i++ {
+			test.That(t, times[i]-times[i-1], test.ShouldAlmostEqual, 1.0/30, 0.001)
+		}
+	})
+
+	t.Run("Negative frame rate is rejected", func(t *testing.T) {
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(4 * time.Second),
+			FrameRate: -1,
+		})
+		test.That(t, err, test.ShouldBeError, "frame rate can't be less than 0")
+	})
+}
+
+func TestExportHardwareFallback(t *testing.T) {
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 4*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	config := validRTPConfig(t)
+	config.Type = SourceTypeReadOnly
+	config.Encoder = EncoderConfig{HardwareAccel: "vaapi", HardwareDevice: "/nonexistent/renderD128"}
+	storeTestSegment(t, config.Storage.StoragePath, start, frames, framerate)
+	storeInProgressSegment(t, config.Storage.StoragePath, start.Add(time.Minute))
+	vs, err := NewReadOnlyVideoStore(config, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer vs.Close()
+
+	t.Run("Unavailable hardware falls back to software", func(t *testing.T) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From: start,
+			To:   start.Add(4 * time.Second),
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res.Encoder, test.ShouldEqual, softwareEncoder)
+		info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.codec, test.ShouldEqual, "h264")
+		test.That(t, info.duration, test.ShouldAlmostEqual, 4*time.Second, 200*time.Millisecond)
+	})
+
+	t.Run("Unknown hardware accel is rejected", func(t *testing.T) {
+		err := EncoderConfig{Bitrate: 1000000, Preset: "medium", HardwareAccel: "metal"}.Validate()
+		test.That(t, err, test.ShouldBeError, "invalid hardware_accel metal, must be one of: auto, vaapi, cuda, qsv")
+	})
+}
+
+func TestExportBoomerang(t *testing.T) {
+	const framerate = 10
+	// The source is black for a second then white for a second.
+	black, white := solidJPEG(t, color.Black), solidJPEG(t, color.White)
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = black
+		if i >= framerate {
+			frames[i] = white
+		}
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	t.Run("Boomerang plays forward then in reverse", func(t *testing.T) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(2 * time.Second),
+			Boomerang: true,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		// The turn isn't repeated, so the boomerang is a frame short of twice the source.
+		test.That(t, info.duration, test.ShouldAlmostEqual, 4*time.Second, 200*time.Millisecond)
+
+		brightness := func(offset time.Duration) int {
+			gray, err := grayFrameAt(path, offset, 64, 48)
+			test.That(t, err, test.ShouldBeNil)
+			return meanBrightness(&image.Gray{Pix: gray, Stride: 64, Rect: image.Rect(0, 0, 64, 48)}, image.Rect(0, 0, 64, 48))
+		}
+		test.That(t, brightness(500*time.Millisecond), test.ShouldBeLessThan, 40)
+		test.That(t, brightness(2*time.Second), test.ShouldBeGreaterThan, 200)
+		test.That(t, brightness(3500*time.Millisecond), test.ShouldBeLessThan, 40)
+	})
+
+	t.Run("Boomerang range must be short", func(t *testing.T) {
+		r := &ExportRequest{From: start, To: start.Add(maxBoomerangDuration + time.Second), Boomerang: true}
+		test.That(t, r.Validate(), test.ShouldBeError, "boomerang range can be at most 10s")
+	})
+}
+
+func TestExportSpeedRamps(t *testing.T) {
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 4*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	t.Run("Slowed interval lengthens the export", func(t *testing.T) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From: start,
+			To:   start.Add(4 * time.Second),
+			// One second at half speed plays for two.
+			SpeedRamps: []SpeedRamp{{From: start.Add(time.Second), To: start.Add(2 * time.Second), Speed: 0.5}},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration, test.ShouldAlmostEqual, 5*time.Second, 200*time.Millisecond)
+
+		// Frames in the slowed interval are twice as far apart.
+		times := frameTimes(t, path)
+		test.That(t, len(times), test.ShouldEqual, len(frames))
+		test.That(t, times[5]-times[4], test.ShouldAlmostEqual, 0.1, 0.01)
+		test.That(t, times[15]-times[14], test.ShouldAlmostEqual, 0.2, 0.01)
+		test.That(t, times[35]-times[34], test.ShouldAlmostEqual, 0.1, 0.01)
+	})
+
+	t.Run("Invalid speed ramps are rejected", func(t *testing.T) {
+		r := &ExportRequest{From: start, To: start.Add(4 * time.Second)}
+		ramp := func(from, to time.Duration, speed float64) SpeedRamp {
+			return SpeedRamp{From: start.Add(from), To: start.Add(to), Speed: speed}
+		}
+		r.SpeedRamps = []SpeedRamp{ramp(3*time.Second, 5*time.Second, 0.5)}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.SpeedRamps = []SpeedRamp{ramp(2*time.Second, 3*time.Second, 0.5), ramp(time.Second, 2500*time.Millisecond, 2)}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.SpeedRamps = []SpeedRamp{ramp(time.Second, 2*time.Second, 0)}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.SpeedRamps = []SpeedRamp{ramp(2*time.Second, time.Second, 0.5)}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.SpeedRamps = []SpeedRamp{ramp(2*time.Second, 3*time.Second, 0.5), ramp(time.Second, 2*time.Second, 2)}
+		test.That(t, r.Validate(), test.ShouldBeNil)
+	})
+}
+
+func TestExportPoster(t *testing.T) {
+	const framerate = 10
+	// The source is black for two seconds then white for a second.
+	black, white := solidJPEG(t, color.Black), solidJPEG(t, color.White)
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = black
+		if i >= 2*framerate {
+			frames[i] = white
+		}
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	brightness := func(path string, offset time.Duration) int {
+		gray, err := grayFrameAt(path, offset, 64, 48)
+		test.That(t, err, test.ShouldBeNil)
+		return meanBrightness(&image.Gray{Pix: gray, Stride: 64, Rect: image.Rect(0, 0, 64, 48)}, image.Rect(0, 0, 64, 48))
+	}
+
+	t.Run("Export opens on the poster", func(t *testing.T) {
+		poster := start.Add(2500 * time.Millisecond)
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:   start,
+			To:     start.Add(3 * time.Second),
+			Poster: &poster,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		test.That(t, brightness(path, 0), test.ShouldBeGreaterThan, 200)
+		// The export then plays from the start, a frame later.
+		test.That(t, brightness(path, 500*time.Millisecond), test.ShouldBeLessThan, 40)
+		times := frameTimes(t, path)
+		test.That(t, len(times), test.ShouldEqual, len(frames)+1)
+		test.That(t, times[1], test.ShouldAlmostEqual, 0.1, 0.01)
+
+		out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format_tags=poster_time",
+			"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, strings.TrimSpace(string(out)), test.ShouldEqual, "0.000000")
+	})
+
+	t.Run("Poster is found after speed ramps", func(t *testing.T) {
+		// At half speed the first second plays for two, so the poster at 2.5s plays at 3.5s.
+		poster := start.Add(2500 * time.Millisecond)
+		test.That(t, rampedOffset([]SpeedRamp{{From: start, To: start.Add(time.Second), Speed: 0.5}}, start, poster),
+			test.ShouldEqual, 3500*time.Millisecond)
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:       start,
+			To:         start.Add(3 * time.Second),
+			SpeedRamps: []SpeedRamp{{From: start, To: start.Add(time.Second), Speed: 0.5}},
+			Poster:     &poster,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		test.That(t, brightness(path, 0), test.ShouldBeGreaterThan, 200)
+	})
+
+	t.Run("Poster outside of the range is rejected", func(t *testing.T) {
+		poster := start.Add(4 * time.Second)
+		r := &ExportRequest{From: start, To: start.Add(3 * time.Second), Poster: &poster}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		poster = start.Add(-time.Second)
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+	})
+}
+
+// setRotation sets the display matrix of the video track of the mp4 at path to rotate
+// it clockwise by 90 degrees, as phones recording in portrait do.
+func setRotation(t *testing.T, path string) {
+	mp4, err := os.ReadFile(path)
+	test.That(t, err, test.ShouldBeNil)
+	tkhd := findBox(t, mp4, "moov", "trak", "tkhd")
+	offset := 40
+	if tkhd[0] == 1 {
+		offset = 52
+	}
+	for i, v := range []int32{0, 0x10000, 0, -0x10000, 0, 0, 0, 0, 0x40000000} {
+		binary.BigEndian.PutUint32(tkhd[offset+4*i:], uint32(v))
+	}
+	test.That(t, os.WriteFile(path, mp4, 0o600), test.ShouldBeNil)
+}
+
+func TestExportOrient(t *testing.T) {
+	const framerate = 10
+	// The top half of the frame is white and the bottom half black.
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+	draw.Draw(img, image.Rect(0, 0, 640, 240), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, img, nil), test.ShouldBeNil)
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = buf.Bytes()
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	files, err := getSortedFiles(config.Storage.StoragePath)
+	test.That(t, err, test.ShouldBeNil)
+	setRotation(t, files[0].name)
+	info, err := getVideoInfo(files[0].name)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, info.rotation, test.ShouldEqual, 90)
+
+	// export exports the stored video and returns its info and the brightness of the left
+	// and right edges of its first frame.
+	export := func(t *testing.T, r *ExportRequest) (videoInfo, int, int) {
+		r.From, r.To = start, start.Add(2*time.Second)
+		res, err := vs.Export(context.Background(), r)
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(config.Storage.UploadPath, res.Filename)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		const width, height = 48, 64
+		gray, err := grayFrameAt(path, 0, width, height)
+		test.That(t, err, test.ShouldBeNil)
+		frame := &image.Gray{Pix: gray, Stride: width, Rect: image.Rect(0, 0, width, height)}
+		return info, meanBrightness(frame, image.Rect(0, 0, 8, height)), meanBrightness(frame, image.Rect(width-8, 0, width, height))
+	}
+
+	t.Run("Orient bakes in the source rotation", func(t *testing.T) {
+		info, left, right := export(t, &ExportRequest{Orient: true})
+		test.That(t, info.width, test.ShouldEqual, 480)
+		test.That(t, info.height, test.ShouldEqual, 640)
+		test.That(t, info.rotation, test.ShouldEqual, 0)
+		// Rotated clockwise, the top of the source is on the right.
+		test.That(t, left, test.ShouldBeLessThan, 40)
+		test.That(t, right, test.ShouldBeGreaterThan, 200)
+	})
+
+	t.Run("Rotation overrides the source rotation", func(t *testing.T) {
+		rotation := 270
+		info, left, right := export(t, &ExportRequest{Rotation: &rotation})
+		test.That(t, info.width, test.ShouldEqual, 480)
+		test.That(t, left, test.ShouldBeGreaterThan, 200)
+		test.That(t, right, test.ShouldBeLessThan, 40)
+
+		rotation = 0
+		info, _, _ = export(t, &ExportRequest{Rotation: &rotation})
+		test.That(t, info.width, test.ShouldEqual, 640)
+		test.That(t, info.rotation, test.ShouldEqual, 0)
+	})
+
+	t.Run("Invalid rotation is rejected", func(t *testing.T) {
+		rotation := 45
+		r := &ExportRequest{From: start, To: start.Add(time.Second), Rotation: &rotation}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+	})
+}
+
+// addAudio muxes a tone as long as the video into the mp4 at path, skipping the test
+// without an ffmpeg binary to make it with, as segments never have audio.
+func addAudio(t *testing.T, path string) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("no ffmpeg binary to add audio with")
+	}
+	withAudio := filepath.Join(t.TempDir(), "audio.mp4")
+	out, err := exec.Command("ffmpeg", "-v", "error", "-i", path,
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000",
+		"-map", "0:v", "-map", "1:a", "-c:v", "copy", "-c:a", "aac", "-shortest", withAudio).CombinedOutput()
+	test.That(t, err, test.ShouldBeNil, string(out))
+	test.That(t, os.Rename(withAudio, path), test.ShouldBeNil)
+}
+
+// audioSpan returns the start and end in seconds of the audio of the video at path.
+func audioSpan(t *testing.T, path string) (float64, float64) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "packet=pts_time,duration_time", "-of", "csv=p=0", path).Output()
+	test.That(t, err, test.ShouldBeNil)
+	lines := strings.Fields(string(out))
+	test.That(t, len(lines), test.ShouldBeGreaterThan, 0)
+	start, end := -1.0, 0.0
+	for _, line := range lines {
+		fields := strings.Split(line, ",")
+		pts, err := strconv.ParseFloat(fields[0], 64)
+		test.That(t, err, test.ShouldBeNil)
+		duration, err := strconv.ParseFloat(fields[1], 64)
+		test.That(t, err, test.ShouldBeNil)
+		if start < 0 || pts < start {
+			start = pts
+		}
+		end = max(end, pts+duration)
+	}
+	return start, end
+}
+
+func TestExportAudio(t *testing.T) {
+	const framerate = 10
+	frames := make([][]byte, 4*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	files, err := getSortedFiles(config.Storage.StoragePath)
+	test.That(t, err, test.ShouldBeNil)
+	addAudio(t, files[0].name)
+	export := func(t *testing.T, metadata string, audio *ExportAudio) string {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start.Add(time.Second),
+			To:       start.Add(3 * time.Second),
+			Metadata: metadata,
+			Audio:    audio,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		return filepath.Join(config.Storage.UploadPath, res.Filename)
+	}
+
+	t.Run("Audio is kept in sync without an offset", func(t *testing.T) {
+		from, to := audioSpan(t, export(t, "audio", &ExportAudio{}))
+		test.That(t, from, test.ShouldAlmostEqual, 0, 0.05)
+		test.That(t, to, test.ShouldAlmostEqual, 2, 0.1)
+	})
+
+	t.Run("A positive offset delays the audio", func(t *testing.T) {
+		from, to := audioSpan(t, export(t, "audio_delayed", &ExportAudio{Offset: 500 * time.Millisecond}))
+		test.That(t, from, test.ShouldAlmostEqual, 0.5, 0.05)
+		test.That(t, to, test.ShouldAlmostEqual, 2.5, 0.1)
+	})
+
+	t.Run("A negative offset advances the audio", func(t *testing.T) {
+		path := export(t, "audio_advanced", &ExportAudio{Offset: -500 * time.Millisecond})
+		from, to := audioSpan(t, path)
+		// The first half second of audio is cut, as it would play before the video.
+		test.That(t, from, test.ShouldAlmostEqual, 0, 0.05)
+		test.That(t, to, test.ShouldAlmostEqual, 1.5, 0.1)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 2, 0.15)
+	})
+
+	t.Run("Exports drop audio unless it is requested", func(t *testing.T) {
+		out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a",
+			"-show_entries", "stream=index", "-of", "csv=p=0", export(t, "no_audio", nil)).Output()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, strings.TrimSpace(string(out)), test.ShouldBeEmpty)
+	})
+
+	t.Run("Invalid audio is rejected", func(t *testing.T) {
+		r := &ExportRequest{From: start, To: start.Add(time.Second), Audio: &ExportAudio{Offset: 3 * time.Second}}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r = &ExportRequest{From: start, To: start.Add(time.Second), Audio: &ExportAudio{}, Boomerang: true}
+		test.That(t, r.Validate(), test.ShouldBeError, "audio can't be combined with boomerang")
+	})
+}
+
+func TestExportManifest(t *testing.T) {
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	config := validRTPConfig(t)
+	config.Type = SourceTypeReadOnly
+	segments := []string{
+		storeTestSegment(t, config.Storage.StoragePath, start, frames, framerate),
+		storeTestSegment(t, config.Storage.StoragePath, start.Add(2*time.Second), frames, framerate),
+	}
+	storeInProgressSegment(t, config.Storage.StoragePath, start.Add(time.Minute))
+	vs, err := NewReadOnlyVideoStore(config, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer vs.Close()
+
+	// The export spans the second half of the first segment and the first half of the second.
+	r := &ExportRequest{
+		From:     start.Add(time.Second),
+		To:       start.Add(3 * time.Second),
+		Metadata: "evidence",
+		Crop:     &CropRegion{X: 0, Y: 0, Width: 320, Height: 240},
+	}
+	res, err := vs.Export(context.Background(), r)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res.Manifest, test.ShouldEqual, res.Filename+manifestExt)
+	b, err := os.ReadFile(filepath.Join(config.Storage.UploadPath, res.Manifest))
+	test.That(t, err, test.ShouldBeNil)
+	var m exportManifest
+	test.That(t, json.Unmarshal(b, &m), test.ShouldBeNil)
+
+	export, err := hashFile(filepath.Join(config.Storage.UploadPath, res.Filename))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, m.Export, test.ShouldResemble, export)
+	test.That(t, m.Source.Name, test.ShouldEqual, config.Storage.OutputFileNamePrefix)
+	test.That(t, m.Request.From.Equal(r.From), test.ShouldBeTrue)
+	test.That(t, m.Request.To.Equal(r.To), test.ShouldBeTrue)
+	test.That(t, m.Request.Metadata, test.ShouldEqual, "evidence")
+	test.That(t, m.Request.Filters, test.ShouldResemble, []string{"crop=w=320:h=240:x=0:y=0"})
+	test.That(t, m.Encoding.SourceCodecs, test.ShouldResemble, []string{"h264"})
+	test.That(t, m.Encoding.Encoder, test.ShouldEqual, res.Encoder)
+	test.That(t, m.Encoding.Preset, test.ShouldEqual, exportPreset)
+
+	test.That(t, len(m.Segments), test.ShouldEqual, 2)
+	for i, segment := range segments {
+		source, err := hashFile(segment)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, m.Segments[i].Filename, test.ShouldEqual, source.Filename)
+		test.That(t, m.Segments[i].SHA256, test.ShouldEqual, source.SHA256)
+		test.That(t, m.Segments[i].SizeBytes, test.ShouldEqual, source.SizeBytes)
+		test.That(t, m.Segments[i].StartTime.Equal(start.Add(time.Duration(i)*2*time.Second)), test.ShouldBeTrue)
+		test.That(t, m.Segments[i].Width, test.ShouldEqual, 640)
+	}
+	test.That(t, *m.Segments[0].Inpoint, test.ShouldAlmostEqual, 1)
+	test.That(t, m.Segments[0].Outpoint, test.ShouldBeNil)
+	test.That(t, m.Segments[1].Inpoint, test.ShouldBeNil)
+	test.That(t, *m.Segments[1].Outpoint, test.ShouldAlmostEqual, 1)
+}
+
+func TestExportProgress(t *testing.T) {
+	const framerate = 10
+	frames := make([][]byte, 30*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	uploads := func(t *testing.T) []string {
+		entries, err := os.ReadDir(config.Storage.UploadPath)
+		test.That(t, err, test.ShouldBeNil)
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	t.Run("Progress advances to 1", func(t *testing.T) {
+		var reports []ExportProgress
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(30 * time.Second),
+			Metadata: "progress",
+			Progress: func(p ExportProgress) { reports = append(reports, p) },
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(reports), test.ShouldBeGreaterThan, 1)
+		for i := 1; i < len(reports); i++ {
+			test.That(t, reports[i].Fraction, test.ShouldBeGreaterThanOrEqualTo, reports[i-1].Fraction)
+			test.That(t, reports[i].Frames, test.ShouldBeGreaterThan, reports[i-1].Frames)
+		}
+		test.That(t, reports[0].Fraction, test.ShouldBeLessThan, 1)
+		last := reports[len(reports)-1]
+		test.That(t, last.Fraction, test.ShouldEqual, 1)
+		test.That(t, last.Frames, test.ShouldEqual, len(frames))
+		info, err := os.Stat(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, last.Bytes, test.ShouldBeLessThanOrEqualTo, info.Size())
+		test.That(t, last.Bytes, test.ShouldBeGreaterThan, 0)
+	})
+
+	t.Run("Canceling stops the export and removes the partial output", func(t *testing.T) {
+		before := uploads(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var reports []ExportProgress
+		_, err := vs.Export(ctx, &ExportRequest{
+			From: start,
+			To:   start.Add(30 * time.Second),
+			Progress: func(p ExportProgress) {
+				reports = append(reports, p)
+				cancel()
+			},
+		})
+		test.That(t, err, test.ShouldWrap, context.Canceled)
+		test.That(t, len(reports), test.ShouldEqual, 1)
+		test.That(t, reports[0].Fraction, test.ShouldBeLessThan, 1)
+		test.That(t, uploads(t), test.ShouldResemble, before)
+	})
+
+	t.Run("An export with a done context doesn't start", func(t *testing.T) {
+		before := uploads(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := vs.Export(ctx, &ExportRequest{From: start, To: start.Add(time.Second)})
+		test.That(t, err, test.ShouldWrap, context.Canceled)
+		test.That(t, uploads(t), test.ShouldResemble, before)
+	})
+}
+
+func TestPadSize(t *testing.T) {
+	width, height := padSize(image.Pt(640, 480), 16, 9)
+	test.That(t, []int{width, height}, test.ShouldResemble, []int{854, 480})
+	width, height = padSize(image.Pt(1920, 1080), 4, 3)
+	test.That(t, []int{width, height}, test.ShouldResemble, []int{1920, 1440})
+	width, height = padSize(image.Pt(1280, 720), 16, 9)
+	test.That(t, []int{width, height}, test.ShouldResemble, []int{1280, 720})
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	test.That(t, escapeFilterValue("plain"), test.ShouldEqual, `\'plain\'`)
+	test.That(t, escapeFilterValue(`it's a: test, [x]`), test.ShouldEqual, `\'it\'\\\'\'s a: test\, \[x\]\'`)
+}
@@ -0,0 +1,93 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+var errNoExportInputPath = errors.New(
+	"exports can't read files by path without an export input path, see StorageConfig.ExportInputPath")
+
+// resolveExportInput returns the real path of the file at path, which is either relative
+// to the export input path root or absolute and within it. Symlinks are followed before
+// the file is checked to be within root, so a link can't lead out of it.
+func resolveExportInput(root, path string) (string, error) {
+	if root == "" {
+		return "", errNoExportInputPath
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", fmt.Errorf("failed to resolve export input path: %w", err)
+	}
+	full := path
+	if !filepath.IsAbs(full) {
+		full = filepath.Join(root, full)
+	}
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve export input %s: %w", path, err)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("export input %s is outside of the export input path", path)
+	}
+	return resolved, nil
+}
+
+// resolveExportInputs resolves the paths of the files r reads from the machine against
+// the export input path root, replacing the options which set them with copies.
+func (r *ExportRequest) resolveExportInputs(root string) error {
+	if s := r.Subtitles; s != nil && s.Path != "" {
+		resolved := *s
+		if err := resolveExportInputAt(root, &resolved.Path); err != nil {
+			return err
+		}
+		r.Subtitles = &resolved
+	}
+	if w := r.Watermark; w != nil && w.ImagePath != "" {
+		resolved := *w
+		if err := resolveExportInputAt(root, &resolved.ImagePath); err != nil {
+			return err
+		}
+		r.Watermark = &resolved
+	}
+	if t := r.Telemetry; t != nil && t.Path != "" {
+		resolved := *t
+		if err := resolveExportInputAt(root, &resolved.Path); err != nil {
+			return err
+		}
+		r.Telemetry = &resolved
+	}
+	if d := r.Detections; d != nil && d.Path != "" {
+		resolved := *d
+		if err := resolveExportInputAt(root, &resolved.Path); err != nil {
+			return err
+		}
+		r.Detections = &resolved
+	}
+	for _, b := range []**Bumper{&r.Intro, &r.Outro} {
+		if *b == nil || (*b).Path == "" {
+			continue
+		}
+		resolved := **b
+		if err := resolveExportInputAt(root, &resolved.Path); err != nil {
+			return err
+		}
+		*b = &resolved
+	}
+	return nil
+}
+
+// resolveExportInputAt replaces *path with the real path of the file it names, see
+// resolveExportInput.
+func resolveExportInputAt(root string, path *string) error {
+	resolved, err := resolveExportInput(root, *path)
+	if err != nil {
+		return err
+	}
+	*path = resolved
+	return nil
+}
@@ -0,0 +1,48 @@
+package videostore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestResolveExportInput(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "inputs")
+	test.That(t, os.MkdirAll(filepath.Join(root, "logos"), 0o755), test.ShouldBeNil)
+	inside := filepath.Join(root, "logos", "logo.png")
+	outside := filepath.Join(dir, "secret")
+	for _, path := range []string{inside, outside} {
+		test.That(t, os.WriteFile(path, nil, 0o600), test.ShouldBeNil)
+	}
+
+	t.Run("Paths relative to or within the root are resolved", func(t *testing.T) {
+		want, err := filepath.EvalSymlinks(inside)
+		test.That(t, err, test.ShouldBeNil)
+		for _, path := range []string{"logos/logo.png", inside, "logos/../logos/logo.png"} {
+			resolved, err := resolveExportInput(root, path)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, resolved, test.ShouldEqual, want)
+		}
+	})
+
+	t.Run("Paths outside of the root are rejected", func(t *testing.T) {
+		for _, path := range []string{"../secret", outside} {
+			_, err := resolveExportInput(root, path)
+			test.That(t, err, test.ShouldBeError, "export input "+path+" is outside of the export input path")
+		}
+	})
+
+	t.Run("Symlinks out of the root are rejected", func(t *testing.T) {
+		test.That(t, os.Symlink(outside, filepath.Join(root, "link")), test.ShouldBeNil)
+		_, err := resolveExportInput(root, "link")
+		test.That(t, err, test.ShouldBeError, "export input link is outside of the export input path")
+	})
+
+	t.Run("Paths are rejected without a root", func(t *testing.T) {
+		_, err := resolveExportInput("", inside)
+		test.That(t, err, test.ShouldBeError, errNoExportInputPath)
+	})
+}
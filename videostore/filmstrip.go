@@ -0,0 +1,194 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// DefaultFilmstripInterval is the time between thumbnails in a filmstrip.
+	DefaultFilmstripInterval = 10 * time.Second
+	// DefaultFilmstripThumbnailHeight is the height of each thumbnail in a filmstrip.
+	// The width keeps the video's aspect ratio.
+	DefaultFilmstripThumbnailHeight = 90
+	// MaxFilmstripWidth is the widest a filmstrip can be, which also bounds the number of
+	// thumbnails in it.
+	MaxFilmstripWidth = 16384
+	// filmstripLabelHeight is the height of the band of time labels under the thumbnails.
+	filmstripLabelHeight = 18
+	// filmstripLabelLayout is how each thumbnail's time is labeled.
+	filmstripLabelLayout = "15:04:05"
+)
+
+// FilmstripRequest is the request to the ExportFilmstrip method.
+type FilmstripRequest struct {
+	From     time.Time
+	To       time.Time
+	Metadata string
+	// Interval is the time between thumbnails. It is widened to a whole number of seconds
+	// when the range would need a filmstrip wider than MaxWidth.
+	Interval        time.Duration
+	ThumbnailHeight int
+	// MaxWidth caps the width of the filmstrip in pixels. 0 uses MaxFilmstripWidth.
+	MaxWidth int
+}
+
+// FilmstripResponse is the response to the ExportFilmstrip method.
+type FilmstripResponse struct {
+	// Filename is a JPEG of thumbnails in time order from left to right, with the UTC
+	// time of each under it.
+	Filename string
+	// Interval is the time between thumbnails, which is longer than requested if the
+	// range was too long for the filmstrip's max width.
+	Interval time.Duration
+	// Thumbnails is the number of thumbnails and ThumbnailWidth the width of each, so the
+	// thumbnail at x shows the video at From plus x / ThumbnailWidth intervals.
+	Thumbnails     int
+	ThumbnailWidth int
+}
+
+// Validate returns an error if the FilmstripRequest is invalid.
+func (r *FilmstripRequest) Validate() error {
+	if !r.From.Before(r.To) {
+		return errors.New("'from' timestamp must be before 'to' timestamp")
+	}
+	if r.To.After(time.Now()) {
+		return errors.New("'to' timestamp is in the future")
+	}
+	if r.Interval < 0 {
+		return errors.New("interval can't be less than 0")
+	}
+	if r.ThumbnailHeight < 0 {
+		return errors.New("thumbnail height can't be less than 0")
+	}
+	if r.MaxWidth < 0 || r.MaxWidth > MaxFilmstripWidth {
+		return fmt.Errorf("max width must be between 0 and %d", MaxFilmstripWidth)
+	}
+	return nil
+}
+
+// ExportFilmstrip writes a filmstrip of the video between r.From and r.To to the upload
+// path: one long horizontal JPEG with a thumbnail every r.Interval, each labeled with its
+// time, for timeline scrubbing UIs. Thumbnails of times with no stored video are left
+// black.
+func (vs *videostore) ExportFilmstrip(ctx context.Context, r *FilmstripRequest) (*FilmstripResponse, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if r.Interval == 0 {
+		r.Interval = DefaultFilmstripInterval
+	}
+	if r.ThumbnailHeight == 0 {
+		r.ThumbnailHeight = DefaultFilmstripThumbnailHeight
+	}
+	if r.MaxWidth == 0 {
+		r.MaxWidth = MaxFilmstripWidth
+	}
+	r.From = r.From.UTC()
+	r.To = r.To.UTC()
+	files, err := getSortedFiles(vs.config.Storage.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(files, r.From, r.To); err != nil {
+		return nil, err
+	}
+	vs.logger.Debug("filmstrip command received and validated")
+
+	// Size every thumbnail from the aspect ratio of the first segment in the range,
+	// rounded to even like FFmpeg.
+	infos := videoInfoCache{}
+	var first *videoInfo
+	for _, f := range files {
+		if f.startTime.After(r.To) {
+			break
+		}
+		if info, err := infos.get(f.name); err == nil && !f.startTime.Add(info.duration).Before(r.From) {
+			first = &info
+			break
+		}
+	}
+	if first == nil {
+		return nil, errors.New("no video found in range")
+	}
+	height := r.ThumbnailHeight
+	width := max(height*first.width/first.height/2*2, 2)
+	if width > r.MaxWidth {
+		return nil, fmt.Errorf("a %dpx high thumbnail is %dpx wide, wider than the max width of %d", height, width, r.MaxWidth)
+	}
+
+	total := r.To.Sub(r.From)
+	interval := r.Interval
+	count := int((total + interval - 1) / interval)
+	if maxCount := r.MaxWidth / width; count > maxCount {
+		interval = (total + time.Duration(maxCount) - 1) / time.Duration(maxCount)
+		interval = (interval + time.Second - 1).Truncate(time.Second)
+		count = int((total + interval - 1) / interval)
+		vs.logger.Debugf("range needs more than %d thumbnails, widening the interval to %s", maxCount, interval)
+	}
+
+	strip := image.NewRGBA(image.Rect(0, 0, count*width, height+filmstripLabelHeight))
+	draw.Draw(strip, strip.Bounds(), image.Black, image.Point{}, draw.Src)
+	// Labels are skipped on thumbnails too narrow to hold one without overlapping the next.
+	labelWidth := len(filmstripLabelLayout)*basicfont.Face7x13.Advance + 4
+	labelEvery := (labelWidth + width - 1) / width
+	for i := range count {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		at := r.From.Add(time.Duration(i) * interval)
+		x := i * width
+		if i%labelEvery == 0 {
+			drawFilmstripLabel(strip, x, height, at.Format(filmstripLabelLayout))
+		}
+		segment, offset, _, ok := infos.segmentAt(files, at)
+		if !ok {
+			continue
+		}
+		thumbnail, err := rgbaFrameAt(segment, offset, width, height)
+		if err != nil {
+			vs.logger.Warnf("failed to get thumbnail at %s from %s, leaving it black: %v", offset, segment, err)
+			continue
+		}
+		draw.Draw(strip, image.Rect(x, 0, x+width, height), thumbnail, image.Point{}, draw.Src)
+	}
+
+	path := strings.TrimSuffix(generateOutputFilePath(
+		vs.config.Storage.OutputFileNamePrefix,
+		r.From,
+		r.Metadata,
+		vs.config.Storage.UploadPath,
+	), ".mp4") + "_filmstrip.jpg"
+	if err := writeSpriteSheet(path, strip); err != nil {
+		return nil, err
+	}
+	return &FilmstripResponse{
+		Filename:       filepath.Base(path),
+		Interval:       interval,
+		Thumbnails:     count,
+		ThumbnailWidth: width,
+	}, nil
+}
+
+// drawFilmstripLabel draws a tick at x on the top of the label band starting at y, and
+// label to the right of it.
+func drawFilmstripLabel(strip *image.RGBA, x, y int, label string) {
+	draw.Draw(strip, image.Rect(x, y, x+1, y+4), image.White, image.Point{}, draw.Src)
+	d := font.Drawer{
+		Dst:  strip,
+		Src:  image.White,
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x+3, y+basicfont.Face7x13.Ascent+3),
+	}
+	d.DrawString(label)
+}
@@ -0,0 +1,100 @@
+package videostore
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestExportFilmstrip(t *testing.T) {
+	const framerate = 10
+	const seconds = 8
+	// The range spans two segments of 4 seconds, and each second of video is brighter than
+	// the last so every thumbnail is distinct.
+	frames := make([][]byte, seconds*framerate)
+	for i := range frames {
+		frames[i] = solidJPEG(t, color.Gray{Y: uint8(30 * (i / framerate))})
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	config := validRTPConfig(t)
+	config.Type = SourceTypeReadOnly
+	storeTestSegment(t, config.Storage.StoragePath, start, frames[:len(frames)/2], framerate)
+	storeTestSegment(t, config.Storage.StoragePath, start.Add(seconds/2*time.Second), frames[len(frames)/2:], framerate)
+	storeInProgressSegment(t, config.Storage.StoragePath, start.Add(time.Minute))
+	vs, err := NewReadOnlyVideoStore(config, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer vs.Close()
+
+	readFilmstrip := func(t *testing.T, res *FilmstripResponse) image.Image {
+		f, err := os.Open(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		defer f.Close()
+		strip, err := jpeg.Decode(f)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, strip.Bounds().Dx(), test.ShouldEqual, res.Thumbnails*res.ThumbnailWidth)
+		test.That(t, strip.Bounds().Dy(), test.ShouldEqual, 60+filmstripLabelHeight)
+		return strip
+	}
+
+	t.Run("Filmstrip has a labeled thumbnail every interval across segments", func(t *testing.T) {
+		res, err := vs.ExportFilmstrip(context.Background(), &FilmstripRequest{
+			From:            start,
+			To:              start.Add(seconds * time.Second),
+			Interval:        time.Second,
+			ThumbnailHeight: 60,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res.Interval, test.ShouldEqual, time.Second)
+		test.That(t, res.Thumbnails, test.ShouldEqual, seconds)
+		test.That(t, res.ThumbnailWidth, test.ShouldEqual, 80)
+		strip := readFilmstrip(t, res)
+		for i := range res.Thumbnails {
+			x := i * res.ThumbnailWidth
+			test.That(t, meanBrightness(strip, image.Rect(x, 0, x+80, 60)), test.ShouldAlmostEqual, 30*i, 12)
+			// The label under each thumbnail is white text on black, below where JPEG blocks
+			// blur the thumbnail into the band.
+			test.That(t, meanBrightness(strip, image.Rect(x, 64, x+60, 60+filmstripLabelHeight)), test.ShouldBeGreaterThan, 10)
+		}
+	})
+
+	t.Run("Long ranges widen the interval to fit the max width", func(t *testing.T) {
+		res, err := vs.ExportFilmstrip(context.Background(), &FilmstripRequest{
+			From:            start,
+			To:              start.Add(seconds * time.Second),
+			Metadata:        "capped",
+			Interval:        time.Second,
+			ThumbnailHeight: 60,
+			MaxWidth:        330,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res.Interval, test.ShouldEqual, 2*time.Second)
+		test.That(t, res.Thumbnails, test.ShouldEqual, 4)
+		strip := readFilmstrip(t, res)
+		for i := range res.Thumbnails {
+			x := i * res.ThumbnailWidth
+			test.That(t, meanBrightness(strip, image.Rect(x, 0, x+80, 60)), test.ShouldAlmostEqual, 60*i, 12)
+		}
+	})
+
+	t.Run("Invalid requests are rejected", func(t *testing.T) {
+		r := &FilmstripRequest{From: start, To: start.Add(time.Second), MaxWidth: MaxFilmstripWidth + 1}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r = &FilmstripRequest{From: start, To: start.Add(time.Second), ThumbnailHeight: -1}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		_, err := vs.ExportFilmstrip(context.Background(), &FilmstripRequest{
+			From:            start,
+			To:              start.Add(seconds * time.Second),
+			ThumbnailHeight: 60,
+			MaxWidth:        40,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,159 @@
+#include "frame.h"
+#include <libavcodec/avcodec.h>
+#include <libavformat/avformat.h>
+#include <libavutil/imgutils.h>
+#include <libavutil/log.h>
+#include <libswscale/swscale.h>
+
+// frame_at decodes the first frame at or after offsetMicroseconds and scales
+// it to width x height in pixFmt, writing it to out with rows stride bytes apart.
+static int frame_at(const char *filename, const int64_t offsetMicroseconds,
+                    const int width, const int height,
+                    const enum AVPixelFormat pixFmt, const int stride,
+                    uint8_t *out) {
+    AVFormatContext *fmtCtx = NULL;
+    AVCodecContext *decCtx = NULL;
+    AVPacket *pkt = NULL;
+    AVFrame *frame = NULL;
+    struct SwsContext *swsCtx = NULL;
+    const AVCodec *dec = NULL;
+    int result = VIDEO_STORE_FRAME_RESP_ERROR;
+    int ret;
+
+    if ((ret = avformat_open_input(&fmtCtx, filename, NULL, NULL)) < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to open input: %s\n", av_err2str(ret));
+        goto cleanup;
+    }
+    if ((ret = avformat_find_stream_info(fmtCtx, NULL)) < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to find stream info: %s\n", av_err2str(ret));
+        goto cleanup;
+    }
+    int streamIndex = av_find_best_stream(fmtCtx, AVMEDIA_TYPE_VIDEO, -1, -1, &dec, 0);
+    if (streamIndex < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to find video stream: %s\n", av_err2str(streamIndex));
+        goto cleanup;
+    }
+    AVStream *stream = fmtCtx->streams[streamIndex];
+
+    decCtx = avcodec_alloc_context3(dec);
+    if (!decCtx) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to allocate decoder context\n");
+        goto cleanup;
+    }
+    if ((ret = avcodec_parameters_to_context(decCtx, stream->codecpar)) < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to copy codec parameters: %s\n", av_err2str(ret));
+        goto cleanup;
+    }
+    if ((ret = avcodec_open2(decCtx, dec, NULL)) < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to open decoder: %s\n", av_err2str(ret));
+        goto cleanup;
+    }
+
+    int64_t target = av_rescale_q(offsetMicroseconds, AV_TIME_BASE_Q, stream->time_base);
+    if (stream->start_time != AV_NOPTS_VALUE) {
+        target += stream->start_time;
+    }
+    if (offsetMicroseconds > 0 &&
+        (ret = av_seek_frame(fmtCtx, streamIndex, target, AVSEEK_FLAG_BACKWARD)) < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to seek: %s\n", av_err2str(ret));
+        goto cleanup;
+    }
+
+    pkt = av_packet_alloc();
+    frame = av_frame_alloc();
+    if (!pkt || !frame) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to allocate packet or frame\n");
+        goto cleanup;
+    }
+
+    // Decode until the first frame at or after the target, falling back to the
+    // last frame decoded if the target is past the end of the video.
+    int gotFrame = 0;
+    int draining = 0;
+    while (1) {
+        if (!draining) {
+            ret = av_read_frame(fmtCtx, pkt);
+            if (ret == AVERROR_EOF) {
+                draining = 1;
+                ret = avcodec_send_packet(decCtx, NULL);
+            } else if (ret < 0) {
+                av_log(NULL, AV_LOG_ERROR, "frame_at failed to read frame: %s\n", av_err2str(ret));
+                goto cleanup;
+            } else if (pkt->stream_index != streamIndex) {
+                av_packet_unref(pkt);
+                continue;
+            } else {
+                ret = avcodec_send_packet(decCtx, pkt);
+                av_packet_unref(pkt);
+            }
+            if (ret < 0) {
+                av_log(NULL, AV_LOG_ERROR, "frame_at failed to send packet: %s\n", av_err2str(ret));
+                goto cleanup;
+            }
+        }
+        int done = 0;
+        while ((ret = avcodec_receive_frame(decCtx, frame)) >= 0) {
+            gotFrame = 1;
+            if (frame->best_effort_timestamp == AV_NOPTS_VALUE || frame->best_effort_timestamp >= target) {
+                done = 1;
+                break;
+            }
+            av_frame_unref(frame);
+        }
+        if (done) {
+            break;
+        }
+        if (ret == AVERROR_EOF) {
+            break;
+        }
+        if (ret != AVERROR(EAGAIN)) {
+            av_log(NULL, AV_LOG_ERROR, "frame_at failed to receive frame: %s\n", av_err2str(ret));
+            goto cleanup;
+        }
+    }
+    if (!gotFrame || !frame->data[0]) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at no frame decoded\n");
+        goto cleanup;
+    }
+
+    swsCtx = sws_getContext(frame->width, frame->height, frame->format, width, height,
+                            pixFmt, SWS_AREA, NULL, NULL, NULL);
+    if (!swsCtx) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to create scaler\n");
+        goto cleanup;
+    }
+    uint8_t *dst[4] = {out, NULL, NULL, NULL};
+    int dstStride[4] = {stride, 0, 0, 0};
+    if ((ret = sws_scale(swsCtx, (const uint8_t *const *)frame->data, frame->linesize, 0,
+                         frame->height, dst, dstStride)) < 0) {
+        av_log(NULL, AV_LOG_ERROR, "frame_at failed to scale frame: %s\n", av_err2str(ret));
+        goto cleanup;
+    }
+    result = VIDEO_STORE_FRAME_RESP_OK;
+
+cleanup:
+    sws_freeContext(swsCtx);
+    av_frame_free(&frame);
+    av_packet_free(&pkt);
+    avcodec_free_context(&decCtx);
+    avformat_close_input(&fmtCtx);
+    return result;
+}
+
+int video_store_gray_frame_at(const char *filename,             // IN
+                              const int64_t offsetMicroseconds, // IN
+                              const int width,                  // IN
+                              const int height,                 // IN
+                              uint8_t *out                      // OUT
+) {
+    return frame_at(filename, offsetMicroseconds, width, height, AV_PIX_FMT_GRAY8, width, out);
+}
+
+int video_store_rgba_frame_at(const char *filename,             // IN
+                              const int64_t offsetMicroseconds, // IN
+                              const int width,                  // IN
+                              const int height,                 // IN
+                              uint8_t *out                      // OUT
+) {
+    return frame_at(filename, offsetMicroseconds, width, height, AV_PIX_FMT_RGBA, width * 4, out);
+}
@@ -0,0 +1,58 @@
+package videostore
+
+/*
+#include "frame.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"time"
+	"unsafe"
+)
+
+// grayFrameAt decodes the first frame at or after offset into the video at path
+// and returns it as a width x height 8 bit grayscale image.
+func grayFrameAt(path string, offset time.Duration, width, height int) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid frame size %dx%d", width, height)
+	}
+	pathCStr := C.CString(path)
+	defer C.free(unsafe.Pointer(pathCStr))
+	out := make([]byte, width*height)
+	ret := C.video_store_gray_frame_at(
+		pathCStr,
+		C.int64_t(offset.Microseconds()),
+		C.int(width),
+		C.int(height),
+		(*C.uint8_t)(unsafe.Pointer(&out[0])),
+	)
+	if ret != C.VIDEO_STORE_FRAME_RESP_OK {
+		return nil, fmt.Errorf("failed to decode frame from %s", path)
+	}
+	return out, nil
+}
+
+// rgbaFrameAt decodes the first frame at or after offset into the video at path
+// and returns it scaled to width x height.
+func rgbaFrameAt(path string, offset time.Duration, width, height int) (*image.RGBA, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid frame size %dx%d", width, height)
+	}
+	pathCStr := C.CString(path)
+	defer C.free(unsafe.Pointer(pathCStr))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	ret := C.video_store_rgba_frame_at(
+		pathCStr,
+		C.int64_t(offset.Microseconds()),
+		C.int(width),
+		C.int(height),
+		(*C.uint8_t)(unsafe.Pointer(&img.Pix[0])),
+	)
+	if ret != C.VIDEO_STORE_FRAME_RESP_OK {
+		return nil, fmt.Errorf("failed to decode frame from %s", path)
+	}
+	return img, nil
+}
@@ -0,0 +1,25 @@
+#ifndef VIAM_VIDEOSTORE_FRAME_H
+#define VIAM_VIDEOSTORE_FRAME_H
+#include <stdint.h>
+#define VIDEO_STORE_FRAME_RESP_OK 0
+#define VIDEO_STORE_FRAME_RESP_ERROR 1
+
+// video_store_gray_frame_at decodes the first frame at or after offsetMicroseconds
+// into the video and writes it to out as a width*height 8 bit grayscale image.
+// The frame is scaled to width x height without preserving aspect ratio.
+int video_store_gray_frame_at(const char *filename,             // IN
+                              const int64_t offsetMicroseconds, // IN
+                              const int width,                  // IN
+                              const int height,                 // IN
+                              uint8_t *out                      // OUT
+);
+
+// video_store_rgba_frame_at is video_store_gray_frame_at but writes a
+// width*height*4 byte RGBA image.
+int video_store_rgba_frame_at(const char *filename,             // IN
+                              const int64_t offsetMicroseconds, // IN
+                              const int width,                  // IN
+                              const int height,                 // IN
+                              uint8_t *out                      // OUT
+);
+#endif /* VIAM_VIDEOSTORE_FRAME_H */
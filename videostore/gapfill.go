@@ -0,0 +1,154 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// gapFills are the supported GapFill.Fill values.
+var gapFills = []string{"freeze", "black", "skip"}
+
+const (
+	// DefaultMinGap is the shortest gap in recording filled by default. Segments are named
+	// by the second they start, so the video either side of shorter gaps is often only
+	// apart by rounding.
+	DefaultMinGap = 2 * time.Second
+	// defaultGapLabel is the text on black placeholders by default.
+	defaultGapLabel = "No footage"
+	// defaultGapFillFrameRate is the frame rate gaps are filled at when neither the export
+	// nor the source video has one.
+	defaultGapFillFrameRate = 15
+)
+
+// GapFill fills the gaps in recording across an export's range, e.g. while the camera
+// was offline, so the export plays as one continuous timeline with each gap lasting as
+// long as it did, rather than jumping from the video before it to the video after.
+type GapFill struct {
+	// Fill is how gaps are filled, one of gapFills: "freeze" repeats the last frame before
+	// each gap, or the first frame after a gap at the start of the range, "black" shows a
+	// black frame with Label on it, and "skip" jumps over gaps like an export without a
+	// GapFill. Empty uses "freeze".
+	Fill string
+	// MinGap is the shortest gap filled, shorter gaps are skipped. 0 uses DefaultMinGap.
+	MinGap time.Duration
+	// Label is the text shown on black placeholders. Empty uses "No footage".
+	Label string
+}
+
+// Validate returns an error if the GapFill is invalid.
+func (g *GapFill) Validate() error {
+	if g.Fill != "" && !slices.Contains(gapFills, g.Fill) {
+		return fmt.Errorf("invalid gap fill %s, must be one of: %s", g.Fill, strings.Join(gapFills, ", "))
+	}
+	if g.MinGap < 0 {
+		return errors.New("gap fill min gap can't be less than 0")
+	}
+	if g.Label != "" && g.Fill != "black" {
+		return errors.New("gap fill label requires the black fill")
+	}
+	return nil
+}
+
+// fills returns whether gaps are filled, which they aren't for a nil GapFill.
+func (g *GapFill) fills() bool {
+	return g != nil && g.Fill != "skip"
+}
+
+func (g *GapFill) minGap() time.Duration {
+	if g.MinGap == 0 {
+		return DefaultMinGap
+	}
+	return g.MinGap
+}
+
+// keepGaps returns the gaps of at least minGap between from and to which spans, the time
+// ranges entries span in order, don't cover. Each entry followed by a gap is given the
+// duration of its part and the gap, so the concat demuxer starts the next entry after the
+// gap. The gaps are returned at their time in the concatenated timeline starting at from,
+// which drifts from wall clock time by how much the video either side of shorter gaps is
+// apart or overlaps.
+func keepGaps(entries []concatFileEntry, spans []timeRange, from, to time.Time, minGap time.Duration) []timeRange {
+	var gaps []timeRange
+	at := from
+	if lead := spans[0].from.Sub(from); lead >= minGap {
+		at = at.Add(lead)
+		gaps = append(gaps, timeRange{from: from, to: at})
+	}
+	for i, span := range spans {
+		at = at.Add(span.to.Sub(span.from))
+		gap := to.Sub(span.to)
+		if i+1 < len(spans) {
+			gap = spans[i+1].from.Sub(span.to)
+		}
+		if gap < minGap {
+			continue
+		}
+		gaps = append(gaps, timeRange{from: at, to: at.Add(gap)})
+		at = at.Add(gap)
+		if i+1 < len(spans) {
+			duration := spans[i+1].from.Sub(span.from).Seconds()
+			entries[i].duration = &duration
+		}
+	}
+	return gaps
+}
+
+// gapFillFrameRate returns the frame rate gaps are filled at in video with sourceFrameRate,
+// which is 0 if it isn't known.
+func gapFillFrameRate(sourceFrameRate float64) int {
+	if sourceFrameRate <= 0 {
+		return defaultGapFillFrameRate
+	}
+	return max(int(math.Round(sourceFrameRate)), 1)
+}
+
+// filters returns the filters which fill gaps, from keepGaps, in video starting at from
+// with frames at frameRate. The gaps within the video are kept as jumps in its
+// timestamps, which the fps filter fills with copies of the frame before each, and the
+// gaps at the start and end are padded with copies of the first and last frames.
+func (g *GapFill) filters(gaps []timeRange, from, to time.Time, frameRate int) ([]string, error) {
+	filters := []string{fmt.Sprintf("fps=fps=%d", frameRate)}
+	var pads []string
+	if gaps[0].from.Equal(from) {
+		pads = append(pads, fmt.Sprintf("start_duration=%.3f:start_mode=clone", gaps[0].to.Sub(from).Seconds()))
+	}
+	if last := gaps[len(gaps)-1]; last.to.Equal(to) {
+		pads = append(pads, fmt.Sprintf("stop_duration=%.3f:stop_mode=clone", to.Sub(last.from).Seconds()))
+	}
+	if len(pads) > 0 {
+		filters = append(filters, "tpad="+strings.Join(pads, ":"))
+	}
+	if g.Fill != "black" {
+		return filters, nil
+	}
+	font, err := findFont()
+	if err != nil {
+		return nil, err
+	}
+	label := g.Label
+	if label == "" {
+		label = defaultGapLabel
+	}
+	var during []string
+	for _, gap := range gaps {
+		during = append(during, fmt.Sprintf("gte(t\\,%.3f)*lt(t\\,%.3f)", gap.from.Sub(from).Seconds(), gap.to.Sub(from).Seconds()))
+	}
+	enable := "enable=" + strings.Join(during, "+")
+	return append(filters,
+		"drawbox=x=0:y=0:w=iw:h=ih:color=black:t=fill:"+enable,
+		"drawtext="+strings.Join([]string{
+			"fontfile=" + escapeFilterValue(font),
+			"text=" + escapeFilterValue(label),
+			"expansion=none",
+			"fontsize=h/12",
+			"fontcolor=white",
+			"x=(w-text_w)/2",
+			"y=(h-text_h)/2",
+			enable,
+		}, ":"),
+	), nil
+}
@@ -0,0 +1,117 @@
+package videostore
+
+import (
+	"context"
+	"image/color"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestExportGapFill(t *testing.T) {
+	const framerate = 10
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	frames := func(y uint8) [][]byte {
+		frames := make([][]byte, 3*framerate)
+		for i := range frames {
+			frames[i] = solidJPEG(t, color.Gray{Y: y})
+		}
+		return frames
+	}
+	// The camera was offline for 3 seconds between a bright and a dark segment.
+	config := validRTPConfig(t)
+	config.Type = SourceTypeReadOnly
+	storeTestSegment(t, config.Storage.StoragePath, start, frames(200), framerate)
+	storeTestSegment(t, config.Storage.StoragePath, start.Add(6*time.Second), frames(60), framerate)
+	storeInProgressSegment(t, config.Storage.StoragePath, start.Add(time.Minute))
+	s, err := NewReadOnlyVideoStore(config, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(s.Close)
+	vs := s.(*videostore)
+
+	export := func(t *testing.T, r *ExportRequest) string {
+		res, err := vs.Export(context.Background(), r)
+		test.That(t, err, test.ShouldBeNil)
+		return filepath.Join(config.Storage.UploadPath, res.Filename)
+	}
+	duration := func(t *testing.T, path string) time.Duration {
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		return info.duration
+	}
+	// frameAt returns the mean and max brightness of the frame at offset into the video at path.
+	frameAt := func(t *testing.T, path string, offset time.Duration) (int, byte) {
+		gray, err := grayFrameAt(path, offset, 320, 240)
+		test.That(t, err, test.ShouldBeNil)
+		sum := 0
+		for _, y := range gray {
+			sum += int(y)
+		}
+		return sum / len(gray), slices.Max(gray)
+	}
+
+	t.Run("Freeze fills gaps with the frame before them", func(t *testing.T) {
+		path := export(t, &ExportRequest{From: start, To: start.Add(9 * time.Second), Metadata: "freeze", GapFill: &GapFill{}})
+		test.That(t, duration(t, path), test.ShouldAlmostEqual, 9*time.Second, 300*time.Millisecond)
+		mean, _ := frameAt(t, path, 4500*time.Millisecond)
+		test.That(t, mean, test.ShouldAlmostEqual, 200, 10)
+		mean, _ = frameAt(t, path, 7500*time.Millisecond)
+		test.That(t, mean, test.ShouldAlmostEqual, 60, 10)
+	})
+
+	t.Run("Freeze fills a gap at the start with the frame after it", func(t *testing.T) {
+		path := export(t, &ExportRequest{From: start.Add(4 * time.Second), To: start.Add(8 * time.Second), Metadata: "lead", GapFill: &GapFill{}})
+		test.That(t, duration(t, path), test.ShouldAlmostEqual, 4*time.Second, 300*time.Millisecond)
+		mean, _ := frameAt(t, path, time.Second)
+		test.That(t, mean, test.ShouldAlmostEqual, 60, 10)
+	})
+
+	t.Run("A gap before the oldest segment is filled", func(t *testing.T) {
+		path := export(t, &ExportRequest{From: start.Add(-2 * time.Second), To: start.Add(2 * time.Second), Metadata: "before", GapFill: &GapFill{}})
+		test.That(t, duration(t, path), test.ShouldAlmostEqual, 4*time.Second, 300*time.Millisecond)
+		mean, _ := frameAt(t, path, time.Second)
+		test.That(t, mean, test.ShouldAlmostEqual, 200, 10)
+	})
+
+	t.Run("Black fills gaps with a labeled placeholder", func(t *testing.T) {
+		path := export(t, &ExportRequest{From: start, To: start.Add(9 * time.Second), Metadata: "black", GapFill: &GapFill{Fill: "black"}})
+		test.That(t, duration(t, path), test.ShouldAlmostEqual, 9*time.Second, 300*time.Millisecond)
+		mean, brightest := frameAt(t, path, 4500*time.Millisecond)
+		test.That(t, mean, test.ShouldBeLessThan, 30)
+		test.That(t, brightest, test.ShouldBeGreaterThan, 128)
+		mean, _ = frameAt(t, path, time.Second)
+		test.That(t, mean, test.ShouldAlmostEqual, 200, 10)
+	})
+
+	t.Run("Skip jumps over gaps", func(t *testing.T) {
+		path := export(t, &ExportRequest{From: start, To: start.Add(9 * time.Second), Metadata: "skip", GapFill: &GapFill{Fill: "skip"}})
+		test.That(t, duration(t, path), test.ShouldAlmostEqual, 6*time.Second, 300*time.Millisecond)
+	})
+
+	t.Run("Gaps shorter than the minimum are skipped", func(t *testing.T) {
+		at := func(seconds float64) time.Time { return start.Add(time.Duration(seconds * float64(time.Second))) }
+		entries := make([]concatFileEntry, 3)
+		spans := []timeRange{{from: at(0), to: at(3)}, {from: at(4), to: at(6)}, {from: at(9), to: at(10)}}
+		gaps := keepGaps(entries, spans, at(0), at(12), 2*time.Second)
+		test.That(t, gaps, test.ShouldResemble, []timeRange{{from: at(5), to: at(8)}, {from: at(9), to: at(11)}})
+		test.That(t, entries[0].duration, test.ShouldBeNil)
+		test.That(t, *entries[1].duration, test.ShouldEqual, 5.0)
+		test.That(t, entries[2].duration, test.ShouldBeNil)
+	})
+
+	t.Run("Invalid gap fills are rejected", func(t *testing.T) {
+		r := &ExportRequest{From: start, To: start.Add(time.Second), GapFill: &GapFill{Fill: "blur"}}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.GapFill = &GapFill{MinGap: -time.Second}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.GapFill = &GapFill{Label: "Offline"}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.GapFill = &GapFill{}
+		r.Activity = &ActivityFilter{}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+	})
+}
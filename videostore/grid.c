@@ -0,0 +1,490 @@
+#include "grid.h"
+#include <libavcodec/avcodec.h>
+#include <libavformat/avformat.h>
+#include <libavutil/log.h>
+#include <libavutil/mathematics.h>
+#include <libswscale/swscale.h>
+#include <string.h>
+
+// grid_reader decodes one stream of a grid export, keeping the latest frame at
+// or before the output time along with the frame after it.
+typedef struct grid_reader {
+  const video_store_grid_stream *stream;
+  // index of the open segment, -1 if none is open
+  int segment;
+  AVFormatContext *inCtx;
+  AVCodecContext *decCtx;
+  int streamIndex;
+  int draining;
+  int eof;
+
+  AVPacket *pkt;
+  AVFrame *cur;
+  AVFrame *next;
+  int hasCur;
+  int hasNext;
+
+  struct SwsContext *swsCtx;
+} grid_reader;
+
+static void close_segment(grid_reader *r) {
+  avcodec_free_context(&r->decCtx);
+  avformat_close_input(&r->inCtx);
+  av_frame_unref(r->cur);
+  av_frame_unref(r->next);
+  r->hasCur = 0;
+  r->hasNext = 0;
+  r->draining = 0;
+  r->eof = 0;
+  r->segment = -1;
+}
+
+// open_segment opens segment i of the reader's stream and seeks to
+// offsetMicroseconds into it.
+static int open_segment(grid_reader *r, const int i,
+                        const int64_t offsetMicroseconds, const int threads) {
+  const AVCodec *dec = NULL;
+  const char *path = r->stream->segments[i].path;
+  int ret;
+  close_segment(r);
+  if ((ret = avformat_open_input(&r->inCtx, path, NULL, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to open %s: %s\n", path,
+           av_err2str(ret));
+    return ret;
+  }
+  if ((ret = avformat_find_stream_info(r->inCtx, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to find stream info: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  r->streamIndex =
+      av_find_best_stream(r->inCtx, AVMEDIA_TYPE_VIDEO, -1, -1, &dec, 0);
+  if (r->streamIndex < 0) {
+    ret = r->streamIndex;
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to find video stream: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  AVStream *stream = r->inCtx->streams[r->streamIndex];
+  r->decCtx = avcodec_alloc_context3(dec);
+  if (r->decCtx == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to allocate decoder context\n");
+    return AVERROR(ENOMEM);
+  }
+  if ((ret = avcodec_parameters_to_context(r->decCtx, stream->codecpar)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to copy codec parameters: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  r->decCtx->pkt_timebase = stream->time_base;
+  r->decCtx->thread_count = threads;
+  if ((ret = avcodec_open2(r->decCtx, dec, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to open decoder: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  r->segment = i;
+  if (offsetMicroseconds > 0) {
+    int64_t target =
+        av_rescale_q(offsetMicroseconds, AV_TIME_BASE_Q, stream->time_base);
+    if (stream->start_time != AV_NOPTS_VALUE) {
+      target += stream->start_time;
+    }
+    if ((ret = av_seek_frame(r->inCtx, r->streamIndex, target,
+                             AVSEEK_FLAG_BACKWARD)) < 0) {
+      av_log(NULL, AV_LOG_ERROR, "video_store_export_grid failed to seek: %s\n",
+             av_err2str(ret));
+      return ret;
+    }
+  }
+  return 0;
+}
+
+// frame_time returns the time of frame relative to the start of the export.
+static int64_t frame_time(const grid_reader *r, const AVFrame *frame) {
+  AVStream *stream = r->inCtx->streams[r->streamIndex];
+  int64_t pts = frame->best_effort_timestamp;
+  if (pts == AV_NOPTS_VALUE) {
+    pts = 0;
+  }
+  if (stream->start_time != AV_NOPTS_VALUE) {
+    pts -= stream->start_time;
+  }
+  return r->stream->segments[r->segment].startMicroseconds +
+         av_rescale_q(pts, stream->time_base, AV_TIME_BASE_Q);
+}
+
+// read_frame decodes the next frame of the open segment into frame.
+// Returns AVERROR_EOF once the segment has been fully decoded.
+static int read_frame(grid_reader *r, AVFrame *frame) {
+  int ret;
+  while (1) {
+    ret = avcodec_receive_frame(r->decCtx, frame);
+    if (ret != AVERROR(EAGAIN)) {
+      if (ret < 0 && ret != AVERROR_EOF) {
+        av_log(NULL, AV_LOG_ERROR,
+               "video_store_export_grid failed to receive frame: %s\n",
+               av_err2str(ret));
+      }
+      return ret;
+    }
+    if (r->draining) {
+      return AVERROR_EOF;
+    }
+    ret = av_read_frame(r->inCtx, r->pkt);
+    if (ret == AVERROR_EOF) {
+      r->draining = 1;
+      ret = avcodec_send_packet(r->decCtx, NULL);
+    } else if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export_grid failed to read frame: %s\n",
+             av_err2str(ret));
+      return ret;
+    } else if (r->pkt->stream_index != r->streamIndex) {
+      av_packet_unref(r->pkt);
+      continue;
+    } else {
+      ret = avcodec_send_packet(r->decCtx, r->pkt);
+      av_packet_unref(r->pkt);
+    }
+    if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export_grid failed to decode packet: %s\n",
+             av_err2str(ret));
+      return ret;
+    }
+  }
+}
+
+// advance moves the reader to the latest frame of its stream at or before
+// timeMicroseconds. Sets *frame to that frame, or NULL if the stream has no
+// video at that time.
+static int advance(grid_reader *r, const int64_t timeMicroseconds,
+                   const int threads, AVFrame **frame) {
+  *frame = NULL;
+  int i = -1;
+  for (int j = 0; j < r->stream->segmentCount; j++) {
+    const video_store_grid_segment *s = &r->stream->segments[j];
+    if (s->startMicroseconds <= timeMicroseconds &&
+        timeMicroseconds < s->startMicroseconds + s->durationMicroseconds) {
+      i = j;
+    }
+  }
+  if (i < 0) {
+    close_segment(r);
+    return 0;
+  }
+  int ret;
+  if (i != r->segment &&
+      (ret = open_segment(r, i,
+                          timeMicroseconds -
+                              r->stream->segments[i].startMicroseconds,
+                          threads)) < 0) {
+    return ret;
+  }
+  while (!r->eof) {
+    if (!r->hasNext) {
+      ret = read_frame(r, r->next);
+      if (ret == AVERROR_EOF) {
+        r->eof = 1;
+        break;
+      }
+      if (ret < 0) {
+        return ret;
+      }
+      r->hasNext = 1;
+    }
+    if (frame_time(r, r->next) > timeMicroseconds) {
+      break;
+    }
+    AVFrame *tmp = r->cur;
+    r->cur = r->next;
+    r->next = tmp;
+    av_frame_unref(r->next);
+    r->hasCur = 1;
+    r->hasNext = 0;
+  }
+  // Right after a seek the first frame decoded may be just after the time.
+  if (r->hasCur) {
+    *frame = r->cur;
+  } else if (r->hasNext) {
+    *frame = r->next;
+  }
+  return 0;
+}
+
+// fill_black fills the yuv420p frame with black.
+static void fill_black(AVFrame *frame) {
+  for (int y = 0; y < frame->height; y++) {
+    memset(frame->data[0] + y * frame->linesize[0], 16, frame->width);
+  }
+  for (int y = 0; y < frame->height / 2; y++) {
+    memset(frame->data[1] + y * frame->linesize[1], 128, frame->width / 2);
+    memset(frame->data[2] + y * frame->linesize[2], 128, frame->width / 2);
+  }
+}
+
+// draw_tile scales src into the tileWidth x tileHeight tile of the yuv420p
+// frame dst with its top left corner at x, y.
+static int draw_tile(grid_reader *r, const AVFrame *src, AVFrame *dst,
+                     const int x, const int y, const int tileWidth,
+                     const int tileHeight) {
+  r->swsCtx = sws_getCachedContext(r->swsCtx, src->width, src->height,
+                                   src->format, tileWidth, tileHeight,
+                                   AV_PIX_FMT_YUV420P, SWS_BILINEAR, NULL,
+                                   NULL, NULL);
+  if (r->swsCtx == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to create scaler\n");
+    return AVERROR(ENOMEM);
+  }
+  uint8_t *data[4] = {
+      dst->data[0] + y * dst->linesize[0] + x,
+      dst->data[1] + y / 2 * dst->linesize[1] + x / 2,
+      dst->data[2] + y / 2 * dst->linesize[2] + x / 2,
+      NULL,
+  };
+  int ret = sws_scale(r->swsCtx, (const uint8_t *const *)src->data,
+                      src->linesize, 0, src->height, data, dst->linesize);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to scale frame: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  return 0;
+}
+
+// encode sends frame, or NULL to flush, to the encoder and writes every
+// packet it produces.
+static int encode(AVCodecContext *encCtx, AVFormatContext *outCtx,
+                  AVStream *outStream, AVPacket *pkt, AVFrame *frame) {
+  int ret = avcodec_send_frame(encCtx, frame);
+  if (ret < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to encode frame: %s\n",
+           av_err2str(ret));
+    return ret;
+  }
+  while ((ret = avcodec_receive_packet(encCtx, pkt)) >= 0) {
+    av_packet_rescale_ts(pkt, encCtx->time_base, outStream->time_base);
+    pkt->stream_index = outStream->index;
+    ret = av_interleaved_write_frame(outCtx, pkt);
+    av_packet_unref(pkt);
+    if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export_grid failed to write packet: %s\n",
+             av_err2str(ret));
+      return ret;
+    }
+  }
+  if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+    return 0;
+  }
+  return ret;
+}
+
+int video_store_export_grid(const video_store_grid_stream *streams, // IN
+                            const int streamCount,                 // IN
+                            const int columns,                     // IN
+                            const int tileWidth,                   // IN
+                            const int tileHeight,                  // IN
+                            const int64_t durationMicroseconds,    // IN
+                            const int frameRate,                   // IN
+                            const char *outputPath,                // IN
+                            const int64_t bitrate,                 // IN
+                            const char *preset,                    // IN
+                            const int threads                      // IN
+) {
+  grid_reader *readers = NULL;
+  AVCodecContext *encCtx = NULL;
+  AVFormatContext *outCtx = NULL;
+  AVStream *outStream = NULL;
+  AVDictionary *opts = NULL;
+  AVPacket *pkt = NULL;
+  AVFrame *outFrame = NULL;
+  int ret = VIDEO_STORE_GRID_RESP_ERROR;
+
+  if (streamCount <= 0 || columns <= 0 || tileWidth <= 0 || tileHeight <= 0 ||
+      tileWidth % 2 != 0 || tileHeight % 2 != 0 || frameRate <= 0 ||
+      durationMicroseconds <= 0) {
+    av_log(NULL, AV_LOG_ERROR, "video_store_export_grid invalid arguments\n");
+    goto cleanup;
+  }
+  const int rows = (streamCount + columns - 1) / columns;
+
+  readers = av_calloc(streamCount, sizeof(*readers));
+  pkt = av_packet_alloc();
+  outFrame = av_frame_alloc();
+  if (readers == NULL || pkt == NULL || outFrame == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to allocate readers or frames\n");
+    goto cleanup;
+  }
+  for (int i = 0; i < streamCount; i++) {
+    readers[i].stream = &streams[i];
+    readers[i].segment = -1;
+    readers[i].pkt = av_packet_alloc();
+    readers[i].cur = av_frame_alloc();
+    readers[i].next = av_frame_alloc();
+    if (readers[i].pkt == NULL || readers[i].cur == NULL ||
+        readers[i].next == NULL) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export_grid failed to allocate readers or frames\n");
+      goto cleanup;
+    }
+  }
+
+  const AVCodec *enc = avcodec_find_encoder_by_name("libx264");
+  if (enc == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to find libx264\n");
+    ret = AVERROR_ENCODER_NOT_FOUND;
+    goto cleanup;
+  }
+  encCtx = avcodec_alloc_context3(enc);
+  if (encCtx == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to allocate encoder context\n");
+    ret = AVERROR(ENOMEM);
+    goto cleanup;
+  }
+  encCtx->width = columns * tileWidth;
+  encCtx->height = rows * tileHeight;
+  encCtx->pix_fmt = AV_PIX_FMT_YUV420P;
+  encCtx->time_base = (AVRational){1, frameRate};
+  encCtx->framerate = (AVRational){frameRate, 1};
+  encCtx->thread_count = threads;
+  if (bitrate > 0) {
+    encCtx->bit_rate = bitrate;
+  }
+  if ((ret = avformat_alloc_output_context2(&outCtx, NULL, "mp4",
+                                            outputPath)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to allocate output context: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  if (outCtx->oformat->flags & AVFMT_GLOBALHEADER) {
+    encCtx->flags |= AV_CODEC_FLAG_GLOBAL_HEADER;
+  }
+  if (preset != NULL && preset[0] != '\0' &&
+      (ret = av_dict_set(&opts, "preset", preset, 0)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = avcodec_open2(encCtx, enc, &opts)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to open encoder: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  outStream = avformat_new_stream(outCtx, NULL);
+  if (outStream == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to create output stream\n");
+    ret = AVERROR(ENOMEM);
+    goto cleanup;
+  }
+  if ((ret = avcodec_parameters_from_context(outStream->codecpar, encCtx)) <
+      0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to copy encoder parameters: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  outStream->time_base = encCtx->time_base;
+  if ((ret = avio_open(&outCtx->pb, outputPath, AVIO_FLAG_WRITE)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to open output file: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  if ((ret = avformat_write_header(outCtx, NULL)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to write header: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+
+  outFrame->width = encCtx->width;
+  outFrame->height = encCtx->height;
+  outFrame->format = encCtx->pix_fmt;
+  if ((ret = av_frame_get_buffer(outFrame, 0)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to allocate output frame: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+
+  // Every output frame shows each stream as of the same wall clock time.
+  const int64_t frameCount =
+      av_rescale(durationMicroseconds, frameRate, AV_TIME_BASE);
+  for (int64_t n = 0; n < frameCount; n++) {
+    const int64_t t = av_rescale(n, AV_TIME_BASE, frameRate);
+    // The encoder may still reference the previous frame's buffer.
+    if ((ret = av_frame_make_writable(outFrame)) < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_export_grid failed to make frame writable: %s\n",
+             av_err2str(ret));
+      goto cleanup;
+    }
+    fill_black(outFrame);
+    for (int i = 0; i < streamCount; i++) {
+      AVFrame *frame = NULL;
+      if ((ret = advance(&readers[i], t, threads, &frame)) < 0) {
+        goto cleanup;
+      }
+      if (frame != NULL &&
+          (ret = draw_tile(&readers[i], frame, outFrame,
+                           i % columns * tileWidth, i / columns * tileHeight,
+                           tileWidth, tileHeight)) < 0) {
+        goto cleanup;
+      }
+    }
+    outFrame->pts = n;
+    if ((ret = encode(encCtx, outCtx, outStream, pkt, outFrame)) < 0) {
+      goto cleanup;
+    }
+  }
+  if ((ret = encode(encCtx, outCtx, outStream, pkt, NULL)) < 0) {
+    goto cleanup;
+  }
+  if ((ret = av_write_trailer(outCtx)) < 0) {
+    av_log(NULL, AV_LOG_ERROR,
+           "video_store_export_grid failed to write trailer: %s\n",
+           av_err2str(ret));
+    goto cleanup;
+  }
+  ret = VIDEO_STORE_GRID_RESP_OK;
+
+cleanup:
+  if (readers != NULL) {
+    for (int i = 0; i < streamCount; i++) {
+      if (readers[i].cur != NULL && readers[i].next != NULL) {
+        close_segment(&readers[i]);
+      }
+      sws_freeContext(readers[i].swsCtx);
+      av_frame_free(&readers[i].cur);
+      av_frame_free(&readers[i].next);
+      av_packet_free(&readers[i].pkt);
+    }
+    av_free(readers);
+  }
+  if (outCtx != NULL) {
+    if (outCtx->pb != NULL) {
+      avio_closep(&outCtx->pb);
+    }
+    avformat_free_context(outCtx);
+  }
+  avcodec_free_context(&encCtx);
+  av_dict_free(&opts);
+  av_frame_free(&outFrame);
+  av_packet_free(&pkt);
+  return ret;
+}
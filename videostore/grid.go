@@ -0,0 +1,254 @@
+package videostore
+
+/*
+#include "grid.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+)
+
+const (
+	// DefaultGridTileWidth is the width of each stream's tile in a grid export.
+	// The height keeps the aspect ratio of the first stream with video in the range.
+	DefaultGridTileWidth = 640
+	// DefaultGridFrameRate is the frame rate of a grid export.
+	DefaultGridFrameRate = 15
+	// gridFilePrefix is the output file name prefix of grid exports.
+	gridFilePrefix = "grid"
+)
+
+// GridExportRequest is the request to the ExportGrid method.
+type GridExportRequest struct {
+	From time.Time
+	To   time.Time
+	// StreamIDs are the streams to show, tiled left to right then top to bottom.
+	StreamIDs []string
+	// Columns defaults to the smallest square grid which fits every stream.
+	Columns   int
+	TileWidth int
+	FrameRate int
+	Metadata  string
+}
+
+// Validate returns an error if the GridExportRequest is invalid.
+func (r *GridExportRequest) Validate() error {
+	if !r.From.Before(r.To) {
+		return errors.New("'from' timestamp must be before 'to' timestamp")
+	}
+	if r.To.After(time.Now()) {
+		return errors.New("'to' timestamp is in the future")
+	}
+	if len(r.StreamIDs) == 0 {
+		return errors.New("at least one stream id must be set")
+	}
+	seen := map[string]bool{}
+	for _, id := range r.StreamIDs {
+		if seen[id] {
+			return fmt.Errorf("stream %s is listed more than once", id)
+		}
+		seen[id] = true
+	}
+	if r.Columns < 0 {
+		return errors.New("columns can't be less than 0")
+	}
+	if r.TileWidth < 0 || r.TileWidth%2 != 0 {
+		return errors.New("tile width must be even and can't be less than 0")
+	}
+	if r.FrameRate < 0 {
+		return errors.New("frame rate can't be less than 0")
+	}
+	return nil
+}
+
+// gridSegment is a stored segment shown in a grid export, with its start relative
+// to the start of the export.
+type gridSegment struct {
+	path     string
+	start    time.Duration
+	duration time.Duration
+}
+
+// ExportGrid exports r.From to r.To of every stream in r.StreamIDs side by side
+// in a grid, to the upload path of the first stream. Streams are aligned on the
+// wall clock times of their segments, so streams which started recording at
+// slightly different times stay in sync, and times where a stream has no stored
+// video are shown as black.
+func (m *Manager) ExportGrid(ctx context.Context, r *GridExportRequest) (*ExportResponse, error) {
+	r.From = r.From.UTC()
+	r.To = r.To.UTC()
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if r.Columns == 0 {
+		r.Columns = int(math.Ceil(math.Sqrt(float64(len(r.StreamIDs)))))
+	}
+	if r.TileWidth == 0 {
+		r.TileWidth = DefaultGridTileWidth
+	}
+	if r.FrameRate == 0 {
+		r.FrameRate = DefaultGridFrameRate
+	}
+
+	configs := make([]Config, 0, len(r.StreamIDs))
+	m.mu.Lock()
+	for _, id := range r.StreamIDs {
+		s, ok := m.streams[id]
+		if !ok {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s", ErrUnknownStream, id)
+		}
+		configs = append(configs, s.config)
+	}
+	m.mu.Unlock()
+
+	streams := make([][]gridSegment, len(configs))
+	var size videoInfo
+	for i, config := range configs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		segments, info, err := m.gridSegments(config.Storage.StoragePath, r.From, r.To)
+		if err != nil {
+			return nil, err
+		}
+		streams[i] = segments
+		if size.width == 0 && len(segments) > 0 {
+			size = info
+		}
+	}
+	if size.width == 0 {
+		return nil, errors.New("no stored video found in time range")
+	}
+	// yuv420p tiles need even dimensions.
+	tileHeight := r.TileWidth * size.height / size.width
+	tileHeight -= tileHeight % 2
+	if tileHeight == 0 {
+		return nil, fmt.Errorf("tile width %d is too small for %dx%d video", r.TileWidth, size.width, size.height)
+	}
+
+	first := configs[0]
+	uploadFilePath := generateOutputFilePath(gridFilePrefix, r.From, r.Metadata, first.Storage.UploadPath)
+	if err := createDir(first.Storage.UploadPath); err != nil {
+		return nil, err
+	}
+	preset := first.Encoder.Preset
+	if preset == "" {
+		preset = exportPreset
+	}
+	err := exportGrid(streams, r.Columns, r.TileWidth, tileHeight, r.To.Sub(r.From), r.FrameRate,
+		uploadFilePath, first.Encoder.Bitrate, preset, first.Encoder.threads())
+	if err != nil {
+		m.logger.Error("failed to export grid ", err)
+		// Don't leave a partial export to be uploaded.
+		if err := os.Remove(uploadFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.logger.Warnf("failed to remove partial export %s: %v", uploadFilePath, err)
+		}
+		return nil, err
+	}
+	return &ExportResponse{Filename: filepath.Base(uploadFilePath)}, nil
+}
+
+// gridSegments returns the segments in storagePath overlapping from to to, and the
+// video info of the first of them. Segments which can't be read, such as the one
+// still being written, are left out and shown as a gap.
+func (m *Manager) gridSegments(storagePath string, from, to time.Time) ([]gridSegment, videoInfo, error) {
+	files, err := getSortedFiles(storagePath)
+	if err != nil {
+		return nil, videoInfo{}, err
+	}
+	var first videoInfo
+	segments := []gridSegment{}
+	for _, f := range files {
+		if !f.startTime.Before(to) {
+			break
+		}
+		info, err := getVideoInfo(f.name)
+		if err != nil {
+			m.logger.Debugf("skipping segment %s in grid export: %v", f.name, err)
+			continue
+		}
+		if !f.startTime.Add(info.duration).After(from) {
+			continue
+		}
+		if len(segments) == 0 {
+			first = info
+		}
+		segments = append(segments, gridSegment{
+			path:     f.name,
+			start:    f.startTime.Sub(from),
+			duration: info.duration,
+		})
+	}
+	return segments, first, nil
+}
+
+// exportGrid calls the C function video_store_export_grid to composite streams
+// into a grid at outputPath.
+func exportGrid(
+	streams [][]gridSegment,
+	columns, tileWidth, tileHeight int,
+	duration time.Duration,
+	frameRate int,
+	outputPath string,
+	bitrate int,
+	preset string,
+	threads int,
+) error {
+	// The segment lists are passed to C so they must be in C memory.
+	cStreams := unsafe.Slice((*C.video_store_grid_stream)(
+		C.malloc(C.size_t(len(streams))*C.sizeof_video_store_grid_stream)), len(streams))
+	defer C.free(unsafe.Pointer(&cStreams[0]))
+	var cStrings []*C.char
+	defer func() {
+		for _, s := range cStrings {
+			C.free(unsafe.Pointer(s))
+		}
+	}()
+	for i, segments := range streams {
+		cStreams[i] = C.video_store_grid_stream{segmentCount: C.int(len(segments))}
+		if len(segments) == 0 {
+			continue
+		}
+		cSegments := unsafe.Slice((*C.video_store_grid_segment)(
+			C.malloc(C.size_t(len(segments))*C.sizeof_video_store_grid_segment)), len(segments))
+		defer C.free(unsafe.Pointer(&cSegments[0]))
+		for j, s := range segments {
+			path := C.CString(s.path)
+			cStrings = append(cStrings, path)
+			cSegments[j] = C.video_store_grid_segment{
+				path:                 path,
+				startMicroseconds:    C.int64_t(s.start.Microseconds()),
+				durationMicroseconds: C.int64_t(s.duration.Microseconds()),
+			}
+		}
+		cStreams[i].segments = &cSegments[0]
+	}
+	outputPathCStr := C.CString(outputPath)
+	presetCStr := C.CString(preset)
+	defer func() {
+		C.free(unsafe.Pointer(outputPathCStr))
+		C.free(unsafe.Pointer(presetCStr))
+	}()
+
+	ret := C.video_store_export_grid(&cStreams[0], C.int(len(streams)), C.int(columns),
+		C.int(tileWidth), C.int(tileHeight), C.int64_t(duration.Microseconds()), C.int(frameRate),
+		outputPathCStr, C.int64_t(bitrate), presetCStr, C.int(threads))
+	switch ret {
+	case C.VIDEO_STORE_GRID_RESP_OK:
+		return nil
+	case C.VIDEO_STORE_GRID_RESP_ERROR:
+		return errors.New("failed to export grid")
+	default:
+		return fmt.Errorf("failed to export grid: error: %s", ffmpegError(ret))
+	}
+}
@@ -0,0 +1,43 @@
+#ifndef VIAM_VIDEOSTORE_GRID_H
+#define VIAM_VIDEOSTORE_GRID_H
+#include <stdint.h>
+#define VIDEO_STORE_GRID_RESP_OK 0
+#define VIDEO_STORE_GRID_RESP_ERROR 1
+
+// video_store_grid_segment is a stored segment of a stream in a grid export.
+typedef struct video_store_grid_segment {
+  const char *path;
+  // wall clock start of the segment relative to the start of the export,
+  // negative if the segment starts before the export
+  int64_t startMicroseconds;
+  int64_t durationMicroseconds;
+} video_store_grid_segment;
+
+// video_store_grid_stream is the segments of one stream in a grid export,
+// sorted by start time.
+typedef struct video_store_grid_stream {
+  video_store_grid_segment *segments;
+  int segmentCount;
+} video_store_grid_stream;
+
+// video_store_export_grid composites durationMicroseconds of each stream into
+// one video, tiling streams left to right and top to bottom in columns columns
+// of tileWidth x tileHeight each, and encodes it with libx264 at frameRate to
+// an mp4 at outputPath. Streams are aligned on wall clock time: every output
+// frame shows the latest frame of each stream at or before its time, or black
+// where a stream has no segment.
+// A bitrate of 0 uses the encoder's default rate control. The decoders and
+// encoder each use up to threads threads, 0 lets FFmpeg pick.
+int video_store_export_grid(const video_store_grid_stream *streams, // IN
+                            const int streamCount,                 // IN
+                            const int columns,                     // IN
+                            const int tileWidth,                   // IN
+                            const int tileHeight,                  // IN
+                            const int64_t durationMicroseconds,    // IN
+                            const int frameRate,                   // IN
+                            const char *outputPath,                // IN
+                            const int64_t bitrate,                 // IN
+                            const char *preset,                    // IN
+                            const int threads                      // IN
+);
+#endif /* VIAM_VIDEOSTORE_GRID_H */
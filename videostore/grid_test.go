@@ -0,0 +1,113 @@
+package videostore
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestExportGrid(t *testing.T) {
+	const framerate = 10
+	solid := func(c color.Color, seconds int) [][]byte {
+		frame := solidJPEG(t, c)
+		frames := make([][]byte, seconds*framerate)
+		for i := range frames {
+			frames[i] = frame
+		}
+		return frames
+	}
+	gray := color.Gray{Y: 128}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	m := NewManager(logging.NewTestLogger(t))
+	defer m.Close()
+	// Each stream starts recording at a different time, left and right have gaps.
+	streams := []struct {
+		id     string
+		start  time.Time
+		frames [][]byte
+	}{
+		{"front", start, solid(color.White, 4)},
+		{"back", start.Add(time.Second), solid(gray, 4)},
+		{"left", start, solid(color.White, 2)},
+		{"right", start.Add(2 * time.Second), solid(gray, 2)},
+	}
+	ids := []string{}
+	var uploadPath string
+	for _, s := range streams {
+		config := validRTPConfig(t)
+		storeTestSegment(t, config.Storage.StoragePath, s.start, s.frames, framerate)
+		_, err := m.Register(s.id, config)
+		test.That(t, err, test.ShouldBeNil)
+		ids = append(ids, s.id)
+		if uploadPath == "" {
+			uploadPath = config.Storage.UploadPath
+		}
+	}
+
+	res, err := m.ExportGrid(context.Background(), &GridExportRequest{
+		From:      start,
+		To:        start.Add(4 * time.Second),
+		StreamIDs: ids,
+		TileWidth: 160,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	exported := filepath.Join(uploadPath, res.Filename)
+
+	t.Run("Streams are tiled in a 2x2 grid", func(t *testing.T) {
+		info, err := getVideoInfo(exported)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.width, test.ShouldEqual, 320)
+		test.That(t, info.height, test.ShouldEqual, 240)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 4, 0.25)
+	})
+
+	// tileBrightness returns the mean brightness of each tile at offset into the export.
+	tileBrightness := func(t *testing.T, offset time.Duration) []int {
+		img, err := rgbaFrameAt(exported, offset, 320, 240)
+		test.That(t, err, test.ShouldBeNil)
+		brightness := []int{}
+		for i := range 4 {
+			x, y := i%2*160, i/2*120
+			// Leave out the edges, which blend with neighbouring tiles.
+			brightness = append(brightness, meanBrightness(img, image.Rect(x+8, y+8, x+152, y+112)))
+		}
+		return brightness
+	}
+	t.Run("Streams without video yet are black", func(t *testing.T) {
+		b := tileBrightness(t, 500*time.Millisecond)
+		test.That(t, b[0], test.ShouldBeGreaterThan, 220)
+		test.That(t, b[1], test.ShouldBeLessThan, 40)
+		test.That(t, b[2], test.ShouldBeGreaterThan, 220)
+		test.That(t, b[3], test.ShouldBeLessThan, 40)
+	})
+
+	t.Run("Streams are aligned on wall clock time", func(t *testing.T) {
+		b := tileBrightness(t, 1500*time.Millisecond)
+		test.That(t, b[1], test.ShouldAlmostEqual, 128, 20)
+		test.That(t, b[3], test.ShouldBeLessThan, 40)
+	})
+
+	t.Run("Gaps after a stream's video are black", func(t *testing.T) {
+		b := tileBrightness(t, 2500*time.Millisecond)
+		test.That(t, b[0], test.ShouldBeGreaterThan, 220)
+		test.That(t, b[1], test.ShouldAlmostEqual, 128, 20)
+		test.That(t, b[2], test.ShouldBeLessThan, 40)
+		test.That(t, b[3], test.ShouldAlmostEqual, 128, 20)
+	})
+
+	t.Run("Unknown streams are rejected", func(t *testing.T) {
+		_, err := m.ExportGrid(context.Background(), &GridExportRequest{
+			From:      start,
+			To:        start.Add(4 * time.Second),
+			StreamIDs: []string{"front", "missing"},
+		})
+		test.That(t, err, test.ShouldWrap, ErrUnknownStream)
+	})
+}
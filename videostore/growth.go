@@ -0,0 +1,174 @@
+package videostore
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+// growthWindow is the span of samples the storage write rate is averaged over.
+const growthWindow = 5 * time.Minute
+
+// GrowthAlertConfig is the thresholds at which storage growth is reported as abnormal,
+// e.g. from a misconfigured bitrate, before the disk fills up.
+type GrowthAlertConfig struct {
+	// MaxBytesPerSecond alerts when segments are written faster than it. 0 disables the alert.
+	MaxBytesPerSecond float64
+	// MinTimeToFull alerts when the disk is projected to fill sooner than it. 0 disables the alert.
+	MinTimeToFull time.Duration
+}
+
+// Validate returns an error if the GrowthAlertConfig is invalid.
+func (c GrowthAlertConfig) Validate() error {
+	if c.MaxBytesPerSecond < 0 {
+		return errors.New("alert max bytes per second can't be less than 0")
+	}
+	if c.MinTimeToFull < 0 {
+		return errors.New("alert min time to full can't be less than 0")
+	}
+	return nil
+}
+
+// StorageGrowth is how fast stored video is growing and whether it crosses the alert thresholds.
+type StorageGrowth struct {
+	// BytesPerSecond is the rate segments were written at over the last few minutes.
+	BytesPerSecond float64
+	// TimeToFull is the projected time until the disk holding the storage path runs out
+	// of space at BytesPerSecond. It is 0 if the disk isn't projected to fill, either
+	// because nothing is being written or because what is left of size_gb fits in the
+	// free space, so the oldest segments are deleted before the disk fills.
+	TimeToFull time.Duration
+	// RateAlert is set while BytesPerSecond is above GrowthAlertConfig.MaxBytesPerSecond.
+	RateAlert bool
+	// FillAlert is set while TimeToFull is below GrowthAlertConfig.MinTimeToFull.
+	FillAlert bool
+}
+
+// growthSample is the total bytes written to storage as of a time.
+type growthSample struct {
+	at      time.Time
+	written int64
+}
+
+// growthTracker tracks the rate segments are written to storage from periodic samples
+// of their sizes. Deleting segments doesn't lower the rate, so it reflects how fast
+// video is recorded regardless of cleanup.
+type growthTracker struct {
+	logger         logging.Logger
+	storagePath    string
+	maxStorageSize int64
+	alerts         GrowthAlertConfig
+
+	mu sync.Mutex
+	// sizes is the size of each segment at the last sample, nil before the first.
+	sizes   map[string]int64
+	written int64
+	samples []growthSample
+	growth  StorageGrowth
+}
+
+func newGrowthTracker(config StorageConfig, logger logging.Logger) (*growthTracker, error) {
+	maxStorageSize, err := sizeGBToBytes(config.SizeGB)
+	if err != nil {
+		return nil, err
+	}
+	return &growthTracker{
+		logger:         logger,
+		storagePath:    config.StoragePath,
+		maxStorageSize: maxStorageSize,
+		alerts:         config.Alerts,
+	}, nil
+}
+
+// sample records the current size of every segment in storage and the free disk space.
+func (g *growthTracker) sample(now time.Time) error {
+	files, err := getSortedFiles(g.storagePath)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]int64, len(files))
+	var storageSize int64
+	for _, f := range files {
+		info, err := os.Stat(f.name)
+		if err != nil {
+			// Deleted by cleanup since being listed.
+			continue
+		}
+		sizes[f.name] = info.Size()
+		if storageSize, err = addSize(storageSize, info.Size()); err != nil {
+			return err
+		}
+	}
+	free, err := getFreeDiskSpace(g.storagePath)
+	if err != nil {
+		return err
+	}
+	g.observe(now, sizes, free, g.maxStorageSize-storageSize)
+	return nil
+}
+
+// observe updates the growth from the segment sizes, the free disk space and how much
+// more storage may grow before the oldest segments are deleted, as of now.
+// A warning is logged when an alert threshold is first crossed.
+func (g *growthTracker) observe(now time.Time, sizes map[string]int64, free, storageLeft int64) StorageGrowth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sizes != nil {
+		for name, size := range sizes {
+			prev, ok := g.sizes[name]
+			switch {
+			case !ok:
+				g.written += size
+			case size > prev:
+				g.written += size - prev
+			}
+		}
+	}
+	g.sizes = sizes
+	g.samples = append(g.samples, growthSample{at: now, written: g.written})
+	// Keep the newest sample at or before the start of the window to average over all of it.
+	for len(g.samples) > 1 && !g.samples[1].at.After(now.Add(-growthWindow)) {
+		g.samples = g.samples[1:]
+	}
+
+	var growth StorageGrowth
+	first := g.samples[0]
+	if span := now.Sub(first.at).Seconds(); span > 0 {
+		growth.BytesPerSecond = float64(g.written-first.written) / span
+	}
+	if growth.BytesPerSecond > 0 && storageLeft > free {
+		growth.TimeToFull = time.Duration(float64(free) / growth.BytesPerSecond * float64(time.Second))
+	}
+	growth.RateAlert = g.alerts.MaxBytesPerSecond > 0 && growth.BytesPerSecond > g.alerts.MaxBytesPerSecond
+	growth.FillAlert = g.alerts.MinTimeToFull > 0 && growth.TimeToFull > 0 && growth.TimeToFull < g.alerts.MinTimeToFull
+	if growth.RateAlert && !g.growth.RateAlert {
+		g.logger.Warnf("storage is growing at %.0f bytes/s, above the alert threshold of %.0f bytes/s",
+			growth.BytesPerSecond, g.alerts.MaxBytesPerSecond)
+	}
+	if growth.FillAlert && !g.growth.FillAlert {
+		g.logger.Warnf("disk is projected to fill in %s, sooner than the alert threshold of %s",
+			growth.TimeToFull.Round(time.Second), g.alerts.MinTimeToFull)
+	}
+	g.growth = growth
+	return growth
+}
+
+// reset forgets every sample, so growth is measured again from the next.
+func (g *growthTracker) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sizes = nil
+	g.written = 0
+	g.samples = nil
+	g.growth = StorageGrowth{}
+}
+
+// current returns the growth as of the last sample.
+func (g *growthTracker) current() StorageGrowth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.growth
+}
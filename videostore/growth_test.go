@@ -0,0 +1,66 @@
+package videostore
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestGrowthTracker(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	start := time.Now()
+	const free, storageLeft = 1 << 30, 1 << 20
+
+	t.Run("Rate spike trips the rate alert", func(t *testing.T) {
+		g := &growthTracker{logger: logger, alerts: GrowthAlertConfig{MaxBytesPerSecond: 1000}}
+		g.observe(start, map[string]int64{"a": 0}, free, storageLeft)
+		growth := g.observe(start.Add(time.Minute), map[string]int64{"a": 30000}, free, storageLeft)
+		test.That(t, growth.BytesPerSecond, test.ShouldAlmostEqual, 500)
+		test.That(t, growth.RateAlert, test.ShouldBeFalse)
+
+		// A new segment is written much faster, e.g. after a bitrate misconfiguration.
+		growth = g.observe(start.Add(2*time.Minute), map[string]int64{"a": 30000, "b": 210000}, free, storageLeft)
+		test.That(t, growth.BytesPerSecond, test.ShouldAlmostEqual, 2000)
+		test.That(t, growth.RateAlert, test.ShouldBeTrue)
+		test.That(t, g.current(), test.ShouldResemble, growth)
+	})
+
+	t.Run("Deleted segments don't lower the rate", func(t *testing.T) {
+		g := &growthTracker{logger: logger}
+		g.observe(start, map[string]int64{"a": 60000}, free, storageLeft)
+		growth := g.observe(start.Add(time.Minute), map[string]int64{"b": 60000}, free, storageLeft)
+		test.That(t, growth.BytesPerSecond, test.ShouldAlmostEqual, 1000)
+	})
+
+	t.Run("Rate is averaged over the window", func(t *testing.T) {
+		g := &growthTracker{logger: logger, alerts: GrowthAlertConfig{MaxBytesPerSecond: 1000}}
+		g.observe(start, map[string]int64{"a": 0}, free, storageLeft)
+		g.observe(start.Add(time.Minute), map[string]int64{"a": 600000}, free, storageLeft)
+		var growth StorageGrowth
+		for i := range 6 {
+			growth = g.observe(start.Add(time.Duration(i+2)*time.Minute), map[string]int64{"a": 600000}, free, storageLeft)
+		}
+		// The spike has left the window.
+		test.That(t, growth.BytesPerSecond, test.ShouldEqual, 0)
+		test.That(t, growth.RateAlert, test.ShouldBeFalse)
+	})
+
+	t.Run("Projected fill time below the threshold trips the fill alert", func(t *testing.T) {
+		g := &growthTracker{logger: logger, alerts: GrowthAlertConfig{MinTimeToFull: time.Hour}}
+		g.observe(start, map[string]int64{"a": 0}, 1<<20, 1<<30)
+		// 1MiB free at 1000 bytes/s fills in under 18 minutes.
+		growth := g.observe(start.Add(time.Minute), map[string]int64{"a": 60000}, 1<<20, 1<<30)
+		test.That(t, growth.TimeToFull.Seconds(), test.ShouldAlmostEqual, 1048.576, 0.001)
+		test.That(t, growth.FillAlert, test.ShouldBeTrue)
+	})
+
+	t.Run("Disk isn't projected to fill if the rest of storage fits", func(t *testing.T) {
+		g := &growthTracker{logger: logger, alerts: GrowthAlertConfig{MinTimeToFull: time.Hour}}
+		g.observe(start, map[string]int64{"a": 0}, free, storageLeft)
+		growth := g.observe(start.Add(time.Minute), map[string]int64{"a": 60000}, free, storageLeft)
+		test.That(t, growth.TimeToFull, test.ShouldEqual, 0)
+		test.That(t, growth.FillAlert, test.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,176 @@
+package videostore
+
+/*
+#include "export.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// defaultHLSSegmentSeconds is the target length of HLS segments, as Apple recommends.
+	defaultHLSSegmentSeconds = 6
+	maxHLSSegmentSeconds     = 60
+	// hlsPlaylistName is the name of an HLS export's playlist in its directory, next to
+	// its segments, and hlsKeyName the key URI it has by default.
+	hlsPlaylistName = "index.m3u8"
+	hlsKeyName      = "index.key"
+	hlsSegmentGlob  = "segment_*.ts"
+	// hlsKeySize is the size of an AES-128 key and IV.
+	hlsKeySize = 16
+)
+
+// HLSOutput writes an export as an HLS VOD playlist of MPEG-TS segments encrypted with
+// AES-128, instead of an mp4. Each export is encrypted with its own random key, which is
+// only returned in ExportResponse.Key. It isn't written to the upload path, where anyone
+// who can fetch the segments could read it.
+type HLSOutput struct {
+	// SegmentSeconds is the target length of each segment, at most 60. 0 uses 6 seconds.
+	SegmentSeconds int
+	// KeyURI is the URI players fetch the key from, written to the playlist's EXT-X-KEY,
+	// e.g. a key server which checks who is asking. Defaults to index.key next to the
+	// playlist, where the key has to be delivered separately.
+	KeyURI string
+}
+
+// Validate returns an error if the HLSOutput is invalid.
+func (h *HLSOutput) Validate() error {
+	if h.SegmentSeconds < 0 || h.SegmentSeconds > maxHLSSegmentSeconds {
+		return fmt.Errorf("hls segment seconds must be between 0 and %d", maxHLSSegmentSeconds)
+	}
+	if strings.ContainsAny(h.KeyURI, "\r\n\"") {
+		return errors.New("hls key uri can't contain quotes or line breaks")
+	}
+	return nil
+}
+
+func (h *HLSOutput) segmentSeconds() int {
+	if h.SegmentSeconds == 0 {
+		return defaultHLSSegmentSeconds
+	}
+	return h.SegmentSeconds
+}
+
+// hlsExport is the directory an HLS export is written to and the key it is encrypted with.
+type hlsExport struct {
+	logger logging.Logger
+	dir    string
+	key    []byte
+	// keyPath and keyInfoPath are the key file and the HLS muxer's key info file, which
+	// are kept out of the upload path and removed once muxed.
+	keyPath     string
+	keyInfoPath string
+	c           *C.video_store_export_hls
+	// ptrs is the C memory of c, which is freed with the export.
+	ptrs []unsafe.Pointer
+}
+
+// newHLSExport creates the directory of an HLS export in place of the mp4 at
+// uploadFilePath, with a new key and IV. It is freed with free.
+func newHLSExport(uploadFilePath string, h *HLSOutput, logger logging.Logger) (*hlsExport, error) {
+	dir := strings.TrimSuffix(uploadFilePath, filepath.Ext(uploadFilePath))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create hls export directory: %w", err)
+	}
+	e := &hlsExport{logger: logger, dir: dir}
+	if err := e.writeKey(h); err != nil {
+		e.free()
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warnf("failed to remove hls export %s: %v", dir, err)
+		}
+		return nil, err
+	}
+	// The config is read by C while exporting so it must be in C memory.
+	cfg := C.calloc(1, C.sizeof_video_store_export_hls)
+	e.ptrs = append(e.ptrs, cfg)
+	e.c = (*C.video_store_export_hls)(cfg)
+	e.c.segmentPattern = e.cString(filepath.Join(dir, strings.Replace(hlsSegmentGlob, "*", "%03d", 1)))
+	e.c.keyInfoPath = e.cString(e.keyInfoPath)
+	e.c.segmentSeconds = C.int(h.segmentSeconds())
+	return e, nil
+}
+
+// cString returns s in C memory which is freed with the export.
+func (e *hlsExport) cString(s string) *C.char {
+	p := C.CString(s)
+	e.ptrs = append(e.ptrs, unsafe.Pointer(p))
+	return p
+}
+
+// writeKey writes a new random key to a temporary file, and the key info file pointing
+// the muxer at it with a random IV.
+func (e *hlsExport) writeKey(h *HLSOutput) error {
+	secret := make([]byte, 2*hlsKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	e.key = secret[:hlsKeySize]
+	iv := secret[hlsKeySize:]
+	keyFile, err := os.CreateTemp("", "video_store_hls_*.key")
+	if err != nil {
+		return err
+	}
+	e.keyPath = keyFile.Name()
+	_, err = keyFile.Write(e.key)
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	keyURI := h.KeyURI
+	if keyURI == "" {
+		keyURI = hlsKeyName
+	}
+	keyInfo, err := os.CreateTemp("", "video_store_hls_*.keyinfo")
+	if err != nil {
+		return err
+	}
+	e.keyInfoPath = keyInfo.Name()
+	_, err = fmt.Fprintf(keyInfo, "%s\n%s\n%s\n", keyURI, e.keyPath, hex.EncodeToString(iv))
+	if closeErr := keyInfo.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// playlistPath returns the path of the export's playlist.
+func (e *hlsExport) playlistPath() string {
+	return filepath.Join(e.dir, hlsPlaylistName)
+}
+
+// segments returns the paths of the export's segments, in order.
+func (e *hlsExport) segments() ([]string, error) {
+	return filepath.Glob(filepath.Join(e.dir, hlsSegmentGlob))
+}
+
+// free frees the C config of the export and removes its key and key info files. The
+// export itself is kept.
+func (e *hlsExport) free() {
+	for _, path := range []*string{&e.keyPath, &e.keyInfoPath} {
+		if *path == "" {
+			continue
+		}
+		if err := os.Remove(*path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			e.logger.Warnf("failed to remove hls key file %s: %v", *path, err)
+		}
+		*path = ""
+	}
+	for _, p := range e.ptrs {
+		C.free(p)
+	}
+	e.ptrs = nil
+	e.c = nil
+}
@@ -0,0 +1,169 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestExportHLS(t *testing.T) {
+	const framerate = 10
+	frames := make([][]byte, 12*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+	keyLine := regexp.MustCompile(`#EXT-X-KEY:METHOD=AES-128,URI="([^"]*)",IV=0x([0-9a-fA-F]{32})`)
+	const tsPacketSize = 188
+	// isTS returns whether b is a stream of MPEG-TS packets.
+	isTS := func(b []byte) bool {
+		if len(b) == 0 || len(b)%tsPacketSize != 0 {
+			return false
+		}
+		for i := 0; i < len(b); i += tsPacketSize {
+			if b[i] != 0x47 {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("Segments are encrypted and decrypt with the emitted key", func(t *testing.T) {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(12 * time.Second),
+			Metadata: "hls",
+			HLS:      &HLSOutput{SegmentSeconds: 2},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, filepath.Base(res.Filename), test.ShouldEqual, hlsPlaylistName)
+		playlistPath := filepath.Join(config.Storage.UploadPath, res.Filename)
+		dir := filepath.Dir(playlistPath)
+		playlist, err := os.ReadFile(playlistPath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(playlist), test.ShouldContainSubstring, "#EXT-X-PLAYLIST-TYPE:VOD")
+		test.That(t, string(playlist), test.ShouldContainSubstring, "#EXT-X-ENDLIST")
+		match := keyLine.FindStringSubmatch(string(playlist))
+		test.That(t, match, test.ShouldNotBeNil)
+		test.That(t, match[1], test.ShouldEqual, hlsKeyName)
+
+		key, err := hex.DecodeString(res.Key)
+		test.That(t, err, test.ShouldBeNil)
+		// The key is only in the response, nothing in the upload path holds it.
+		err = filepath.WalkDir(config.Storage.UploadPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			b, err := os.ReadFile(path)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, bytes.Contains(b, key), test.ShouldBeFalse)
+			return nil
+		})
+		test.That(t, err, test.ShouldBeNil)
+		_, err = os.Stat(filepath.Join(dir, hlsKeyName))
+		test.That(t, err, test.ShouldWrap, os.ErrNotExist)
+		// The key the muxer read was removed once muxed.
+		keys, err := filepath.Glob(filepath.Join(os.TempDir(), "video_store_hls_*"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, keys, test.ShouldBeEmpty)
+		iv, err := hex.DecodeString(match[2])
+		test.That(t, err, test.ShouldBeNil)
+
+		segments, err := filepath.Glob(filepath.Join(dir, hlsSegmentGlob))
+		test.That(t, err, test.ShouldBeNil)
+		// A keyframe starts every 2 seconds, so the segments are close to that long.
+		test.That(t, len(segments), test.ShouldBeBetweenOrEqual, 5, 7)
+		block, err := aes.NewCipher(key)
+		test.That(t, err, test.ShouldBeNil)
+		for _, segment := range segments {
+			encrypted, err := os.ReadFile(segment)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, isTS(encrypted), test.ShouldBeFalse)
+			test.That(t, len(encrypted)%aes.BlockSize, test.ShouldEqual, 0)
+			decrypted := make([]byte, len(encrypted))
+			cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+			// Segments are padded with PKCS#7.
+			padding := int(decrypted[len(decrypted)-1])
+			test.That(t, padding, test.ShouldBeBetweenOrEqual, 1, aes.BlockSize)
+			test.That(t, bytes.Count(decrypted[len(decrypted)-padding:], []byte{byte(padding)}), test.ShouldEqual, padding)
+			test.That(t, isTS(decrypted[:len(decrypted)-padding]), test.ShouldBeTrue)
+		}
+
+		// Players decrypt and decode the whole export through the playlist once the key is
+		// delivered at its URI.
+		test.That(t, os.WriteFile(filepath.Join(dir, hlsKeyName), key, 0o600), test.ShouldBeNil)
+		test.That(t, len(frameTimes(t, playlistPath)), test.ShouldEqual, len(frames))
+
+		b, err := os.ReadFile(filepath.Join(config.Storage.UploadPath, res.Manifest))
+		test.That(t, err, test.ShouldBeNil)
+		var m exportManifest
+		test.That(t, json.Unmarshal(b, &m), test.ShouldBeNil)
+		test.That(t, m.Export.Filename, test.ShouldEqual, hlsPlaylistName)
+		test.That(t, len(m.Parts), test.ShouldEqual, len(segments))
+	})
+
+	t.Run("Each export has its own key and the key URI is configurable", func(t *testing.T) {
+		const keyURI = "https://keys.example.com/exports/1"
+		first, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(2 * time.Second),
+			Metadata: "hls_uri",
+			HLS:      &HLSOutput{KeyURI: keyURI},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		playlist, err := os.ReadFile(filepath.Join(config.Storage.UploadPath, first.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		match := keyLine.FindStringSubmatch(string(playlist))
+		test.That(t, match, test.ShouldNotBeNil)
+		test.That(t, match[1], test.ShouldEqual, keyURI)
+
+		second, err := vs.Export(context.Background(), &ExportRequest{
+			From:     start,
+			To:       start.Add(2 * time.Second),
+			Metadata: "hls_second",
+			HLS:      &HLSOutput{},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, second.Key, test.ShouldNotEqual, first.Key)
+	})
+
+	t.Run("A failed export removes its directory", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := vs.Export(ctx, &ExportRequest{
+			From:     start,
+			To:       start.Add(2 * time.Second),
+			Metadata: "hls_canceled",
+			HLS:      &HLSOutput{},
+		})
+		test.That(t, err, test.ShouldWrap, context.Canceled)
+		dirs, err := filepath.Glob(filepath.Join(config.Storage.UploadPath, "*hls_canceled*"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, dirs, test.ShouldBeEmpty)
+	})
+
+	t.Run("Invalid HLS options are rejected", func(t *testing.T) {
+		poster := start.Add(time.Second)
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:   start,
+			To:     start.Add(2 * time.Second),
+			Poster: &poster,
+			HLS:    &HLSOutput{},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, (&HLSOutput{SegmentSeconds: maxHLSSegmentSeconds + 1}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&HLSOutput{KeyURI: "key\"\n"}).Validate(), test.ShouldNotBeNil)
+	})
+}
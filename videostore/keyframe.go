@@ -0,0 +1,85 @@
+package videostore
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultKeyframeRequestInterval is how often a keyframe request is repeated by default.
+const defaultKeyframeRequestInterval = time.Second
+
+// KeyframeRequestPolicy makes segments written from RTP start playable when recording
+// resumes mid-GOP, after a stall or when packets start arriving between IDRs. The
+// packets before the next IDR reference frames which were never written, so they are
+// dropped, and a keyframe is requested from the source with the function passed to
+// RawSegmenter.SetKeyframeRequester, e.g. by sending an RTCP PLI or an RTSP request, so
+// the gap lasts until the source responds rather than until its next scheduled IDR.
+// Sources which can't send a keyframe on request still start clean, but only from their
+// next scheduled IDR. Frame sources don't need this, as the store encodes them itself.
+type KeyframeRequestPolicy struct {
+	// Stall is how long without packets counts as a stall, after which the next packet
+	// resumes recording. 0 disables the policy.
+	Stall time.Duration
+	// Interval is how often the request is repeated until an IDR arrives. 0 uses a second.
+	Interval time.Duration
+}
+
+// Validate returns an error if the KeyframeRequestPolicy is invalid.
+func (p KeyframeRequestPolicy) Validate() error {
+	if p.Stall < 0 {
+		return errors.New("keyframe request stall can't be less than 0")
+	}
+	if p.Interval < 0 {
+		return errors.New("keyframe request interval can't be less than 0")
+	}
+	return nil
+}
+
+func (p KeyframeRequestPolicy) interval() time.Duration {
+	if p.Interval == 0 {
+		return defaultKeyframeRequestInterval
+	}
+	return p.Interval
+}
+
+// keyframeWaiter tracks whether a segmenter is waiting for an IDR to resume recording.
+type keyframeWaiter struct {
+	policy KeyframeRequestPolicy
+	// lastPacket is when the last packet was written, zero before the first since Init.
+	lastPacket time.Time
+	waiting    bool
+	// lastRequest is when a keyframe was last requested while waiting.
+	lastRequest time.Time
+	// dropped counts the packets dropped while waiting.
+	dropped int
+}
+
+// newKeyframeWaiter returns nil if the policy is disabled.
+func newKeyframeWaiter(policy KeyframeRequestPolicy) *keyframeWaiter {
+	if policy.Stall == 0 {
+		return nil
+	}
+	return &keyframeWaiter{policy: policy}
+}
+
+// packet returns whether the packet arriving at now should be dropped as it can't be
+// decoded, and whether a keyframe should be requested.
+func (w *keyframeWaiter) packet(now time.Time, isIDR bool) (drop, request bool) {
+	resumed := w.lastPacket.IsZero() || now.Sub(w.lastPacket) > w.policy.Stall
+	w.lastPacket = now
+	if isIDR {
+		w.waiting = false
+		return false, false
+	}
+	if resumed && !w.waiting {
+		w.waiting, w.lastRequest, w.dropped = true, now, 0
+		request = true
+	} else if w.waiting && now.Sub(w.lastRequest) >= w.policy.interval() {
+		w.lastRequest = now
+		request = true
+	}
+	if w.waiting {
+		w.dropped++
+	}
+	return w.waiting, request
+}
@@ -0,0 +1,87 @@
+package videostore
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestKeyframeRequest(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	// IDRs are at packets 0, 10 and 20.
+	packets := h264Packets(t, frames, framerate)
+	newSegmenter := func(t *testing.T, storagePath string, requests *atomic.Int32) *RawSegmenter {
+		policy := KeyframeRequestPolicy{Stall: 50 * time.Millisecond, Interval: time.Hour}
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{keyframes: policy}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		rs.SetKeyframeRequester(func() { requests.Add(1) })
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		return rs
+	}
+	write := func(t *testing.T, rs *RawSegmenter, packets []testPacket) {
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+	}
+	// segment returns the only segment in storagePath and checks it starts playable.
+	segment := func(t *testing.T, storagePath string) string {
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, keyframeIndexes(t, files[0].name)[0], test.ShouldEqual, 0)
+		_, err = grayFrameAt(files[0].name, 0, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+		return files[0].name
+	}
+
+	t.Run("A keyframe is requested when packets resume mid-GOP after a stall", func(t *testing.T) {
+		storagePath := t.TempDir()
+		var requests atomic.Int32
+		rs := newSegmenter(t, storagePath, &requests)
+		write(t, rs, packets[:15])
+		test.That(t, requests.Load(), test.ShouldEqual, 0)
+
+		time.Sleep(100 * time.Millisecond)
+		write(t, rs, packets[15:20])
+		test.That(t, requests.Load(), test.ShouldEqual, 1)
+		write(t, rs, packets[20:])
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		// Only one request is made while waiting within the interval.
+		test.That(t, requests.Load(), test.ShouldEqual, 1)
+
+		// Packets 15 to 19 reference frames which were never written, so they are dropped.
+		test.That(t, len(frameTimes(t, segment(t, storagePath))), test.ShouldEqual, 25)
+	})
+
+	t.Run("A keyframe is requested when the first packet isn't an IDR", func(t *testing.T) {
+		storagePath := t.TempDir()
+		var requests atomic.Int32
+		rs := newSegmenter(t, storagePath, &requests)
+		write(t, rs, packets[5:])
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		test.That(t, requests.Load(), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, segment(t, storagePath))), test.ShouldEqual, 20)
+	})
+
+	t.Run("Packets aren't dropped without the policy", func(t *testing.T) {
+		rs, err := newRawSegmenter(t.TempDir(), rawSegmenterOptions{}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		defer rs.Close()
+		test.That(t, rs.keyframeWaiter, test.ShouldBeNil)
+	})
+
+	t.Run("Negative durations are invalid", func(t *testing.T) {
+		test.That(t, KeyframeRequestPolicy{Stall: -1}.Validate(), test.ShouldNotBeNil)
+		test.That(t, KeyframeRequestPolicy{Interval: -1}.Validate(), test.ShouldNotBeNil)
+		test.That(t, KeyframeRequestPolicy{Stall: time.Second}.Validate(), test.ShouldBeNil)
+	})
+}
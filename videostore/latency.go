@@ -0,0 +1,109 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultSustainedSlowWrites is how many slow writes in a row engage a LatencyPolicy by default.
+const defaultSustainedSlowWrites = 5
+
+// LatencyAction is what a RawSegmenter does while writes to storage are slow.
+type LatencyAction int
+
+const (
+	// LatencyActionBuffer suspends the flush policy so packets are buffered in memory and
+	// written in larger, less frequent writes. It has no effect without a flush policy.
+	LatencyActionBuffer LatencyAction = iota
+	// LatencyActionShed drops every packet but IDRs, so the segment keeps a keyframe per
+	// GOP and stays seekable. Writing whole GOPs resumes from the IDR written on recovery.
+	LatencyActionShed
+)
+
+// latencyActionDescriptions are logged when a LatencyPolicy engages.
+var latencyActionDescriptions = map[LatencyAction]string{
+	LatencyActionBuffer: "buffering writes",
+	LatencyActionShed:   "shedding packets other than IDRs",
+}
+
+// LatencyPolicy adapts writing packets from RTP when storage write latency spikes, e.g. on
+// a spinning disk, so the source isn't held up by a backlog of slow writes.
+// It engages once Sustained writes in a row take longer than Threshold and disengages on
+// the first write which doesn't.
+type LatencyPolicy struct {
+	// Threshold is the write latency considered slow. 0 disables the policy.
+	Threshold time.Duration
+	// Sustained is how many slow writes in a row engage the policy. 0 uses 5.
+	Sustained int
+	// Action is what is done while the policy is engaged.
+	Action LatencyAction
+}
+
+// Validate returns an error if the LatencyPolicy is invalid.
+func (p LatencyPolicy) Validate() error {
+	if p.Threshold < 0 {
+		return errors.New("latency threshold can't be less than 0")
+	}
+	if p.Sustained < 0 {
+		return errors.New("latency sustained can't be less than 0")
+	}
+	if p.Action != LatencyActionBuffer && p.Action != LatencyActionShed {
+		return fmt.Errorf("invalid latency action %d", p.Action)
+	}
+	return nil
+}
+
+// latencyMonitor tracks the latency of packet writes against a LatencyPolicy.
+type latencyMonitor struct {
+	policy    LatencyPolicy
+	sustained int
+	// slowWrites is the number of slow writes in a row.
+	slowWrites int
+	engaged    bool
+	// shed is the number of packets dropped since the policy engaged.
+	shed int
+}
+
+// newLatencyMonitor returns nil if the policy is disabled.
+func newLatencyMonitor(p LatencyPolicy) *latencyMonitor {
+	if p.Threshold <= 0 {
+		return nil
+	}
+	sustained := p.Sustained
+	if sustained == 0 {
+		sustained = defaultSustainedSlowWrites
+	}
+	return &latencyMonitor{policy: p, sustained: sustained}
+}
+
+// skip returns true if a packet should be dropped instead of written.
+// While shedding only IDRs are written, so the policy disengages on an IDR and the
+// GOP after it is written whole.
+func (m *latencyMonitor) skip(isIDR bool) bool {
+	if m.policy.Action != LatencyActionShed || !m.engaged || isIDR {
+		return false
+	}
+	m.shed++
+	return true
+}
+
+// observe records the latency of a write. It returns true if the policy engaged or
+// disengaged because of it.
+func (m *latencyMonitor) observe(latency time.Duration) bool {
+	if latency <= m.policy.Threshold {
+		m.slowWrites = 0
+		if !m.engaged {
+			return false
+		}
+		m.engaged = false
+		return true
+	}
+	m.slowWrites++
+	if m.engaged || m.slowWrites < m.sustained {
+		return false
+	}
+	m.engaged = true
+	m.shed = 0
+	return true
+}
@@ -0,0 +1,99 @@
+package videostore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestLatencyPolicy(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	packets := h264Packets(t, frames, framerate)
+
+	// Writes take 80ms while slow is set, and written counts the packets written.
+	var slow bool
+	var written int
+	writeLatency := func() {
+		written++
+		if slow {
+			time.Sleep(80 * time.Millisecond)
+		}
+	}
+
+	newSegmenter := func(t *testing.T, flush FlushPolicy, latency LatencyPolicy) (*RawSegmenter, string) {
+		storagePath := t.TempDir()
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{flush: flush, latency: latency, writeLatency: writeLatency}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		return rs, storagePath
+	}
+	write := func(t *testing.T, rs *RawSegmenter, packets []testPacket) {
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+	}
+
+	t.Run("Sustained slow writes shed packets other than IDRs", func(t *testing.T) {
+		rs, storagePath := newSegmenter(t, FlushPolicy{}, LatencyPolicy{
+			Threshold: 50 * time.Millisecond,
+			Sustained: 3,
+			Action:    LatencyActionShed,
+		})
+		slow, written = true, 0
+		// Packets 0 to 2 are slow and engage the policy, then only the IDR at 10 is written.
+		write(t, rs, packets[:15])
+		test.That(t, rs.latencyMonitor.engaged, test.ShouldBeTrue)
+		test.That(t, written, test.ShouldEqual, 4)
+
+		// Shedding continues until the IDR at 20 is written quickly, then whole GOPs are written.
+		slow = false
+		write(t, rs, packets[15:])
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		test.That(t, rs.latencyMonitor.engaged, test.ShouldBeFalse)
+		test.That(t, written, test.ShouldEqual, 14)
+
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, segments[0])), test.ShouldEqual, 14)
+		// What was written is still decodable.
+		_, err = grayFrameAt(segments[0], 0, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("Sustained slow writes suspend flushing", func(t *testing.T) {
+		rs, _ := newSegmenter(t, FlushPolicy{Packets: 1}, LatencyPolicy{
+			Threshold: 50 * time.Millisecond,
+			Sustained: 3,
+			Action:    LatencyActionBuffer,
+		})
+		slow, written = true, 0
+		write(t, rs, packets[:6])
+		test.That(t, rs.latencyMonitor.engaged, test.ShouldBeTrue)
+		slow = false
+		write(t, rs, packets[6:])
+		test.That(t, rs.latencyMonitor.engaged, test.ShouldBeFalse)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		// Nothing is dropped while buffering.
+		test.That(t, written, test.ShouldEqual, len(packets))
+	})
+
+	t.Run("Disabled policy doesn't monitor writes", func(t *testing.T) {
+		test.That(t, newLatencyMonitor(LatencyPolicy{}), test.ShouldBeNil)
+	})
+
+	t.Run("Invalid policy is rejected", func(t *testing.T) {
+		test.That(t, LatencyPolicy{Threshold: time.Second, Action: LatencyActionShed}.Validate(), test.ShouldBeNil)
+		test.That(t, LatencyPolicy{Threshold: -time.Second}.Validate(), test.ShouldNotBeNil)
+		test.That(t, LatencyPolicy{Sustained: -1}.Validate(), test.ShouldNotBeNil)
+		test.That(t, LatencyPolicy{Action: 2}.Validate(), test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,73 @@
+package videostore
+
+/*
+#include "utils.h"
+#include <libavformat/avformat.h>
+*/
+import "C"
+
+import "sync"
+
+// libavGlobals counts references to libav's global state. The state is process wide,
+// so every video store shares processLibAV: network support is initialized when the
+// first video store is created and deinitialized when the last is closed, and the log
+// callback is registered once and never unregistered, as FFmpeg may log through it from
+// any thread.
+type libavGlobals struct {
+	mu   sync.Mutex
+	refs int
+	// logCallbackOnce guards registering the log callback.
+	logCallbackOnce sync.Once
+	// registerLogCallback, networkInit and networkDeinit set up and tear down libav's
+	// global state.
+	registerLogCallback func()
+	networkInit         func()
+	networkDeinit       func()
+}
+
+// processLibAV counts references to the process's libav global state.
+var processLibAV = &libavGlobals{
+	registerLogCallback: func() { C.video_store_set_custom_av_log_callback() },
+	networkInit:         func() { C.avformat_network_init() },
+	networkDeinit:       func() { C.avformat_network_deinit() },
+}
+
+// libavRef is a video store's reference to libav's global state.
+type libavRef struct {
+	g    *libavGlobals
+	once sync.Once
+}
+
+// setLogCallback registers the log callback, if it isn't already.
+func (g *libavGlobals) setLogCallback() {
+	g.logCallbackOnce.Do(g.registerLogCallback)
+}
+
+// acquire returns a reference to libav's global state, setting it up if there are no
+// other references.
+func (g *libavGlobals) acquire() *libavRef {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.refs == 0 {
+		g.setLogCallback()
+		g.networkInit()
+	}
+	g.refs++
+	return &libavRef{g: g}
+}
+
+// release releases the reference, tearing down libav's global state if it was the last.
+// Only the first call releases it, and it is a no-op on a nil reference.
+func (r *libavRef) release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.g.mu.Lock()
+		defer r.g.mu.Unlock()
+		r.g.refs--
+		if r.g.refs == 0 {
+			r.g.networkDeinit()
+		}
+	})
+}
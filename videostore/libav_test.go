@@ -0,0 +1,67 @@
+package videostore
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestLibAVGlobals(t *testing.T) {
+	var callbacks, inits, deinits int
+	g := &libavGlobals{
+		registerLogCallback: func() { callbacks++ },
+		networkInit:         func() { inits++ },
+		networkDeinit:       func() { deinits++ },
+	}
+
+	t.Run("Globals are set up by the first reference and torn down by the last", func(t *testing.T) {
+		const n = 20
+		refs := make([]*libavRef, n)
+		var wg sync.WaitGroup
+		for i := range refs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				refs[i] = g.acquire()
+			}()
+		}
+		wg.Wait()
+		test.That(t, g.refs, test.ShouldEqual, n)
+		for _, ref := range refs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ref.release()
+			}()
+		}
+		wg.Wait()
+		// Releasing a reference again doesn't release it twice.
+		refs[0].release()
+		test.That(t, g.refs, test.ShouldEqual, 0)
+		test.That(t, callbacks, test.ShouldEqual, 1)
+		test.That(t, inits, test.ShouldEqual, 1)
+		test.That(t, deinits, test.ShouldEqual, 1)
+	})
+
+	t.Run("A reconfigured store takes over the reference", func(t *testing.T) {
+		logger := logging.NewTestLogger(t)
+		config := validRTPConfig(t)
+		vs, err := NewRTPVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		// Swap the store's reference to the process's globals for one to the test's.
+		vs.(*videostore).libav.release()
+		vs.(*videostore).libav = g.acquire()
+		test.That(t, inits, test.ShouldEqual, 2)
+		next, err := vs.(*videostore).reconfigure(context.Background(), config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, g.refs, test.ShouldEqual, 1)
+		next.Close()
+		test.That(t, g.refs, test.ShouldEqual, 0)
+		test.That(t, deinits, test.ShouldEqual, 2)
+		// The log callback is only ever registered once.
+		test.That(t, callbacks, test.ShouldEqual, 1)
+	})
+}
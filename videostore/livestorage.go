@@ -0,0 +1,180 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// defaultLiveSizeMB is the default budget for segments in the live path.
+	defaultLiveSizeMB = 256
+	// tmpExt is appended to segments while they are copied to the storage path.
+	tmpExt = ".tmp"
+)
+
+// migrator is a go routine that moves completed segments from the live path to the storage path.
+func (vs *videostore) migrator(ctx context.Context) {
+	ticker := time.NewTicker(vs.config.Storage.Live.syncInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := migrateSegments(vs.config.Storage, false, vs.settingDurations(), vs.logger); err != nil {
+				vs.logger.Error("failed to migrate segments", err)
+			}
+		}
+	}
+}
+
+// settingDurations returns the segments whose durations the store's segmenter is setting,
+// nil if it has no segmenter.
+func (vs *videostore) settingDurations() *segmentDurations {
+	if vs.rawSegmenter == nil {
+		return nil
+	}
+	return vs.rawSegmenter.settingDurations
+}
+
+// migrateSegments moves completed segments from the live path to the storage path, which
+// stays the source of truth for fetches and cleanup. The newest live segment is still being
+// written unless final is set, so a symlink to it is left in the storage path in its place.
+// A segment whose duration is still being set in durations isn't complete either, so is
+// left for the next migration, or waited for if final is set.
+// Live segments that can't be migrated are deleted, oldest first, once the live path is over
+// its budget so that a full storage path can't fill up memory.
+func migrateSegments(config StorageConfig, final bool, durations *segmentDurations, logger logging.Logger) error {
+	livePath, err := filepath.Abs(config.Live.Path)
+	if err != nil {
+		return err
+	}
+	files, err := getSortedFiles(livePath)
+	if err != nil {
+		return err
+	}
+	if err := removeDanglingSymlinks(config.StoragePath); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	completed := files
+	if !final {
+		completed = files[:len(files)-1]
+		newest := files[len(files)-1].name
+		link := filepath.Join(config.StoragePath, filepath.Base(newest))
+		if err := os.Symlink(newest, link); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+
+	var migrateErr error
+	for _, file := range completed {
+		if final {
+			durations.wait(file.name)
+		} else if durations.pending(file.name) {
+			logger.Debugf("not migrating segment %s, its duration is being set", file.name)
+			continue
+		}
+		if err := migrateSegment(file.name, config.StoragePath); err != nil {
+			logger.Warnf("failed to migrate segment %s: %v", file.name, err)
+			migrateErr = errors.Join(migrateErr, err)
+			continue
+		}
+		logger.Debugf("migrated segment %s", file.name)
+	}
+
+	// Only segments which failed to migrate, and the one being written, are left.
+	budget := int64(config.Live.sizeMB()) * 1024 * 1024
+	size, err := getDirectorySize(livePath)
+	if err != nil {
+		return errors.Join(migrateErr, err)
+	}
+	for _, file := range completed {
+		if size <= budget {
+			break
+		}
+		if durations.pending(file.name) {
+			continue
+		}
+		fileSize, err := getFileSize(file.name)
+		if err != nil {
+			// Migrated.
+			continue
+		}
+		logger.Warnf("live path is over its %dMB budget, deleting segment %s which was not migrated",
+			config.Live.sizeMB(), file.name)
+		if err := os.Remove(file.name); err != nil {
+			return errors.Join(migrateErr, err)
+		}
+		size -= fileSize
+	}
+	return migrateErr
+}
+
+// migrateSegment copies the segment at path into storagePath and then removes it.
+// The segment is copied to a temporary file first so it only appears in storagePath once
+// complete, replacing the symlink to it.
+func migrateSegment(path, storagePath string) error {
+	dst := filepath.Join(storagePath, filepath.Base(path))
+	tmp := dst + tmpExt
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// copyFile copies src to dst and syncs dst to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeDanglingSymlinks removes symlinks in storagePath whose targets no longer exist,
+// e.g. segments in a live path on tmpfs which was cleared by a reboot.
+func removeDanglingSymlinks(storagePath string) error {
+	entries, err := os.ReadDir(storagePath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		path := filepath.Join(storagePath, entry.Name())
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
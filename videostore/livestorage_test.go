@@ -0,0 +1,132 @@
+package videostore
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestMigrateSegments(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	config := validRTPConfig(t)
+	config.Storage.Live.Path = filepath.Join(t.TempDir(), "live")
+	livePath, storagePath := config.Storage.Live.Path, config.Storage.StoragePath
+	test.That(t, os.MkdirAll(storagePath, 0o755), test.ShouldBeNil)
+
+	const framerate = 10
+	frame := solidJPEG(t, color.Black)
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = frame
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := storeTestSegment(t, livePath, start, frames, framerate)
+	second := storeTestSegment(t, livePath, start.Add(time.Minute), frames, framerate)
+	storeInProgressSegment(t, livePath, start.Add(2*time.Minute))
+
+	test.That(t, migrateSegments(config.Storage, false, nil, logger), test.ShouldBeNil)
+
+	t.Run("Completed segments are moved to the storage path", func(t *testing.T) {
+		for _, segment := range []string{first, second} {
+			_, err := os.Stat(segment)
+			test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+			info, err := getVideoInfo(filepath.Join(storagePath, filepath.Base(segment)))
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, info.duration, test.ShouldBeGreaterThan, 0)
+		}
+		files, err := getSortedFiles(livePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+	})
+
+	t.Run("Segment being written is linked into the storage path", func(t *testing.T) {
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 3)
+		newest, err := os.Lstat(files[2].name)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, newest.Mode()&os.ModeSymlink, test.ShouldNotEqual, 0)
+	})
+
+	config.Type = SourceTypeReadOnly
+	config.Storage.Live = LiveStorageConfig{}
+	vs, err := NewReadOnlyVideoStore(config, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer vs.Close()
+	fetch := func(t *testing.T) {
+		res, err := vs.Fetch(context.Background(), &FetchRequest{
+			From: start.Add(time.Minute),
+			To:   start.Add(time.Minute + time.Second),
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(res.Video), test.ShouldBeGreaterThan, 0)
+	}
+
+	t.Run("Fetch reads migrated segments from the storage path", fetch)
+
+	t.Run("Migrated segments survive the live path being cleared", func(t *testing.T) {
+		test.That(t, os.RemoveAll(livePath), test.ShouldBeNil)
+		test.That(t, os.MkdirAll(livePath, 0o755), test.ShouldBeNil)
+		fetch(t)
+
+		// The link to the lost segment is removed on the next migration.
+		liveConfig := config.Storage
+		liveConfig.Live.Path = livePath
+		test.That(t, migrateSegments(liveConfig, false, nil, logger), test.ShouldBeNil)
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 2)
+	})
+
+	t.Run("Final migration moves the newest segment", func(t *testing.T) {
+		liveConfig := config.Storage
+		liveConfig.Live.Path = livePath
+		last := storeTestSegment(t, livePath, start.Add(3*time.Minute), frames, framerate)
+		test.That(t, migrateSegments(liveConfig, true, nil, logger), test.ShouldBeNil)
+		info, err := os.Lstat(filepath.Join(storagePath, filepath.Base(last)))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.Mode().IsRegular(), test.ShouldBeTrue)
+	})
+	t.Run("Segments whose duration is being set are left for the next migration", func(t *testing.T) {
+		liveConfig := config.Storage
+		liveConfig.Live.Path = livePath
+		segment := storeTestSegment(t, livePath, start.Add(4*time.Minute), frames, framerate)
+		storeInProgressSegment(t, livePath, start.Add(5*time.Minute))
+		durations := newSegmentDurations()
+		durations.start(segment)
+		test.That(t, migrateSegments(liveConfig, false, durations, logger), test.ShouldBeNil)
+		_, err := os.Stat(segment)
+		test.That(t, err, test.ShouldBeNil)
+
+		durations.done(segment)
+		test.That(t, migrateSegments(liveConfig, false, durations, logger), test.ShouldBeNil)
+		_, err = os.Stat(segment)
+		test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+	})
+
+	t.Run("Final migration waits for durations being set", func(t *testing.T) {
+		liveConfig := config.Storage
+		liveConfig.Live.Path = livePath
+		segment := storeTestSegment(t, livePath, start.Add(6*time.Minute), frames, framerate)
+		durations := newSegmentDurations()
+		durations.start(segment)
+		var set atomic.Bool
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			set.Store(true)
+			durations.done(segment)
+		}()
+		test.That(t, migrateSegments(liveConfig, true, durations, logger), test.ShouldBeNil)
+		test.That(t, set.Load(), test.ShouldBeTrue)
+		info, err := os.Lstat(filepath.Join(storagePath, filepath.Base(segment)))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.Mode().IsRegular(), test.ShouldBeTrue)
+	})
+}
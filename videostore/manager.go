@@ -0,0 +1,185 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"go.viam.com/rdk/logging"
+)
+
+var (
+	// ErrDuplicateStream is returned when registering a stream with an id which is already registered.
+	ErrDuplicateStream = errors.New("stream already registered")
+	// ErrUnknownStream is returned when looking up a stream id which isn't registered.
+	ErrUnknownStream = errors.New("stream not registered")
+)
+
+// Manager stores video from multiple RTP streams, each identified by a unique id
+// and stored to its own storage path.
+type Manager struct {
+	logger  logging.Logger
+	mu      sync.Mutex
+	streams map[string]*managedStream
+}
+
+type managedStream struct {
+	vs          RTPVideoStore
+	storagePath string
+	config      Config
+	// reconfiguring is set while Reconfigure applies a config storing to reconfigurePath,
+	// which is reserved for the stream meanwhile. deregistered is set if the stream is
+	// deregistered meanwhile, so Reconfigure closes it once done.
+	reconfiguring   bool
+	reconfigurePath string
+	deregistered    bool
+}
+
+// storesTo returns whether the stream stores to storagePath, or is being reconfigured to.
+func (s *managedStream) storesTo(storagePath string) bool {
+	return s.storagePath == storagePath || (s.reconfiguring && s.reconfigurePath == storagePath)
+}
+
+// NewManager returns a Manager with no streams registered.
+func NewManager(logger logging.Logger) *Manager {
+	return &Manager{
+		logger:  logger,
+		streams: map[string]*managedStream{},
+	}
+}
+
+// Register creates an RTP video store for the stream id.
+// Returns ErrDuplicateStream if id is already registered, rather than replacing the
+// existing stream, or if another stream already stores to the same storage path.
+func (m *Manager) Register(id string, config Config) (RTPVideoStore, error) {
+	if id == "" {
+		return nil, errors.New("stream id can't be blank")
+	}
+	storagePath := filepath.Clean(config.Storage.StoragePath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.streams[id]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateStream, id)
+	}
+	for otherID, s := range m.streams {
+		if s.storesTo(storagePath) {
+			return nil, fmt.Errorf("%w: stream %s already stores to %s", ErrDuplicateStream, otherID, storagePath)
+		}
+	}
+	vs, err := NewRTPVideoStore(config, m.logger.Sublogger(id))
+	if err != nil {
+		return nil, err
+	}
+	m.streams[id] = &managedStream{vs: vs, storagePath: storagePath, config: config}
+	return vs, nil
+}
+
+// Reconfigure applies config to the stream id while packets are being written to it,
+// and returns the video store to use from then on. The stream keeps its segmenter, which
+// is reconfigured by RawSegmenter.Reconfigure: the segment being written is finished and
+// the next started with the new settings at the next IDR, so writers don't drop packets.
+// The previous video store's background workers are stopped; it shouldn't be used or
+// closed afterwards. If the segmenter can't be reconfigured the stream is closed and
+// deregistered.
+//
+// The manager isn't locked while the segmenter waits for the IDR, so other streams can
+// be used meanwhile. A stream deregistered meanwhile is closed once reconfigured.
+func (m *Manager) Reconfigure(ctx context.Context, id string, config Config) (RTPVideoStore, error) {
+	storagePath := filepath.Clean(config.Storage.StoragePath)
+
+	m.mu.Lock()
+	s, ok := m.streams[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStream, id)
+	}
+	if s.reconfiguring {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("stream %s is already being reconfigured", id)
+	}
+	for otherID, other := range m.streams {
+		if otherID != id && other.storesTo(storagePath) {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("%w: stream %s already stores to %s", ErrDuplicateStream, otherID, storagePath)
+		}
+	}
+	s.reconfiguring, s.reconfigurePath = true, storagePath
+	m.mu.Unlock()
+
+	vs, err := s.vs.(*videostore).reconfigure(ctx, config, m.logger.Sublogger(id))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.reconfiguring = false
+	if s.deregistered {
+		if vs == nil {
+			vs = s.vs.(*videostore)
+		}
+		vs.Close()
+		return nil, fmt.Errorf("%w: %s was deregistered while reconfiguring", ErrUnknownStream, id)
+	}
+	if err != nil {
+		if vs == nil {
+			return nil, err
+		}
+		delete(m.streams, id)
+		vs.Close()
+		return nil, err
+	}
+	m.streams[id] = &managedStream{vs: vs, storagePath: storagePath, config: config}
+	return vs, nil
+}
+
+// Stream returns the video store registered for id.
+func (m *Manager) Stream(id string) (RTPVideoStore, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStream, id)
+	}
+	return s.vs, nil
+}
+
+// List returns the ids of the registered streams in sorted order.
+func (m *Manager) List() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Sorted(maps.Keys(m.streams))
+}
+
+// Deregister closes the video store registered for id, which stops its segmenter
+// and writes the trailer of the segment being written, then removes it.
+// The id may be registered again afterwards.
+func (m *Manager) Deregister(id string) error {
+	m.mu.Lock()
+	s, ok := m.streams[id]
+	delete(m.streams, id)
+	reconfiguring := ok && s.reconfiguring
+	if reconfiguring {
+		s.deregistered = true
+	}
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownStream, id)
+	}
+	// A stream being reconfigured is closed by Reconfigure once done.
+	if !reconfiguring {
+		s.vs.Close()
+	}
+	return nil
+}
+
+// Close deregisters every stream.
+func (m *Manager) Close() {
+	for _, id := range m.List() {
+		if err := m.Deregister(id); err != nil {
+			m.logger.Warnf("failed to deregister stream %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package videostore
+
+import (
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestManager(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("Duplicate ids are rejected", func(t *testing.T) {
+		m := NewManager(logger)
+		defer m.Close()
+		first, err := m.Register("front", validRTPConfig(t))
+		test.That(t, err, test.ShouldBeNil)
+
+		_, err = m.Register("front", validRTPConfig(t))
+		test.That(t, err, test.ShouldWrap, ErrDuplicateStream)
+		// The first stream is still registered rather than replaced.
+		test.That(t, m.List(), test.ShouldResemble, []string{"front"})
+		vs, err := m.Stream("front")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vs, test.ShouldEqual, first)
+	})
+
+	t.Run("Streams sharing a storage path are rejected", func(t *testing.T) {
+		m := NewManager(logger)
+		defer m.Close()
+		config := validRTPConfig(t)
+		_, err := m.Register("front", config)
+		test.That(t, err, test.ShouldBeNil)
+		_, err = m.Register("back", config)
+		test.That(t, err, test.ShouldWrap, ErrDuplicateStream)
+		test.That(t, m.List(), test.ShouldResemble, []string{"front"})
+	})
+
+	t.Run("Deregister stops the stream's segmenter", func(t *testing.T) {
+		m := NewManager(logger)
+		defer m.Close()
+		_, err := m.Register("back", validRTPConfig(t))
+		test.That(t, err, test.ShouldBeNil)
+		front, err := m.Register("front", validRTPConfig(t))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, m.List(), test.ShouldResemble, []string{"back", "front"})
+		test.That(t, front.Segmenter().Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+
+		test.That(t, m.Deregister("front"), test.ShouldBeNil)
+		test.That(t, m.List(), test.ShouldResemble, []string{"back"})
+		_, err = m.Stream("front")
+		test.That(t, err, test.ShouldWrap, ErrUnknownStream)
+		// The segmenter was closed so it no longer accepts packets.
+		test.That(t, front.Segmenter().WritePacket([]byte{0, 0, 0, 1}, 0, 0, true), test.ShouldNotBeNil)
+
+		test.That(t, m.Deregister("front"), test.ShouldWrap, ErrUnknownStream)
+		// The id can be reused once deregistered.
+		_, err = m.Register("front", validRTPConfig(t))
+		test.That(t, err, test.ShouldBeNil)
+	})
+}
@@ -0,0 +1,186 @@
+package videostore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// manifestExt is appended to an export for its manifest.
+	manifestExt = ".manifest.json"
+	// exportCodec is the codec every export encoder encodes.
+	exportCodec = "h264"
+)
+
+// exportManifest documents where an export came from and how it was made, so its origin
+// can be audited. It is written next to the export.
+type exportManifest struct {
+	Export manifestFile `json:"export"`
+	// Parts are the other files the export is made of, e.g. the segments of an HLS export.
+	Parts     []manifestFile    `json:"parts,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Source    manifestSource    `json:"source"`
+	Request   manifestRequest   `json:"request"`
+	Encoding  manifestEncoding  `json:"encoding"`
+	Segments  []manifestSegment `json:"segments"`
+}
+
+type manifestFile struct {
+	Filename  string `json:"filename"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+type manifestSource struct {
+	// Name is the output file name prefix, which is the name of the camera.
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Hostname string `json:"hostname"`
+}
+
+type manifestRequest struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Metadata string    `json:"metadata,omitempty"`
+	// Filters are the libavfilter filters the changes requested were applied with, in order.
+	Filters []string `json:"filters"`
+}
+
+type manifestEncoding struct {
+	// SourceCodecs are the codecs of the source segments, which were decoded and encoded to Codec.
+	SourceCodecs  []string `json:"source_codecs"`
+	Codec         string   `json:"codec"`
+	Encoder       string   `json:"encoder"`
+	Preset        string   `json:"preset"`
+	Bitrate       int      `json:"bitrate"`
+	HardwareAccel string   `json:"hardware_accel,omitempty"`
+}
+
+// manifestSegment is a source segment and the part of it in the export. Archived segments
+// are listed as the part of their archive.
+type manifestSegment struct {
+	Filename  string    `json:"filename"`
+	StartTime time.Time `json:"start_time"`
+	// Inpoint and Outpoint are the seconds into the segment the part starts and ends at,
+	// the part runs from the start or to the end of the segment without them.
+	Inpoint   *float64 `json:"inpoint,omitempty"`
+	Outpoint  *float64 `json:"outpoint,omitempty"`
+	Codec     string   `json:"codec"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	SizeBytes int64    `json:"size_bytes"`
+	SHA256    string   `json:"sha256"`
+}
+
+// writeExportManifest writes the manifest of the export at path, and the other files in
+// parts it is made of, made from entries.
+func (vs *videostore) writeExportManifest(
+	path string,
+	parts []string,
+	r *ExportRequest,
+	filters []string,
+	entries []concatFileEntry,
+	encoderConfig EncoderConfig,
+	encoder string,
+) error {
+	export, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	var hashedParts []manifestFile
+	for _, part := range parts {
+		file, err := hashFile(part)
+		if err != nil {
+			return err
+		}
+		hashedParts = append(hashedParts, file)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	m := exportManifest{
+		Export:    export,
+		Parts:     hashedParts,
+		CreatedAt: time.Now().UTC(),
+		Source: manifestSource{
+			Name:     vs.config.Storage.OutputFileNamePrefix,
+			Type:     vs.typ.String(),
+			Hostname: hostname,
+		},
+		Request: manifestRequest{
+			From:     r.From,
+			To:       r.To,
+			Metadata: r.Metadata,
+			Filters:  append([]string{}, filters...),
+		},
+		Encoding: manifestEncoding{
+			SourceCodecs:  []string{},
+			Codec:         exportCodec,
+			Encoder:       encoder,
+			Preset:        encoderConfig.Preset,
+			Bitrate:       encoderConfig.Bitrate,
+			HardwareAccel: encoderConfig.HardwareAccel,
+		},
+		Segments: make([]manifestSegment, 0, len(entries)),
+	}
+	// Archives are listed once per segment in them but only hashed once.
+	hashes := map[string]manifestFile{}
+	for _, e := range entries {
+		file, ok := hashes[e.filePath]
+		if !ok {
+			if file, err = hashFile(e.filePath); err != nil {
+				return err
+			}
+			hashes[e.filePath] = file
+		}
+		info, err := getVideoInfo(e.filePath)
+		if err != nil {
+			return err
+		}
+		start, err := extractDateTimeFromFilename(e.filePath)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(m.Encoding.SourceCodecs, info.codec) {
+			m.Encoding.SourceCodecs = append(m.Encoding.SourceCodecs, info.codec)
+		}
+		m.Segments = append(m.Segments, manifestSegment{
+			Filename:  file.Filename,
+			StartTime: start.UTC(),
+			Inpoint:   e.inpoint,
+			Outpoint:  e.outpoint,
+			Codec:     info.codec,
+			Width:     info.width,
+			Height:    info.height,
+			SizeBytes: file.SizeBytes,
+			SHA256:    file.SHA256,
+		})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+manifestExt, b, 0o600)
+}
+
+// hashFile returns the name, size and SHA-256 of the file at path.
+func hashFile(path string) (manifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{Filename: filepath.Base(path), SizeBytes: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
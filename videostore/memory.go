@@ -0,0 +1,256 @@
+package videostore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// defaultMemoryCheckInterval is how often memory usage is checked by default.
+	defaultMemoryCheckInterval = time.Second
+	// cgroupV1Unlimited is the least limit cgroup v1 reports for "no limit", which is the
+	// largest page count in bytes rather than a marker like v2's "max".
+	cgroupV1Unlimited = 1 << 60
+)
+
+// cgroupPaths are where a process's memory cgroup is found: root is where the cgroup
+// filesystem is mounted and procFile lists the cgroups the process is in, under root.
+type cgroupPaths struct {
+	root     string
+	procFile string
+}
+
+var defaultCgroupPaths = cgroupPaths{root: "/sys/fs/cgroup", procFile: "/proc/self/cgroup"}
+
+// MemoryPressurePolicy keeps recording running in a memory limited container by shedding
+// memory as the process nears its cgroup memory limit, preferring degraded recording to
+// being OOM killed. While usage is above Threshold of the limit, pooled packet buffers
+// are freed and not pooled, packets held back by LatencyActionBuffer are flushed, the Go
+// heap is returned to the OS, and with DropPackets every packet but IDRs is dropped.
+// It has no effect outside of a cgroup with a memory limit.
+type MemoryPressurePolicy struct {
+	// Threshold is the fraction of the limit, from 0 to 1, above which memory is under
+	// pressure. 0 disables the policy.
+	Threshold float64
+	// Interval is how often usage is checked. 0 uses 1 second.
+	Interval time.Duration
+	// DropPackets, if set, also drops every packet but IDRs from RTP under pressure, like
+	// LatencyActionShed, so the segment keeps a keyframe per GOP.
+	DropPackets bool
+}
+
+// Validate returns an error if the MemoryPressurePolicy is invalid.
+func (p MemoryPressurePolicy) Validate() error {
+	if p.Threshold < 0 || p.Threshold > 1 {
+		return errors.New("memory pressure threshold must be between 0 and 1")
+	}
+	if p.Interval < 0 {
+		return errors.New("memory pressure interval can't be less than 0")
+	}
+	return nil
+}
+
+func (p MemoryPressurePolicy) interval() time.Duration {
+	if p.Interval == 0 {
+		return defaultMemoryCheckInterval
+	}
+	return p.Interval
+}
+
+// MemoryHeadroom is how much memory the process has left before its cgroup memory limit.
+type MemoryHeadroom struct {
+	// Limit is the memory limit in bytes, 0 if there is none.
+	Limit int64
+	// Usage is the memory used in bytes, without the page cache the kernel reclaims
+	// before it kills anything. It is 0 without a limit.
+	Usage int64
+	// Headroom is the bytes left before the limit.
+	Headroom int64
+	// UnderPressure is set while the MemoryPressurePolicy is shedding memory.
+	UnderPressure bool
+}
+
+// readMemoryHeadroom returns the headroom of the process's cgroup, from the v2 files, or
+// the v1 memory controller's if there are none. The cgroup's directory is the one listed
+// in procFile, or root if it isn't there, as in a container whose cgroup is mounted at
+// root while procFile lists it by its path on the host.
+func (c cgroupPaths) readMemoryHeadroom() (MemoryHeadroom, error) {
+	limitFile, usageFile, statFile, inactiveKey := "memory.max", "memory.current", "memory.stat", "inactive_file"
+	v2, v1 := c.membership()
+	dir, ok := dirWith(limitFile, filepath.Join(c.root, v2), c.root)
+	if !ok {
+		limitFile, usageFile, inactiveKey = "memory.limit_in_bytes", "memory.usage_in_bytes", "total_inactive_file"
+		if dir, ok = dirWith(limitFile, filepath.Join(c.root, "memory", v1), filepath.Join(c.root, "memory")); !ok {
+			return MemoryHeadroom{}, nil
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(dir, limitFile))
+	if err != nil {
+		return MemoryHeadroom{}, fmt.Errorf("failed to read memory limit: %w", err)
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "max" {
+		return MemoryHeadroom{}, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return MemoryHeadroom{}, fmt.Errorf("failed to parse memory limit %q: %w", value, err)
+	}
+	if limit <= 0 || limit >= cgroupV1Unlimited {
+		return MemoryHeadroom{}, nil
+	}
+	b, err = os.ReadFile(filepath.Join(dir, usageFile))
+	if err != nil {
+		return MemoryHeadroom{}, fmt.Errorf("failed to read memory usage: %w", err)
+	}
+	usage, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return MemoryHeadroom{}, fmt.Errorf("failed to parse memory usage: %w", err)
+	}
+	// The stat file is optional, without it the page cache is counted as used.
+	if b, err := os.ReadFile(filepath.Join(dir, statFile)); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			key, v, ok := strings.Cut(scanner.Text(), " ")
+			if !ok || key != inactiveKey {
+				continue
+			}
+			if inactive, err := strconv.ParseInt(v, 10, 64); err == nil {
+				usage = max(usage-inactive, 0)
+			}
+		}
+	}
+	return MemoryHeadroom{Limit: limit, Usage: usage, Headroom: max(limit-usage, 0)}, nil
+}
+
+// membership returns the paths of the process's cgroup v2 and v1 memory controller cgroup
+// relative to root, from procFile's "hierarchy:controllers:path" lines. A cgroup which
+// isn't listed, or is outside of the process's cgroup namespace, is root's.
+func (c cgroupPaths) membership() (v2, v1 string) {
+	b, err := os.ReadFile(c.procFile)
+	if err != nil {
+		return "", ""
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		hierarchy, rest, _ := strings.Cut(line, ":")
+		controllers, path, ok := strings.Cut(rest, ":")
+		path = strings.TrimPrefix(path, "/")
+		if !ok || (path != "" && !filepath.IsLocal(path)) {
+			continue
+		}
+		switch {
+		case hierarchy == "0" && controllers == "":
+			v2 = path
+		case slices.Contains(strings.Split(controllers, ","), "memory"):
+			v1 = path
+		}
+	}
+	return v2, v1
+}
+
+// dirWith returns the first of dirs holding file.
+func dirWith(file string, dirs ...string) (string, bool) {
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// memoryMonitor applies a MemoryPressurePolicy to the memory usage it checks.
+type memoryMonitor struct {
+	policy MemoryPressurePolicy
+	cgroup cgroupPaths
+	logger logging.Logger
+	// shed is called when pressure starts and ends, nil if there is nothing to shed but
+	// the Go heap.
+	shed func(underPressure bool)
+
+	mu            sync.Mutex
+	underPressure bool
+}
+
+func newMemoryMonitor(policy MemoryPressurePolicy, shed func(bool), logger logging.Logger) *memoryMonitor {
+	return &memoryMonitor{policy: policy, cgroup: defaultCgroupPaths, shed: shed, logger: logger}
+}
+
+// headroom returns the process's memory headroom.
+func (m *memoryMonitor) headroom() (MemoryHeadroom, error) {
+	h, err := m.cgroup.readMemoryHeadroom()
+	if err != nil {
+		return MemoryHeadroom{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h.UnderPressure = m.underPressure
+	return h, nil
+}
+
+// check reads the memory usage and starts or stops shedding memory as it crosses the
+// policy's threshold.
+func (m *memoryMonitor) check() (MemoryHeadroom, error) {
+	h, err := m.cgroup.readMemoryHeadroom()
+	if err != nil {
+		return MemoryHeadroom{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	underPressure := h.Limit > 0 && float64(h.Usage) > m.policy.Threshold*float64(h.Limit)
+	if underPressure != m.underPressure {
+		m.underPressure = underPressure
+		if underPressure {
+			m.logger.Warnf("memory usage %d of %d bytes is over %.0f%% of the cgroup limit, shedding buffers",
+				h.Usage, h.Limit, 100*m.policy.Threshold)
+		} else {
+			m.logger.Infof("memory usage recovered to %d of %d bytes", h.Usage, h.Limit)
+		}
+		if m.shed != nil {
+			m.shed(underPressure)
+		}
+		if underPressure {
+			// Memory freed by shedding goes back to the Go heap rather than the OS otherwise.
+			debug.FreeOSMemory()
+		}
+	}
+	h.UnderPressure = underPressure
+	return h, nil
+}
+
+// memoryChecker checks the process's memory every MemoryPressurePolicy interval.
+func (vs *videostore) memoryChecker(ctx context.Context) {
+	ticker := time.NewTicker(vs.config.MemoryPressure.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := vs.memory.check(); err != nil {
+				vs.logger.Error("failed to check memory pressure ", err)
+			}
+		}
+	}
+}
+
+// MemoryHeadroom returns how much memory the process has left before its cgroup memory
+// limit.
+func (vs *videostore) MemoryHeadroom() (MemoryHeadroom, error) {
+	if vs.memory == nil {
+		return defaultCgroupPaths.readMemoryHeadroom()
+	}
+	return vs.memory.headroom()
+}
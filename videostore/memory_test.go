@@ -0,0 +1,160 @@
+package videostore
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestMemoryPressure(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const limit = 1 << 30
+	// fakeCgroup simulates a cgroup v2 limit and usage for a process in the root cgroup.
+	fakeCgroup := func(t *testing.T, max string, current int64) cgroupPaths {
+		root := t.TempDir()
+		test.That(t, os.WriteFile(filepath.Join(root, "memory.max"), []byte(max+"\n"), 0o600), test.ShouldBeNil)
+		test.That(t, os.WriteFile(filepath.Join(root, "memory.current"), []byte(strconv.FormatInt(current, 10)+"\n"), 0o600), test.ShouldBeNil)
+		return cgroupPaths{root: root, procFile: filepath.Join(root, "cgroup.procs.missing")}
+	}
+	const framerate = 10
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	// IDRs are at packets 0, 10 and 20.
+	packets := h264Packets(t, frames, framerate)
+	write := func(t *testing.T, rs *RawSegmenter, packets []testPacket) {
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+	}
+
+	t.Run("Pressure sheds buffers and packets until memory recovers", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Storage.BufferPool = BufferPoolConfig{Buffers: 4}
+		config.MemoryPressure = MemoryPressurePolicy{Threshold: 0.9, Interval: time.Hour, DropPackets: true}
+		vs, err := NewRTPVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		defer vs.Close()
+		store := vs.(*videostore)
+		rs := vs.Segmenter()
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+
+		store.memory.cgroup = fakeCgroup(t, strconv.Itoa(limit), limit/2)
+		h, err := store.memory.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h, test.ShouldResemble, MemoryHeadroom{Limit: limit, Usage: limit / 2, Headroom: limit / 2})
+		write(t, rs, packets[:10])
+		test.That(t, len(rs.bufferPool.free), test.ShouldEqual, 1)
+
+		store.memory.cgroup = fakeCgroup(t, strconv.Itoa(limit), limit*95/100)
+		h, err = store.memory.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h.UnderPressure, test.ShouldBeTrue)
+		test.That(t, len(rs.bufferPool.free), test.ShouldEqual, 0)
+		write(t, rs, packets[10:20])
+		// Buffers aren't pooled and only the IDR is written.
+		test.That(t, len(rs.bufferPool.free), test.ShouldEqual, 0)
+		test.That(t, rs.memoryShed, test.ShouldEqual, 9)
+		h, err = vs.MemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h.UnderPressure, test.ShouldBeTrue)
+		test.That(t, h.Headroom, test.ShouldEqual, limit-limit*95/100)
+
+		store.memory.cgroup = fakeCgroup(t, strconv.Itoa(limit), limit/2)
+		h, err = store.memory.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h.UnderPressure, test.ShouldBeFalse)
+		write(t, rs, packets[20:])
+		test.That(t, len(rs.bufferPool.free), test.ShouldEqual, 1)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, len(packets)-9)
+	})
+
+	t.Run("Packets are kept under pressure without DropPackets", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.MemoryPressure = MemoryPressurePolicy{Threshold: 0.9, Interval: time.Hour}
+		vs, err := NewRTPVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		defer vs.Close()
+		rs := vs.Segmenter()
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		store := vs.(*videostore)
+		store.memory.cgroup = fakeCgroup(t, strconv.Itoa(limit), limit)
+		_, err = store.memory.check()
+		test.That(t, err, test.ShouldBeNil)
+		write(t, rs, packets)
+		test.That(t, rs.memoryShed, test.ShouldEqual, 0)
+	})
+
+	t.Run("Usage is read without the reclaimable page cache", func(t *testing.T) {
+		cgroup := fakeCgroup(t, strconv.Itoa(limit), limit*95/100)
+		stat := "anon 1000\ninactive_file " + strconv.Itoa(limit/4) + "\nactive_file 10\n"
+		test.That(t, os.WriteFile(filepath.Join(cgroup.root, "memory.stat"), []byte(stat), 0o600), test.ShouldBeNil)
+		h, err := cgroup.readMemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h.Usage, test.ShouldEqual, limit*95/100-limit/4)
+	})
+
+	t.Run("There is no headroom to report without a limit", func(t *testing.T) {
+		h, err := fakeCgroup(t, "max", limit).readMemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h, test.ShouldResemble, MemoryHeadroom{})
+
+		// cgroup v1 reports no limit as the largest page count.
+		root := t.TempDir()
+		test.That(t, os.Mkdir(filepath.Join(root, "memory"), 0o755), test.ShouldBeNil)
+		test.That(t, os.WriteFile(filepath.Join(root, "memory", "memory.limit_in_bytes"), []byte("9223372036854771712\n"), 0o600), test.ShouldBeNil)
+		h, err = cgroupPaths{root: root, procFile: filepath.Join(root, "missing")}.readMemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h, test.ShouldResemble, MemoryHeadroom{})
+	})
+
+	t.Run("The process's own cgroup is read", func(t *testing.T) {
+		writeFile := func(t *testing.T, path, content string) {
+			test.That(t, os.MkdirAll(filepath.Dir(path), 0o755), test.ShouldBeNil)
+			test.That(t, os.WriteFile(path, []byte(content), 0o600), test.ShouldBeNil)
+		}
+		// The root cgroup's usage is the whole machine's, which the process's cgroup is under.
+		cgroup := fakeCgroup(t, "max", limit)
+		service := filepath.Join(cgroup.root, "system.slice", "viam.service")
+		writeFile(t, filepath.Join(service, "memory.max"), strconv.Itoa(limit/2)+"\n")
+		writeFile(t, filepath.Join(service, "memory.current"), strconv.Itoa(limit/4)+"\n")
+		cgroup.procFile = filepath.Join(t.TempDir(), "cgroup")
+		writeFile(t, cgroup.procFile, "0::/system.slice/viam.service\n")
+		h, err := cgroup.readMemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h, test.ShouldResemble, MemoryHeadroom{Limit: limit / 2, Usage: limit / 4, Headroom: limit / 4})
+
+		// A container's own cgroup is at the root when the process's is listed by its host path.
+		writeFile(t, cgroup.procFile, "0::/docker/0123abcd\n")
+		h, err = cgroup.readMemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h, test.ShouldResemble, MemoryHeadroom{})
+
+		// cgroup v1 lists the memory controller's hierarchy among the others.
+		v1 := cgroupPaths{root: t.TempDir(), procFile: filepath.Join(t.TempDir(), "cgroup")}
+		docker := filepath.Join(v1.root, "memory", "docker", "0123abcd")
+		writeFile(t, filepath.Join(docker, "memory.limit_in_bytes"), strconv.Itoa(limit)+"\n")
+		writeFile(t, filepath.Join(docker, "memory.usage_in_bytes"), strconv.Itoa(limit/2)+"\n")
+		writeFile(t, filepath.Join(docker, "memory.stat"), "total_inactive_file "+strconv.Itoa(limit/4)+"\n")
+		writeFile(t, v1.procFile, "5:cpu,cpuacct:/docker/0123abcd\n4:memory:/docker/0123abcd\n0::/\n")
+		h, err = v1.readMemoryHeadroom()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h, test.ShouldResemble, MemoryHeadroom{Limit: limit, Usage: limit / 4, Headroom: limit * 3 / 4})
+	})
+
+	t.Run("Invalid thresholds are rejected", func(t *testing.T) {
+		test.That(t, MemoryPressurePolicy{Threshold: 1.5}.Validate(), test.ShouldNotBeNil)
+		test.That(t, MemoryPressurePolicy{Interval: -time.Second}.Validate(), test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,237 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+// codecRun is the part of a range in consecutive segments recorded in the same codec.
+type codecRun struct {
+	codec string
+	// from is when the footage of the run starts.
+	from    time.Time
+	entries []concatFileEntry
+}
+
+// concatOutput is a file a range was concatenated into.
+type concatOutput struct {
+	path  string
+	from  time.Time
+	codec CodecType
+}
+
+// StoredRange is a span of stored video in one codec with no gaps between its segments.
+type StoredRange struct {
+	From  time.Time
+	To    time.Time
+	Codec CodecType
+	// Segments are the segments of the range, oldest first.
+	Segments []StoredSegment
+}
+
+// StoredSegment is a segment of stored video. Archived segments are the part of their
+// archive they span.
+type StoredSegment struct {
+	Path  string
+	From  time.Time
+	To    time.Time
+	Codec CodecType
+}
+
+// storedRangeGap is the largest gap between the end of a segment and the start of the next
+// which is still in the same StoredRange, as segments are named to the second.
+const storedRangeGap = time.Second
+
+// codecTypeOf returns the CodecType of a videoInfo codec, which is named by libavcodec.
+func codecTypeOf(codec string) CodecType {
+	switch codec {
+	case "h264":
+		return CodecTypeH264
+	case "hevc":
+		return CodecTypeH265
+	default:
+		return CodecTypeUnknown
+	}
+}
+
+// codecRuns returns the parts of from to to in storage split at each change of codec.
+func (c *concater) codecRuns(from, to time.Time, path string) ([]codecRun, error) {
+	storageFiles, err := c.sortedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTimeRange(storageFiles, from, to); err != nil {
+		return nil, err
+	}
+	runs := splitByCodec(storageFiles, from, to, c.logger)
+	if len(runs) == 0 {
+		return nil, errors.New("no matching video data to save")
+	}
+	return runs, nil
+}
+
+// splitByCodec matches files to the range start to end like matchStorageToRange, split into
+// runs at each change of codec. Runs are matched separately so each is checked for video
+// parameters against its own first segment rather than being skipped for its codec. Each
+// segment is only probed once, for both.
+func splitByCodec(files []fileWithDate, start, end time.Time, logger logging.Logger) []codecRun {
+	infos := videoInfoCache{}
+	// Only the segment containing start and those after it, up to end, can overlap the range.
+	first := 0
+	for i, file := range files {
+		if file.startTime.After(start) {
+			break
+		}
+		first = i
+	}
+	var runs []codecRun
+	var runFiles []fileWithDate
+	var codec string
+	addRun := func() {
+		entries, _ := matchStorageSpans(runFiles, start, end, infos, logger)
+		if len(entries) == 0 {
+			return
+		}
+		from := runFiles[0].startTime
+		if from.Before(start) {
+			from = start
+		}
+		runs = append(runs, codecRun{codec: codec, from: from, entries: entries})
+	}
+	for _, file := range files[first:] {
+		if !file.startTime.Before(end) {
+			break
+		}
+		info, err := infos.get(file.name)
+		if err != nil {
+			logger.Debugf("failed to get video info for file: %s, error: %v", file.name, err)
+			continue
+		}
+		if len(runFiles) > 0 && info.codec != codec {
+			addRun()
+			runFiles = nil
+		}
+		codec = info.codec
+		runFiles = append(runFiles, file)
+	}
+	if len(runFiles) > 0 {
+		addRun()
+	}
+	return runs
+}
+
+// StoredRanges returns the spans of stored video which can be fetched, oldest first, split
+// at each gap and each change of codec. The newest segment is still being written so isn't
+// included, and segments which can't be probed are skipped.
+func (vs *videostore) StoredRanges(_ context.Context) ([]StoredRange, error) {
+	files, err := vs.concater.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		files = files[:len(files)-1]
+	}
+	var ranges []StoredRange
+	// Archived segments share the file of their archive.
+	infos := videoInfoCache{}
+	for _, file := range files {
+		info, err := infos.get(file.name)
+		if err != nil {
+			vs.logger.Debugf("failed to get video info for file: %s, error: %v", file.name, err)
+			continue
+		}
+		if file.duration > 0 {
+			info.duration = file.duration
+		}
+		segment := StoredSegment{
+			Path:  file.name,
+			From:  file.startTime,
+			To:    file.startTime.Add(info.duration),
+			Codec: codecTypeOf(info.codec),
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].Codec == segment.Codec && !segment.From.After(ranges[n-1].To.Add(storedRangeGap)) {
+			ranges[n-1].To = maxTime(ranges[n-1].To, segment.To)
+			ranges[n-1].Segments = append(ranges[n-1].Segments, segment)
+			continue
+		}
+		ranges = append(ranges, StoredRange{From: segment.From, To: segment.To, Codec: segment.Codec, Segments: []StoredSegment{segment}})
+	}
+	return ranges, nil
+}
+
+// concatRuns concatenates the video between from and to into path, handling a range which
+// spans a change of codec by the MixedCodec policy. With MixedCodecSplit, each run after the
+// first is written to nextPath of when it starts. Returns every output written, in order.
+func (vs *videostore) concatRuns(ctx context.Context, from, to time.Time, path string, nextPath func(from time.Time) string) ([]concatOutput, error) {
+	runs, err := vs.concater.codecRuns(from, to, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) > 1 {
+		vs.logger.Infof("range from %s to %s spans %d codec changes", from, to, len(runs)-1)
+		if vs.config.MixedCodec == MixedCodecTranscode {
+			if err := vs.transcodeRuns(ctx, runs, path); err != nil {
+				return nil, err
+			}
+			return []concatOutput{{path: path, from: runs[0].from, codec: CodecTypeH264}}, nil
+		}
+	}
+	var outputs []concatOutput
+	for i, run := range runs {
+		runPath := path
+		if i > 0 {
+			runPath = nextPath(run.from)
+		}
+		if err := vs.concatEntries(run.entries, runPath); err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, concatOutput{path: runPath, from: run.from, codec: codecTypeOf(run.codec)})
+	}
+	return outputs, nil
+}
+
+// transcodeRuns re-encodes each run to H.264 and concatenates them into path. Every run is
+// re-encoded, including those already in H.264, so all parts share encoder parameters and
+// can be joined without re-encoding again. The transcode stops once ctx is done.
+func (vs *videostore) transcodeRuns(ctx context.Context, runs []codecRun, path string) error {
+	dir, err := os.MkdirTemp("", "video_store_transcode_*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			vs.logger.Warnf("failed to remove transcoded runs %s: %v", dir, err)
+		}
+	}()
+	parts := make([]concatFileEntry, 0, len(runs))
+	for i, run := range runs {
+		concatFilePath := generateConcatFilePath()
+		err := writeConcatFileEntries(run.entries, concatFilePath)
+		if err == nil {
+			part := filepath.Join(dir, fmt.Sprintf("%d.mp4", i))
+			_, err = export(ctx, concatFilePath, part, "", vs.exportEncoderConfig(), nil, noPoster, nil, nil, nil)
+			parts = append(parts, concatFileEntry{filePath: part})
+		}
+		vs.concater.removeConcatFile(concatFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to transcode %s video from %s: %w", run.codec, run.from, err)
+		}
+	}
+	return vs.concatEntries(parts, path)
+}
+
+// concatEntries concatenates the concat file entries into path.
+func (vs *videostore) concatEntries(entries []concatFileEntry, path string) error {
+	concatFilePath := generateConcatFilePath()
+	defer vs.concater.removeConcatFile(concatFilePath)
+	if err := writeConcatFileEntries(entries, concatFilePath); err != nil {
+		return err
+	}
+	return concat(concatFilePath, path)
+}
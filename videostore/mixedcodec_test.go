@@ -0,0 +1,115 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestMixedCodec(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	// The source switched from H.264 to H.265 at 16:15:10.
+	newStore := func(t *testing.T, policy MixedCodecPolicy) (VideoStore, Config) {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeReadOnly
+		config.MixedCodec = policy
+		test.That(t, os.MkdirAll(config.Storage.StoragePath, 0o755), test.ShouldBeNil)
+		for _, name := range []string{"2025-03-11_16-14-40.mp4", "2025-03-11_16-15-10.mp4"} {
+			test.That(t, copyFile(artifactStoragePath+name, filepath.Join(config.Storage.StoragePath, name)), test.ShouldBeNil)
+		}
+		inProgress, err := ParseDateTimeString("2025-03-11_16-20-00")
+		test.That(t, err, test.ShouldBeNil)
+		storeInProgressSegment(t, config.Storage.StoragePath, inProgress)
+		vs, err := NewReadOnlyVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		t.Cleanup(vs.Close)
+		return vs, config
+	}
+	from, err := ParseDateTimeString("2025-03-11_16-15-05")
+	test.That(t, err, test.ShouldBeNil)
+	transition := from.Add(5 * time.Second)
+	to := from.Add(10 * time.Second)
+
+	t.Run("Fetch is split into a clip per codec", func(t *testing.T) {
+		vs, _ := newStore(t, MixedCodecSplit)
+		res, err := vs.Fetch(context.Background(), &FetchRequest{From: from, To: to})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(res.Clips), test.ShouldEqual, 2)
+		test.That(t, res.Video, test.ShouldResemble, res.Clips[0].Video)
+		test.That(t, res.Codec, test.ShouldEqual, CodecTypeH264)
+		test.That(t, res.Clips[0].From.Equal(from), test.ShouldBeTrue)
+		test.That(t, res.Clips[1].Codec, test.ShouldEqual, CodecTypeH265)
+		test.That(t, res.Clips[1].From.Equal(transition), test.ShouldBeTrue)
+		for _, clip := range res.Clips {
+			path := filepath.Join(t.TempDir(), "clip.mp4")
+			test.That(t, os.WriteFile(path, clip.Video, 0o600), test.ShouldBeNil)
+			info, err := getVideoInfo(path)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, codecTypeOf(info.codec), test.ShouldEqual, clip.Codec)
+		}
+
+		// The split clips can't be written to one writer.
+		err = vs.FetchClipTo(context.Background(), &FetchRequest{From: from, To: to}, &bytes.Buffer{})
+		test.That(t, err, test.ShouldBeError, ErrMixedCodec)
+	})
+
+	t.Run("Save writes a file per codec", func(t *testing.T) {
+		vs, config := newStore(t, MixedCodecSplit)
+		res, err := vs.Save(context.Background(), &SaveRequest{From: from, To: to})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(res.Clips), test.ShouldEqual, 2)
+		test.That(t, res.Clips[0].Filename, test.ShouldEqual, res.Filename)
+		for i, codec := range []CodecType{CodecTypeH264, CodecTypeH265} {
+			info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Clips[i].Filename))
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, codecTypeOf(info.codec), test.ShouldEqual, codec)
+		}
+	})
+
+	t.Run("Transcoding fetches one H.264 clip", func(t *testing.T) {
+		vs, _ := newStore(t, MixedCodecTranscode)
+		var buf bytes.Buffer
+		test.That(t, vs.FetchClipTo(context.Background(), &FetchRequest{From: from, To: to}, &buf), test.ShouldBeNil)
+		path := filepath.Join(t.TempDir(), "clip.mp4")
+		test.That(t, os.WriteFile(path, buf.Bytes(), 0o600), test.ShouldBeNil)
+		info, err := getVideoInfo(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, codecTypeOf(info.codec), test.ShouldEqual, CodecTypeH264)
+		test.That(t, info.duration.Seconds(), test.ShouldAlmostEqual, 10, 1)
+		// Frames from both sides of the transition decode.
+		for _, offset := range []time.Duration{2 * time.Second, 8 * time.Second} {
+			_, err := grayFrameAt(path, offset, pHashSize, pHashSize)
+			test.That(t, err, test.ShouldBeNil)
+		}
+	})
+
+	t.Run("A range in one codec isn't split", func(t *testing.T) {
+		vs, _ := newStore(t, MixedCodecSplit)
+		res, err := vs.Fetch(context.Background(), &FetchRequest{From: from, To: transition})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res.Clips, test.ShouldBeNil)
+		test.That(t, res.Codec, test.ShouldEqual, CodecTypeH264)
+	})
+	t.Run("Stored ranges are split at the change of codec", func(t *testing.T) {
+		vs, config := newStore(t, MixedCodecSplit)
+		ranges, err := vs.StoredRanges(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		// The segment in progress isn't listed.
+		test.That(t, len(ranges), test.ShouldEqual, 2)
+		for i, codec := range []CodecType{CodecTypeH264, CodecTypeH265} {
+			test.That(t, ranges[i].Codec, test.ShouldEqual, codec)
+			test.That(t, len(ranges[i].Segments), test.ShouldEqual, 1)
+			test.That(t, ranges[i].Segments[0].Codec, test.ShouldEqual, codec)
+			test.That(t, ranges[i].From.Equal(ranges[i].Segments[0].From), test.ShouldBeTrue)
+			test.That(t, ranges[i].To.Equal(ranges[i].Segments[0].To), test.ShouldBeTrue)
+		}
+		test.That(t, ranges[0].Segments[0].Path, test.ShouldEqual, filepath.Join(config.Storage.StoragePath, "2025-03-11_16-14-40.mp4"))
+		test.That(t, ranges[1].From.Equal(transition), test.ShouldBeTrue)
+	})
+}
@@ -0,0 +1,34 @@
+package videostore
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime/cgo"
+	"syscall"
+)
+
+// segmentOpenFunc is called with the path of each segment file before it is opened. An
+// error fails the open with its syscall.Errno, or EIO if it doesn't have one.
+type segmentOpenFunc func(path string) error
+
+// videoStoreOpenHook calls the segmentOpenFunc held by hook with url and returns 0, or
+// the AVERROR of the error it returns.
+//
+//export videoStoreOpenHook
+func videoStoreOpenHook(hook C.uintptr_t, url *C.char) C.int {
+	open, ok := cgo.Handle(hook).Value().(segmentOpenFunc)
+	if !ok {
+		return 0
+	}
+	err := open(C.GoString(url))
+	if err == nil {
+		return 0
+	}
+	errno := syscall.EIO
+	errors.As(err, &errno)
+	return -C.int(errno)
+}
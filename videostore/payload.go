@@ -0,0 +1,63 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errNoPayloadReleaser = errors.New("payloads can't be borrowed without a payload releaser, see SetPayloadReleaser")
+
+// PayloadOwnershipPolicy is who owns the payloads passed to RawSegmenter.WritePacket, and
+// so when the caller may reuse their buffers.
+type PayloadOwnershipPolicy int
+
+const (
+	// PayloadCopy copies each payload before WritePacket returns, so the caller may reuse
+	// or mutate its buffer as soon as WritePacket returns, whatever it returns.
+	PayloadCopy PayloadOwnershipPolicy = iota
+	// PayloadBorrow reads each payload in place, saving a copy of every packet. The
+	// segmenter borrows the payload's buffer until it passes the payload to the function
+	// set by RawSegmenter.SetPayloadReleaser, and the caller mustn't mutate or reuse the
+	// buffer until then. Every payload is released exactly once, once it is written,
+	// dropped or fails to be written, which is before WritePacket returns unless its
+	// access unit is held back to be coalesced with DuplicatePTSCoalesce. A held back
+	// payload is released once the next access unit starts, or on Close or a reconfigure.
+	// WritePacket fails without borrowing the payload if no releaser is set or the storage
+	// is read-only.
+	PayloadBorrow
+)
+
+// Validate returns an error if the PayloadOwnershipPolicy is invalid.
+func (p PayloadOwnershipPolicy) Validate() error {
+	if p != PayloadCopy && p != PayloadBorrow {
+		return fmt.Errorf("invalid payload ownership policy %d", p)
+	}
+	return nil
+}
+
+// SetPayloadReleaser sets the function payloads borrowed under PayloadBorrow are
+// released to, after which the caller may reuse their buffers. It is called with the
+// segmenter's lock held, so it mustn't call the segmenter, and shouldn't block.
+func (rs *RawSegmenter) SetPayloadReleaser(release func(payload []byte)) {
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	rs.releasePayload = release
+}
+
+// writePending writes the access unit held back to be coalesced, releasing the payload
+// it borrowed if it did.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) writePending(p *pendingPacket) error {
+	err := rs.writePacket(p.payload, p.pts, p.dts, p.isIDR)
+	p.release(rs)
+	return err
+}
+
+// release releases the payload p borrowed, if any.
+// cRawSegMu must be held.
+func (p *pendingPacket) release(rs *RawSegmenter) {
+	if p.borrowed != nil && rs.releasePayload != nil {
+		rs.releasePayload(p.borrowed)
+		p.borrowed = nil
+	}
+}
@@ -0,0 +1,104 @@
+package videostore
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestPayloadOwnership(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	packets := h264Packets(t, frames, framerate)
+	newSegmenter := func(t *testing.T, storagePath string, policy PayloadOwnershipPolicy) *RawSegmenter {
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{duplicatePTS: DuplicatePTSCoalesce, payloadOwnership: policy}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		return rs
+	}
+	// write writes each access unit as two packets with the same pts, so the first is held
+	// back to be coalesced with the second, passing each packet in the buffer returned by
+	// buffer.
+	write := func(t *testing.T, rs *RawSegmenter, buffer func(n int) []byte, written func(b []byte)) {
+		for _, p := range packets {
+			half := len(p.payload) / 2
+			for _, part := range [][]byte{p.payload[:half], p.payload[half:]} {
+				b := buffer(len(part))
+				copy(b, part)
+				test.That(t, rs.WritePacket(b, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+				written(b)
+			}
+		}
+	}
+	// segmentFrames returns the frames of the only segment in storagePath, decoded as gray.
+	segmentFrames := func(t *testing.T, storagePath string) [][]byte {
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, len(frames))
+		var decoded [][]byte
+		for i := range frames {
+			gray, err := grayFrameAt(files[0].name, time.Duration(i)*time.Second/framerate, pHashSize, pHashSize)
+			test.That(t, err, test.ShouldBeNil)
+			decoded = append(decoded, gray)
+		}
+		return decoded
+	}
+	scribble := func(b []byte) {
+		for i := range b {
+			b[i] = 0xff
+		}
+	}
+
+	reference := t.TempDir()
+	rs := newSegmenter(t, reference, PayloadCopy)
+	write(t, rs, func(n int) []byte { return make([]byte, n) }, func([]byte) {})
+	test.That(t, rs.Close(), test.ShouldBeNil)
+	want := segmentFrames(t, reference)
+
+	t.Run("Copied payloads can be reused as soon as WritePacket returns", func(t *testing.T) {
+		storagePath := t.TempDir()
+		rs := newSegmenter(t, storagePath, PayloadCopy)
+		// Every packet is written from one buffer, overwritten right after each write.
+		buf := make([]byte, 1<<20)
+		write(t, rs, func(n int) []byte { return buf[:n] }, scribble)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		test.That(t, segmentFrames(t, storagePath), test.ShouldResemble, want)
+	})
+
+	t.Run("Borrowed payloads are released exactly once", func(t *testing.T) {
+		storagePath := t.TempDir()
+		rs := newSegmenter(t, storagePath, PayloadBorrow)
+		test.That(t, rs.WritePacket(packets[0].payload, 0, 0, true), test.ShouldBeError, errNoPayloadReleaser)
+
+		var borrowed [][]byte
+		released := map[*byte]int{}
+		rs.SetPayloadReleaser(func(b []byte) {
+			released[&b[0]]++
+			// The caller reuses a buffer as soon as it is released.
+			scribble(b)
+		})
+		write(t, rs, func(n int) []byte { return make([]byte, n) }, func(b []byte) {
+			borrowed = append(borrowed, b)
+		})
+		// The last access unit is still held back, but as a copy made when its second packet
+		// was coalesced with its first, so every payload has been released.
+		test.That(t, len(released), test.ShouldEqual, len(borrowed))
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		for _, b := range borrowed {
+			test.That(t, released[&b[0]], test.ShouldEqual, 1)
+		}
+		test.That(t, segmentFrames(t, storagePath), test.ShouldResemble, want)
+	})
+
+	t.Run("Invalid payload ownership policies are rejected", func(t *testing.T) {
+		test.That(t, PayloadOwnershipPolicy(-1).Validate(), test.ShouldNotBeNil)
+		test.That(t, (PayloadBorrow + 1).Validate(), test.ShouldNotBeNil)
+	})
+}
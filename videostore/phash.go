@@ -0,0 +1,263 @@
+package videostore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// pHashSize is the width and height of the grayscale frame the DCT is taken over.
+	pHashSize = 32
+	// pHashLowFreqSize is the width and height of the low frequency DCT block kept in the hash.
+	pHashLowFreqSize = 8
+	hasherInterval   = 1 // minutes
+	sidecarExt       = ".json"
+	// DefaultMaxDuplicateDistance is the largest hamming distance between the
+	// hashes of two segments that are considered duplicates.
+	DefaultMaxDuplicateDistance = 10
+)
+
+// segmentSidecar holds metadata computed from a segment after it is written.
+// It is stored next to the segment as <segment>.json.
+type segmentSidecar struct {
+	// PHash is the hex encoded perceptual hash of the segment's middle frame.
+	PHash string `json:"phash"`
+}
+
+// DuplicateSegments is a set of segments whose perceptual hashes are all within
+// a hamming distance of some other segment in the set.
+type DuplicateSegments struct {
+	// Segments are the paths of the segments, oldest first.
+	Segments []string
+	// Bytes is the combined size of the segments.
+	Bytes int64
+}
+
+func sidecarPath(segmentPath string) string {
+	return segmentPath + sidecarExt
+}
+
+func readSidecar(segmentPath string) (segmentSidecar, error) {
+	var sc segmentSidecar
+	b, err := os.ReadFile(sidecarPath(segmentPath))
+	if err != nil {
+		return sc, err
+	}
+	err = json.Unmarshal(b, &sc)
+	return sc, err
+}
+
+// writeSidecar writes sc via a temp file so readers never see a partial sidecar.
+func writeSidecar(segmentPath string, sc segmentSidecar) error {
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	tmp := sidecarPath(segmentPath) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sidecarPath(segmentPath))
+}
+
+// removeSidecar removes the sidecar of a deleted segment if it has one.
+func removeSidecar(segmentPath string) error {
+	err := os.Remove(sidecarPath(segmentPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// pHash returns the DCT perceptual hash of a pHashSize x pHashSize grayscale image.
+// Each bit is set when the matching low frequency DCT coefficient is above the median.
+func pHash(gray []byte) uint64 {
+	const n = pHashSize
+	// cos[u][x] = cos((2x+1)uπ/2n)
+	var cos [n][n]float64
+	for u := range n {
+		for x := range n {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	// Separable 2D DCT-II. Only the low frequency rows and columns are needed.
+	var rows [n][pHashLowFreqSize]float64
+	for y := range n {
+		for u := range pHashLowFreqSize {
+			var sum float64
+			for x := range n {
+				sum += float64(gray[y*n+x]) * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coeffs := make([]float64, 0, pHashLowFreqSize*pHashLowFreqSize)
+	for v := range pHashLowFreqSize {
+		for u := range pHashLowFreqSize {
+			var sum float64
+			for y := range n {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+	// The DC coefficient is the average brightness, leave it out of the median
+	// so the hash is about structure rather than exposure.
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// segmentPHash computes the perceptual hash of the frame in the middle of the segment at path.
+func segmentPHash(path string) (uint64, error) {
+	info, err := getVideoInfo(path)
+	if err != nil {
+		return 0, err
+	}
+	gray, err := grayFrameAt(path, info.duration/2, pHashSize, pHashSize)
+	if err != nil {
+		return 0, err
+	}
+	return pHash(gray), nil
+}
+
+// hasher is a go routine that computes the perceptual hash of each completed
+// segment and stores it in the segment's sidecar. Runs on interval so hashing
+// stays off the write path.
+func (vs *videostore) hasher(ctx context.Context) {
+	ticker := time.NewTicker(hasherInterval * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := hashSegments(ctx, vs.config.Storage.StoragePath, vs.logger); err != nil {
+				vs.logger.Error("failed to hash segments", err)
+			}
+		}
+	}
+}
+
+// hashSegments writes a sidecar for every segment in storagePath which doesn't have one.
+// The newest segment is skipped as it may still be being written.
+func hashSegments(ctx context.Context, storagePath string, logger logging.Logger) error {
+	files, err := getSortedFiles(storagePath)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	for _, file := range files[:len(files)-1] {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := os.Stat(sidecarPath(file.name)); err == nil {
+			continue
+		}
+		hash, err := segmentPHash(file.name)
+		if err != nil {
+			logger.Warnf("failed to hash segment %s: %v", file.name, err)
+			continue
+		}
+		if err := writeSidecar(file.name, segmentSidecar{PHash: strconv.FormatUint(hash, 16)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindDuplicateSegments groups stored segments whose perceptual hashes are within
+// maxDistance of each other. Segments which have not been hashed yet are ignored.
+func (vs *videostore) FindDuplicateSegments(_ context.Context, maxDistance int) ([]DuplicateSegments, error) {
+	if maxDistance < 0 || maxDistance > 64 {
+		return nil, fmt.Errorf("max distance must be between 0 and 64, got %d", maxDistance)
+	}
+	files, err := getSortedFiles(vs.config.Storage.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		paths  []string
+		hashes []uint64
+	)
+	for _, file := range files {
+		sc, err := readSidecar(file.name)
+		if err != nil {
+			continue
+		}
+		hash, err := strconv.ParseUint(sc.PHash, 16, 64)
+		if err != nil {
+			vs.logger.Warnf("invalid phash in sidecar for %s: %v", file.name, err)
+			continue
+		}
+		paths = append(paths, file.name)
+		hashes = append(hashes, hash)
+	}
+
+	// Union segments within maxDistance of each other.
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if hammingDistance(hashes[i], hashes[j]) <= maxDistance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := map[int]*DuplicateSegments{}
+	var roots []int
+	for i, path := range paths {
+		root := find(i)
+		group, ok := groups[root]
+		if !ok {
+			group = &DuplicateSegments{}
+			groups[root] = group
+			roots = append(roots, root)
+		}
+		group.Segments = append(group.Segments, path)
+		size, err := getFileSize(path)
+		if err == nil {
+			group.Bytes += size
+		}
+	}
+	duplicates := []DuplicateSegments{}
+	for _, root := range roots {
+		if len(groups[root].Segments) > 1 {
+			duplicates = append(duplicates, *groups[root])
+		}
+	}
+	return duplicates, nil
+}
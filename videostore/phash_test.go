@@ -0,0 +1,95 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// patternJPEG returns a 640x480 JPEG of smooth light and dark blobs.
+// Different variants have blobs of different sizes and positions.
+func patternJPEG(t *testing.T, variant int) []byte {
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+	for y := range 480 {
+		for x := range 640 {
+			fx, fy := float64(x), float64(y)
+			v := 128 + 100*math.Sin(fx/90)*math.Cos(fy/70)
+			if variant != 0 {
+				v = 128 + 100*math.Cos(fx/60)*math.Sin(fy/110+1)
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, img, nil), test.ShouldBeNil)
+	return buf.Bytes()
+}
+
+// encodeSegment encodes 2 seconds of frame and returns the path of the segment written.
+func encodeSegment(t *testing.T, frame []byte) string {
+	const framerate = 10
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = frame
+	}
+	return encodeFrames(t, frames, framerate)
+}
+
+func TestSegmentPHash(t *testing.T) {
+	frame := patternJPEG(t, 0)
+	a, err := segmentPHash(encodeSegment(t, frame))
+	test.That(t, err, test.ShouldBeNil)
+	b, err := segmentPHash(encodeSegment(t, frame))
+	test.That(t, err, test.ShouldBeNil)
+	c, err := segmentPHash(encodeSegment(t, patternJPEG(t, 1)))
+	test.That(t, err, test.ShouldBeNil)
+
+	t.Run("Identical content produces close hashes", func(t *testing.T) {
+		test.That(t, hammingDistance(a, b), test.ShouldBeLessThanOrEqualTo, DefaultMaxDuplicateDistance)
+	})
+
+	t.Run("Different content produces distant hashes", func(t *testing.T) {
+		test.That(t, hammingDistance(a, c), test.ShouldBeGreaterThan, DefaultMaxDuplicateDistance)
+	})
+}
+
+func TestFindDuplicateSegments(t *testing.T) {
+	config := validRTPConfig(t)
+	storagePath := config.Storage.StoragePath
+	test.That(t, os.MkdirAll(storagePath, 0o755), test.ShouldBeNil)
+	// Segments are named by start time, the hashes of the first, second and
+	// fourth are within a few bits of each other.
+	hashes := []uint64{0xff00ff00ff00ff00, 0xff00ff00ff00ff01, 0x00ff00ff00ff00ff, 0xff00ff00ff00ff03}
+	var segments []string
+	for i, hash := range hashes {
+		segment := filepath.Join(storagePath, strconv.Itoa(1700000000+30*i)+".mp4")
+		test.That(t, os.WriteFile(segment, []byte("segment"), 0o600), test.ShouldBeNil)
+		test.That(t, writeSidecar(segment, segmentSidecar{PHash: strconv.FormatUint(hash, 16)}), test.ShouldBeNil)
+		segments = append(segments, segment)
+	}
+	// Segments without a sidecar are ignored.
+	unhashed := filepath.Join(storagePath, "1700000120.mp4")
+	test.That(t, os.WriteFile(unhashed, []byte("segment"), 0o600), test.ShouldBeNil)
+
+	vs := &videostore{config: config, logger: logging.NewTestLogger(t)}
+	duplicates, err := vs.FindDuplicateSegments(context.Background(), DefaultMaxDuplicateDistance)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, duplicates, test.ShouldResemble, []DuplicateSegments{{
+		Segments: []string{segments[0], segments[1], segments[3]},
+		Bytes:    3 * int64(len("segment")),
+	}})
+
+	duplicates, err = vs.FindDuplicateSegments(context.Background(), 0)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, duplicates, test.ShouldBeEmpty)
+}
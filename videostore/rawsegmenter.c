@@ -1,21 +1,123 @@
 #include "rawsegmenter.h"
+#include "utils.h"
 #include "libavcodec/packet.h"
+#include "libavutil/avstring.h"
 #include "libavutil/dict.h"
 #include "libavutil/log.h"
 #include "libavutil/mem.h"
+#include "libavutil/time.h"
+#include <inttypes.h>
 #include <libavcodec/avcodec.h>
 #include <stddef.h>
 #include <stdint.h>
 #include <stdio.h>
 #include <string.h>
-int video_store_raw_seg_init(struct raw_seg **ppRS,     // OUT
-                             const int segmentSeconds,  // IN
-                             const char *outputPattern, // IN
-                             const int width,           // IN
-                             const int height,          // IN
-                             const AVCodec *codec       // IN
+#include <unistd.h>
+// raw_seg_io_open opens each segment the segment muxer starts, retrying while
+// file descriptors are exhausted, and tracks its io context so it can be
+// flushed and its path so it can be reported once completed.
+static int raw_seg_io_open(struct AVFormatContext *s, AVIOContext **pb,
+                           const char *url, int flags, AVDictionary **options) {
+  struct raw_seg *rs = (struct raw_seg *)s->opaque;
+  int ret = video_store_io_open_retry(rs->ioOpen, s, pb, url, flags, options,
+                                      rs->openAttempts,
+                                      rs->openBackoffMicroseconds, rs->openHook,
+                                      rs->openHookCtx);
+  if (ret >= 0) {
+    rs->segmentPB = *pb;
+    av_free(rs->segmentURL);
+    rs->segmentURL = av_strdup(url);
+    rs->segmentHasPackets = 0;
+    rs->packetsSinceFlush = 0;
+    rs->lastFlushMicroseconds = av_gettime_relative();
+  }
+  return ret;
+}
+
+static int raw_seg_io_close2(struct AVFormatContext *s, AVIOContext *pb) {
+  struct raw_seg *rs = (struct raw_seg *)s->opaque;
+  if (pb == rs->segmentPB) {
+    rs->segmentPB = NULL;
+    if (!rs->closing && rs->segmentURL != NULL) {
+      // the segment muxer closes a segment once the next has started
+      av_free(rs->completedURL);
+      rs->completedURL = rs->segmentURL;
+      rs->segmentURL = NULL;
+    }
+  }
+  return rs->ioClose2(s, pb);
+}
+
+// raw_seg_maybe_flush flushes the active segment if the flush policy is due.
+// Must be called after each packet is written.
+static void raw_seg_maybe_flush(struct raw_seg *rs, const int isIdr) {
+  if (rs->segmentPB == NULL) {
+    return;
+  }
+  rs->packetsSinceFlush++;
+  int due = 0;
+  if (rs->flushPackets > 0) {
+    due = rs->packetsSinceFlush >= rs->flushPackets;
+  } else if (rs->flushIntervalMicroseconds > 0) {
+    due = av_gettime_relative() - rs->lastFlushMicroseconds >=
+          rs->flushIntervalMicroseconds;
+  } else if (rs->flushOnIdr) {
+    // the mp4 muxer completes the previous GOP's fragment when an IDR arrives
+    due = isIdr;
+  }
+  if (!due || rs->flushSuspended) {
+    return;
+  }
+  avio_flush(rs->segmentPB);
+  rs->packetsSinceFlush = 0;
+  rs->lastFlushMicroseconds = av_gettime_relative();
+}
+
+// raw_seg_name_segment renames the active segment by the wall clock time of
+// its first packet, if it is known and no segment has that name already.
+// Renaming keeps the segment open, so it is still written to.
+static void raw_seg_name_segment(struct raw_seg *rs) {
+  if (rs->startMilliseconds <= 0 || rs->segmentURL == NULL) {
+    return;
+  }
+  const char *slash = strrchr(rs->segmentURL, '/');
+  const int dirLength = slash == NULL ? 0 : (int)(slash - rs->segmentURL + 1);
+  char *url = av_asprintf("%.*s%" PRId64 ".%03" PRId64 ".mp4", dirLength,
+                          rs->segmentURL, rs->startMilliseconds / 1000,
+                          rs->startMilliseconds % 1000);
+  if (url == NULL) {
+    av_log(NULL, AV_LOG_ERROR,
+           "raw_seg_name_segment failed to allocate segment name\n");
+    return;
+  }
+  if (access(url, F_OK) == 0 || rename(rs->segmentURL, url) != 0) {
+    av_log(NULL, AV_LOG_WARNING,
+           "raw_seg_name_segment failed to rename %s to %s, keeping its "
+           "arrival time\n",
+           rs->segmentURL, url);
+    av_free(url);
+    return;
+  }
+  av_free(rs->segmentURL);
+  rs->segmentURL = url;
+}
+
+int video_store_raw_seg_init(struct raw_seg **ppRS,                   // OUT
+                             const int segmentSeconds,                // IN
+                             const char *outputPattern,               // IN
+                             const int width,                         // IN
+                             const int height,                        // IN
+                             const AVCodec *codec,                    // IN
+                             const int flushPackets,                  // IN
+                             const int64_t flushIntervalMicroseconds, // IN
+                             const int flushOnIdr,                    // IN
+                             const int openAttempts,                  // IN
+                             const int64_t openBackoffMicroseconds,   // IN
+                             video_store_open_hook_fn openHook,       // IN
+                             const uintptr_t openHookCtx              // IN
 ) {
-  struct raw_seg *rs = (struct raw_seg *)malloc(sizeof(struct raw_seg));
+  // calloc so the flush state starts zeroed
+  struct raw_seg *rs = (struct raw_seg *)calloc(1, sizeof(struct raw_seg));
   if (rs == NULL) {
     av_log(NULL, AV_LOG_ERROR,
            "video_store_raw_seg_init failed allocate a raw_seg_h264\n");
@@ -99,6 +201,36 @@ int video_store_raw_seg_init(struct raw_seg **ppRS,     // OUT
     goto cleanup;
   }
 
+  rs->flushPackets = flushPackets;
+  rs->flushIntervalMicroseconds = flushIntervalMicroseconds;
+  rs->flushOnIdr = flushOnIdr;
+  if (flushPackets > 0 || flushIntervalMicroseconds > 0 || flushOnIdr) {
+    // A plain mp4 is unreadable until its moov is written on close, so a
+    // flushed segment is fragmented to make everything flushed playable.
+    const char *movflags =
+        "movflags=+empty_moov+default_base_moof+frag_every_frame";
+    if (flushOnIdr) {
+      movflags = "movflags=+empty_moov+default_base_moof+frag_keyframe";
+    }
+    ret = av_dict_set(&opts, "segment_format_options", movflags, 0);
+    if (ret < 0) {
+      av_log(NULL, AV_LOG_ERROR,
+             "video_store_raw_seg_init failed to set segment_format_options\n");
+      goto cleanup;
+    }
+  }
+  // The segment muxer opens each segment with io_open, wrap it to retry
+  // opening and get at the segment's io context.
+  rs->openAttempts = openAttempts;
+  rs->openBackoffMicroseconds = openBackoffMicroseconds;
+  rs->openHook = openHook;
+  rs->openHookCtx = openHookCtx;
+  fmtCtx->opaque = rs;
+  rs->ioOpen = fmtCtx->io_open;
+  rs->ioClose2 = fmtCtx->io_close2;
+  fmtCtx->io_open = raw_seg_io_open;
+  fmtCtx->io_close2 = raw_seg_io_close2;
+
   /* // Open the output file for writing */
   ret = avformat_write_header(fmtCtx, &opts);
   if (ret < 0) {
@@ -113,6 +245,7 @@ int video_store_raw_seg_init(struct raw_seg **ppRS,     // OUT
 
 cleanup:
   if (ret != VIDEO_STORE_RAW_SEG_RESP_OK) {
+    av_free(rs->segmentURL);
     free(rs);
     if (fmtCtx != NULL) {
       avformat_free_context(fmtCtx);
@@ -128,11 +261,18 @@ cleanup:
   return ret;
 }
 
-int video_store_raw_seg_init_h264(struct raw_seg **ppRS,     // OUT
-                                  const int segmentSeconds,  // IN
-                                  const char *outputPattern, // IN
-                                  const int width,           // IN
-                                  const int height           // IN
+int video_store_raw_seg_init_h264(struct raw_seg **ppRS,                   // OUT
+                                  const int segmentSeconds,                // IN
+                                  const char *outputPattern,               // IN
+                                  const int width,                         // IN
+                                  const int height,                        // IN
+                                  const int flushPackets,                  // IN
+                                  const int64_t flushIntervalMicroseconds, // IN
+                                  const int flushOnIdr,                    // IN
+                                  const int openAttempts,                  // IN
+                                  const int64_t openBackoffMicroseconds,   // IN
+                                  video_store_open_hook_fn openHook,       // IN
+                                  const uintptr_t openHookCtx              // IN
 ) {
   const struct AVCodec *codec = avcodec_find_decoder(AV_CODEC_ID_H264);
   if (codec == NULL) {
@@ -141,14 +281,24 @@ int video_store_raw_seg_init_h264(struct raw_seg **ppRS,     // OUT
     return VIDEO_STORE_RAW_SEG_RESP_ERROR;
   }
   return video_store_raw_seg_init(ppRS, segmentSeconds, outputPattern, width,
-                                  height, codec);
+                                  height, codec, flushPackets,
+                                  flushIntervalMicroseconds, flushOnIdr,
+                                  openAttempts, openBackoffMicroseconds,
+                                  openHook, openHookCtx);
 }
 
-int video_store_raw_seg_init_h265(struct raw_seg **ppRS,     // OUT
-                                  const int segmentSeconds,  // IN
-                                  const char *outputPattern, // IN
-                                  const int width,           // IN
-                                  const int height           // IN
+int video_store_raw_seg_init_h265(struct raw_seg **ppRS,                   // OUT
+                                  const int segmentSeconds,                // IN
+                                  const char *outputPattern,               // IN
+                                  const int width,                         // IN
+                                  const int height,                        // IN
+                                  const int flushPackets,                  // IN
+                                  const int64_t flushIntervalMicroseconds, // IN
+                                  const int flushOnIdr,                    // IN
+                                  const int openAttempts,                  // IN
+                                  const int64_t openBackoffMicroseconds,   // IN
+                                  video_store_open_hook_fn openHook,       // IN
+                                  const uintptr_t openHookCtx              // IN
 ) {
   const struct AVCodec *codec = avcodec_find_decoder(AV_CODEC_ID_H265);
   if (codec == NULL) {
@@ -157,7 +307,10 @@ int video_store_raw_seg_init_h265(struct raw_seg **ppRS,     // OUT
     return VIDEO_STORE_RAW_SEG_RESP_ERROR;
   }
   return video_store_raw_seg_init(ppRS, segmentSeconds, outputPattern, width,
-                                  height, codec);
+                                  height, codec, flushPackets,
+                                  flushIntervalMicroseconds, flushOnIdr,
+                                  openAttempts, openBackoffMicroseconds,
+                                  openHook, openHookCtx);
 }
 
 int video_store_raw_seg_write_packet(struct raw_seg *rs,       // IN
@@ -229,9 +382,16 @@ int video_store_raw_seg_write_packet(struct raw_seg *rs,       // IN
            "video_store_raw_seg_write_packet failed to write frame\n");
     goto cleanup;
   }
+  // the segment muxer opens a segment before writing the packet which starts it
+  if (!rs->segmentHasPackets) {
+    raw_seg_name_segment(rs);
+    rs->segmentHasPackets = 1;
+  }
+  raw_seg_maybe_flush(rs, isIdr);
 
   ret = VIDEO_STORE_RAW_SEG_RESP_OK;
 cleanup:
+  rs->startMilliseconds = 0;
   if (pkt != NULL) {
     av_packet_unref(pkt);
     av_packet_free(&pkt);
@@ -239,6 +399,30 @@ cleanup:
   return ret;
 }
 
+void video_store_raw_seg_suspend_flush(struct raw_seg *rs, // IN
+                                       const int suspended // IN
+) {
+  rs->flushSuspended = suspended;
+}
+
+void video_store_raw_seg_set_start_time(struct raw_seg *rs,               // IN
+                                        const int64_t startMilliseconds // IN
+) {
+  rs->startMilliseconds = startMilliseconds;
+}
+
+int video_store_raw_seg_take_completed(struct raw_seg *rs,   // IN
+                                       char *path,           // OUT
+                                       const size_t pathSize // IN
+) {
+  if (rs->completedURL == NULL) {
+    return 0;
+  }
+  av_strlcpy(path, rs->completedURL, pathSize);
+  av_freep(&rs->completedURL);
+  return 1;
+}
+
 int video_store_raw_seg_close(struct raw_seg **ppRS // OUT
 ) {
   if (ppRS == NULL) {
@@ -252,14 +436,20 @@ int video_store_raw_seg_close(struct raw_seg **ppRS // OUT
            "video_store_raw_seg_close called with null raw_seg_h264 *ppRS\n");
     return VIDEO_STORE_RAW_SEG_RESP_ERROR;
   }
+  (*ppRS)->closing = 1;
   int ret = av_write_trailer((*ppRS)->outCtx);
   if (ret < 0) {
     av_log(NULL, AV_LOG_ERROR,
            "video_store_raw_seg_close called failed to write trailer\n");
-    return ret;
+  } else {
+    ret = VIDEO_STORE_RAW_SEG_RESP_OK;
   }
+  // Free the segmenter even if the trailer couldn't be written so the segment
+  // file isn't left open.
   avformat_free_context((*ppRS)->outCtx);
+  av_free((*ppRS)->segmentURL);
+  av_free((*ppRS)->completedURL);
   free(*ppRS);
   *ppRS = NULL;
-  return VIDEO_STORE_RAW_SEG_RESP_OK;
+  return ret;
 }
@@ -3,13 +3,23 @@ package videostore
 /*
 #include "rawsegmenter.h"
 #include <stdlib.h>
+
+extern int videoStoreOpenHook(uintptr_t hook, char *url);
+
+// video_store_go_open_hook calls the segmentOpenFunc held by hook.
+static int video_store_go_open_hook(uintptr_t hook, const char *url) {
+  return videoStoreOpenHook(hook, (char *)url);
+}
 */
 import "C"
 
 import (
 	"errors"
 	"fmt"
+	"runtime/cgo"
+	"slices"
 	"sync"
+	"time"
 	"unsafe"
 
 	"go.viam.com/rdk/logging"
@@ -20,8 +30,82 @@ type RawSegmenter struct {
 	logger         logging.Logger
 	storagePath    string
 	segmentSeconds int
-	cRawSegMu      sync.Mutex
-	cRawSeg        *C.raw_seg
+	flush          FlushPolicy
+	smoothing      TimestampSmoothingConfig
+	duplicatePTS   DuplicatePTSPolicy
+	openRetry      OpenRetryPolicy
+	// open, if set, is called before each segment file is opened, and openHandle holds
+	// it while a segment is being written.
+	open       segmentOpenFunc
+	openHandle cgo.Handle
+	latency    LatencyPolicy
+	// writeLatency, if set, is called inside the timed part of each packet write.
+	writeLatency func()
+	keyframes    KeyframeRequestPolicy
+	rtpHeader    RTPHeaderPolicy
+	// segmentDuration is applied to each segment once it is completed by rolling over, off
+	// the write path. durations tracks the segments being set, which Close waits for, and
+	// settingDurations which they are, so they aren't migrated from the live path mid-write.
+	segmentDuration  SegmentDurationPolicy
+	durations        sync.WaitGroup
+	settingDurations *segmentDurations
+	// segmentTimestamps is how each segment's start time, which it is named by, is found.
+	segmentTimestamps SegmentTimestampPolicy
+	// payloadOwnership is whether payloads passed to WritePacket are copied or borrowed,
+	// and releasePayload releases those borrowed.
+	payloadOwnership PayloadOwnershipPolicy
+	releasePayload   func(payload []byte)
+	// senderReport is the latest RTCP sender report passed to WriteRTCP, nil before the first.
+	senderReport *SenderReport
+	// rtpHeaderStripped is set once RTPHeaderStrip has stripped a header, so it is only
+	// warned about once.
+	rtpHeaderStripped bool
+	// requestKeyframe, if set, asks the source for an IDR. It is called without cRawSegMu held.
+	requestKeyframe func()
+	// readOnly is set when the storage path is on a read-only filesystem, as found by
+	// readOnlyFilesystem. Init and WritePacket return ErrReadOnlyStorage in this case.
+	readOnly           bool
+	readOnlyFilesystem func(path string) bool
+	cRawSegMu          sync.Mutex
+	cRawSeg            *C.raw_seg
+	// smoother is nil when timestamp smoothing is disabled.
+	smoother *timestampSmoother
+	// latencyMonitor is nil when the latency policy is disabled.
+	latencyMonitor *latencyMonitor
+	// keyframeWaiter is nil when the keyframe request policy is disabled.
+	keyframeWaiter *keyframeWaiter
+	// bufferPool is nil when buffer pooling is disabled.
+	bufferPool *cBufferPool
+	// memoryPressure is set while the MemoryPressurePolicy sheds memory, and
+	// dropUnderPressure if packets other than IDRs are dropped meanwhile. memoryShed is
+	// the number of packets dropped.
+	memoryPressure    bool
+	dropUnderPressure bool
+	memoryShed        int
+	// pending is the access unit being coalesced with DuplicatePTSCoalesce, nil before the
+	// first packet.
+	pending *pendingPacket
+	// lastPTS is the PTS of the last packet passed to WritePacket, if hasLastPTS.
+	lastPTS    int64
+	hasLastPTS bool
+	// codec, width and height are those passed to Init, to start new segments with on
+	// reconfigure.
+	codec         CodecType
+	width, height int
+	// reconfigMu serializes Reconfigure calls, and reconfig is the reconfigure waiting
+	// for the next IDR to start a segment, nil if there is none.
+	reconfigMu sync.Mutex
+	reconfig   *pendingReconfig
+}
+
+// pendingPacket is an access unit which hasn't been written yet.
+type pendingPacket struct {
+	payload  []byte
+	pts, dts int64
+	isIDR    bool
+	// borrowed is the payload passed to WritePacket which payload is read from in place
+	// under PayloadBorrow, nil if payload is the segmenter's own.
+	borrowed []byte
 }
 
 //  -----------------
@@ -39,11 +123,73 @@ type RawSegmenter struct {
 //       -------
 //    (WritePacket)
 
-func newRawSegmenter(storagePath string, logger logging.Logger) (*RawSegmenter, error) {
+// rawSegmenterOptions are the settings a RawSegmenter is created with. The zero value of
+// each is its default.
+type rawSegmenterOptions struct {
+	flush             FlushPolicy
+	smoothing         TimestampSmoothingConfig
+	duplicatePTS      DuplicatePTSPolicy
+	openRetry         OpenRetryPolicy
+	latency           LatencyPolicy
+	bufferPool        BufferPoolConfig
+	keyframes         KeyframeRequestPolicy
+	rtpHeader         RTPHeaderPolicy
+	segmentDuration   SegmentDurationPolicy
+	segmentTimestamps SegmentTimestampPolicy
+	payloadOwnership  PayloadOwnershipPolicy
+	// open, if set, is called before each segment file is opened, so tests can fail opens.
+	open segmentOpenFunc
+	// writeLatency, if set, is called inside the timed part of each packet write, so tests
+	// can simulate slow storage.
+	writeLatency func()
+	// readOnlyFilesystem, if set, replaces isReadOnlyFilesystem, so tests can simulate
+	// read-only mounts.
+	readOnlyFilesystem func(path string) bool
+}
+
+// rawSegmenterOptions returns the options of the segmenter for Config c.
+func (c *Config) rawSegmenterOptions() rawSegmenterOptions {
+	return rawSegmenterOptions{
+		flush:             c.Storage.Flush,
+		smoothing:         c.TimestampSmoothing,
+		duplicatePTS:      c.DuplicatePTS,
+		openRetry:         c.Storage.OpenRetry,
+		latency:           c.Storage.Latency,
+		bufferPool:        c.Storage.BufferPool,
+		keyframes:         c.KeyframeRequest,
+		rtpHeader:         c.RTPHeader,
+		segmentDuration:   c.SegmentDuration,
+		segmentTimestamps: c.SegmentTimestamps,
+		payloadOwnership:  c.PayloadOwnership,
+	}
+}
+
+func newRawSegmenter(storagePath string, opts rawSegmenterOptions, logger logging.Logger) (*RawSegmenter, error) {
 	s := &RawSegmenter{
-		logger:         logger,
-		storagePath:    storagePath,
-		segmentSeconds: segmentSeconds,
+		logger:            logger,
+		storagePath:       storagePath,
+		segmentSeconds:    segmentSeconds,
+		flush:             opts.flush,
+		smoothing:         opts.smoothing,
+		duplicatePTS:      opts.duplicatePTS,
+		openRetry:         opts.openRetry,
+		open:              opts.open,
+		latency:           opts.latency,
+		writeLatency:      opts.writeLatency,
+		bufferPool:        newCBufferPool(opts.bufferPool),
+		keyframes:         opts.keyframes,
+		rtpHeader:         opts.rtpHeader,
+		segmentDuration:   opts.segmentDuration,
+		segmentTimestamps: opts.segmentTimestamps,
+		payloadOwnership:  opts.payloadOwnership,
+	}
+	s.settingDurations = newSegmentDurations()
+	if s.readOnlyFilesystem = opts.readOnlyFilesystem; s.readOnlyFilesystem == nil {
+		s.readOnlyFilesystem = isReadOnlyFilesystem
+	}
+	if s.readOnlyFilesystem(s.storagePath) {
+		s.readOnly = true
+		return s, nil
 	}
 	err := createDir(s.storagePath)
 	if err != nil {
@@ -56,6 +202,9 @@ func newRawSegmenter(storagePath string, logger logging.Logger) (*RawSegmenter,
 // Close must be called to free the resources taken during Init
 // Note: May write to disk
 func (rs *RawSegmenter) Init(codec CodecType, width, height int) error {
+	if rs.readOnly {
+		return ErrReadOnlyStorage
+	}
 	if width <= 0 || height <= 0 {
 		return errors.New("both width and height must be greater than zero")
 	}
@@ -65,13 +214,33 @@ func (rs *RawSegmenter) Init(codec CodecType, width, height int) error {
 	if rs.cRawSeg != nil {
 		return errors.New("*rawSegmenter init called more than once")
 	}
+	if err := rs.init(codec, width, height); err != nil {
+		return err
+	}
+	// The waiter is kept when init starts the next segment of the same stream, on
+	// reconfigure or remount, as the packets either side of the boundary are continuous.
+	rs.keyframeWaiter = newKeyframeWaiter(rs.keyframes)
+	return nil
+}
 
+// init starts writing segments of video in codec.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) init(codec CodecType, width, height int) error {
 	var cRS *C.raw_seg
 	// Allocate output context for segmenter. The "segment" format is a special format
 	// that allows for segmenting output files. The output pattern is a strftime pattern
 	// that specifies the output file name. The pattern is set to the current time.
 	outputPatternCStr := C.CString(rs.storagePath + "/" + outputPattern)
 	defer C.free(unsafe.Pointer(outputPatternCStr))
+	flushOnIDR := C.int(0)
+	if rs.flush.OnIDR {
+		flushOnIDR = C.int(1)
+	}
+	openHook, openHookCtx := C.video_store_open_hook_fn(nil), C.uintptr_t(0)
+	if rs.open != nil {
+		rs.openHandle = cgo.NewHandle(rs.open)
+		openHook, openHookCtx = C.video_store_open_hook_fn(C.video_store_go_open_hook), C.uintptr_t(rs.openHandle)
+	}
 	var ret C.int
 	switch codec {
 	case CodecTypeH264:
@@ -80,33 +249,82 @@ func (rs *RawSegmenter) Init(codec CodecType, width, height int) error {
 			C.int(rs.segmentSeconds),
 			outputPatternCStr,
 			C.int(width),
-			C.int(height))
+			C.int(height),
+			C.int(rs.flush.Packets),
+			C.int64_t(rs.flush.Interval.Microseconds()),
+			flushOnIDR,
+			C.int(rs.openRetry.attempts()),
+			C.int64_t(rs.openRetry.backoff().Microseconds()),
+			openHook,
+			openHookCtx)
 	case CodecTypeH265:
 		ret = C.video_store_raw_seg_init_h265(
 			&cRS,
 			C.int(rs.segmentSeconds),
 			outputPatternCStr,
 			C.int(width),
-			C.int(height))
+			C.int(height),
+			C.int(rs.flush.Packets),
+			C.int64_t(rs.flush.Interval.Microseconds()),
+			flushOnIDR,
+			C.int(rs.openRetry.attempts()),
+			C.int64_t(rs.openRetry.backoff().Microseconds()),
+			openHook,
+			openHookCtx)
 	default:
+		rs.deleteOpenHandle()
 		return fmt.Errorf("rawSegmenter.Init called on invalid codec %s", codec)
 	}
 
 	if ret != C.VIDEO_STORE_RAW_SEG_RESP_OK {
+		rs.deleteOpenHandle()
 		err := errors.New("failed to initialize raw segmenter")
 		rs.logger.Errorf("%s: %d: %s", err.Error(), ret, ffmpegError(ret))
+		if isOutOfFileDescriptors(ret) {
+			return fmt.Errorf("%s: %w", err.Error(), ErrTooManyOpenFiles)
+		}
 		return err
 	}
 	rs.cRawSeg = cRS
+	rs.codec, rs.width, rs.height = codec, width, height
+	rs.smoother = newTimestampSmoother(rs.smoothing)
+	rs.latencyMonitor = newLatencyMonitor(rs.latency)
+	rs.pending = nil
+	rs.hasLastPTS = false
 
 	return nil
 }
 
 // WritePacket writes video data in the codec passed to Init to the current segment file.
+// pts and dts are in the 90kHz RTP clock rate. A packet with the same pts as the packet
+// before it is handled by the segmenter's DuplicatePTSPolicy. When the caller may reuse
+// payload's buffer is set by the segmenter's PayloadOwnershipPolicy.
 // Can't be called before Init is called
 func (rs *RawSegmenter) WritePacket(payload []byte, pts, dts int64, isIDR bool) error {
+	if rs.readOnly {
+		return ErrReadOnlyStorage
+	}
+	// The source is asked for a keyframe once the lock is released.
+	var requestKeyframe func()
+	defer func() {
+		if requestKeyframe != nil {
+			requestKeyframe()
+		}
+	}()
 	rs.cRawSegMu.Lock()
 	defer rs.cRawSegMu.Unlock()
+	// A borrowed payload is released before returning, unless its access unit is held back.
+	borrowed := payload
+	if rs.payloadOwnership == PayloadBorrow {
+		if rs.releasePayload == nil {
+			return errNoPayloadReleaser
+		}
+		defer func() {
+			if borrowed != nil {
+				rs.releasePayload(borrowed)
+			}
+		}()
+	}
 	if rs.cRawSeg == nil {
 		return errors.New("writePacket called before init")
 	}
@@ -115,41 +333,275 @@ func (rs *RawSegmenter) WritePacket(payload []byte, pts, dts int64, isIDR bool)
 		return errors.New("writePacket called with empty packet")
 	}
 
-	payloadC := C.CBytes(payload)
-	defer C.free(payloadC)
+	payload, err := rs.checkRTPHeader(payload, pts)
+	if err != nil {
+		return err
+	}
+
+	if w := rs.keyframeWaiter; w != nil {
+		waiting := w.waiting
+		drop, request := w.packet(time.Now(), isIDR)
+		if request {
+			requestKeyframe = rs.requestKeyframe
+		}
+		if drop {
+			if !waiting {
+				rs.logger.Infof("recording resumed mid-GOP, dropping packets until the next IDR")
+			}
+			return nil
+		}
+		if waiting {
+			rs.logger.Infof("recording resumed at an IDR, %d packets were dropped", w.dropped)
+		}
+	}
+
+	duplicate := rs.hasLastPTS && pts == rs.lastPTS
+	// A pending reconfigure starts a segment at the next IDR, after the access unit
+	// before it is written to the current segment.
+	if rs.reconfig != nil && isIDR && !duplicate {
+		if err := rs.takeBoundary(); err != nil {
+			return err
+		}
+	}
+	rs.lastPTS, rs.hasLastPTS = pts, true
+	switch rs.duplicatePTS {
+	case DuplicatePTSCoalesce:
+		if duplicate {
+			p := rs.pending
+			if p.borrowed != nil {
+				// A borrowed payload can't be appended to in place, so the access unit is
+				// copied and the payload released.
+				p.payload = append(slices.Clip(p.payload), payload...)
+				p.release(rs)
+			} else {
+				p.payload = append(p.payload, payload...)
+			}
+			p.isIDR = p.isIDR || isIDR
+			return nil
+		}
+		p := rs.pending
+		rs.pending = &pendingPacket{payload: payload, pts: pts, dts: dts, isIDR: isIDR}
+		if rs.payloadOwnership == PayloadBorrow {
+			// The payload is held until the access unit is written.
+			rs.pending.borrowed, borrowed = borrowed, nil
+		} else {
+			// The payload is copied as the caller may reuse it before the access unit is written.
+			rs.pending.payload = slices.Clone(payload)
+		}
+		if p == nil {
+			return nil
+		}
+		return rs.writePending(p)
+	case DuplicatePTSDrop:
+		if duplicate {
+			rs.logger.Debugf("dropping packet with duplicate pts %d", pts)
+			return nil
+		}
+	case DuplicatePTSReject:
+		if duplicate {
+			return fmt.Errorf("failed to write packet with pts %d: %w", pts, ErrDuplicatePTS)
+		}
+	}
+	return rs.writePacket(payload, pts, dts, isIDR)
+}
+
+// writePacket writes an access unit to the current segment file.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) writePacket(payload []byte, pts, dts int64, isIDR bool) error {
+	if rs.latencyMonitor != nil && rs.latencyMonitor.skip(isIDR) {
+		return nil
+	}
+	if rs.memoryPressure && rs.dropUnderPressure && !isIDR {
+		rs.memoryShed++
+		return nil
+	}
+	// Segments start at IDRs, so an IDR names the segment it starts. Its wall clock time
+	// is found before smoothing moves its pts off its RTP timestamp.
+	if isIDR && rs.segmentTimestamps == SegmentTimestampRTCP && rs.senderReport != nil {
+		C.video_store_raw_seg_set_start_time(rs.cRawSeg, C.int64_t(rs.senderReport.wallClock(pts).UnixMilli()))
+	}
+	if rs.smoother != nil {
+		pts, dts = rs.smoother.smooth(pts, dts)
+	}
+
+	// A borrowed payload is read in place, as C copies it before the write returns.
+	data := unsafe.Pointer(&payload[0])
+	if rs.payloadOwnership == PayloadCopy {
+		// Buffers aren't pooled under memory pressure.
+		pool := rs.bufferPool
+		if rs.memoryPressure {
+			pool = nil
+		}
+		payloadC := pool.get(payload)
+		defer pool.put(payloadC)
+		data = payloadC.ptr
+	}
 
 	idr := C.int(0)
 	if isIDR {
 		idr = C.int(1)
 	}
+	begin := time.Now()
+	if rs.writeLatency != nil {
+		rs.writeLatency()
+	}
 	ret := C.video_store_raw_seg_write_packet(
 		rs.cRawSeg,
-		(*C.char)(payloadC),
+		(*C.char)(data),
 		C.size_t(len(payload)),
 		C.int64_t(pts),
 		C.int64_t(dts),
 		idr)
+	if rs.latencyMonitor != nil {
+		rs.observeLatency(time.Since(begin))
+	}
 	if ret != C.VIDEO_STORE_RAW_SEG_RESP_OK {
 		err := errors.New("failed to write packet")
 		rs.logger.Errorf("%s: %d", err.Error(), ret)
+		if isOutOfFileDescriptors(ret) {
+			return fmt.Errorf("%s: %w", err.Error(), ErrTooManyOpenFiles)
+		}
 		return err
 	}
+	if rs.segmentDuration == SegmentDurationExact {
+		if path := rs.takeCompleted(); path != "" {
+			target := time.Duration(rs.segmentSeconds) * time.Second
+			rs.durations.Add(1)
+			rs.settingDurations.start(path)
+			go func() {
+				defer rs.durations.Done()
+				defer rs.settingDurations.done(path)
+				rs.setCompletedSegmentDuration(path, target)
+			}()
+		}
+	}
 	return nil
 }
 
+// takeCompleted returns the path of the segment the last packet completed, empty if none.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) takeCompleted() string {
+	path := make([]byte, maxSegmentPathLength)
+	if C.video_store_raw_seg_take_completed(rs.cRawSeg, (*C.char)(unsafe.Pointer(&path[0])), C.size_t(len(path))) == 0 {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&path[0])))
+}
+
+// setCompletedSegmentDuration sets the duration of the completed segment name to exactly
+// target. Failing to is only logged, as the segment is intact.
+func (rs *RawSegmenter) setCompletedSegmentDuration(name string, target time.Duration) {
+	set, err := setSegmentDuration(name, target, target/2)
+	if err != nil {
+		rs.logger.Warnf("failed to set duration of segment %s: %v", name, err)
+	} else if !set {
+		rs.logger.Debugf("segment %s is too far from %s to set its duration", name, target)
+	}
+}
+
+// SetKeyframeRequester sets the function called to ask the source for an IDR when
+// recording resumes mid-GOP under the KeyframeRequestPolicy. It is called from
+// WritePacket, after the segmenter's lock is released, and shouldn't block.
+func (rs *RawSegmenter) SetKeyframeRequester(request func()) {
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	rs.requestKeyframe = request
+}
+
+// observeLatency applies the latency policy to the latency of a write.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) observeLatency(latency time.Duration) {
+	m := rs.latencyMonitor
+	if !m.observe(latency) {
+		return
+	}
+	if m.engaged {
+		rs.logger.Warnf("%d segment writes in a row took over %s, the last %s: %s until storage recovers",
+			m.sustained, m.policy.Threshold, latency, latencyActionDescriptions[m.policy.Action])
+	} else if m.policy.Action == LatencyActionShed {
+		rs.logger.Infof("segment write latency recovered to %s, %d packets were shed", latency, m.shed)
+	} else {
+		rs.logger.Infof("segment write latency recovered to %s", latency)
+	}
+	// Packets aren't buffered under memory pressure.
+	if m.policy.Action == LatencyActionBuffer && !rs.memoryPressure {
+		suspended := C.int(0)
+		if m.engaged {
+			suspended = C.int(1)
+		}
+		C.video_store_raw_seg_suspend_flush(rs.cRawSeg, suspended)
+	}
+}
+
+// setMemoryPressure starts or stops shedding the segmenter's memory. Under pressure the
+// buffer pool is drained and bypassed, packets buffered by LatencyActionBuffer are
+// flushed, and with dropPackets every packet but IDRs is dropped.
+func (rs *RawSegmenter) setMemoryPressure(underPressure, dropPackets bool) {
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	if rs.memoryPressure == underPressure {
+		return
+	}
+	rs.memoryPressure = underPressure
+	rs.dropUnderPressure = dropPackets
+	if underPressure {
+		rs.bufferPool.drain()
+	}
+	if m := rs.latencyMonitor; m != nil && m.engaged && m.policy.Action == LatencyActionBuffer && rs.cRawSeg != nil {
+		suspended := C.int(1)
+		if underPressure {
+			suspended = C.int(0)
+		}
+		C.video_store_raw_seg_suspend_flush(rs.cRawSeg, suspended)
+	}
+	if !underPressure && rs.memoryShed > 0 {
+		rs.logger.Infof("%d packets were dropped under memory pressure", rs.memoryShed)
+		rs.memoryShed = 0
+	}
+}
+
 // Close closes the segmenter and writes the trailer to prevent corruption
 // when exiting early in the middle of a segment.
 // Init may be called after Close
 func (rs *RawSegmenter) Close() error {
 	rs.cRawSegMu.Lock()
 	defer rs.cRawSegMu.Unlock()
+	// There is no segment left for a pending reconfigure to wait for.
+	if r := rs.reconfig; r != nil {
+		rs.reconfig = nil
+		rs.applyConfig(r.config)
+		r.done <- nil
+	}
+	defer rs.durations.Wait()
+	return rs.close()
+}
+
+// close writes the pending access unit and the trailer of the current segment.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) close() error {
 	if rs.cRawSeg == nil {
 		return nil
 	}
+	if p := rs.pending; p != nil {
+		rs.pending = nil
+		if err := rs.writePending(p); err != nil {
+			rs.logger.Warnf("failed to write last packet before closing: %v", err)
+		}
+	}
 	ret := C.video_store_raw_seg_close(&rs.cRawSeg)
 	if ret != C.VIDEO_STORE_RAW_SEG_RESP_OK {
 		return fmt.Errorf("failed to close raw segmeneter: %d", ret)
 	}
 	rs.cRawSeg = nil
+	rs.deleteOpenHandle()
+	rs.bufferPool.drain()
 	return nil
 }
+
+// deleteOpenHandle deletes openHandle once the segmenter it was passed to is freed.
+func (rs *RawSegmenter) deleteOpenHandle() {
+	if rs.openHandle != 0 {
+		rs.openHandle.Delete()
+		rs.openHandle = 0
+	}
+}
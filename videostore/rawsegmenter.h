@@ -1,22 +1,82 @@
 #ifndef VIAM_RAW_SEGMENTER_H
 #define VIAM_RAW_SEGMENTER_H
+#include "utils.h"
 #include <libavformat/avformat.h>
 typedef struct raw_seg {
   AVFormatContext *outCtx;
+
+  // flush policy for the active segment, all zero never flushes and the
+  // segment is only complete once it is closed.
+  int flushPackets;
+  int64_t flushIntervalMicroseconds;
+  int flushOnIdr;
+  // set while flushing is suspended, see video_store_raw_seg_suspend_flush
+  int flushSuspended;
+
+  // how opening each segment file is retried when file descriptors run out
+  int openAttempts;
+  int64_t openBackoffMicroseconds;
+  // called before each segment file is opened, NULL if not set
+  video_store_open_hook_fn openHook;
+  uintptr_t openHookCtx;
+
+  // flush state
+  AVIOContext *segmentPB;
+  int packetsSinceFlush;
+  int64_t lastFlushMicroseconds;
+  int (*ioOpen)(struct AVFormatContext *s, AVIOContext **pb, const char *url,
+                int flags, AVDictionary **options);
+  int (*ioClose2)(struct AVFormatContext *s, AVIOContext *pb);
+
+  // path of the active segment, and of the last segment completed by rolling
+  // over to the next, see video_store_raw_seg_take_completed
+  char *segmentURL;
+  char *completedURL;
+  // set while closing, so the segment ended by close isn't reported completed
+  int closing;
+  // wall clock time of the next packet, see video_store_raw_seg_set_start_time,
+  // and whether a packet has been written to the active segment
+  int64_t startMilliseconds;
+  int segmentHasPackets;
 } raw_seg;
 
-int video_store_raw_seg_init_h264(struct raw_seg **ppRS,     // OUT
-                                  const int segmentSeconds,  // IN
-                                  const char *outputPattern, // IN
-                                  const int width,           // IN
-                                  const int height           // IN
+// video_store_raw_seg_init_h264 and video_store_raw_seg_init_h265 initialize
+// a segmenter. At most one of flushPackets, flushIntervalMicroseconds and
+// flushOnIdr may be set. When one is, segments are written as fragmented mp4
+// and the active segment is flushed to disk every flushPackets packets, once
+// flushIntervalMicroseconds have passed since the last flush, or on each IDR
+// frame, so less of it is lost if the process crashes.
+// Opening each segment file is tried up to openAttempts times while file
+// descriptors are exhausted, waiting openBackoffMicroseconds before the first
+// retry and doubling after each. openHook, if not NULL, is called with
+// openHookCtx and the path of each segment file before it is opened, and fails
+// the open if it returns an error.
+int video_store_raw_seg_init_h264(struct raw_seg **ppRS,                   // OUT
+                                  const int segmentSeconds,                // IN
+                                  const char *outputPattern,               // IN
+                                  const int width,                         // IN
+                                  const int height,                        // IN
+                                  const int flushPackets,                  // IN
+                                  const int64_t flushIntervalMicroseconds, // IN
+                                  const int flushOnIdr,                    // IN
+                                  const int openAttempts,                  // IN
+                                  const int64_t openBackoffMicroseconds,   // IN
+                                  video_store_open_hook_fn openHook,       // IN
+                                  const uintptr_t openHookCtx              // IN
 );
 
-int video_store_raw_seg_init_h265(struct raw_seg **ppRS,     // OUT
-                                  const int segmentSeconds,  // IN
-                                  const char *outputPattern, // IN
-                                  const int width,           // IN
-                                  const int height           // IN
+int video_store_raw_seg_init_h265(struct raw_seg **ppRS,                   // OUT
+                                  const int segmentSeconds,                // IN
+                                  const char *outputPattern,               // IN
+                                  const int width,                         // IN
+                                  const int height,                        // IN
+                                  const int flushPackets,                  // IN
+                                  const int64_t flushIntervalMicroseconds, // IN
+                                  const int flushOnIdr,                    // IN
+                                  const int openAttempts,                  // IN
+                                  const int64_t openBackoffMicroseconds,   // IN
+                                  video_store_open_hook_fn openHook,       // IN
+                                  const uintptr_t openHookCtx              // IN
 );
 
 int video_store_raw_seg_write_packet(struct raw_seg *rs,       // IN
@@ -27,6 +87,31 @@ int video_store_raw_seg_write_packet(struct raw_seg *rs,       // IN
                                      const int isIdr           // IN
 );
 
+// video_store_raw_seg_suspend_flush stops the flush policy from flushing the
+// active segment while suspended is set, so writes are buffered instead, e.g.
+// while storage is slow. Once cleared the next packet is flushed if it is due.
+void video_store_raw_seg_suspend_flush(struct raw_seg *rs, // IN
+                                       const int suspended // IN
+);
+
+// video_store_raw_seg_take_completed copies the path of the last segment
+// completed by rolling over to the next into path and forgets it. Returns 1 if
+// a segment was completed since the last call, otherwise 0. Segments ended by
+// video_store_raw_seg_close aren't reported.
+int video_store_raw_seg_take_completed(struct raw_seg *rs,   // IN
+                                       char *path,           // OUT
+                                       const size_t pathSize // IN
+);
+
+// video_store_raw_seg_set_start_time sets the unix wall clock time in
+// milliseconds of the next packet written. If the packet is the first of a
+// segment, the segment is renamed from the time it was opened to
+// <seconds>.<milliseconds>.mp4 of startMilliseconds, unless a segment already
+// has that name. It only applies to the next packet.
+void video_store_raw_seg_set_start_time(struct raw_seg *rs,               // IN
+                                        const int64_t startMilliseconds // IN
+);
+
 int video_store_raw_seg_close(struct raw_seg **rs // OUT
 );
 #define VIDEO_STORE_RAW_SEG_RESP_OK 0
@@ -0,0 +1,324 @@
+package videostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+	"golang.org/x/sys/unix"
+)
+
+// testPacket is an Annex-B access unit as WritePacket expects it.
+type testPacket struct {
+	payload []byte
+	pts     int64
+	isIDR   bool
+}
+
+// findBox returns the payload of the first box along path in the mp4 boxes in b.
+func findBox(t *testing.T, b []byte, path ...string) []byte {
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			t.Fatalf("invalid mp4 box size %d", size)
+		}
+		if string(b[4:8]) == path[0] {
+			if len(path) == 1 {
+				return b[8:size]
+			}
+			return findBox(t, b[8:size], path[1:]...)
+		}
+		b = b[size:]
+	}
+	t.Fatalf("mp4 box %s not found", path[0])
+	return nil
+}
+
+// h264Packets encodes frames at framerate with the frame encoder, which places an IDR
+// every second, and returns the encoded frames as Annex-B packets with 90kHz timestamps.
+func h264Packets(t *testing.T, frames [][]byte, framerate int) []testPacket {
+	mp4, err := os.ReadFile(encodeFrames(t, frames, framerate))
+	test.That(t, err, test.ShouldBeNil)
+
+	stbl := findBox(t, mp4, "moov", "trak", "mdia", "minf", "stbl")
+	// stsd full box header and entry count, avc1 box header and visual sample entry fields.
+	avcC := findBox(t, findBox(t, stbl, "stsd")[8:][8+78:], "avcC")
+	startCode := []byte{0, 0, 0, 1}
+	var parameterSets []byte
+	rest := avcC[5:]
+	for range 2 { // SPS then PPS
+		count := int(rest[0] & 0x1f)
+		rest = rest[1:]
+		for range count {
+			n := int(binary.BigEndian.Uint16(rest))
+			parameterSets = append(append(parameterSets, startCode...), rest[2:2+n]...)
+			rest = rest[2+n:]
+		}
+	}
+
+	stsz := findBox(t, stbl, "stsz")
+	stco := findBox(t, stbl, "stco")
+	stsc := findBox(t, stbl, "stsc")
+	stss := findBox(t, stbl, "stss")
+	sampleCount := int(binary.BigEndian.Uint32(stsz[8:]))
+	keyframes := map[int]bool{}
+	for i := range int(binary.BigEndian.Uint32(stss[4:])) {
+		keyframes[int(binary.BigEndian.Uint32(stss[8+4*i:]))-1] = true
+	}
+	chunkCount := int(binary.BigEndian.Uint32(stco[4:]))
+	stscCount := int(binary.BigEndian.Uint32(stsc[4:]))
+
+	var packets []testPacket
+	sample := 0
+	for chunk := range chunkCount {
+		// The last stsc entry whose first chunk is at or before this chunk applies.
+		samplesPerChunk := 0
+		for i := range stscCount {
+			if int(binary.BigEndian.Uint32(stsc[8+12*i:]))-1 <= chunk {
+				samplesPerChunk = int(binary.BigEndian.Uint32(stsc[8+12*i+4:]))
+			}
+		}
+		offset := int(binary.BigEndian.Uint32(stco[8+4*chunk:]))
+		for range samplesPerChunk {
+			size := int(binary.BigEndian.Uint32(stsz[12+4*sample:]))
+			// Convert 4 byte length prefixed NALs to Annex-B.
+			var payload []byte
+			if keyframes[sample] {
+				payload = append(payload, parameterSets...)
+			}
+			for avcc := mp4[offset : offset+size]; len(avcc) > 0; {
+				n := int(binary.BigEndian.Uint32(avcc))
+				payload = append(append(payload, startCode...), avcc[4:4+n]...)
+				avcc = avcc[4+n:]
+			}
+			packets = append(packets, testPacket{
+				payload: payload,
+				pts:     int64(sample * 90000 / framerate),
+				isIDR:   keyframes[sample],
+			})
+			offset += size
+			sample++
+		}
+	}
+	test.That(t, len(packets), test.ShouldEqual, sampleCount)
+	return packets
+}
+
+func TestRawSegmenterFlushPolicy(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	var frames [][]byte
+	variants := [][]byte{patternJPEG(t, 0), patternJPEG(t, 1)}
+	for i := range 6 * framerate {
+		frames = append(frames, variants[i/5%2])
+	}
+	packets := h264Packets(t, frames, framerate)
+	var written int64
+	for _, p := range packets {
+		written += int64(len(p.payload))
+	}
+
+	// crash writes every packet without closing the segmenter, as if the process died,
+	// and returns the segment as it is on disk.
+	crash := func(t *testing.T, flush FlushPolicy) (string, int64) {
+		storagePath := t.TempDir()
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{flush: flush}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+		size, err := getFileSize(segments[0])
+		test.That(t, err, test.ShouldBeNil)
+		t.Cleanup(func() { test.That(t, rs.Close(), test.ShouldBeNil) })
+		return segments[0], size
+	}
+
+	t.Run("Flushing every packet loses at most the last packets", func(t *testing.T) {
+		segment, size := crash(t, FlushPolicy{Packets: 1})
+		last := int64(len(packets[len(packets)-1].payload) + len(packets[len(packets)-2].payload))
+		test.That(t, size, test.ShouldBeGreaterThanOrEqualTo, written-last)
+		// What was flushed is playable without the segment being closed.
+		_, err := grayFrameAt(segment, 0, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("Higher cadence loses less data", func(t *testing.T) {
+		_, everyPacket := crash(t, FlushPolicy{Packets: 1})
+		_, everyFiftyPackets := crash(t, FlushPolicy{Packets: 50})
+		test.That(t, everyPacket, test.ShouldBeGreaterThan, everyFiftyPackets)
+	})
+
+	t.Run("Flushing on IDR loses at most the last GOP", func(t *testing.T) {
+		_, size := crash(t, FlushPolicy{OnIDR: true})
+		var lastGOP int64
+		for i := len(packets) - 1; i >= 0; i-- {
+			lastGOP += int64(len(packets[i].payload))
+			if packets[i].isIDR {
+				break
+			}
+		}
+		test.That(t, size, test.ShouldBeGreaterThanOrEqualTo, written-lastGOP)
+	})
+
+	t.Run("Interval flushing writes the segment as it goes", func(t *testing.T) {
+		_, size := crash(t, FlushPolicy{Interval: time.Nanosecond})
+		last := int64(len(packets[len(packets)-1].payload) + len(packets[len(packets)-2].payload))
+		test.That(t, size, test.ShouldBeGreaterThanOrEqualTo, written-last)
+	})
+}
+
+func TestFlushPolicyValidate(t *testing.T) {
+	test.That(t, FlushPolicy{}.Validate(), test.ShouldBeNil)
+	test.That(t, FlushPolicy{Packets: 10}.Validate(), test.ShouldBeNil)
+	test.That(t, FlushPolicy{Interval: time.Second}.Validate(), test.ShouldBeNil)
+	test.That(t, FlushPolicy{OnIDR: true}.Validate(), test.ShouldBeNil)
+	test.That(t, FlushPolicy{Packets: -1}.Validate(), test.ShouldNotBeNil)
+	test.That(t, FlushPolicy{Packets: 10, OnIDR: true}.Validate(), test.ShouldNotBeNil)
+}
+
+// failOpens returns a segmentOpenFunc which fails every open with EMFILE, as if file
+// descriptors had run out, until release is called.
+func failOpens() (open segmentOpenFunc, release func()) {
+	var released atomic.Bool
+	open = func(string) error {
+		if released.Load() {
+			return nil
+		}
+		return unix.EMFILE
+	}
+	return open, func() { released.Store(true) }
+}
+
+func TestRawSegmenterOpenRetry(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("Transient open failure is retried", func(t *testing.T) {
+		storagePath := t.TempDir()
+		open, release := failOpens()
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{
+			openRetry: OpenRetryPolicy{Attempts: 5, Backoff: 20 * time.Millisecond},
+			open:      open,
+		}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		// Release the descriptors while Init is backing off.
+		timer := time.AfterFunc(50*time.Millisecond, release)
+		defer timer.Stop()
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+	})
+
+	t.Run("Open failure is retryable once retries are exhausted", func(t *testing.T) {
+		open, release := failOpens()
+		rs, err := newRawSegmenter(t.TempDir(), rawSegmenterOptions{openRetry: OpenRetryPolicy{Attempts: 1}, open: open}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		err = rs.Init(CodecTypeH264, 640, 480)
+		release()
+		test.That(t, errors.Is(err, ErrTooManyOpenFiles), test.ShouldBeTrue)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+	})
+
+	t.Run("Invalid policy is rejected", func(t *testing.T) {
+		test.That(t, OpenRetryPolicy{}.Validate(), test.ShouldBeNil)
+		test.That(t, OpenRetryPolicy{Attempts: -1}.Validate(), test.ShouldNotBeNil)
+		test.That(t, OpenRetryPolicy{Backoff: -time.Second}.Validate(), test.ShouldNotBeNil)
+	})
+}
+
+func TestRawSegmenterDuplicatePTS(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	packets := h264Packets(t, frames, framerate)
+
+	// split has one packet per NAL with the access unit's PTS, as some sources send the
+	// SPS and PPS ahead of an IDR.
+	var split []testPacket
+	startCode := []byte{0, 0, 0, 1}
+	for _, p := range packets {
+		for _, nal := range bytes.Split(p.payload, startCode)[1:] {
+			isIDR := p.isIDR && nal[0]&0x1f == 5
+			split = append(split, testPacket{payload: append(slices.Clone(startCode), nal...), pts: p.pts, isIDR: isIDR})
+		}
+	}
+	test.That(t, len(split), test.ShouldBeGreaterThan, len(packets))
+
+	// write writes packets with policy and returns the segment written.
+	write := func(t *testing.T, policy DuplicatePTSPolicy, packets []testPacket) string {
+		storagePath := t.TempDir()
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{duplicatePTS: policy}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+		return segments[0]
+	}
+
+	t.Run("Packets are passed through by default", func(t *testing.T) {
+		rs, err := newRawSegmenter(t.TempDir(), rawSegmenterOptions{}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		defer func() { test.That(t, rs.Close(), test.ShouldBeNil) }()
+		for _, p := range packets[:3] {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+			// Nothing is held back to be coalesced.
+			test.That(t, rs.pending, test.ShouldBeNil)
+		}
+	})
+
+	t.Run("Access units split across packets are coalesced", func(t *testing.T) {
+		segment := write(t, DuplicatePTSCoalesce, split)
+		test.That(t, len(frameTimes(t, segment)), test.ShouldEqual, len(packets))
+		_, err := grayFrameAt(segment, 0, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("Repeated access units are dropped", func(t *testing.T) {
+		var repeated []testPacket
+		for _, p := range packets {
+			repeated = append(repeated, p, p)
+		}
+		segment := write(t, DuplicatePTSDrop, repeated)
+		test.That(t, len(frameTimes(t, segment)), test.ShouldEqual, len(packets))
+	})
+
+	t.Run("Duplicate PTS can be rejected", func(t *testing.T) {
+		rs, err := newRawSegmenter(t.TempDir(), rawSegmenterOptions{duplicatePTS: DuplicatePTSReject}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		defer func() { test.That(t, rs.Close(), test.ShouldBeNil) }()
+		test.That(t, rs.WritePacket(packets[0].payload, 0, 0, true), test.ShouldBeNil)
+		err = rs.WritePacket(packets[0].payload, 0, 0, true)
+		test.That(t, errors.Is(err, ErrDuplicatePTS), test.ShouldBeTrue)
+		test.That(t, rs.WritePacket(packets[1].payload, packets[1].pts, packets[1].pts, false), test.ShouldBeNil)
+	})
+
+	t.Run("Invalid policy is rejected", func(t *testing.T) {
+		test.That(t, DuplicatePTSPassThrough.Validate(), test.ShouldBeNil)
+		test.That(t, DuplicatePTSReject.Validate(), test.ShouldBeNil)
+		test.That(t, DuplicatePTSPolicy(-1).Validate(), test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,170 @@
+package videostore
+
+/*
+#include "rawsegmenter.h"
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pendingReconfig is a reconfigure waiting for a segment boundary.
+type pendingReconfig struct {
+	config Config
+	// done receives the result of starting the segment with config.
+	done chan error
+}
+
+// Reconfigure applies the segmenter settings in config while packets are being written.
+//
+// Settings which change how segment files are written need a segment boundary: the
+// record path (the storage path, or the live path if set), the flush and open retry
+// policies, and timestamp smoothing. The current segment ends before the next IDR,
+// which starts a new segment with the new settings, so both segments are complete and
+// decodable and no packets are dropped. WritePacket blocks while the segment is
+// finished and the next one opened. Reconfigure waits for the IDR until ctx is done,
+// then ends the segment at once, in which case the new segment can't be decoded until
+// its first IDR.
+//
+// The duplicate PTS, latency, keyframe request, RTP header and segment duration policies
+// and the buffer pool are applied immediately.
+func (rs *RawSegmenter) Reconfigure(ctx context.Context, config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if rs.readOnly {
+		return ErrReadOnlyStorage
+	}
+	rs.reconfigMu.Lock()
+	defer rs.reconfigMu.Unlock()
+
+	rs.cRawSegMu.Lock()
+	if rs.cRawSeg == nil || !rs.needsBoundary(config) {
+		defer rs.cRawSegMu.Unlock()
+		if err := createDir(config.Storage.recordPath()); err != nil {
+			return err
+		}
+		rs.applyImmediate(config)
+		rs.applyConfig(config)
+		return nil
+	}
+	if err := createDir(config.Storage.recordPath()); err != nil {
+		rs.cRawSegMu.Unlock()
+		return err
+	}
+	rs.applyImmediate(config)
+	r := &pendingReconfig{config: config, done: make(chan error, 1)}
+	rs.reconfig = r
+	rs.cRawSegMu.Unlock()
+
+	select {
+	case err := <-r.done:
+		return err
+	case <-ctx.Done():
+	}
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	if rs.reconfig != r {
+		// The boundary was taken while the lock was waited for.
+		return <-r.done
+	}
+	rs.logger.Warnf("no IDR arrived to reconfigure at, ending the segment mid-GOP: %v", ctx.Err())
+	return rs.takeBoundary()
+}
+
+// needsBoundary returns whether applying config changes how segment files are written.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) needsBoundary(config Config) bool {
+	return rs.storagePath != config.Storage.recordPath() ||
+		rs.flush != config.Storage.Flush ||
+		rs.openRetry != config.Storage.OpenRetry ||
+		rs.smoothing != config.TimestampSmoothing
+}
+
+// applyImmediate applies the settings of config which don't need a segment boundary.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) applyImmediate(config Config) {
+	if rs.duplicatePTS != config.DuplicatePTS {
+		// Only DuplicatePTSCoalesce holds back an access unit.
+		if p := rs.pending; p != nil && config.DuplicatePTS != DuplicatePTSCoalesce {
+			rs.pending = nil
+			if err := rs.writePending(p); err != nil {
+				rs.logger.Warnf("failed to write pending packet on reconfigure: %v", err)
+			}
+		}
+		rs.duplicatePTS = config.DuplicatePTS
+	}
+	if rs.latency != config.Storage.Latency {
+		if m := rs.latencyMonitor; m != nil && m.engaged && m.policy.Action == LatencyActionBuffer {
+			C.video_store_raw_seg_suspend_flush(rs.cRawSeg, C.int(0))
+		}
+		rs.latency = config.Storage.Latency
+		if rs.cRawSeg != nil {
+			rs.latencyMonitor = newLatencyMonitor(rs.latency)
+		}
+	}
+	if rs.keyframes != config.KeyframeRequest {
+		rs.keyframes = config.KeyframeRequest
+		if rs.cRawSeg != nil {
+			rs.keyframeWaiter = newKeyframeWaiter(rs.keyframes)
+		}
+	}
+	rs.rtpHeader = config.RTPHeader
+	rs.segmentDuration = config.SegmentDuration
+	rs.segmentTimestamps = config.SegmentTimestamps
+	rs.payloadOwnership = config.PayloadOwnership
+	if pool := newCBufferPool(config.Storage.BufferPool); !sameBufferPool(rs.bufferPool, pool) {
+		rs.bufferPool.drain()
+		rs.bufferPool = pool
+	}
+}
+
+// applyConfig sets the segmenter's settings to those of config, for the next Init.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) applyConfig(config Config) {
+	rs.storagePath = config.Storage.recordPath()
+	rs.flush = config.Storage.Flush
+	rs.openRetry = config.Storage.OpenRetry
+	rs.smoothing = config.TimestampSmoothing
+}
+
+// takeBoundary ends the current segment and starts the next with the pending reconfigure.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) takeBoundary() error {
+	r := rs.reconfig
+	rs.reconfig = nil
+	if err := rs.close(); err != nil {
+		rs.logger.Warnf("failed to finalize segment on reconfigure: %v", err)
+	}
+	rs.applyConfig(r.config)
+	waitForUnusedSegmentName(rs.storagePath)
+	err := rs.init(rs.codec, rs.width, rs.height)
+	if err != nil {
+		err = fmt.Errorf("failed to start segment on reconfigure: %w", err)
+	}
+	r.done <- err
+	return err
+}
+
+// sameBufferPool returns whether pools a and b are configured the same.
+func sameBufferPool(a, b *cBufferPool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.size == b.size && cap(a.free) == cap(b.free)
+}
+
+// waitForUnusedSegmentName waits until the segment opened now in storagePath wouldn't
+// overwrite an existing one. Segments are named by the second they start, so a segment
+// started in the same second as the last would replace it.
+func waitForUnusedSegmentName(storagePath string) {
+	now := time.Now()
+	if _, err := os.Stat(filepath.Join(storagePath, fmt.Sprintf("%d.mp4", now.Unix()))); err == nil {
+		time.Sleep(time.Until(now.Truncate(time.Second).Add(time.Second)))
+	}
+}
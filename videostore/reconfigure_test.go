@@ -0,0 +1,191 @@
+package videostore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestReconfigure(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	// IDRs are at packets 0, 10 and 20.
+	packets := h264Packets(t, frames, framerate)
+	write := func(t *testing.T, rs *RawSegmenter, packets []testPacket) {
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+	}
+	pending := func(rs *RawSegmenter) bool {
+		rs.cRawSegMu.Lock()
+		defer rs.cRawSegMu.Unlock()
+		return rs.reconfig != nil
+	}
+
+	t.Run("Reconfigure mid-stream starts a new segment at the next IDR", func(t *testing.T) {
+		m := NewManager(logger)
+		defer m.Close()
+		config := validRTPConfig(t)
+		vs, err := m.Register("front", config)
+		test.That(t, err, test.ShouldBeNil)
+		rs := vs.Segmenter()
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		write(t, rs, packets[:15])
+
+		config.Storage.Flush = FlushPolicy{OnIDR: true}
+		type result struct {
+			vs  RTPVideoStore
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			vs, err := m.Reconfigure(context.Background(), "front", config)
+			done <- result{vs, err}
+		}()
+		for !pending(rs) {
+			time.Sleep(time.Millisecond)
+		}
+		// The manager isn't locked while the segmenter waits for the IDR.
+		_, err = m.Stream("front")
+		test.That(t, err, test.ShouldBeNil)
+		_, err = m.Reconfigure(context.Background(), "front", config)
+		test.That(t, err, test.ShouldBeError, "stream front is already being reconfigured")
+		write(t, rs, packets[15:])
+		res := <-done
+		test.That(t, res.err, test.ShouldBeNil)
+		// The stream keeps writing with the same segmenter.
+		test.That(t, res.vs.Segmenter(), test.ShouldEqual, rs)
+		test.That(t, rs.flush.OnIDR, test.ShouldBeTrue)
+		test.That(t, m.Deregister("front"), test.ShouldBeNil)
+
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 2)
+		// Every packet was written, the first segment ending before the IDR at packet 20.
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, 20)
+		test.That(t, len(frameTimes(t, files[1].name)), test.ShouldEqual, 10)
+		for _, f := range files {
+			_, err := grayFrameAt(f.name, 0, pHashSize, pHashSize)
+			test.That(t, err, test.ShouldBeNil)
+		}
+	})
+
+	t.Run("Settings without a boundary apply immediately", func(t *testing.T) {
+		config := validRTPConfig(t)
+		rs, err := newRawSegmenter(config.Storage.StoragePath, rawSegmenterOptions{duplicatePTS: DuplicatePTSCoalesce}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		write(t, rs, packets[:5])
+
+		config.DuplicatePTS = DuplicatePTSReject
+		config.Storage.BufferPool = BufferPoolConfig{Buffers: 2}
+		test.That(t, rs.Reconfigure(context.Background(), config), test.ShouldBeNil)
+		test.That(t, rs.duplicatePTS, test.ShouldEqual, DuplicatePTSReject)
+		test.That(t, rs.bufferPool, test.ShouldNotBeNil)
+		// The access unit held back to coalesce was written.
+		test.That(t, rs.pending, test.ShouldBeNil)
+		write(t, rs, packets[5:])
+		test.That(t, rs.Close(), test.ShouldBeNil)
+
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, len(packets))
+	})
+
+	t.Run("Reconfigure waits for an IDR until the context is done", func(t *testing.T) {
+		config := validRTPConfig(t)
+		rs, err := newRawSegmenter(config.Storage.StoragePath, rawSegmenterOptions{}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		defer rs.Close()
+		write(t, rs, packets[:5])
+
+		config.Storage.Flush = FlushPolicy{Packets: 1}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		test.That(t, rs.Reconfigure(ctx, config), test.ShouldBeNil)
+		test.That(t, pending(rs), test.ShouldBeFalse)
+		test.That(t, rs.flush.Packets, test.ShouldEqual, 1)
+	})
+
+	t.Run("Keyframe requests carry across the boundary", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.KeyframeRequest = KeyframeRequestPolicy{Stall: time.Minute}
+		rs, err := newRawSegmenter(config.Storage.StoragePath, config.rawSegmenterOptions(), logger)
+		test.That(t, err, test.ShouldBeNil)
+		requests := 0
+		rs.SetKeyframeRequester(func() { requests++ })
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		write(t, rs, packets[:15])
+
+		config.Storage.Flush = FlushPolicy{OnIDR: true}
+		done := make(chan error, 1)
+		go func() { done <- rs.Reconfigure(context.Background(), config) }()
+		for !pending(rs) {
+			time.Sleep(time.Millisecond)
+		}
+		write(t, rs, packets[15:])
+		test.That(t, <-done, test.ShouldBeNil)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+
+		// Recording didn't resume at the boundary, so nothing after its IDR was dropped.
+		test.That(t, requests, test.ShouldEqual, 0)
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 2)
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, 20)
+		test.That(t, len(frameTimes(t, files[1].name)), test.ShouldEqual, 10)
+	})
+
+	t.Run("Stream deregistered while reconfiguring is closed once reconfigured", func(t *testing.T) {
+		m := NewManager(logger)
+		defer m.Close()
+		config := validRTPConfig(t)
+		vs, err := m.Register("front", config)
+		test.That(t, err, test.ShouldBeNil)
+		rs := vs.Segmenter()
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		write(t, rs, packets[:15])
+
+		config.Storage.Flush = FlushPolicy{OnIDR: true}
+		done := make(chan error, 1)
+		go func() {
+			_, err := m.Reconfigure(context.Background(), "front", config)
+			done <- err
+		}()
+		for !pending(rs) {
+			time.Sleep(time.Millisecond)
+		}
+		test.That(t, m.Deregister("front"), test.ShouldBeNil)
+		test.That(t, m.List(), test.ShouldBeEmpty)
+		write(t, rs, packets[15:21])
+		test.That(t, <-done, test.ShouldWrap, ErrUnknownStream)
+		// The segmenter was closed so it no longer accepts packets.
+		test.That(t, rs.WritePacket(packets[21].payload, packets[21].pts, packets[21].pts, false), test.ShouldNotBeNil)
+	})
+
+	t.Run("Reconfiguring to another stream's storage path is rejected", func(t *testing.T) {
+		m := NewManager(logger)
+		defer m.Close()
+		front := validRTPConfig(t)
+		_, err := m.Register("front", front)
+		test.That(t, err, test.ShouldBeNil)
+		back := validRTPConfig(t)
+		_, err = m.Register("back", back)
+		test.That(t, err, test.ShouldBeNil)
+
+		back.Storage.StoragePath = front.Storage.StoragePath
+		_, err = m.Reconfigure(context.Background(), "back", back)
+		test.That(t, err, test.ShouldWrap, ErrDuplicateStream)
+		_, err = m.Reconfigure(context.Background(), "side", back)
+		test.That(t, err, test.ShouldWrap, ErrUnknownStream)
+	})
+}
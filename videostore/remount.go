@@ -0,0 +1,211 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"golang.org/x/sys/unix"
+)
+
+// defaultRemountInterval is how often storage paths are checked for a remount by default.
+const defaultRemountInterval = 10 * time.Second
+
+// RemountPolicy is how a store notices its storage being remounted while it runs, e.g.
+// when a container restarts and its volume is mounted again at the same path on another
+// device. The storage and record paths are re-validated when the store starts and on
+// every check. Once either is found to be another directory, the store rebuilds what it
+// had cached about the old one: the segment being written is ended and a new one is
+// started on the new directory, and storage growth is measured afresh.
+type RemountPolicy struct {
+	// Interval is how often the paths are checked. 0 uses 10 seconds.
+	Interval time.Duration
+}
+
+// Validate returns an error if the RemountPolicy is invalid.
+func (p RemountPolicy) Validate() error {
+	if p.Interval < 0 {
+		return errors.New("remount interval can't be less than 0")
+	}
+	return nil
+}
+
+func (p RemountPolicy) interval() time.Duration {
+	if p.Interval == 0 {
+		return defaultRemountInterval
+	}
+	return p.Interval
+}
+
+// dirIdentity is the device and inode of a directory, which change when another
+// filesystem is mounted at its path.
+type dirIdentity struct {
+	dev uint64
+	ino uint64
+}
+
+// statDirIdentity returns the identity of the directory at path.
+func statDirIdentity(path string) (dirIdentity, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return dirIdentity{}, &fs.PathError{Op: "stat", Path: path, Err: err}
+	}
+	//nolint:unconvert // Dev is not a uint64 on every platform
+	return dirIdentity{dev: uint64(st.Dev), ino: st.Ino}, nil
+}
+
+// validate returns an error unless path is, or can be created as, a directory the
+// process can write to on a writable filesystem.
+func (w *remountWatcher) validate(path string) error {
+	if err := createDir(path); err != nil {
+		return err
+	}
+	if w.readOnlyFilesystem(path) {
+		return fmt.Errorf("storage path %s: %w", path, ErrReadOnlyStorage)
+	}
+	if err := isWritable(path); err != nil {
+		return fmt.Errorf("storage path %s isn't writable by uid %d: %w", path, os.Geteuid(), err)
+	}
+	return nil
+}
+
+// remountWatcher checks that the directories a store writes to are the ones it started
+// with, and calls rebuild once they have been remounted.
+type remountWatcher struct {
+	logger     logging.Logger
+	identities map[string]dirIdentity
+	// stat returns the identity of a directory, which tests replace to simulate remounts.
+	stat func(path string) (dirIdentity, error)
+	// readOnlyFilesystem returns true if a path is on a read-only filesystem.
+	readOnlyFilesystem func(path string) bool
+	// rebuild resets the store's cached state of the directories after a remount.
+	rebuild func() error
+}
+
+// newRemountWatcher validates paths and records their identities.
+func newRemountWatcher(paths []string, readOnlyFilesystem func(path string) bool, rebuild func() error, logger logging.Logger) (*remountWatcher, error) {
+	w := &remountWatcher{
+		logger:             logger,
+		identities:         map[string]dirIdentity{},
+		stat:               statDirIdentity,
+		readOnlyFilesystem: readOnlyFilesystem,
+		rebuild:            rebuild,
+	}
+	for _, path := range paths {
+		if _, ok := w.identities[path]; ok {
+			continue
+		}
+		if err := w.validate(path); err != nil {
+			return nil, err
+		}
+		id, err := w.stat(path)
+		if err != nil {
+			return nil, err
+		}
+		w.identities[path] = id
+	}
+	return w, nil
+}
+
+// check re-validates any path which has been remounted since the last check and, once
+// every remounted path is usable, rebuilds the store's state. It returns whether the
+// state was rebuilt. Paths which aren't usable yet are checked again next time.
+func (w *remountWatcher) check() (bool, error) {
+	remounted := map[string]dirIdentity{}
+	for path, old := range w.identities {
+		id, err := w.stat(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		if err == nil && id == old {
+			continue
+		}
+		w.logger.Warnf("storage path %s was remounted or replaced, re-validating it", path)
+		if err := w.validate(path); err != nil {
+			return false, fmt.Errorf("remounted storage path is unusable: %w", err)
+		}
+		if id, err = w.stat(path); err != nil {
+			return false, err
+		}
+		remounted[path] = id
+	}
+	if len(remounted) == 0 {
+		return false, nil
+	}
+	for path, id := range remounted {
+		w.identities[path] = id
+	}
+	return true, w.rebuild()
+}
+
+// remountChecker checks the store's storage for a remount every RemountPolicy interval.
+func (vs *videostore) remountChecker(ctx context.Context) {
+	ticker := time.NewTicker(vs.config.Storage.Remount.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rebuilt, err := vs.remounts.check()
+			if err != nil {
+				vs.logger.Error("failed to handle storage remount ", err)
+				continue
+			}
+			if rebuilt {
+				vs.logger.Infof("rebuilt storage state after remount")
+			}
+		}
+	}
+}
+
+// storageRemountWatcher returns a remount watcher of the storage and record paths of vs.
+func (vs *videostore) storageRemountWatcher() (*remountWatcher, error) {
+	paths := []string{vs.config.Storage.StoragePath, vs.config.Storage.recordPath()}
+	return newRemountWatcher(paths, vs.readOnlyFilesystem, vs.rebuildStorageState, vs.logger)
+}
+
+// rebuildStorageState drops what vs has cached about its remounted storage. The segment
+// being written is on the old directory, so it is ended and the next is started on the
+// new one. Segment sizes sampled from the old directory would count every segment on
+// the new one as newly written, so growth is measured from scratch.
+func (vs *videostore) rebuildStorageState() error {
+	if err := createDir(vs.config.Storage.UploadPath); err != nil {
+		return err
+	}
+	if vs.growth != nil {
+		vs.growth.reset()
+	}
+	if vs.encoder != nil {
+		if err := vs.encoder.reopen(); err != nil {
+			return err
+		}
+	}
+	if vs.rawSegmenter != nil {
+		return vs.rawSegmenter.restart()
+	}
+	return nil
+}
+
+// restart ends the segment being written, which is on the directory the storage path
+// was before it was remounted, and starts the next on the storage path as it is now.
+// The new segment can't be decoded until its first IDR.
+func (rs *RawSegmenter) restart() error {
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	if rs.cRawSeg == nil {
+		return nil
+	}
+	if err := rs.close(); err != nil {
+		rs.logger.Warnf("failed to finalize segment on remounted storage: %v", err)
+	}
+	waitForUnusedSegmentName(rs.storagePath)
+	if err := rs.init(rs.codec, rs.width, rs.height); err != nil {
+		return fmt.Errorf("failed to start segment on remounted storage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package videostore
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestRemount(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	// IDRs are at packets 0 and 10.
+	packets := h264Packets(t, frames, framerate)
+	write := func(t *testing.T, rs *RawSegmenter, packets []testPacket) {
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+	}
+	// remount makes the directory at path look to w like it is on another device from now on.
+	remount := func(w *remountWatcher, path string) {
+		w.stat = func(p string) (dirIdentity, error) {
+			id, err := statDirIdentity(p)
+			if p == path {
+				id.dev++
+			}
+			return id, err
+		}
+	}
+
+	t.Run("A device change on the storage path rebuilds the store's state", func(t *testing.T) {
+		config := validRTPConfig(t)
+		vs, err := NewRTPVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		defer vs.Close()
+		store := vs.(*videostore)
+		rs := vs.Segmenter()
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		write(t, rs, packets[:10])
+		test.That(t, store.growth.sample(time.Now()), test.ShouldBeNil)
+		test.That(t, store.growth.sizes, test.ShouldNotBeNil)
+
+		rebuilt, err := store.remounts.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rebuilt, test.ShouldBeFalse)
+
+		remount(store.remounts, config.Storage.StoragePath)
+		rebuilt, err = store.remounts.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rebuilt, test.ShouldBeTrue)
+		test.That(t, store.growth.sizes, test.ShouldBeNil)
+		// The new identity is kept, so the same mount isn't rebuilt again.
+		rebuilt, err = store.remounts.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rebuilt, test.ShouldBeFalse)
+
+		write(t, rs, packets[10:])
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		files, err := getSortedFiles(config.Storage.StoragePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 2)
+		for _, f := range files {
+			test.That(t, len(frameTimes(t, f.name)), test.ShouldEqual, 10)
+			_, err := grayFrameAt(f.name, 0, pHashSize, pHashSize)
+			test.That(t, err, test.ShouldBeNil)
+		}
+	})
+
+	t.Run("A remounted path which can't be written is checked again", func(t *testing.T) {
+		config := validRTPConfig(t)
+		rebuilds := 0
+		w, err := newRemountWatcher([]string{config.Storage.StoragePath}, isReadOnlyFilesystem, func() error {
+			rebuilds++
+			return nil
+		}, logger)
+		test.That(t, err, test.ShouldBeNil)
+
+		remount(w, config.Storage.StoragePath)
+		w.readOnlyFilesystem = func(path string) bool { return path == config.Storage.StoragePath }
+		_, err = w.check()
+		test.That(t, err, test.ShouldWrap, ErrReadOnlyStorage)
+		test.That(t, rebuilds, test.ShouldEqual, 0)
+
+		w.readOnlyFilesystem = isReadOnlyFilesystem
+		rebuilt, err := w.check()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rebuilt, test.ShouldBeTrue)
+		test.That(t, rebuilds, test.ShouldEqual, 1)
+	})
+
+	t.Run("A storage path which isn't writable fails at startup", func(t *testing.T) {
+		config := validRTPConfig(t)
+		readOnly := func(path string) bool { return path == config.Storage.StoragePath }
+		_, err := newRemountWatcher([]string{config.Storage.StoragePath}, readOnly, func() error { return nil }, logger)
+		test.That(t, err, test.ShouldWrap, ErrReadOnlyStorage)
+	})
+
+	t.Run("Negative intervals are invalid", func(t *testing.T) {
+		test.That(t, RemountPolicy{Interval: -time.Second}.Validate(), test.ShouldNotBeNil)
+		test.That(t, RemountPolicy{}.interval(), test.ShouldEqual, defaultRemountInterval)
+	})
+}
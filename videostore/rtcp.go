@@ -0,0 +1,131 @@
+package videostore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// rtcpSenderReportType is the RTCP packet type of a sender report.
+	rtcpSenderReportType = 200
+	// rtcpSenderReportLength is the length of a sender report without report blocks.
+	rtcpSenderReportLength = 28
+	// ntpEpochOffset is the seconds from the NTP epoch, 1900, to the unix epoch.
+	ntpEpochOffset = 2208988800
+)
+
+// SegmentTimestampPolicy is how the start times of segments written from RTP, which
+// they are named by and which exports and fetches match time ranges against, are found.
+type SegmentTimestampPolicy int
+
+const (
+	// SegmentTimestampArrival names each segment by the second it is opened, off by the
+	// latency from the camera to the segmenter.
+	SegmentTimestampArrival SegmentTimestampPolicy = iota
+	// SegmentTimestampRTCP names each segment by the wall clock time its first frame was
+	// captured, to the millisecond, mapped from its RTP timestamp by the latest RTCP
+	// sender report passed to RawSegmenter.WriteRTCP. A source which syncs its clock over
+	// NTP then has segments matching other sources in time. Segments fall back to
+	// SegmentTimestampArrival until a sender report is received.
+	SegmentTimestampRTCP
+)
+
+// Validate returns an error if the SegmentTimestampPolicy is invalid.
+func (p SegmentTimestampPolicy) Validate() error {
+	if p != SegmentTimestampArrival && p != SegmentTimestampRTCP {
+		return fmt.Errorf("invalid segment timestamp policy %d", p)
+	}
+	return nil
+}
+
+// SenderReport is the mapping from a source's RTP timestamps to its wall clock in an
+// RTCP sender report: RTPTimestamp is the RTP timestamp of the moment NTPTime.
+type SenderReport struct {
+	SSRC         uint32
+	NTPTime      time.Time
+	RTPTimestamp uint32
+}
+
+// parseSenderReports returns the sender reports in the compound RTCP packet b, skipping
+// its other packets.
+func parseSenderReports(b []byte) ([]SenderReport, error) {
+	var reports []SenderReport
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("truncated RTCP header")
+		}
+		if b[0]>>6 != rtpVersion {
+			return nil, fmt.Errorf("invalid RTCP version %d", b[0]>>6)
+		}
+		length := 4 * (int(binary.BigEndian.Uint16(b[2:])) + 1)
+		if length > len(b) {
+			return nil, fmt.Errorf("RTCP packet length %d is longer than the %d bytes left", length, len(b))
+		}
+		if b[1] == rtcpSenderReportType {
+			if length < rtcpSenderReportLength {
+				return nil, errors.New("truncated RTCP sender report")
+			}
+			reports = append(reports, SenderReport{
+				SSRC:         binary.BigEndian.Uint32(b[4:]),
+				NTPTime:      ntpTime(binary.BigEndian.Uint64(b[8:])),
+				RTPTimestamp: binary.BigEndian.Uint32(b[16:]),
+			})
+		}
+		b = b[length:]
+	}
+	return reports, nil
+}
+
+// ntpTime returns the time of a 64 bit NTP timestamp, seconds since 1900 in the high 32
+// bits and fractions of a second in the low.
+func ntpTime(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanoseconds := int64((ntp & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
+
+// wallClock returns the wall clock time of the RTP timestamp pts by the sender report.
+// Only the low 32 bits of pts are used, like the RTP timestamps in the report, so pts
+// may be extended past wraparound or not. The report must be within half the range of
+// RTP timestamps, about 6.6 hours at rtpClockRate, of pts.
+func (sr SenderReport) wallClock(pts int64) time.Time {
+	ticks := int64(int32(uint32(pts) - sr.RTPTimestamp))
+	return sr.NTPTime.Add(time.Duration(ticks) * time.Second / rtpClockRate)
+}
+
+// WriteRTCP passes the RTCP packets received alongside the packets passed to WritePacket
+// to the segmenter. Their sender reports map the PTS of packets, which must be their RTP
+// timestamps, to the source's wall clock under SegmentTimestampRTCP. Other RTCP packets
+// are ignored, as are the packets of a source with a different SSRC to the first sender
+// report's, e.g. an audio stream sharing the session.
+func (rs *RawSegmenter) WriteRTCP(packet []byte) error {
+	reports, err := parseSenderReports(packet)
+	if err != nil {
+		return fmt.Errorf("failed to parse RTCP packet: %w", err)
+	}
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	for _, sr := range reports {
+		if rs.senderReport != nil && rs.senderReport.SSRC != sr.SSRC {
+			continue
+		}
+		if rs.senderReport == nil {
+			rs.logger.Infof("received RTCP sender report from SSRC %d, mapping RTP timestamps to its wall clock", sr.SSRC)
+		}
+		rs.senderReport = &sr
+	}
+	return nil
+}
+
+// WallClock returns the wall clock time of the packet with RTP timestamp pts by the
+// latest RTCP sender report, or false if no sender report has been received.
+func (rs *RawSegmenter) WallClock(pts int64) (time.Time, bool) {
+	rs.cRawSegMu.Lock()
+	defer rs.cRawSegMu.Unlock()
+	if rs.senderReport == nil {
+		return time.Time{}, false
+	}
+	return rs.senderReport.wallClock(pts), true
+}
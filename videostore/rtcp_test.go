@@ -0,0 +1,111 @@
+package videostore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// rtcpSenderReport returns a compound RTCP packet of a sender report from ssrc mapping
+// rtpTimestamp to ntp, with a reception report block, followed by an SDES packet.
+func rtcpSenderReport(ssrc uint32, ntp time.Time, rtpTimestamp uint32) []byte {
+	seconds := uint64(ntp.Unix() + ntpEpochOffset)
+	fraction := uint64(ntp.Nanosecond()) << 32 / uint64(time.Second)
+	b := []byte{0x80 | 1, rtcpSenderReportType, 0, 12}
+	b = binary.BigEndian.AppendUint32(b, ssrc)
+	b = binary.BigEndian.AppendUint64(b, seconds<<32|fraction)
+	b = binary.BigEndian.AppendUint32(b, rtpTimestamp)
+	// Packet and octet counts, then the report block.
+	b = append(b, make([]byte, 8+24)...)
+	b = append(b, 0x80|1, 202, 0, 2)
+	b = binary.BigEndian.AppendUint32(b, ssrc)
+	return append(b, 1, 2, 'v', 's')
+}
+
+func TestRTCPSegmentTimestamps(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	packets := h264Packets(t, frames, framerate)
+	newSegmenter := func(t *testing.T, storagePath string) *RawSegmenter {
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{segmentTimestamps: SegmentTimestampRTCP}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		return rs
+	}
+	// The camera captured the first frame an hour ago, well before it arrives.
+	captured := time.Now().Add(-time.Hour).Truncate(time.Second).Add(250 * time.Millisecond)
+	const base = 1 << 20
+
+	t.Run("Sender reports map RTP timestamps to the wall clock", func(t *testing.T) {
+		reports, err := parseSenderReports(rtcpSenderReport(0x1234, captured, 0xffffff00))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(reports), test.ShouldEqual, 1)
+		sr := reports[0]
+		test.That(t, sr.SSRC, test.ShouldEqual, uint32(0x1234))
+		test.That(t, sr.RTPTimestamp, test.ShouldEqual, uint32(0xffffff00))
+		test.That(t, sr.NTPTime.Sub(captured).Abs(), test.ShouldBeLessThan, time.Microsecond)
+		test.That(t, sr.wallClock(0xffffff00).Equal(sr.NTPTime), test.ShouldBeTrue)
+		// Timestamps past wraparound map forward, extended or not.
+		later := sr.NTPTime.Add(2 * time.Second)
+		test.That(t, sr.wallClock(0xffffff00+2*rtpClockRate).Equal(later), test.ShouldBeTrue)
+		test.That(t, sr.wallClock((0xffffff00+2*rtpClockRate)%(1<<32)).Equal(later), test.ShouldBeTrue)
+		test.That(t, sr.wallClock(0xffffff00-rtpClockRate/10).Equal(sr.NTPTime.Add(-100*time.Millisecond)), test.ShouldBeTrue)
+	})
+
+	t.Run("Segments are named by the wall clock time of their first frame", func(t *testing.T) {
+		storagePath := t.TempDir()
+		rs := newSegmenter(t, storagePath)
+		_, ok := rs.WallClock(base)
+		test.That(t, ok, test.ShouldBeFalse)
+		test.That(t, rs.WriteRTCP(rtcpSenderReport(0x1234, captured, base)), test.ShouldBeNil)
+		// Reports from another source in the session are ignored.
+		test.That(t, rs.WriteRTCP(rtcpSenderReport(0x5678, captured.Add(time.Minute), base)), test.ShouldBeNil)
+		wall, ok := rs.WallClock(base + rtpClockRate)
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, wall.Sub(captured.Add(time.Second)).Abs(), test.ShouldBeLessThan, time.Microsecond)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, base+p.pts, base+p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, filepath.Base(files[0].name), test.ShouldEqual, fmt.Sprintf("%d.250.mp4", captured.Unix()))
+		test.That(t, files[0].startTime.Equal(captured), test.ShouldBeTrue)
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, len(frames))
+	})
+
+	t.Run("Segments fall back to their arrival time without sender reports", func(t *testing.T) {
+		storagePath := t.TempDir()
+		rs := newSegmenter(t, storagePath)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, base+p.pts, base+p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, time.Since(files[0].startTime), test.ShouldBeLessThan, time.Minute)
+	})
+
+	t.Run("Invalid RTCP is rejected", func(t *testing.T) {
+		rs := newSegmenter(t, t.TempDir())
+		defer rs.Close()
+		sr := rtcpSenderReport(0x1234, captured, base)
+		test.That(t, rs.WriteRTCP(sr[:20]), test.ShouldNotBeNil)
+		sr[0] = 1 << 6
+		test.That(t, rs.WriteRTCP(sr), test.ShouldNotBeNil)
+		test.That(t, SegmentTimestampPolicy(-1).Validate(), test.ShouldNotBeNil)
+		test.That(t, (SegmentTimestampRTCP + 1).Validate(), test.ShouldNotBeNil)
+	})
+}
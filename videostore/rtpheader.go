@@ -0,0 +1,136 @@
+package videostore
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	rtpVersion         = 2
+	rtpFixedHeaderSize = 12
+	// rtpExtensionHeaderSize is the profile and length words before a header extension.
+	rtpExtensionHeaderSize = 4
+)
+
+// annexBStartCode prefixes each NAL unit of an Annex B access unit.
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// RTPHeaderPolicy is how RawSegmenter.WritePacket handles a payload which is a whole RTP
+// packet, header included, rather than the depacketized access unit it expects. Annex B
+// access units start with a zero byte, while the first byte of an RTP header carries
+// version 2 in its top bits, so the two are never confused.
+type RTPHeaderPolicy int
+
+const (
+	// RTPHeaderReject returns ErrRTPPacket without writing the packet.
+	RTPHeaderReject RTPHeaderPolicy = iota
+	// RTPHeaderStrip strips the header, extensions and padding and writes the NAL units
+	// the packet carries, for sources which send one access unit per RTP packet. Packets
+	// carrying fragments of a NAL unit still return ErrRTPPacket, as they can only be
+	// written once depacketized together.
+	RTPHeaderStrip
+	// RTPHeaderIgnore writes payloads as they are, without checking for RTP headers.
+	RTPHeaderIgnore
+)
+
+// Validate returns an error if the RTPHeaderPolicy is invalid.
+func (p RTPHeaderPolicy) Validate() error {
+	if p < RTPHeaderReject || p > RTPHeaderIgnore {
+		return fmt.Errorf("invalid rtp header policy %d", p)
+	}
+	return nil
+}
+
+// rtpPayload returns the payload of b if b is an RTP packet, with the header, CSRCs,
+// header extension and padding removed, and whether it is one.
+func rtpPayload(b []byte) ([]byte, bool) {
+	if len(b) <= rtpFixedHeaderSize || b[0]>>6 != rtpVersion {
+		return nil, false
+	}
+	start := rtpFixedHeaderSize + 4*int(b[0]&0x0f)
+	if b[0]&0x10 != 0 {
+		if len(b) < start+rtpExtensionHeaderSize {
+			return nil, false
+		}
+		words := int(binary.BigEndian.Uint16(b[start+2:]))
+		start += rtpExtensionHeaderSize + 4*words
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 {
+		end -= int(b[end-1])
+	}
+	if start >= end {
+		return nil, false
+	}
+	return b[start:end], true
+}
+
+// annexB returns the NAL units of an RTP payload in codec as an Annex B access unit. A
+// payload is a single NAL unit, or an aggregation packet of several, which are each
+// prefixed with a start code. It returns false for fragmentation units, which hold part
+// of a NAL unit.
+func annexB(codec CodecType, payload []byte) ([]byte, bool) {
+	var lengthPrefixed []byte
+	switch codec {
+	case CodecTypeH264:
+		switch payload[0] & 0x1f {
+		case 24: // STAP-A
+			lengthPrefixed = payload[1:]
+		case 25, 26, 27, 28, 29: // STAP-B, MTAP16, MTAP24, FU-A, FU-B
+			return nil, false
+		}
+	case CodecTypeH265:
+		if len(payload) < 2 {
+			return nil, false
+		}
+		switch (payload[0] >> 1) & 0x3f {
+		case 48: // AP
+			lengthPrefixed = payload[2:]
+		case 49: // FU
+			return nil, false
+		}
+	case CodecTypeUnknown:
+		return nil, false
+	}
+	if lengthPrefixed == nil {
+		return append(append([]byte{}, annexBStartCode...), payload...), true
+	}
+	var au []byte
+	for len(lengthPrefixed) > 0 {
+		if len(lengthPrefixed) < 2 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint16(lengthPrefixed))
+		if n == 0 || len(lengthPrefixed) < 2+n {
+			return nil, false
+		}
+		au = append(append(au, annexBStartCode...), lengthPrefixed[2:2+n]...)
+		lengthPrefixed = lengthPrefixed[2+n:]
+	}
+	return au, true
+}
+
+// checkRTPHeader applies the segmenter's RTPHeaderPolicy to payload and returns what to
+// write in its place.
+// cRawSegMu must be held.
+func (rs *RawSegmenter) checkRTPHeader(payload []byte, pts int64) ([]byte, error) {
+	if rs.rtpHeader == RTPHeaderIgnore {
+		return payload, nil
+	}
+	rtp, ok := rtpPayload(payload)
+	if !ok {
+		return payload, nil
+	}
+	if rs.rtpHeader == RTPHeaderReject {
+		return nil, fmt.Errorf("failed to write packet with pts %d: %w", pts, ErrRTPPacket)
+	}
+	au, ok := annexB(rs.codec, rtp)
+	if !ok {
+		return nil, fmt.Errorf("failed to write packet with pts %d: %w carrying a fragment of a NAL unit", pts, ErrRTPPacket)
+	}
+	if !rs.rtpHeaderStripped {
+		rs.rtpHeaderStripped = true
+		rs.logger.Warnf("payloads passed to WritePacket are RTP packets, stripping their headers")
+	}
+	return au, nil
+}
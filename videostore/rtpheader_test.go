@@ -0,0 +1,106 @@
+package videostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestRTPHeader(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const framerate = 10
+	frames := make([][]byte, 2*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	packets := h264Packets(t, frames, framerate)
+	newSegmenter := func(t *testing.T, storagePath string, policy RTPHeaderPolicy) *RawSegmenter {
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{rtpHeader: policy}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		return rs
+	}
+	// rtpPacket returns payload behind an RTP header with a CSRC, a header extension and
+	// padding, as a source which doesn't depacketize would pass it on.
+	rtpPacket := func(seq uint16, pts int64, payload []byte) []byte {
+		const padding = 3
+		b := []byte{0x80 | 0x20 | 0x10 | 1, 96}
+		b = binary.BigEndian.AppendUint16(b, seq)
+		b = binary.BigEndian.AppendUint32(b, uint32(pts))
+		b = binary.BigEndian.AppendUint32(b, 0x1234)
+		b = binary.BigEndian.AppendUint32(b, 0x5678)
+		b = append(b, 0xbe, 0xde, 0, 1, 0x10, 0xff, 0, 0)
+		b = append(b, payload...)
+		return append(b, 0, 0, padding)
+	}
+	// stapA returns the NAL units of an Annex B access unit as a STAP-A payload.
+	stapA := func(au []byte) []byte {
+		b := []byte{24}
+		for _, nal := range bytes.Split(au, annexBStartCode)[1:] {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(nal)))
+			b = append(b, nal...)
+		}
+		return b
+	}
+
+	t.Run("RTP packets are detected and rejected by default", func(t *testing.T) {
+		rs := newSegmenter(t, t.TempDir(), RTPHeaderReject)
+		p := packets[0]
+		err := rs.WritePacket(rtpPacket(0, p.pts, stapA(p.payload)), p.pts, p.pts, p.isIDR)
+		test.That(t, err, test.ShouldWrap, ErrRTPPacket)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+	})
+
+	t.Run("Stripped RTP packets are written as access units", func(t *testing.T) {
+		storagePath := t.TempDir()
+		rs := newSegmenter(t, storagePath, RTPHeaderStrip)
+		for i, p := range packets {
+			payload := stapA(p.payload)
+			if nals := bytes.Split(p.payload, annexBStartCode)[1:]; len(nals) == 1 {
+				// Access units of a single NAL unit are sent as is.
+				payload = nals[0]
+			}
+			test.That(t, rs.WritePacket(rtpPacket(uint16(i), p.pts, payload), p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		files, err := getSortedFiles(storagePath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+		test.That(t, len(frameTimes(t, files[0].name)), test.ShouldEqual, len(frames))
+		_, err = grayFrameAt(files[0].name, 0, pHashSize, pHashSize)
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("Fragmentation units can't be stripped", func(t *testing.T) {
+		rs := newSegmenter(t, t.TempDir(), RTPHeaderStrip)
+		p := packets[0]
+		fuA := append([]byte{28, 0x80 | 5}, p.payload[len(annexBStartCode)+1:]...)
+		err := rs.WritePacket(rtpPacket(0, p.pts, fuA), p.pts, p.pts, p.isIDR)
+		test.That(t, err, test.ShouldWrap, ErrRTPPacket)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+	})
+
+	t.Run("Payloads aren't checked when ignored", func(t *testing.T) {
+		rs := newSegmenter(t, t.TempDir(), RTPHeaderIgnore)
+		p := packets[0]
+		test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		p = packets[1]
+		test.That(t, rs.WritePacket(rtpPacket(1, p.pts, stapA(p.payload)), p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		test.That(t, rs.Close(), test.ShouldBeNil)
+	})
+
+	t.Run("Annex B access units aren't RTP packets", func(t *testing.T) {
+		for _, p := range packets {
+			_, ok := rtpPayload(p.payload)
+			test.That(t, ok, test.ShouldBeFalse)
+		}
+		test.That(t, RTPHeaderPolicy(-1).Validate(), test.ShouldNotBeNil)
+		test.That(t, (RTPHeaderIgnore + 1).Validate(), test.ShouldNotBeNil)
+	})
+}
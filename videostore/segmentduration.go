@@ -0,0 +1,258 @@
+package videostore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxSegmentPathLength is the longest path of a segment, Linux's PATH_MAX.
+const maxSegmentPathLength = 4096
+
+var errExactSegmentDurationFlush = errors.New("exact segment durations can't be combined with a flush policy")
+
+// SegmentDurationPolicy is how the durations segments written from RTP report are kept.
+// Segments roll over at the first IDR after segmentSeconds, so each holds whole GOPs and
+// runs up to a GOP longer, or after a gap in IDRs shorter, than segmentSeconds.
+type SegmentDurationPolicy int
+
+const (
+	// SegmentDurationKeyframe reports each segment's duration as the footage it holds, so
+	// durations jitter by up to a GOP from segment to segment.
+	SegmentDurationKeyframe SegmentDurationPolicy = iota
+	// SegmentDurationExact pads or trims the duration of each segment completed by rolling
+	// over to exactly segmentSeconds in its mp4 headers, for consumers which expect evenly
+	// sized segments. The footage is kept intact: a segment still holds every frame
+	// written to it, so up to a GOP of its frames plays past or short of the duration it
+	// reports, and playing segments back to back by their reported durations can skip or
+	// repeat footage at the boundaries. Segments more than half of segmentSeconds off,
+	// e.g. after the source stalled, and the segment ended by Close or a reconfigure keep
+	// their actual duration. It can't be combined with a flush policy, as fragmented
+	// segments have no duration in their headers to adjust.
+	SegmentDurationExact
+)
+
+// segmentDurations tracks the completed segments whose durations are being set, which
+// aren't complete until they have been. A nil segmentDurations tracks none.
+type segmentDurations struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	setting map[string]struct{}
+}
+
+func newSegmentDurations() *segmentDurations {
+	d := &segmentDurations{setting: map[string]struct{}{}}
+	d.changed = sync.NewCond(&d.mu)
+	return d
+}
+
+// start records that the duration of the segment at path is being set.
+func (d *segmentDurations) start(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setting[absPath(path)] = struct{}{}
+}
+
+// done records that the duration of the segment at path has been set.
+func (d *segmentDurations) done(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.setting, absPath(path))
+	d.changed.Broadcast()
+}
+
+// pending returns whether the duration of the segment at path is being set.
+func (d *segmentDurations) pending(path string) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.setting[absPath(path)]
+	return ok
+}
+
+// wait waits until the duration of the segment at path has been set.
+func (d *segmentDurations) wait(path string) {
+	if d == nil {
+		return
+	}
+	path = absPath(path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		if _, ok := d.setting[path]; !ok {
+			return
+		}
+		d.changed.Wait()
+	}
+}
+
+// absPath returns the absolute path of path, or path if it has none.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Validate returns an error if the SegmentDurationPolicy is invalid.
+func (p SegmentDurationPolicy) Validate() error {
+	if p != SegmentDurationKeyframe && p != SegmentDurationExact {
+		return fmt.Errorf("invalid segment duration policy %d", p)
+	}
+	return nil
+}
+
+// setSegmentDuration sets the duration the mp4 at path reports in its movie and track
+// headers and edit lists to d, leaving its samples untouched. The media headers, which
+// hold the duration of the samples, are kept as the samples are. Returns whether the
+// duration was set, which it isn't if the actual duration is more than tolerance from d.
+func setSegmentDuration(path string, d, tolerance time.Duration) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	offset, size, err := findTopLevelBox(f, "moov")
+	if err != nil {
+		return false, err
+	}
+	moov := make([]byte, size)
+	if _, err := f.ReadAt(moov, offset); err != nil {
+		return false, fmt.Errorf("failed to read moov: %w", err)
+	}
+	mvhd := childBox(moov[8:], "mvhd")
+	if len(mvhd) < 32 {
+		return false, errors.New("segment has no movie header")
+	}
+	// mvhd's timescale follows the creation and modification times, and is followed by
+	// the duration.
+	durationOffset, durationSize := 16, 4
+	if mvhd[0] == 1 {
+		durationOffset, durationSize = 24, 8
+	}
+	timescale := int64(binary.BigEndian.Uint32(mvhd[durationOffset-4:]))
+	if timescale == 0 {
+		return false, errors.New("segment has no timescale")
+	}
+	actual := time.Duration(readBoxUint(mvhd[durationOffset:], durationSize) * int64(time.Second) / timescale)
+	if actual == 0 || (actual-d).Abs() > tolerance {
+		return false, nil
+	}
+	duration := int64(d) * timescale / int64(time.Second)
+	writeBoxUint(mvhd[durationOffset:], durationSize, duration)
+	// tkhd durations are in the movie's timescale too, after the track ID and a reserved
+	// word.
+	for _, trak := range childBoxes(moov[8:], "trak") {
+		tkhd := childBox(trak, "tkhd")
+		trackOffset, trackSize := 20, 4
+		if len(tkhd) > 0 && tkhd[0] == 1 {
+			trackOffset, trackSize = 28, 8
+		}
+		if len(tkhd) >= trackOffset+trackSize {
+			writeBoxUint(tkhd[trackOffset:], trackSize, duration)
+		}
+		// Players play each track for its edit list, also in the movie's timescale.
+		setEditListDuration(childBox(childBox(trak, "edts"), "elst"), duration)
+	}
+	if _, err := f.WriteAt(moov, offset); err != nil {
+		return false, fmt.Errorf("failed to write moov: %w", err)
+	}
+	return true, nil
+}
+
+// setEditListDuration sets the segment durations of the edit list elst to add up to
+// duration by changing its last edit, keeping any empty edits before it which delay the
+// start of the track.
+func setEditListDuration(elst []byte, duration int64) {
+	if len(elst) < 8 {
+		return
+	}
+	// Each edit is a segment duration and media time, followed by the media rate.
+	entrySize, fieldSize := 12, 4
+	if elst[0] == 1 {
+		entrySize, fieldSize = 20, 8
+	}
+	count := int(binary.BigEndian.Uint32(elst[4:]))
+	entries := elst[8:]
+	if count == 0 || len(entries) < count*entrySize {
+		return
+	}
+	var before int64
+	for i := range count - 1 {
+		before += readBoxUint(entries[i*entrySize:], fieldSize)
+	}
+	if last := duration - before; last > 0 {
+		writeBoxUint(entries[(count-1)*entrySize:], fieldSize, last)
+	}
+}
+
+// findTopLevelBox returns the offset and size of the first top level box of type typ in f.
+func findTopLevelBox(f *os.File, typ string) (int64, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= info.Size(); {
+		if n, err := f.ReadAt(header, offset); n < 8 {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		if size == 1 {
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < 8 || offset+size > info.Size() {
+			break
+		}
+		if string(header[4:8]) == typ {
+			return offset, size, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("segment has no %s", typ)
+}
+
+// childBoxes returns the payload, after the size and type, of each box of type typ in b.
+func childBoxes(b []byte, typ string) [][]byte {
+	var boxes [][]byte
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			break
+		}
+		if string(b[4:8]) == typ {
+			boxes = append(boxes, b[8:size])
+		}
+		b = b[size:]
+	}
+	return boxes
+}
+
+// childBox returns the payload of the first box of type typ in b, nil if there is none.
+func childBox(b []byte, typ string) []byte {
+	if boxes := childBoxes(b, typ); len(boxes) > 0 {
+		return boxes[0]
+	}
+	return nil
+}
+
+func readBoxUint(b []byte, size int) int64 {
+	if size == 8 {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return int64(binary.BigEndian.Uint32(b))
+}
+
+func writeBoxUint(b []byte, size int, v int64) {
+	if size == 8 {
+		binary.BigEndian.PutUint64(b, uint64(v))
+		return
+	}
+	binary.BigEndian.PutUint32(b, uint32(v))
+}
@@ -0,0 +1,140 @@
+package videostore
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestSegmentDuration(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	const (
+		framerate = 10
+		target    = 2 * time.Second
+		// The source's frames are 130ms apart and its IDRs 1.3s apart, so keyframe aligned
+		// segments are 2.6s, or 1.3s when an IDR lands just past the rollover.
+		stretch = 1.3
+		frame   = time.Duration(stretch * float64(time.Second) / framerate)
+	)
+	frames := make([][]byte, 7*framerate)
+	for i := range frames {
+		frames[i] = patternJPEG(t, i%2)
+	}
+	packets := h264Packets(t, frames, framerate)
+	for i := range packets {
+		packets[i].pts = int64(float64(packets[i].pts) * stretch)
+	}
+	// editListDuration returns how long the edit list of the first track of the mp4 at path
+	// plays it for.
+	editListDuration := func(t *testing.T, path string) time.Duration {
+		f, err := os.Open(path)
+		test.That(t, err, test.ShouldBeNil)
+		defer f.Close()
+		offset, size, err := findTopLevelBox(f, "moov")
+		test.That(t, err, test.ShouldBeNil)
+		moov := make([]byte, size)
+		_, err = f.ReadAt(moov, offset)
+		test.That(t, err, test.ShouldBeNil)
+		mvhd := childBox(moov[8:], "mvhd")
+		test.That(t, mvhd[0], test.ShouldEqual, 0)
+		timescale := int64(binary.BigEndian.Uint32(mvhd[12:]))
+		elst := childBox(childBox(childBox(moov[8:], "trak"), "edts"), "elst")
+		test.That(t, len(elst), test.ShouldBeGreaterThanOrEqualTo, 8)
+		test.That(t, elst[0], test.ShouldEqual, 0)
+		var total int64
+		for i := range int(binary.BigEndian.Uint32(elst[4:])) {
+			total += int64(binary.BigEndian.Uint32(elst[8+12*i:]))
+		}
+		return time.Duration(total * int64(time.Second) / timescale)
+	}
+	// record writes packets, waiting for a new second each time a segment rolls over as
+	// segments are named by the second they start in, and returns the segments' durations.
+	record := func(t *testing.T, policy SegmentDurationPolicy) []time.Duration {
+		dir := t.TempDir()
+		rs, err := newRawSegmenter(dir, rawSegmenterOptions{segmentDuration: policy}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		rs.segmentSeconds = int(target.Seconds())
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		next := int64(target.Seconds() * 90000)
+		for _, p := range packets {
+			if p.isIDR && p.pts >= next {
+				waitForUnusedSegmentName(dir)
+				for next <= p.pts {
+					next += int64(target.Seconds() * 90000)
+				}
+			}
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+
+		files, err := getSortedFiles(dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 4)
+		var durations []time.Duration
+		written := 0
+		for _, f := range files {
+			info, err := getVideoInfo(f.name)
+			test.That(t, err, test.ShouldBeNil)
+			durations = append(durations, info.duration)
+			// The edit list plays the track for as long as the segment reports.
+			test.That(t, (editListDuration(t, f.name) - info.duration).Abs(), test.ShouldBeLessThanOrEqualTo, frame)
+			written += len(frameTimes(t, f.name))
+		}
+		// Every frame is kept whatever the segments report.
+		test.That(t, written, test.ShouldEqual, len(packets))
+		return durations
+	}
+
+	t.Run("Keyframe aligned segments jitter by up to a GOP", func(t *testing.T) {
+		durations := record(t, SegmentDurationKeyframe)
+		test.That(t, (durations[0] - target).Abs(), test.ShouldBeGreaterThan, frame)
+		test.That(t, (durations[2] - target).Abs(), test.ShouldBeGreaterThan, frame)
+	})
+
+	t.Run("Exact segments report the segment duration within a frame", func(t *testing.T) {
+		durations := record(t, SegmentDurationExact)
+		// The last segment was ended by Close, so it keeps its duration.
+		for _, d := range durations[:len(durations)-1] {
+			test.That(t, (d - target).Abs(), test.ShouldBeLessThanOrEqualTo, frame)
+		}
+	})
+
+	t.Run("Durations far from the target are left alone", func(t *testing.T) {
+		dir := t.TempDir()
+		rs, err := newRawSegmenter(dir, rawSegmenterOptions{}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		for _, p := range packets {
+			test.That(t, rs.WritePacket(p.payload, p.pts, p.pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		files, err := getSortedFiles(dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 1)
+
+		// The segment holds 9.1s of footage.
+		set, err := setSegmentDuration(files[0].name, target, target/2)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, set, test.ShouldBeFalse)
+		set, err = setSegmentDuration(files[0].name, 9*time.Second, time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, set, test.ShouldBeTrue)
+		info, err := getVideoInfo(files[0].name)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.duration, test.ShouldEqual, 9*time.Second)
+		test.That(t, editListDuration(t, files[0].name), test.ShouldEqual, 9*time.Second)
+	})
+
+	t.Run("Invalid policies are rejected", func(t *testing.T) {
+		test.That(t, SegmentDurationPolicy(2).Validate(), test.ShouldNotBeNil)
+		config := validRTPConfig(t)
+		config.SegmentDuration = SegmentDurationExact
+		test.That(t, config.Validate(), test.ShouldBeNil)
+		config.Storage.Flush = FlushPolicy{OnIDR: true}
+		test.That(t, config.Validate(), test.ShouldBeError, errExactSegmentDurationFlush)
+	})
+}
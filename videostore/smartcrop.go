@@ -0,0 +1,155 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// smartCropFrameWidth and smartCropFrameHeight are the size frames are scaled down to
+	// before being compared to find where the motion is.
+	smartCropFrameWidth  = 160
+	smartCropFrameHeight = 120
+	// smartCropNoise is the luma difference, out of 255, a pixel must change by between
+	// samples to count as motion rather than sensor noise.
+	smartCropNoise = 24
+	// maxSmartCropSamples is the most frames sampled for motion, spreading them further
+	// apart than defaultActivitySampleInterval over long ranges.
+	maxSmartCropSamples = 120
+)
+
+// SmartCrop crops an export to an aspect ratio, e.g. 9:16 for vertical clips from
+// landscape footage, keeping the full height or width of the frame so nothing is
+// letterboxed. The crop is centered on the detections of interest when the request has
+// Detections, otherwise on the motion across the range, falling back to Fallback when
+// there is neither. The crop is fixed for the whole export, it doesn't pan.
+type SmartCrop struct {
+	AspectWidth  int
+	AspectHeight int
+	// Fallback, if set, is the point in pixels of the source frame to center the crop on
+	// when there is no motion. nil centers it in the frame.
+	Fallback *image.Point
+}
+
+// Validate returns an error if the SmartCrop is invalid.
+func (c *SmartCrop) Validate() error {
+	if c.AspectWidth <= 0 || c.AspectHeight <= 0 {
+		return errors.New("smart crop aspect ratio must be greater than 0")
+	}
+	if f := c.Fallback; f != nil && (f.X < 0 || f.Y < 0) {
+		return errors.New("smart crop fallback x and y can't be less than 0")
+	}
+	return nil
+}
+
+// region returns the largest region with c's aspect ratio of a frame of size, centered
+// as close to center as the frame allows, in even coordinates as exports are encoded as
+// yuv420p.
+func (c *SmartCrop) region(center, size image.Point) (CropRegion, error) {
+	width, height := size.X&^1, size.Y&^1
+	if width*c.AspectHeight > height*c.AspectWidth {
+		width = (height * c.AspectWidth / c.AspectHeight) &^ 1
+	} else {
+		height = (width * c.AspectHeight / c.AspectWidth) &^ 1
+	}
+	if width == 0 || height == 0 {
+		return CropRegion{}, fmt.Errorf("smart crop aspect ratio %d:%d is too extreme for the %dx%d frame",
+			c.AspectWidth, c.AspectHeight, size.X, size.Y)
+	}
+	x := min(max(center.X-width/2, 0), size.X-width) &^ 1
+	y := min(max(center.Y-height/2, 0), size.Y-height) &^ 1
+	return CropRegion{X: x, Y: y, Width: width, Height: height}, nil
+}
+
+// motionCenter returns the centroid of the motion between from and to in the video in
+// files, in pixels of a frame of size, weighting each pixel by how much it changes
+// between samples. Samples which can't be decoded are skipped. ok is false if nothing moved.
+func motionCenter(ctx context.Context, files []fileWithDate, from, to time.Time, size image.Point, logger logging.Logger) (image.Point, bool, error) {
+	interval := max(defaultActivitySampleInterval, to.Sub(from)/maxSmartCropSamples)
+	var (
+		sumX, sumY, total float64
+		previous          []byte
+		infos             = videoInfoCache{}
+	)
+	for t := from; t.Before(to); t = t.Add(interval) {
+		if err := ctx.Err(); err != nil {
+			return image.Point{}, false, err
+		}
+		segment, offset, _, ok := infos.segmentAt(files, t)
+		if !ok {
+			// Don't compare across gaps in the video.
+			previous = nil
+			continue
+		}
+		gray, err := grayFrameAt(segment, offset, smartCropFrameWidth, smartCropFrameHeight)
+		if err != nil {
+			// The crop is found from the frames which can be decoded.
+			logger.Warnf("skipping smart crop sample of %s at %s: %v", segment, offset, err)
+			previous = nil
+			continue
+		}
+		if previous != nil {
+			for i := range gray {
+				d := int(gray[i]) - int(previous[i])
+				if d = max(d, -d); d > smartCropNoise {
+					sumX += float64(d) * (float64(i%smartCropFrameWidth) + 0.5)
+					sumY += float64(d) * (float64(i/smartCropFrameWidth) + 0.5)
+					total += float64(d)
+				}
+			}
+		}
+		previous = gray
+	}
+	if total == 0 {
+		return image.Point{}, false, nil
+	}
+	return image.Pt(
+		int(sumX/total*float64(size.X)/smartCropFrameWidth),
+		int(sumY/total*float64(size.Y)/smartCropFrameHeight),
+	), true, nil
+}
+
+// smartCropped returns r with the crop found by r.SmartCrop in frames of size. r itself
+// isn't changed.
+func (vs *videostore) smartCropped(ctx context.Context, r *ExportRequest, size image.Point) (*ExportRequest, error) {
+	center, found := image.Point{}, false
+	if r.Detections != nil {
+		samples, err := r.Detections.samples()
+		if err != nil {
+			return nil, err
+		}
+		_, box := detectionRanges(samples, r.From, r.To, *r.Detections)
+		center, found = image.Pt((box.Min.X+box.Max.X)/2, (box.Min.Y+box.Max.Y)/2), !box.Empty()
+	} else {
+		files, err := getSortedFiles(vs.config.Storage.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateTimeRange(files, r.From, r.To); err != nil {
+			return nil, err
+		}
+		if center, found, err = motionCenter(ctx, files, r.From, r.To, size, vs.logger); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case found:
+		vs.logger.Debugf("smart crop centered on activity at %s", center)
+	case r.SmartCrop.Fallback != nil:
+		center = *r.SmartCrop.Fallback
+	default:
+		center = image.Pt(size.X/2, size.Y/2)
+	}
+	crop, err := r.SmartCrop.region(center, size)
+	if err != nil {
+		return nil, err
+	}
+	cropped := *r
+	cropped.Crop = &crop
+	return &cropped, nil
+}
@@ -0,0 +1,128 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+// squareJPEG returns a dark frame with a bright square whose top left corner is at p.
+func squareJPEG(t *testing.T, p image.Point) []byte {
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.Gray{Y: 40}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rectangle{Min: p, Max: p.Add(image.Pt(60, 60))}, &image.Uniform{C: color.Gray{Y: 230}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, img, nil), test.ShouldBeNil)
+	return buf.Bytes()
+}
+
+func TestExportSmartCrop(t *testing.T) {
+	const framerate = 10
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	// exportCrop exports the first 3 seconds of vs with r and returns the region of the
+	// source frame it was cropped to.
+	exportCrop := func(t *testing.T, vs *videostore, config Config, r *ExportRequest) CropRegion {
+		r.From, r.To = start, start.Add(3*time.Second)
+		res, err := vs.Export(context.Background(), r)
+		test.That(t, err, test.ShouldBeNil)
+		info, err := getVideoInfo(filepath.Join(config.Storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+
+		b, err := os.ReadFile(filepath.Join(config.Storage.UploadPath, res.Manifest))
+		test.That(t, err, test.ShouldBeNil)
+		var m exportManifest
+		test.That(t, json.Unmarshal(b, &m), test.ShouldBeNil)
+		test.That(t, len(m.Request.Filters), test.ShouldEqual, 1)
+		var c CropRegion
+		_, err = fmt.Sscanf(m.Request.Filters[0], "crop=w=%d:h=%d:x=%d:y=%d", &c.Width, &c.Height, &c.X, &c.Y)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.width, test.ShouldEqual, c.Width)
+		test.That(t, info.height, test.ShouldEqual, c.Height)
+		return c
+	}
+
+	t.Run("Smart crop follows off-center motion", func(t *testing.T) {
+		// A square paces back and forth across the right of the frame.
+		frames := make([][]byte, 4*framerate)
+		for i := range frames {
+			step := i % 20
+			if step >= 10 {
+				step = 20 - step
+			}
+			frames[i] = squareJPEG(t, image.Pt(440+12*step, 200))
+		}
+		vs, config := newTestExportStore(t, start, frames, framerate)
+		c := exportCrop(t, vs, config, &ExportRequest{Metadata: "motion", SmartCrop: &SmartCrop{AspectWidth: 9, AspectHeight: 16}})
+		test.That(t, c.Width, test.ShouldEqual, 270)
+		test.That(t, c.Height, test.ShouldEqual, 480)
+		// The square paces between x 440 and 620, which the crop holds.
+		test.That(t, c.X, test.ShouldBeGreaterThan, 330)
+		test.That(t, c.X+c.Width, test.ShouldBeGreaterThanOrEqualTo, 620)
+	})
+
+	t.Run("Smart crop centers on detections", func(t *testing.T) {
+		frames := make([][]byte, 4*framerate)
+		for i := range frames {
+			frames[i] = squareJPEG(t, image.Pt(440+12*(i%10), 200))
+		}
+		vs, config := newTestExportStore(t, start, frames, framerate)
+		detections := &Detections{Samples: []DetectionSample{{
+			Time:       start.Add(500 * time.Millisecond),
+			Detections: []Detection{{Label: "person", Confidence: 0.9, XMin: 20, YMin: 100, XMax: 120, YMax: 200}},
+		}}}
+		c := exportCrop(t, vs, config, &ExportRequest{
+			Metadata:   "detections",
+			Detections: detections,
+			SmartCrop:  &SmartCrop{AspectWidth: 1, AspectHeight: 1},
+		})
+		test.That(t, c, test.ShouldResemble, CropRegion{X: 0, Y: 0, Width: 480, Height: 480})
+	})
+
+	t.Run("Smart crop falls back without motion", func(t *testing.T) {
+		frames := make([][]byte, 4*framerate)
+		for i := range frames {
+			frames[i] = squareJPEG(t, image.Pt(100, 100))
+		}
+		vs, config := newTestExportStore(t, start, frames, framerate)
+		c := exportCrop(t, vs, config, &ExportRequest{Metadata: "still", SmartCrop: &SmartCrop{AspectWidth: 9, AspectHeight: 16}})
+		test.That(t, c, test.ShouldResemble, CropRegion{X: 184, Y: 0, Width: 270, Height: 480})
+		c = exportCrop(t, vs, config, &ExportRequest{
+			Metadata:  "fallback",
+			SmartCrop: &SmartCrop{AspectWidth: 9, AspectHeight: 16, Fallback: &image.Point{X: 600, Y: 0}},
+		})
+		test.That(t, c, test.ShouldResemble, CropRegion{X: 370, Y: 0, Width: 270, Height: 480})
+	})
+
+	t.Run("Smart crop regions fill the frame", func(t *testing.T) {
+		size := image.Pt(640, 480)
+		wide := &SmartCrop{AspectWidth: 32, AspectHeight: 9}
+		c, err := wide.region(image.Pt(320, 479), size)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, c, test.ShouldResemble, CropRegion{X: 0, Y: 300, Width: 640, Height: 180})
+		_, err = (&SmartCrop{AspectWidth: 1, AspectHeight: 1000}).region(image.Pt(320, 240), size)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("Invalid smart crops are rejected", func(t *testing.T) {
+		r := &ExportRequest{From: start, To: start.Add(time.Second), SmartCrop: &SmartCrop{AspectWidth: 9}}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.SmartCrop = &SmartCrop{AspectWidth: 9, AspectHeight: 16, Fallback: &image.Point{X: -1}}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.SmartCrop = &SmartCrop{AspectWidth: 9, AspectHeight: 16}
+		r.Crop = &CropRegion{Width: 2, Height: 2}
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+		r.Crop, r.Orient = nil, true
+		test.That(t, r.Validate(), test.ShouldNotBeNil)
+	})
+}
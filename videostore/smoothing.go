@@ -0,0 +1,90 @@
+package videostore
+
+import (
+	"errors"
+	"time"
+)
+
+// rtpClockRate is the RTP video clock rate, which is the time base of the PTS and DTS
+// passed to RawSegmenter.WritePacket.
+const rtpClockRate = 90000
+
+// TimestampSmoothingConfig snaps the timestamps of packets from a constant frame rate
+// source to the frame grid, so network jitter doesn't play back as stutter.
+// Timestamps further than MaxCorrection from the grid are written unchanged and start a
+// new grid, so real frame drops and clock changes are preserved.
+type TimestampSmoothingConfig struct {
+	// Framerate is the nominal frame rate of the source. 0 disables smoothing.
+	Framerate int
+	// MaxCorrection is the furthest a timestamp is moved. 0 uses a quarter of the frame interval.
+	MaxCorrection time.Duration
+}
+
+// Validate returns an error if the TimestampSmoothingConfig is invalid.
+func (c TimestampSmoothingConfig) Validate() error {
+	if c.Framerate < 0 {
+		return errors.New("timestamp smoothing framerate can't be less than 0")
+	}
+	if c.MaxCorrection < 0 {
+		return errors.New("timestamp smoothing max correction can't be less than 0")
+	}
+	if c.Framerate > 0 && 2*c.MaxCorrection >= time.Second/time.Duration(c.Framerate) {
+		return errors.New("timestamp smoothing max correction must be less than half the frame interval")
+	}
+	return nil
+}
+
+// timestampSmoother snaps packet timestamps to a grid of frame intervals anchored on the
+// first packet it sees.
+type timestampSmoother struct {
+	// interval and maxCorrection are in rtpClockRate ticks.
+	interval      int64
+	maxCorrection int64
+	started       bool
+	anchor        int64
+	lastDTS       int64
+}
+
+// newTimestampSmoother returns nil if smoothing is disabled by c.
+func newTimestampSmoother(c TimestampSmoothingConfig) *timestampSmoother {
+	if c.Framerate <= 0 {
+		return nil
+	}
+	interval := int64(rtpClockRate / c.Framerate)
+	maxCorrection := interval / 4
+	if c.MaxCorrection > 0 {
+		maxCorrection = int64(c.MaxCorrection * rtpClockRate / time.Second)
+	}
+	return &timestampSmoother{interval: interval, maxCorrection: maxCorrection}
+}
+
+// smooth returns pts moved to the nearest frame on the grid and dts moved by the same
+// amount. The grid is re-anchored on pts when it is too far from the grid, or when
+// snapping it would write DTS out of order.
+func (s *timestampSmoother) smooth(pts, dts int64) (int64, int64) {
+	if s.started {
+		offset := pts - s.anchor
+		frames := offset / s.interval
+		if rem := offset % s.interval; rem*2 >= s.interval {
+			frames++
+		} else if rem*2 < -s.interval {
+			frames--
+		}
+		correction := s.anchor + frames*s.interval - pts
+		if abs(correction) <= s.maxCorrection && dts+correction > s.lastDTS {
+			s.lastDTS = dts + correction
+			return pts + correction, dts + correction
+		}
+	}
+	s.started = true
+	s.anchor = pts
+	s.lastDTS = dts
+	return pts, dts
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,77 @@
+package videostore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestTimestampSmoothing(t *testing.T) {
+	const framerate = 10
+	const interval = rtpClockRate / framerate
+	// jitter is up to 20ms of network jitter in rtpClockRate ticks.
+	jitter := []int64{0, 1800, -1500, 900, -1800, 300, 1200, -600}
+
+	t.Run("Jittery constant frame rate input is written evenly spaced", func(t *testing.T) {
+		frames := make([][]byte, 3*framerate)
+		for i := range frames {
+			frames[i] = patternJPEG(t, i%2)
+		}
+		packets := h264Packets(t, frames, framerate)
+		storagePath := t.TempDir()
+		smoothing := TimestampSmoothingConfig{Framerate: framerate}
+		rs, err := newRawSegmenter(storagePath, rawSegmenterOptions{smoothing: smoothing}, logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rs.Init(CodecTypeH264, 640, 480), test.ShouldBeNil)
+		for i, p := range packets {
+			pts := p.pts + jitter[i%len(jitter)]
+			test.That(t, rs.WritePacket(p.payload, pts, pts, p.isIDR), test.ShouldBeNil)
+		}
+		test.That(t, rs.Close(), test.ShouldBeNil)
+		segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(segments), test.ShouldEqual, 1)
+
+		times := frameTimes(t, segments[0])
+		test.That(t, len(times), test.ShouldEqual, len(packets))
+		for i := 1; i < len(times); i++ {
+			test.That(t, times[i]-times[i-1], test.ShouldAlmostEqual, 1.0/framerate, 0.001)
+		}
+	})
+
+	t.Run("Dropped frames are not hidden", func(t *testing.T) {
+		s := newTimestampSmoother(TimestampSmoothingConfig{Framerate: framerate})
+		var got []int64
+		for _, frame := range []int64{0, 1, 2, 5, 6} {
+			pts := frame*interval + jitter[frame]
+			pts, dts := s.smooth(pts, pts)
+			test.That(t, dts, test.ShouldEqual, pts)
+			got = append(got, pts)
+		}
+		test.That(t, got, test.ShouldResemble, []int64{0, interval, 2 * interval, 5 * interval, 6 * interval})
+	})
+
+	t.Run("Corrections are bounded", func(t *testing.T) {
+		s := newTimestampSmoother(TimestampSmoothingConfig{Framerate: framerate, MaxCorrection: 10 * time.Millisecond})
+		s.smooth(0, 0)
+		pts, _ := s.smooth(interval+1800, interval+1800)
+		test.That(t, pts, test.ShouldEqual, interval+1800)
+		// The grid restarts on the timestamp which couldn't be snapped.
+		pts, _ = s.smooth(2*interval+1800+500, 2*interval+1800+500)
+		test.That(t, pts, test.ShouldEqual, 2*interval+1800)
+	})
+
+	t.Run("Smoothing can be disabled", func(t *testing.T) {
+		test.That(t, newTimestampSmoother(TimestampSmoothingConfig{}), test.ShouldBeNil)
+	})
+
+	t.Run("Invalid config is rejected", func(t *testing.T) {
+		test.That(t, TimestampSmoothingConfig{Framerate: -1}.Validate(), test.ShouldNotBeNil)
+		test.That(t, TimestampSmoothingConfig{Framerate: 10, MaxCorrection: 50 * time.Millisecond}.Validate(),
+			test.ShouldBeError, "timestamp smoothing max correction must be less than half the frame interval")
+		test.That(t, TimestampSmoothingConfig{Framerate: 10, MaxCorrection: 40 * time.Millisecond}.Validate(), test.ShouldBeNil)
+	})
+}
@@ -0,0 +1,251 @@
+package videostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSpriteInterval is the time between thumbnails in a sprite sheet.
+	DefaultSpriteInterval = 5 * time.Second
+	// DefaultSpriteThumbnailWidth is the width of each thumbnail in a sprite sheet.
+	// The height keeps the video's aspect ratio.
+	DefaultSpriteThumbnailWidth = 160
+	// DefaultSpriteColumns is the number of thumbnails in each row of a sprite sheet.
+	DefaultSpriteColumns = 10
+	// maxSpriteThumbnails bounds the size of a sprite sheet.
+	maxSpriteThumbnails = 1000
+	spriteJPEGQuality   = 80
+)
+
+// SpriteSheetRequest is the request to the ExportSpriteSheet method.
+// Either From and To or Segment must be set.
+type SpriteSheetRequest struct {
+	From time.Time
+	To   time.Time
+	// Segment is the file name of a completed segment to cover in full instead of a time range.
+	Segment        string
+	Metadata       string
+	Interval       time.Duration
+	ThumbnailWidth int
+	Columns        int
+}
+
+// SpriteSheetResponse is the response to the ExportSpriteSheet method.
+type SpriteSheetResponse struct {
+	// SpriteFilename is a JPEG grid of thumbnails in time order, left to right then top to bottom.
+	SpriteFilename string
+	// VTTFilename is a WebVTT file with a cue per thumbnail. Cue times are relative to the
+	// start of the range and each cue's text is <SpriteFilename>#xywh=x,y,w,h.
+	VTTFilename string
+}
+
+// Validate returns an error if the SpriteSheetRequest is invalid.
+func (r *SpriteSheetRequest) Validate() error {
+	if r.Segment != "" {
+		if !r.From.IsZero() || !r.To.IsZero() {
+			return errors.New("only one of segment or 'from' and 'to' timestamps can be set")
+		}
+	} else {
+		if !r.From.Before(r.To) {
+			return errors.New("'from' timestamp must be before 'to' timestamp")
+		}
+		if r.To.After(time.Now()) {
+			return errors.New("'to' timestamp is in the future")
+		}
+	}
+	if r.Interval < 0 {
+		return errors.New("interval can't be less than 0")
+	}
+	if r.ThumbnailWidth < 0 {
+		return errors.New("thumbnail width can't be less than 0")
+	}
+	if r.Columns < 0 {
+		return errors.New("columns can't be less than 0")
+	}
+	return nil
+}
+
+// spriteTile is a thumbnail in a sprite sheet: the segment and offset it was taken from,
+// the time range it covers relative to the start of the sheet, and where it is drawn.
+type spriteTile struct {
+	segment    string
+	offset     time.Duration
+	start, end time.Duration
+	rect       image.Rectangle
+}
+
+// ExportSpriteSheet writes a sprite sheet with a thumbnail of the video every r.Interval,
+// and a WebVTT file mapping time offsets to the thumbnails, to the upload path.
+// Web players use the pair to show previews while scrubbing.
+// Thumbnails of times with no stored video are left black.
+func (vs *videostore) ExportSpriteSheet(ctx context.Context, r *SpriteSheetRequest) (*SpriteSheetResponse, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if r.Interval == 0 {
+		r.Interval = DefaultSpriteInterval
+	}
+	if r.ThumbnailWidth == 0 {
+		r.ThumbnailWidth = DefaultSpriteThumbnailWidth
+	}
+	if r.Columns == 0 {
+		r.Columns = DefaultSpriteColumns
+	}
+
+	files, err := getSortedFiles(vs.config.Storage.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	infos := videoInfoCache{}
+	if r.Segment != "" {
+		i := segmentIndex(files, r.Segment)
+		if i < 0 {
+			return nil, fmt.Errorf("segment %s not found", r.Segment)
+		}
+		if i == len(files)-1 {
+			return nil, fmt.Errorf("segment %s is still being written", r.Segment)
+		}
+		info, err := infos.get(files[i].name)
+		if err != nil {
+			return nil, err
+		}
+		r.From = files[i].startTime
+		r.To = r.From.Add(info.duration)
+	} else {
+		r.From = r.From.UTC()
+		r.To = r.To.UTC()
+		if err := validateTimeRange(files, r.From, r.To); err != nil {
+			return nil, err
+		}
+	}
+	vs.logger.Debug("sprite sheet command received and validated")
+
+	total := r.To.Sub(r.From)
+	count := int((total + r.Interval - 1) / r.Interval)
+	if count == 0 {
+		return nil, errors.New("range is too short for a thumbnail")
+	}
+	if count > maxSpriteThumbnails {
+		return nil, fmt.Errorf("range needs %d thumbnails which is more than the max of %d, use a larger interval",
+			count, maxSpriteThumbnails)
+	}
+
+	// Take each thumbnail from the segment covering its start time.
+	tiles := make([]spriteTile, count)
+	var first *videoInfo
+	for i := range tiles {
+		tile := &tiles[i]
+		tile.start = time.Duration(i) * r.Interval
+		tile.end = min(tile.start+r.Interval, total)
+		segment, offset, info, ok := infos.segmentAt(files, r.From.Add(tile.start))
+		if !ok {
+			continue
+		}
+		tile.segment, tile.offset = segment, offset
+		if first == nil {
+			first = &info
+		}
+	}
+	if first == nil {
+		return nil, errors.New("no video found in range")
+	}
+
+	// Size every thumbnail from the first segment's aspect ratio, rounded to even like FFmpeg.
+	width := r.ThumbnailWidth
+	height := max(width*first.height/first.width/2*2, 2)
+	columns := min(r.Columns, count)
+	rows := (count + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*width, rows*height))
+	draw.Draw(sheet, sheet.Bounds(), image.Black, image.Point{}, draw.Src)
+	for i := range tiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tile := &tiles[i]
+		x, y := (i%columns)*width, (i/columns)*height
+		tile.rect = image.Rect(x, y, x+width, y+height)
+		if tile.segment == "" {
+			continue
+		}
+		thumbnail, err := rgbaFrameAt(tile.segment, tile.offset, width, height)
+		if err != nil {
+			vs.logger.Warnf("failed to get thumbnail at %s from %s, leaving it black: %v", tile.offset, tile.segment, err)
+			continue
+		}
+		draw.Draw(sheet, tile.rect, thumbnail, image.Point{}, draw.Src)
+	}
+
+	base := strings.TrimSuffix(generateOutputFilePath(
+		vs.config.Storage.OutputFileNamePrefix,
+		r.From,
+		r.Metadata,
+		vs.config.Storage.UploadPath,
+	), ".mp4")
+	spritePath := base + "_sprites.jpg"
+	vttPath := base + "_sprites.vtt"
+	if err := writeSpriteSheet(spritePath, sheet); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(vttPath, []byte(spriteVTT(filepath.Base(spritePath), tiles)), 0o600); err != nil {
+		// Don't leave a sprite sheet without its VTT to be uploaded.
+		if err := os.Remove(spritePath); err != nil {
+			vs.logger.Warnf("failed to remove sprite sheet %s: %v", spritePath, err)
+		}
+		return nil, err
+	}
+	return &SpriteSheetResponse{
+		SpriteFilename: filepath.Base(spritePath),
+		VTTFilename:    filepath.Base(vttPath),
+	}, nil
+}
+
+// segmentIndex returns the index of the file named segment in files, or -1.
+func segmentIndex(files []fileWithDate, segment string) int {
+	for i, f := range files {
+		if filepath.Base(f.name) == filepath.Base(segment) {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeSpriteSheet encodes sheet as a JPEG at path.
+func writeSpriteSheet(path string, sheet image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, sheet, &jpeg.Options{Quality: spriteJPEGQuality}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// spriteVTT returns a WebVTT file with a cue pointing each tile's time range at its rectangle in sprite.
+func spriteVTT(sprite string, tiles []spriteTile) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, tile := range tiles {
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(tile.start), vttTimestamp(tile.end), sprite,
+			tile.rect.Min.X, tile.rect.Min.Y, tile.rect.Dx(), tile.rect.Dy())
+	}
+	return b.String()
+}
+
+// vttTimestamp formats d as a WebVTT timestamp (HH:MM:SS.mmm).
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,130 @@
+package videostore
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+// vttCue is a parsed WebVTT cue pointing at a region of a sprite sheet.
+type vttCue struct {
+	start, end string
+	sprite     string
+	rect       image.Rectangle
+}
+
+// parseSpriteVTT parses a sprite sheet WebVTT file.
+func parseSpriteVTT(t *testing.T, vtt string) []vttCue {
+	blocks := strings.Split(strings.TrimSpace(vtt), "\n\n")
+	test.That(t, blocks[0], test.ShouldEqual, "WEBVTT")
+	var cues []vttCue
+	for _, block := range blocks[1:] {
+		lines := strings.Split(block, "\n")
+		test.That(t, len(lines), test.ShouldEqual, 2)
+		var cue vttCue
+		times := strings.Split(lines[0], " --> ")
+		test.That(t, len(times), test.ShouldEqual, 2)
+		cue.start, cue.end = times[0], times[1]
+		sprite, xywh, ok := strings.Cut(lines[1], "#xywh=")
+		test.That(t, ok, test.ShouldBeTrue)
+		cue.sprite = sprite
+		var x, y, w, h int
+		_, err := fmt.Sscanf(xywh, "%d,%d,%d,%d", &x, &y, &w, &h)
+		test.That(t, err, test.ShouldBeNil)
+		cue.rect = image.Rect(x, y, x+w, y+h)
+		cues = append(cues, cue)
+	}
+	return cues
+}
+
+// meanBrightness returns the mean luma of img within r.
+func meanBrightness(img image.Image, r image.Rectangle) int {
+	var sum, n int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += int(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			n++
+		}
+	}
+	return sum / n
+}
+
+func TestExportSpriteSheet(t *testing.T) {
+	const framerate = 10
+	const seconds = 6
+	// Each second of video is brighter than the last so every thumbnail is distinct.
+	frames := make([][]byte, seconds*framerate)
+	for i := range frames {
+		frames[i] = solidJPEG(t, color.Gray{Y: uint8(40 * (i / framerate))})
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	checkSpriteSheet := func(t *testing.T, res *SpriteSheetResponse) {
+		vtt, err := os.ReadFile(filepath.Join(config.Storage.UploadPath, res.VTTFilename))
+		test.That(t, err, test.ShouldBeNil)
+		f, err := os.Open(filepath.Join(config.Storage.UploadPath, res.SpriteFilename))
+		test.That(t, err, test.ShouldBeNil)
+		defer f.Close()
+		sheet, err := jpeg.Decode(f)
+		test.That(t, err, test.ShouldBeNil)
+
+		cues := parseSpriteVTT(t, string(vtt))
+		test.That(t, len(cues), test.ShouldEqual, seconds)
+		for i, cue := range cues {
+			test.That(t, cue.sprite, test.ShouldEqual, res.SpriteFilename)
+			test.That(t, cue.start, test.ShouldEqual, fmt.Sprintf("00:00:%02d.000", i))
+			test.That(t, cue.end, test.ShouldEqual, fmt.Sprintf("00:00:%02d.000", i+1))
+			test.That(t, cue.rect.Dx(), test.ShouldEqual, 80)
+			test.That(t, cue.rect.Dy(), test.ShouldEqual, 60)
+			test.That(t, cue.rect.In(sheet.Bounds()), test.ShouldBeTrue)
+			// The thumbnail a cue points at shows the video at the cue's start.
+			test.That(t, meanBrightness(sheet, cue.rect), test.ShouldAlmostEqual, 40*i, 12)
+		}
+	}
+
+	t.Run("Sprite sheet of a time range matches its VTT", func(t *testing.T) {
+		res, err := vs.ExportSpriteSheet(context.Background(), &SpriteSheetRequest{
+			From:           start,
+			To:             start.Add(seconds * time.Second),
+			Interval:       time.Second,
+			ThumbnailWidth: 80,
+			Columns:        4,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		checkSpriteSheet(t, res)
+	})
+
+	t.Run("Sprite sheet of a whole segment matches its VTT", func(t *testing.T) {
+		res, err := vs.ExportSpriteSheet(context.Background(), &SpriteSheetRequest{
+			Segment:        fmt.Sprintf("%d.mp4", start.Unix()),
+			Metadata:       "segment",
+			Interval:       time.Second,
+			ThumbnailWidth: 80,
+			Columns:        4,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		checkSpriteSheet(t, res)
+	})
+
+	t.Run("In progress segment is rejected", func(t *testing.T) {
+		_, err := vs.ExportSpriteSheet(context.Background(), &SpriteSheetRequest{
+			Segment: fmt.Sprintf("%d.mp4", start.Add(time.Minute).Unix()),
+		})
+		test.That(t, err, test.ShouldBeError, fmt.Errorf("segment %d.mp4 is still being written", start.Add(time.Minute).Unix()))
+	})
+}
+
+func TestVTTTimestamp(t *testing.T) {
+	test.That(t, vttTimestamp(0), test.ShouldEqual, "00:00:00.000")
+	test.That(t, vttTimestamp(time.Hour+2*time.Minute+3*time.Second+45*time.Millisecond), test.ShouldEqual, "01:02:03.045")
+}
@@ -0,0 +1,166 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srtTimingPattern matches an SRT cue timing line, e.g. "00:00:01,000 --> 00:00:02,500".
+var srtTimingPattern = regexp.MustCompile(
+	`^(\d+):(\d{2}):(\d{2})[,.](\d{3})\s+-->\s+(\d+):(\d{2}):(\d{2})[,.](\d{3})`)
+
+// srtTagPattern matches the HTML style (<i>) and SSA style ({\an8}) formatting tags SRT files may contain.
+var srtTagPattern = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`)
+
+// Subtitles is an SRT transcript to burn into an export with the libass subtitles
+// filter, which renders the SRT's formatting tags. One of SRT or Path must be set.
+type Subtitles struct {
+	// SRT is the contents of an SRT file.
+	SRT string
+	// Path is the path of an SRT file in the export input path, see
+	// StorageConfig.ExportInputPath.
+	Path string
+	// Start is the time that 00:00:00,000 in the SRT refers to.
+	// Defaults to the start of the export.
+	Start time.Time
+}
+
+// srtCue is a subtitle shown from start to end, relative to the start of an SRT.
+type srtCue struct {
+	start, end time.Duration
+	text       string
+}
+
+// Validate returns an error if the Subtitles are invalid.
+func (s *Subtitles) Validate() error {
+	if (s.SRT == "") == (s.Path == "") {
+		return errors.New("exactly one of subtitles srt or path must be set")
+	}
+	return nil
+}
+
+// writeSRT writes the subtitles to a temporary SRT file for the subtitles filter, with
+// the cues shifted so the SRT starts at s.Start or, if unset, at exportStart, the start of
+// the export's timeline. Cues which end before the export starts are left out. The caller
+// must remove the file.
+func (s *Subtitles) writeSRT(exportStart time.Time) (string, error) {
+	srt := s.SRT
+	if s.Path != "" {
+		b, err := os.ReadFile(s.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read subtitles: %w", err)
+		}
+		srt = string(b)
+	}
+	cues, err := parseSRT(srt)
+	if err != nil {
+		return "", err
+	}
+	start := s.Start
+	if start.IsZero() {
+		start = exportStart
+	}
+	offset := start.Sub(exportStart)
+	var b strings.Builder
+	n := 0
+	for _, cue := range cues {
+		from, to := max(cue.start+offset, 0), cue.end+offset
+		if to <= 0 {
+			continue
+		}
+		n++
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", n, srtTimestamp(from), srtTimestamp(to), cue.text)
+	}
+	f, err := os.CreateTemp("", "video-store-subtitles-*.srt")
+	if err != nil {
+		return "", fmt.Errorf("failed to write subtitles: %w", err)
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write subtitles: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write subtitles: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// subtitlesFilter returns a subtitles filter which renders the SRT file at path with
+// libass, using the fonts next to font if it is set.
+func subtitlesFilter(path, font string) string {
+	filter := "subtitles=filename=" + escapeFilterValue(path)
+	if font != "" {
+		filter += ":fontsdir=" + escapeFilterValue(filepath.Dir(font))
+	}
+	return filter
+}
+
+// parseSRT parses the cues of an SRT file. Formatting tags are kept in cue text for libass
+// to style the cue with, and cues with only tags are left out.
+func parseSRT(srt string) ([]srtCue, error) {
+	srt = strings.TrimPrefix(srt, "\ufeff")
+	srt = strings.ReplaceAll(srt, "\r\n", "\n")
+	var cues []srtCue
+	for i, block := range strings.Split(strings.TrimSpace(srt), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if len(lines) == 1 && lines[0] == "" {
+			continue
+		}
+		// The cue number is optional in practice.
+		if _, err := strconv.Atoi(strings.TrimSpace(lines[0])); err == nil {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("subtitle %d has no timing", i+1)
+		}
+		m := srtTimingPattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			// The SRT may be any file, so its contents aren't quoted.
+			return nil, fmt.Errorf("subtitle %d has invalid timing", i+1)
+		}
+		cue := srtCue{start: srtDuration(m[1:5]), end: srtDuration(m[5:9])}
+		if cue.end < cue.start {
+			return nil, fmt.Errorf("subtitle %d ends before it starts", i+1)
+		}
+		var text []string
+		for _, line := range lines[1:] {
+			if line = strings.TrimSpace(line); line != "" {
+				text = append(text, line)
+			}
+		}
+		if strings.TrimSpace(srtTagPattern.ReplaceAllString(strings.Join(text, ""), "")) == "" {
+			continue
+		}
+		cue.text = strings.Join(text, "\n")
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 {
+		return nil, errors.New("subtitles have no cues")
+	}
+	return cues, nil
+}
+
+// srtDuration converts the hours, minutes, seconds and milliseconds of an SRT timestamp to a duration.
+func srtDuration(parts []string) time.Duration {
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second, time.Millisecond} {
+		// The pattern only matches digits.
+		n, _ := strconv.Atoi(parts[i])
+		d += time.Duration(n) * unit
+	}
+	return d
+}
+
+// srtTimestamp formats d as an SRT timestamp, e.g. "00:00:01,000".
+func srtTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,117 @@
+package videostore
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestParseSRT(t *testing.T) {
+	t.Run("Cues are parsed with their tags", func(t *testing.T) {
+		cues, err := parseSRT("\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i>\r\nthere\r\n\r\n" +
+			"2\r\n01:02:03.004 --> 01:02:04.000\r\n{\\an8}Top\r\n")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cues, test.ShouldResemble, []srtCue{
+			{start: time.Second, end: 2500 * time.Millisecond, text: "<i>Hello</i>\nthere"},
+			{
+				start: time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond,
+				end:   time.Hour + 2*time.Minute + 4*time.Second,
+				text:  "{\\an8}Top",
+			},
+		})
+	})
+
+	t.Run("Invalid timing is rejected", func(t *testing.T) {
+		_, err := parseSRT("1\n00:00:01 --> 00:00:02\nHello\n")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "subtitle 1 has invalid timing")
+	})
+
+	t.Run("Cues with only tags are skipped", func(t *testing.T) {
+		cues, err := parseSRT("1\n00:00:01,000 --> 00:00:02,000\n<i></i>\n\n2\n00:00:03,000 --> 00:00:04,000\nHello\n")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cues, test.ShouldResemble, []srtCue{{start: 3 * time.Second, end: 4 * time.Second, text: "Hello"}})
+	})
+
+	t.Run("Cue ending before it starts is rejected", func(t *testing.T) {
+		_, err := parseSRT("1\n00:00:02,000 --> 00:00:01,000\nHello\n")
+		test.That(t, err, test.ShouldBeError, "subtitle 1 ends before it starts")
+	})
+
+	t.Run("Empty SRT is rejected", func(t *testing.T) {
+		_, err := parseSRT("\n\n")
+		test.That(t, err, test.ShouldBeError, "subtitles have no cues")
+	})
+}
+
+func TestExportSubtitles(t *testing.T) {
+	if _, err := findFont(); err != nil {
+		t.Skip(err)
+	}
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 8*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	// The transcript starts a second into the stored video, so cues are shifted by
+	// a second relative to the export which starts 2 seconds in.
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nFirst\n\n2\n00:00:04,000 --> 00:00:05,000\nSecond\n"
+	test.That(t, os.MkdirAll(config.Storage.ExportInputPath, 0o755), test.ShouldBeNil)
+	test.That(t, os.WriteFile(filepath.Join(config.Storage.ExportInputPath, "transcript.srt"), []byte(srt), 0o600), test.ShouldBeNil)
+
+	for _, tc := range []struct {
+		name      string
+		subtitles *Subtitles
+	}{
+		{name: "Inline", subtitles: &Subtitles{SRT: srt, Start: start.Add(time.Second)}},
+		{name: "Path", subtitles: &Subtitles{Path: "transcript.srt", Start: start.Add(time.Second)}},
+	} {
+		t.Run(tc.name+" subtitles appear during their cues", func(t *testing.T) {
+			res, err := vs.Export(context.Background(), &ExportRequest{
+				From:      start.Add(2 * time.Second),
+				To:        start.Add(8 * time.Second),
+				Metadata:  tc.name,
+				Subtitles: tc.subtitles,
+			})
+			test.That(t, err, test.ShouldBeNil)
+			exported := filepath.Join(config.Storage.UploadPath, res.Filename)
+			// Cues are at 0-1s and 3-4s of the export.
+			test.That(t, maxBrightness(t, exported, 500*time.Millisecond), test.ShouldBeGreaterThan, 200)
+			test.That(t, maxBrightness(t, exported, 2*time.Second), test.ShouldBeLessThan, 40)
+			test.That(t, maxBrightness(t, exported, 3500*time.Millisecond), test.ShouldBeGreaterThan, 200)
+			test.That(t, maxBrightness(t, exported, 5*time.Second), test.ShouldBeLessThan, 40)
+		})
+	}
+
+	t.Run("Invalid SRT is rejected", func(t *testing.T) {
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(2 * time.Second),
+			Subtitles: &Subtitles{SRT: "not an srt"},
+		})
+		// The SRT may be any file, so its contents aren't in the error.
+		test.That(t, err, test.ShouldBeError, "subtitle 1 has invalid timing")
+	})
+
+	t.Run("SRT files outside of the export input path are rejected", func(t *testing.T) {
+		outside := filepath.Join(filepath.Dir(config.Storage.ExportInputPath), "transcript.srt")
+		test.That(t, os.WriteFile(outside, []byte(srt), 0o600), test.ShouldBeNil)
+		for _, path := range []string{outside, "../transcript.srt"} {
+			_, err := vs.Export(context.Background(), &ExportRequest{
+				From:      start,
+				To:        start.Add(2 * time.Second),
+				Subtitles: &Subtitles{Path: path},
+			})
+			test.That(t, err, test.ShouldBeError, "export input "+path+" is outside of the export input path")
+		}
+	})
+}
@@ -0,0 +1,288 @@
+package videostore
+
+/*
+#include "export.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// hudMetadataKey is VIDEO_STORE_EXPORT_HUD_METADATA_KEY, the frame metadata the HUD text is set at.
+const hudMetadataKey = "video_store.hud"
+
+// Telemetry is a time series of values, such as speed or GPS position, drawn on an export
+// as a HUD. Fields are interpolated linearly between samples to the time of each frame.
+// One of Samples or Path must be set.
+type Telemetry struct {
+	// Fields are the values drawn, one per line in order.
+	Fields []TelemetryField
+	// Samples are the telemetry in time order. A sample may leave out fields it doesn't have.
+	Samples []TelemetrySample
+	// Path is the path of a CSV file in the export input path with a header row. The first column is
+	// the time of each row, RFC 3339 or Unix seconds, and the rest are values named by the
+	// header. Empty cells are left out of the row's sample.
+	Path string
+	// MaxGap is the furthest apart samples of a field are interpolated between, frames in
+	// longer gaps draw the field as "--". 0 interpolates across any gap.
+	MaxGap time.Duration
+	// Timestamp, if set, draws the wall clock time of each frame on the first line.
+	Timestamp bool
+}
+
+// TelemetryField is a value drawn on a line of the HUD as "<Label>: <value> <Unit>".
+type TelemetryField struct {
+	// Name is the key of the field in TelemetrySample.Values or the CSV header.
+	Name string
+	// Label defaults to Name.
+	Label string
+	// Unit, e.g. m/s, is optional.
+	Unit string
+	// Decimals is the number of decimal places the value is drawn with.
+	Decimals int
+}
+
+// TelemetrySample is the values of fields at a time.
+type TelemetrySample struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// Validate returns an error if the Telemetry is invalid.
+func (t *Telemetry) Validate() error {
+	if len(t.Fields) == 0 {
+		return errors.New("telemetry must have at least one field")
+	}
+	names := map[string]bool{}
+	for i, f := range t.Fields {
+		if f.Name == "" {
+			return fmt.Errorf("telemetry field %d name can't be blank", i)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("telemetry field %s is listed more than once", f.Name)
+		}
+		names[f.Name] = true
+		if f.Decimals < 0 || f.Decimals > 9 {
+			return fmt.Errorf("telemetry field %s decimals must be between 0 and 9", f.Name)
+		}
+	}
+	if (len(t.Samples) == 0) == (t.Path == "") {
+		return errors.New("exactly one of telemetry samples or path must be set")
+	}
+	if t.MaxGap < 0 {
+		return errors.New("telemetry max gap can't be less than 0")
+	}
+	if len(t.Samples) > 0 {
+		return t.validateSamples(t.Samples)
+	}
+	return nil
+}
+
+// validateSamples returns an error if samples are out of order, hold values which can't be
+// drawn, or leave a field without samples.
+func (t *Telemetry) validateSamples(samples []TelemetrySample) error {
+	counts := map[string]int{}
+	for i, s := range samples {
+		if i > 0 && !s.Time.After(samples[i-1].Time) {
+			return fmt.Errorf("telemetry sample %d is not after the previous sample", i)
+		}
+		for name, v := range s.Values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("telemetry sample %d %s must be a finite number", i, name)
+			}
+			counts[name]++
+		}
+	}
+	for _, f := range t.Fields {
+		if counts[f.Name] == 0 {
+			return fmt.Errorf("telemetry field %s has no samples", f.Name)
+		}
+	}
+	return nil
+}
+
+// samples returns the Samples, or those read from the CSV file at Path.
+func (t *Telemetry) samples() ([]TelemetrySample, error) {
+	if t.Path == "" {
+		return t.Samples, nil
+	}
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry: %w", err)
+	}
+	defer f.Close()
+	samples, err := parseTelemetryCSV(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.validateSamples(samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// parseTelemetryCSV parses a CSV of telemetry as described by Telemetry.Path.
+func parseTelemetryCSV(r io.Reader) ([]TelemetrySample, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse telemetry csv: %w", err)
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, errors.New("telemetry csv must have a header with a time column and at least one value column")
+	}
+	header := records[0]
+	samples := make([]TelemetrySample, 0, len(records)-1)
+	for i, record := range records[1:] {
+		// Rows are numbered as in the file, after the header.
+		row := i + 2
+		t, err := parseTelemetryTime(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("telemetry csv row %d: %w", row, err)
+		}
+		s := TelemetrySample{Time: t, Values: map[string]float64{}}
+		for j, cell := range record[1:] {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return nil, fmt.Errorf("telemetry csv row %d %s: %w", row, header[j+1], err)
+			}
+			s.Values[strings.TrimSpace(header[j+1])] = v
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// parseTelemetryTime parses an RFC 3339 time or Unix time in seconds.
+func parseTelemetryTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be RFC 3339 or Unix seconds", s)
+	}
+	return time.UnixMicro(int64(math.Round(seconds * 1e6))), nil
+}
+
+// hud returns the telemetry as a HUD for an export starting at from. It must be freed.
+func (t *Telemetry) hud(from time.Time) (*cHUD, error) {
+	samples, err := t.samples()
+	if err != nil {
+		return nil, err
+	}
+	// The HUD is read by C while exporting so it must be in C memory.
+	h := &cHUD{}
+	h.hud = (*C.video_store_export_hud)(h.alloc(C.sizeof_video_store_export_hud))
+	fields := unsafe.Slice((*C.video_store_export_hud_field)(
+		h.alloc(C.size_t(len(t.Fields))*C.sizeof_video_store_export_hud_field)), len(t.Fields))
+	for i, f := range t.Fields {
+		var times []int64
+		var values []float64
+		for _, s := range samples {
+			if v, ok := s.Values[f.Name]; ok {
+				times = append(times, s.Time.Sub(from).Microseconds())
+				values = append(values, v)
+			}
+		}
+		cTimes := unsafe.Slice((*C.int64_t)(h.alloc(C.size_t(len(times))*C.sizeof_int64_t)), len(times))
+		cValues := unsafe.Slice((*C.double)(h.alloc(C.size_t(len(values))*C.sizeof_double)), len(values))
+		for j := range times {
+			cTimes[j] = C.int64_t(times[j])
+			cValues[j] = C.double(values[j])
+		}
+		label := f.Label
+		if label == "" {
+			label = f.Name
+		}
+		suffix := ""
+		if f.Unit != "" {
+			suffix = " " + f.Unit
+		}
+		fields[i] = C.video_store_export_hud_field{
+			prefix:      h.cString(label + ": "),
+			suffix:      h.cString(suffix),
+			decimals:    C.int(f.Decimals),
+			sampleCount: C.int(len(times)),
+		}
+		if len(times) > 0 {
+			fields[i].timesMicroseconds = &cTimes[0]
+			fields[i].values = &cValues[0]
+		}
+	}
+	h.hud.fields = &fields[0]
+	h.hud.fieldCount = C.int(len(fields))
+	h.hud.maxGapMicroseconds = C.int64_t(t.MaxGap.Microseconds())
+	h.hud.timestamp = 0
+	if t.Timestamp {
+		h.hud.timestamp = 1
+	}
+	h.hud.startUnixMicroseconds = C.int64_t(from.UnixMicro())
+	return h, nil
+}
+
+// cHUD is a video_store_export_hud and the C memory it points to.
+type cHUD struct {
+	hud  *C.video_store_export_hud
+	ptrs []unsafe.Pointer
+}
+
+// alloc returns size bytes of C memory which is freed with the HUD.
+func (h *cHUD) alloc(size C.size_t) unsafe.Pointer {
+	p := C.malloc(size)
+	h.ptrs = append(h.ptrs, p)
+	return p
+}
+
+// cString returns s in C memory which is freed with the HUD.
+func (h *cHUD) cString(s string) *C.char {
+	p := C.CString(s)
+	h.ptrs = append(h.ptrs, unsafe.Pointer(p))
+	return p
+}
+
+// text returns the HUD text drawn on the frame d into the export.
+func (h *cHUD) text(d time.Duration) string {
+	var text [C.VIDEO_STORE_EXPORT_HUD_TEXT_LEN]C.char
+	C.video_store_export_hud_text(h.hud, C.int64_t(d.Microseconds()), &text[0], C.int(len(text)))
+	return C.GoString(&text[0])
+}
+
+// free frees the HUD's C memory.
+func (h *cHUD) free() {
+	for _, p := range h.ptrs {
+		C.free(p)
+	}
+	h.ptrs = nil
+}
+
+// hudFilter returns a drawtext filter which draws the HUD text set on each frame in the top left.
+func hudFilter(font string) string {
+	return "drawtext=" + strings.Join([]string{
+		"fontfile=" + escapeFilterValue(font),
+		"text=" + escapeFilterValue("%{metadata:"+hudMetadataKey+"}"),
+		"fontsize=h/30",
+		"fontcolor=white",
+		"line_spacing=4",
+		"box=1",
+		"boxcolor=black@0.6",
+		"boxborderw=8",
+		"x=w/40",
+		"y=h/40",
+	}, ":")
+}
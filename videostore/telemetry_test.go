@@ -0,0 +1,143 @@
+package videostore
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestTelemetry(t *testing.T) {
+	const framerate = 10
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	// Speed ramps from 0 to 20 m/s over 2s, GPS is sampled once a second.
+	telemetry := &Telemetry{
+		Fields: []TelemetryField{
+			{Name: "speed", Label: "Speed", Unit: "m/s", Decimals: 1},
+			{Name: "lat", Decimals: 5},
+		},
+		Samples: []TelemetrySample{
+			{Time: start, Values: map[string]float64{"speed": 0, "lat": 37.77490}},
+			{Time: start.Add(time.Second), Values: map[string]float64{"lat": 37.77500}},
+			{Time: start.Add(2 * time.Second), Values: map[string]float64{"speed": 20, "lat": 37.77520}},
+		},
+	}
+
+	t.Run("HUD values match the telemetry at sampled frames", func(t *testing.T) {
+		test.That(t, telemetry.Validate(), test.ShouldBeNil)
+		hud, err := telemetry.hud(start)
+		test.That(t, err, test.ShouldBeNil)
+		defer hud.free()
+		for _, frame := range []int{0, 3, 10, 15, 20} {
+			d := time.Duration(frame) * time.Second / framerate
+			seconds := d.Seconds()
+			lat := 37.77490 + 0.0001*seconds
+			if seconds > 1 {
+				lat = 37.77500 + 0.0002*(seconds-1)
+			}
+			want := fmt.Sprintf("Speed: %.1f m/s\nlat: %.5f", 10*seconds, lat)
+			test.That(t, hud.text(d), test.ShouldEqual, want)
+		}
+	})
+
+	t.Run("Frames outside the telemetry draw no value", func(t *testing.T) {
+		hud, err := telemetry.hud(start)
+		test.That(t, err, test.ShouldBeNil)
+		defer hud.free()
+		test.That(t, hud.text(3*time.Second), test.ShouldEqual, "Speed: -- m/s\nlat: --")
+		test.That(t, hud.text(-time.Second), test.ShouldEqual, "Speed: -- m/s\nlat: --")
+	})
+
+	t.Run("Gaps longer than the max gap aren't interpolated", func(t *testing.T) {
+		gappy := *telemetry
+		gappy.MaxGap = 1500 * time.Millisecond
+		hud, err := gappy.hud(start)
+		test.That(t, err, test.ShouldBeNil)
+		defer hud.free()
+		test.That(t, hud.text(time.Second/2), test.ShouldEqual, "Speed: -- m/s\nlat: 37.77495")
+		test.That(t, hud.text(2*time.Second), test.ShouldEqual, "Speed: 20.0 m/s\nlat: 37.77520")
+	})
+
+	t.Run("Timestamp is the wall clock time of the frame", func(t *testing.T) {
+		stamped := *telemetry
+		stamped.Timestamp = true
+		hud, err := stamped.hud(start)
+		test.That(t, err, test.ShouldBeNil)
+		defer hud.free()
+		d := 1250 * time.Millisecond
+		lines := strings.Split(hud.text(d), "\n")
+		test.That(t, len(lines), test.ShouldEqual, 3)
+		test.That(t, lines[0], test.ShouldEqual, start.Add(d).Local().Format("2006-01-02 15:04:05.000"))
+		test.That(t, lines[1], test.ShouldEqual, "Speed: 12.5 m/s")
+	})
+
+	t.Run("Telemetry is read from a CSV", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "telemetry.csv")
+		csv := fmt.Sprintf("time,speed,lat\n%s,0,37.7749\n%d.5,,37.775\n%s,20,37.7752\n",
+			start.Format(time.RFC3339), start.Unix(), start.Add(2*time.Second).Format(time.RFC3339))
+		test.That(t, os.WriteFile(path, []byte(csv), 0o600), test.ShouldBeNil)
+		fromCSV := &Telemetry{Fields: telemetry.Fields, Path: path}
+		test.That(t, fromCSV.Validate(), test.ShouldBeNil)
+		hud, err := fromCSV.hud(start)
+		test.That(t, err, test.ShouldBeNil)
+		defer hud.free()
+		test.That(t, hud.text(time.Second/2), test.ShouldEqual, "Speed: 5.0 m/s\nlat: 37.77500")
+		test.That(t, hud.text(time.Second), test.ShouldEqual, "Speed: 10.0 m/s\nlat: 37.77507")
+	})
+
+	t.Run("HUD is drawn on the export", func(t *testing.T) {
+		if _, err := findFont(); err != nil {
+			t.Skip(err)
+		}
+		black := solidJPEG(t, color.Black)
+		frames := make([][]byte, 3*framerate)
+		for i := range frames {
+			frames[i] = black
+		}
+		vs, config := newTestExportStore(t, start, frames, framerate)
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(3 * time.Second),
+			Telemetry: telemetry,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		gray, err := grayFrameAt(filepath.Join(config.Storage.UploadPath, res.Filename), time.Second, 640, 480)
+		test.That(t, err, test.ShouldBeNil)
+		img := &image.Gray{Pix: gray, Stride: 640, Rect: image.Rect(0, 0, 640, 480)}
+		// The HUD is drawn in the top left, 1/40th of the frame in.
+		empty := meanBrightness(img, image.Rect(320, 240, 640, 480))
+		test.That(t, meanBrightness(img, image.Rect(16, 12, 200, 60)), test.ShouldBeGreaterThan, empty+10)
+	})
+
+	t.Run("Telemetry CSV must be in the export input path", func(t *testing.T) {
+		vs, _ := newTestExportStore(t, start, [][]byte{solidJPEG(t, color.Black)}, framerate)
+		outside := filepath.Join(t.TempDir(), "telemetry.csv")
+		test.That(t, os.WriteFile(outside, []byte("time,speed\n"), 0o600), test.ShouldBeNil)
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(time.Second),
+			Telemetry: &Telemetry{Fields: telemetry.Fields, Path: outside},
+		})
+		test.That(t, err, test.ShouldBeError, "export input "+outside+" is outside of the export input path")
+	})
+
+	t.Run("Invalid telemetry is rejected", func(t *testing.T) {
+		test.That(t, (&Telemetry{Samples: telemetry.Samples}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Telemetry{Fields: telemetry.Fields}).Validate(), test.ShouldNotBeNil)
+		missing := &Telemetry{Fields: []TelemetryField{{Name: "heading"}}, Samples: telemetry.Samples}
+		test.That(t, missing.Validate(), test.ShouldBeError, "telemetry field heading has no samples")
+		unordered := &Telemetry{Fields: telemetry.Fields, Samples: []TelemetrySample{
+			telemetry.Samples[1], telemetry.Samples[0],
+		}}
+		test.That(t, unordered.Validate(), test.ShouldNotBeNil)
+		r := &ExportRequest{From: start, To: start.Add(time.Second), Telemetry: telemetry, Activity: &ActivityFilter{}}
+		test.That(t, r.Validate(), test.ShouldBeError, "activity can't be combined with telemetry")
+	})
+}
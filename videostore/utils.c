@@ -1,8 +1,29 @@
 #include "utils.h"
 #include <libavutil/log.h>
+#include <libavutil/time.h>
+#include <libavutil/display.h>
 #include <libavcodec/avcodec.h>
+#include <errno.h>
+#include <inttypes.h>
+#include <math.h>
 #include <string.h>
 
+// display_rotation returns the clockwise rotation in degrees, rounded to a
+// multiple of 90, that the display matrix of st says to display it at.
+static int display_rotation(const AVStream *st) {
+    const AVPacketSideData *sd = av_packet_side_data_get(
+        st->codecpar->coded_side_data, st->codecpar->nb_coded_side_data, AV_PKT_DATA_DISPLAYMATRIX);
+    if (sd == NULL || sd->size < 9 * sizeof(int32_t)) {
+        return 0;
+    }
+    double counterclockwise = av_display_rotation_get((const int32_t *)sd->data);
+    if (isnan(counterclockwise)) {
+        return 0;
+    }
+    int rotation = (int)lround(-counterclockwise / 90) * 90 % 360;
+    return rotation < 0 ? rotation + 360 : rotation;
+}
+
 int video_store_get_video_info(video_store_video_info *info, // OUT
                                const char *filename          // IN
 ) {
@@ -41,12 +62,18 @@ int video_store_get_video_info(video_store_video_info *info, // OUT
 
     int tmpWidth = 0;
     int tmpHeight = 0;
+    int tmpRotation = 0;
+    double tmpFrameRate = 0;
     char tmpCodec[VIDEO_STORE_CODEC_NAME_LEN];
     for (unsigned i = 0; i < fmt_ctx->nb_streams; i++) {
         AVStream *st = fmt_ctx->streams[i];
         if (st->codecpar->codec_type == AVMEDIA_TYPE_VIDEO) {
             tmpWidth  = st->codecpar->width;
             tmpHeight = st->codecpar->height;
+            tmpRotation = display_rotation(st);
+            if (st->avg_frame_rate.den > 0) {
+                tmpFrameRate = av_q2d(st->avg_frame_rate);
+            }
             const char *codecName = avcodec_get_name(st->codecpar->codec_id);
             if (codecName) {
                 strncpy(tmpCodec, codecName, VIDEO_STORE_CODEC_NAME_LEN - 1);
@@ -75,12 +102,43 @@ int video_store_get_video_info(video_store_video_info *info, // OUT
     info->duration = fmt_ctx->duration;
     info->width    = tmpWidth;
     info->height   = tmpHeight;
+    info->rotation = tmpRotation;
+    info->frameRate = tmpFrameRate;
     strncpy(info->codec, tmpCodec, VIDEO_STORE_CODEC_NAME_LEN);
 
     avformat_close_input(&fmt_ctx);
     return VIDEO_STORE_VIDEO_INFO_RESP_OK;
 }
 
+// io_open_hooked opens url with ioOpen, unless openHook fails it first.
+static int io_open_hooked(video_store_io_open_fn ioOpen, struct AVFormatContext *s, AVIOContext **pb,
+                          const char *url, int flags, AVDictionary **options, video_store_open_hook_fn openHook,
+                          const uintptr_t openHookCtx) {
+    if (openHook != NULL) {
+        int ret = openHook(openHookCtx, url);
+        if (ret < 0) {
+            return ret;
+        }
+    }
+    return ioOpen(s, pb, url, flags, options);
+}
+
+int video_store_io_open_retry(video_store_io_open_fn ioOpen, struct AVFormatContext *s, AVIOContext **pb,
+                              const char *url, int flags, AVDictionary **options, const int attempts,
+                              const int64_t backoffMicroseconds, video_store_open_hook_fn openHook,
+                              const uintptr_t openHookCtx) {
+    int64_t backoff = backoffMicroseconds;
+    int ret = io_open_hooked(ioOpen, s, pb, url, flags, options, openHook, openHookCtx);
+    for (int attempt = 1; attempt < attempts && (ret == AVERROR(EMFILE) || ret == AVERROR(ENFILE)); attempt++) {
+        av_log(NULL, AV_LOG_WARNING, "video_store_io_open_retry out of file descriptors opening %s, retrying in %" PRId64 "us\n",
+               url, backoff);
+        av_usleep((unsigned)backoff);
+        backoff *= 2;
+        ret = io_open_hooked(ioOpen, s, pb, url, flags, options, openHook, openHookCtx);
+    }
+    return ret;
+}
+
 void video_store_custom_av_log_callback(void *ptr, int level, const char *fmt, va_list vargs) {
     // Default callback will handle log level filtering
     av_log_default_callback(ptr, level, fmt, vargs);
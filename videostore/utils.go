@@ -7,12 +7,15 @@ package videostore
 #include <libavcodec/avcodec.h>
 #include <libavformat/avformat.h>
 #include <libavcodec/avcodec.h>
+#include <errno.h>
 */
 import "C"
 
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -22,6 +25,7 @@ import (
 	"unsafe"
 
 	"go.viam.com/rdk/logging"
+	"golang.org/x/sys/unix"
 )
 
 // SetLibAVLogLevel sets the libav log level.
@@ -49,11 +53,25 @@ type videoInfo struct {
 	width    int
 	height   int
 	codec    string
+	// rotation is the clockwise rotation in degrees, 0, 90, 180 or 270, the video is
+	// displayed at by players which honor its rotation metadata.
+	rotation int
+	// frameRate is the average frame rate, 0 if it is unknown.
+	frameRate float64
+}
+
+// timeRange is a span of time from from to to.
+type timeRange struct {
+	from, to time.Time
 }
 
 type fileWithDate struct {
 	name      string
 	startTime time.Time
+	// offset and duration are set for a segment in an archive, which is the duration long
+	// part of the archive at name starting offset into it.
+	offset   time.Duration
+	duration time.Duration
 }
 
 // ConcatFileEntry represents an entry in an FFmpeg concat demuxer file
@@ -61,6 +79,7 @@ type concatFileEntry struct {
 	filePath string
 	inpoint  *float64 // Optional start time trim point
 	outpoint *float64 // Optional end time trim point
+	duration *float64 // Optional time until the next entry starts, to keep a gap after it
 }
 
 // String returns the FFmpeg concat demuxer compatible string representation
@@ -73,6 +92,9 @@ func (e concatFileEntry) string() []string {
 	if e.outpoint != nil {
 		lines = append(lines, fmt.Sprintf("outpoint %.2f", *e.outpoint))
 	}
+	if e.duration != nil {
+		lines = append(lines, fmt.Sprintf("duration %.2f", *e.duration))
+	}
 	return lines
 }
 
@@ -81,10 +103,12 @@ func fromCVideoInfo(cinfo C.video_store_video_info) videoInfo {
 	return videoInfo{
 		// FFmpeg stores AVFormatContext->duration in AV_TIME_BASE units (1,000,000 ticks per second),
 		// so it effectively represents microseconds.
-		duration: time.Duration(cinfo.duration) * time.Microsecond,
-		width:    int(cinfo.width),
-		height:   int(cinfo.height),
-		codec:    C.GoString(&cinfo.codec[0]),
+		duration:  time.Duration(cinfo.duration) * time.Microsecond,
+		width:     int(cinfo.width),
+		height:    int(cinfo.height),
+		codec:     C.GoString(&cinfo.codec[0]),
+		rotation:  int(cinfo.rotation),
+		frameRate: float64(cinfo.frameRate),
 	}
 }
 
@@ -127,8 +151,9 @@ func ffmpegLogLevel(loglevel C.int) {
 }
 
 // SetFFmpegLogCallback sets the custom log callback for ffmpeg.
+// It is also set when the first video store is created, and is only ever set once.
 func SetFFmpegLogCallback() {
-	C.video_store_set_custom_av_log_callback()
+	processLibAV.setLogCallback()
 }
 
 // lookupLogID returns the log ID for the provided log level.
@@ -148,15 +173,110 @@ func lookupLogID(level string) C.int {
 }
 
 // createDir creates a directory at the provided path if it does not exist.
+// Failures are wrapped with what needs fixing, see dirError.
 func createDir(path string) error {
 	const dirPermissions = 0o755
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		err := os.MkdirAll(path, dirPermissions)
-		if err != nil {
-			return err
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if !info.IsDir() {
+			return dirError(path, &fs.PathError{Op: "mkdir", Path: path, Err: unix.EEXIST})
+		}
+		return nil
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(path, dirPermissions); err != nil {
+			return dirError(path, err)
 		}
+		return nil
+	default:
+		return dirError(path, err)
 	}
-	return nil
+}
+
+// dirError wraps err, from creating the directory at path, with what the operator needs to
+// fix: the permission the process is missing, the file in the way, or the full filesystem.
+func dirError(path string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		parent, parentErr := nearestExistingDir(path)
+		if parentErr != nil {
+			parent = filepath.Dir(filepath.Clean(path))
+		}
+		return fmt.Errorf("permission denied creating directory %s: uid %d needs write and execute permission on %s: %w",
+			path, os.Geteuid(), parent, err)
+	case errors.Is(err, unix.EEXIST), errors.Is(err, unix.ENOTDIR):
+		return fmt.Errorf("failed to create directory %s: %s is a file, move it or choose another path: %w",
+			path, existingPath(path), err)
+	case errors.Is(err, unix.ENOSPC):
+		return fmt.Errorf("failed to create directory %s: no space left on its filesystem, free some or choose another path: %w",
+			path, err)
+	default:
+		return fmt.Errorf("failed to create directory %s: %w", path, err)
+	}
+}
+
+// existingPath returns path or, if it doesn't exist, its nearest parent which does.
+func existingPath(path string) string {
+	p := filepath.Clean(path)
+	for {
+		if _, err := os.Lstat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return p
+		}
+		p = parent
+	}
+}
+
+// nearestExistingDir walks up from path and returns the first directory that exists.
+// This is the directory createDir would create path under.
+func nearestExistingDir(path string) (string, error) {
+	dir := filepath.Clean(path)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return "", fmt.Errorf("%s is not a directory", dir)
+			}
+			return dir, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no existing parent directory found for %s", path)
+		}
+		dir = parent
+	}
+}
+
+// isWritable returns an error if the current process can not write to the directory at path.
+func isWritable(path string) error {
+	return unix.Access(path, unix.W_OK)
+}
+
+// isReadOnlyFilesystem returns true if path is on a filesystem mounted read-only. A path
+// which doesn't exist yet is on the filesystem of its nearest existing parent.
+func isReadOnlyFilesystem(path string) bool {
+	dir, err := nearestExistingDir(path)
+	if err != nil {
+		return false
+	}
+	return errors.Is(unix.Access(dir, unix.W_OK), unix.EROFS)
+}
+
+// getFreeDiskSpace returns the number of bytes available to unprivileged users
+// on the filesystem containing path.
+func getFreeDiskSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	//nolint:unconvert // Bsize is not an int64 on every platform
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
 }
 
 // readVideoFile takes in a path to mp4 file and returns bytes of the file.
@@ -168,6 +288,25 @@ func readVideoFile(path string) ([]byte, error) {
 	return data, nil
 }
 
+// maxSizeGB is the largest storage size in gigabytes whose size in bytes fits in an int64.
+const maxSizeGB = math.MaxInt64 / gigabyte
+
+// sizeGBToBytes converts sizeGB to bytes, returning an error if it doesn't fit in an int64.
+func sizeGBToBytes(sizeGB int) (int64, error) {
+	if int64(sizeGB) > maxSizeGB {
+		return 0, fmt.Errorf("size_gb %d is too large, must be at most %d", sizeGB, maxSizeGB)
+	}
+	return int64(sizeGB) * gigabyte, nil
+}
+
+// addSize returns total+n, or an error if it doesn't fit in an int64.
+func addSize(total, n int64) (int64, error) {
+	if n > math.MaxInt64-total {
+		return 0, errors.New("size overflows int64")
+	}
+	return total + n, nil
+}
+
 // getDirectorySize returns the size of a directory in bytes.
 func getDirectorySize(path string) (int64, error) {
 	var size int64
@@ -176,7 +315,9 @@ func getDirectorySize(path string) (int64, error) {
 			return err
 		}
 		if !info.IsDir() {
-			size += info.Size()
+			if size, err = addSize(size, info.Size()); err != nil {
+				return fmt.Errorf("size of %s: %w", path, err)
+			}
 		}
 		return nil
 	})
@@ -241,6 +382,15 @@ func extractDateTimeFromFilename(filePath string) (time.Time, error) {
 		return time.Unix(timestamp, 0), nil
 	}
 
+	// Unix timestamp with milliseconds case, for segments named by their RTCP wall clock time
+	if seconds, milliseconds, ok := strings.Cut(nameWithoutExt, "."); ok && len(milliseconds) == 3 {
+		s, sErr := strconv.ParseInt(seconds, 10, 64)
+		ms, msErr := strconv.ParseInt(milliseconds, 10, 64)
+		if sErr == nil && msErr == nil {
+			return time.UnixMilli(s*1000 + ms), nil
+		}
+	}
+
 	// Datetime format case - keep in local time
 	return ParseDateTimeString(nameWithoutExt)
 }
@@ -267,7 +417,17 @@ func ParseDateTimeString(datetime string) (time.Time, error) {
 // The input files must be sorted by start time, and the function assumes video segments
 // don't overlap in time.
 func matchStorageToRange(files []fileWithDate, start, end time.Time, logger logging.Logger) []concatFileEntry {
-	var entries []concatFileEntry
+	entries, _ := matchStorageSpans(files, start, end, videoInfoCache{}, logger)
+	return entries
+}
+
+// matchStorageSpans is matchStorageToRange which also returns the time range the part of
+// the file in each entry spans. The video info of each file is looked up in infos.
+func matchStorageSpans(files []fileWithDate, start, end time.Time, infos videoInfoCache, logger logging.Logger) ([]concatFileEntry, []timeRange) {
+	var (
+		entries []concatFileEntry
+		spans   []timeRange
+	)
 	// Cache of the first matched video file's width, height, and codec
 	// to ensure every video in the matched files set have the same params.
 	var firstSeenVideoInfo videoInfo
@@ -276,7 +436,8 @@ func matchStorageToRange(files []fileWithDate, start, end time.Time, logger logg
 	lastFileIndex := len(files)
 	for i, file := range files {
 		if file.startTime.After(start) && firstFileIndex == -1 {
-			firstFileIndex = i - 1
+			// A range starting before the oldest file starts with it.
+			firstFileIndex = max(i-1, 0)
 		}
 		if file.startTime.After(end) {
 			lastFileIndex = i
@@ -288,11 +449,14 @@ func matchStorageToRange(files []fileWithDate, start, end time.Time, logger logg
 		firstFileIndex = len(files) - 1
 	}
 	for _, file := range files[firstFileIndex:lastFileIndex] {
-		videoFileInfo, err := getVideoInfo(file.name)
+		videoFileInfo, err := infos.get(file.name)
 		if err != nil {
 			logger.Debugf("failed to get video duration for file: %s, error: %v", file.name, err)
 			continue
 		}
+		if file.duration > 0 {
+			videoFileInfo.duration = file.duration
+		}
 		fileEndTime := file.startTime.Add(videoFileInfo.duration)
 		// Check if the segment file's time range intersects
 		// with the match request time range [start, end)
@@ -313,20 +477,28 @@ func matchStorageToRange(files []fileWithDate, start, end time.Time, logger logg
 			logger.Debugf("Matched file %s", file.name)
 			entry := concatFileEntry{filePath: file.name}
 			// Calculate inpoint if the file starts before the 'start' time and overlaps
+			// Archived segments are always trimmed to their part of the archive.
 			if file.startTime.Before(start) {
-				inpoint := start.Sub(file.startTime).Seconds()
+				inpoint := (file.offset + start.Sub(file.startTime)).Seconds()
+				entry.inpoint = &inpoint
+			} else if file.offset > 0 {
+				inpoint := file.offset.Seconds()
 				entry.inpoint = &inpoint
 			}
 			// Calculate outpoint if the file ends after the 'end' time
 			if fileEndTime.After(end) {
-				outpoint := end.Sub(file.startTime).Seconds()
+				outpoint := (file.offset + end.Sub(file.startTime)).Seconds()
+				entry.outpoint = &outpoint
+			} else if file.duration > 0 {
+				outpoint := (file.offset + file.duration).Seconds()
 				entry.outpoint = &outpoint
 			}
 			entries = append(entries, entry)
+			spans = append(spans, timeRange{from: maxTime(file.startTime, start), to: minTime(fileEndTime, end)})
 		}
 	}
 
-	return entries
+	return entries, spans
 }
 
 // cacheFirstVid caches the first video file's width, height, and codec.
@@ -372,12 +544,54 @@ func validateTimeRange(files []fileWithDate, start, end time.Time) error {
 	}
 	oldestFileStart := files[0].startTime
 	newestFileStart := files[len(files)-1].startTime
-	if start.Before(oldestFileStart) || end.After(newestFileStart) {
+	if start.Before(oldestFileStart) || start.After(newestFileStart) {
 		return errors.New("time range is outside of storage range")
 	}
+	if end.After(newestFileStart) {
+		return fmt.Errorf("time range is outside of storage range: %w", ErrActiveSegment)
+	}
 	return nil
 }
 
+// videoInfoCache caches the video info of segments which are looked up more than once.
+type videoInfoCache map[string]videoInfo
+
+// get returns the video info of the segment at path.
+func (c videoInfoCache) get(path string) (videoInfo, error) {
+	if info, ok := c[path]; ok {
+		return info, nil
+	}
+	info, err := getVideoInfo(path)
+	if err != nil {
+		return videoInfo{}, err
+	}
+	c[path] = info
+	return info, nil
+}
+
+// segmentAt returns the segment in files which contains t, the offset of t into it and
+// its video info. ok is false if t falls in a gap between segments.
+func (c videoInfoCache) segmentAt(files []fileWithDate, t time.Time) (string, time.Duration, videoInfo, bool) {
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].startTime.After(t) {
+			continue
+		}
+		info, err := c.get(files[i].name)
+		offset := t.Sub(files[i].startTime)
+		if err != nil || offset >= info.duration {
+			return "", 0, videoInfo{}, false
+		}
+		return files[i].name, offset, info, true
+	}
+	return "", 0, videoInfo{}, false
+}
+
+// isOutOfFileDescriptors returns true if ret is the libav error for the process or
+// system running out of file descriptors.
+func isOutOfFileDescriptors(ret C.int) bool {
+	return ret == -C.EMFILE || ret == -C.ENFILE
+}
+
 // getVideoInfo calls the C function get_video_info to retrieve
 // duration, width, height, and codec of a video file.
 func getVideoInfo(filePath string) (videoInfo, error) {
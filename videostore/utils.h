@@ -9,10 +9,28 @@ struct video_store_video_info {
     int width;
     int height;
     char codec[VIDEO_STORE_CODEC_NAME_LEN];
+    // rotation is the clockwise rotation in degrees, 0, 90, 180 or 270, the
+    // stream's display matrix says to display the video at.
+    int rotation;
+    // frameRate is the stream's average frame rate, 0 if it is unknown.
+    double frameRate;
 };
 typedef struct video_store_video_info video_store_video_info;
 int video_store_get_video_duration(int64_t *duration, const char *filename);
 void video_store_custom_av_log_callback(void *ptr, int level, const char *fmt, va_list vargs);
 void video_store_set_custom_av_log_callback();
 int video_store_get_video_info(video_store_video_info *info, const char *filename);
+typedef int (*video_store_io_open_fn)(struct AVFormatContext *s, AVIOContext **pb, const char *url, int flags,
+                                      AVDictionary **options);
+// video_store_open_hook_fn is called with hookCtx and the url of each file
+// before it is opened. A negative AVERROR fails the open with it.
+typedef int (*video_store_open_hook_fn)(uintptr_t hookCtx, const char *url);
+// video_store_io_open_retry opens url with ioOpen, trying up to attempts times
+// while it fails with EMFILE or ENFILE because file descriptors are exhausted.
+// It waits backoffMicroseconds before the first retry, doubling after each.
+// openHook, if not NULL, is called with openHookCtx before each attempt.
+int video_store_io_open_retry(video_store_io_open_fn ioOpen, struct AVFormatContext *s, AVIOContext **pb,
+                              const char *url, int flags, AVDictionary **options, const int attempts,
+                              const int64_t backoffMicroseconds, video_store_open_hook_fn openHook,
+                              const uintptr_t openHookCtx);
 #endif /* VIAM_VIDEOSTORE_UTILS_H */
@@ -3,11 +3,17 @@ package videostore
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"go.viam.com/rdk/logging"
 	"go.viam.com/test"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -248,6 +254,11 @@ func TestExtractDateTimeFromFilename(t *testing.T) {
 			filename:         unixToFilename(segmentUnix1),
 			expectedDateTime: time.Unix(segmentUnix1, 0),
 		},
+		{
+			name:             "Unix timestamp with milliseconds format",
+			filename:         fmt.Sprintf("%d.042.mp4", segmentUnix1),
+			expectedDateTime: time.Unix(segmentUnix1, 42*int64(time.Millisecond)),
+		},
 		{
 			name:             "Legacy datetime format (local time)",
 			filename:         unixToDatetimeFilename(segmentUnix1),
@@ -276,3 +287,86 @@ func TestExtractDateTimeFromFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateDir(t *testing.T) {
+	t.Run("Missing directories are created", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "a", "b")
+		test.That(t, createDir(path), test.ShouldBeNil)
+		info, err := os.Stat(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.IsDir(), test.ShouldBeTrue)
+		test.That(t, createDir(path), test.ShouldBeNil)
+	})
+
+	t.Run("Permission errors name the directory and uid", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root is not denied permission")
+		}
+		parent := filepath.Join(t.TempDir(), "locked")
+		test.That(t, os.Mkdir(parent, 0o500), test.ShouldBeNil)
+		err := createDir(filepath.Join(parent, "storage"))
+		test.That(t, errors.Is(err, fs.ErrPermission), test.ShouldBeTrue)
+		test.That(t, err.Error(), test.ShouldContainSubstring,
+			fmt.Sprintf("uid %d needs write and execute permission on %s", os.Geteuid(), parent))
+	})
+
+	t.Run("Files in the way are named", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "storage")
+		test.That(t, os.WriteFile(file, nil, 0o600), test.ShouldBeNil)
+		err := createDir(file)
+		test.That(t, errors.Is(err, unix.EEXIST), test.ShouldBeTrue)
+		test.That(t, err.Error(), test.ShouldContainSubstring, file+" is a file")
+
+		err = createDir(filepath.Join(file, "segments"))
+		test.That(t, errors.Is(err, unix.ENOTDIR), test.ShouldBeTrue)
+		test.That(t, err.Error(), test.ShouldContainSubstring, file+" is a file")
+	})
+
+	t.Run("Each error class is described differently", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "storage")
+		messages := map[string]bool{}
+		for _, errno := range []unix.Errno{unix.EACCES, unix.EEXIST, unix.ENOSPC, unix.EIO} {
+			err := dirError(path, &fs.PathError{Op: "mkdir", Path: path, Err: errno})
+			test.That(t, errors.Is(err, errno), test.ShouldBeTrue)
+			test.That(t, err.Error(), test.ShouldContainSubstring, path)
+			// The description, before the wrapped error.
+			messages[strings.TrimSuffix(err.Error(), errno.Error())] = true
+		}
+		test.That(t, len(messages), test.ShouldEqual, 4)
+		err := dirError(path, &fs.PathError{Op: "mkdir", Path: path, Err: unix.ENOSPC})
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no space left")
+	})
+}
+
+// readOnlyMount returns the mount point of a filesystem mounted read-only, skipping the
+// test if there is none.
+func readOnlyMount(t *testing.T) string {
+	t.Helper()
+	b, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		t.Skip("no mount table to find a read-only filesystem in")
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		// Mount points with spaces are escaped, which isn't worth unescaping.
+		fields := strings.Fields(line)
+		if len(fields) < 4 || strings.Contains(fields[1], `\`) || !slices.Contains(strings.Split(fields[3], ","), "ro") {
+			continue
+		}
+		if info, err := os.Stat(fields[1]); err == nil && info.IsDir() && errors.Is(unix.Access(fields[1], unix.W_OK), unix.EROFS) {
+			return fields[1]
+		}
+	}
+	t.Skip("no read-only filesystem is mounted")
+	return ""
+}
+
+func TestIsReadOnlyFilesystem(t *testing.T) {
+	t.Run("A missing path under a read-only mount is read-only", func(t *testing.T) {
+		path := filepath.Join(readOnlyMount(t), "video-store-missing", "storage")
+		test.That(t, isReadOnlyFilesystem(path), test.ShouldBeTrue)
+	})
+
+	t.Run("A missing path under a writable directory isn't", func(t *testing.T) {
+		test.That(t, isReadOnlyFilesystem(filepath.Join(t.TempDir(), "missing", "storage")), test.ShouldBeFalse)
+	})
+}
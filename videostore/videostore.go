@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync/atomic"
@@ -34,6 +35,35 @@ const (
 	TimeFormat = "2006-01-02_15-04-05"
 )
 
+// ErrReadOnlyStorage is returned by operations that write to the storage path
+// when it is on a read-only filesystem. Operations that only read stored video still work.
+var ErrReadOnlyStorage = errors.New("storage path is on a read-only filesystem")
+
+// ErrActiveSegment is returned when a requested range extends into the segment which is
+// still being written and the latest footage isn't available yet.
+var ErrActiveSegment = errors.New("latest footage is still being written and isn't available yet")
+
+// ErrMixedCodec is returned when a range spanning a change of codec has to be returned as
+// one clip but MixedCodecSplit splits it.
+var ErrMixedCodec = errors.New("time range spans a change of codec, which is split into a clip per codec")
+
+// ErrTooManyOpenFiles is returned when a segment file couldn't be opened, even after
+// retrying, because the process or system is out of file descriptors. It is transient:
+// the segmenter can be closed and initialized again once descriptors are released.
+var ErrTooManyOpenFiles = errors.New("too many open files to open segment")
+
+// ErrDuplicatePTS is returned by RawSegmenter.WritePacket with DuplicatePTSReject when a
+// packet has the same PTS as the packet before it.
+var ErrDuplicatePTS = errors.New("packet has the same pts as the previous packet")
+
+// ErrRTPPacket is returned by RawSegmenter.WritePacket when a payload is an RTP packet,
+// header included, rather than a depacketized access unit. See RTPHeaderPolicy.
+var ErrRTPPacket = errors.New("payload is an rtp packet, depacketize it before writing it or set the rtp header policy to strip")
+
+// ErrDiskFull is returned by Export when the upload path doesn't have the space to write
+// the export, found before it starts or once a write fails. Nothing of it is left behind.
+var ErrDiskFull = errors.New("not enough disk space for export")
+
 var presets = map[string]struct{}{
 	"ultrafast": {},
 	"superfast": {},
@@ -56,12 +86,35 @@ type videostore struct {
 
 	rawSegmenter *RawSegmenter
 	concater     *concater
+	cleaner      *storageCleaner
+	growth       *growthTracker
+	// encoder is the encoder of a frame store, nil otherwise.
+	encoder  *encoder
+	remounts *remountWatcher
+	// memory is nil for read-only stores.
+	memory *memoryMonitor
+	// libav is the store's reference to libav's global state, released on Close.
+	libav *libavRef
+	// freeDiskSpace, if set, replaces getFreeDiskSpace when checking an export fits, so
+	// tests can simulate full disks.
+	freeDiskSpace func(path string) (int64, error)
+	// readOnlyFilesystem returns true if a path is on a read-only filesystem. It is nil
+	// for read-only stores, which don't write.
+	readOnlyFilesystem func(path string) bool
 }
 
 // VideoStore stores video and provides APIs to request the stored video.
 type VideoStore interface {
 	Fetch(ctx context.Context, r *FetchRequest) (*FetchResponse, error)
+	FetchClipTo(ctx context.Context, r *FetchRequest, w io.Writer) error
 	Save(ctx context.Context, r *SaveRequest) (*SaveResponse, error)
+	Export(ctx context.Context, r *ExportRequest) (*ExportResponse, error)
+	ExportSpriteSheet(ctx context.Context, r *SpriteSheetRequest) (*SpriteSheetResponse, error)
+	ExportFilmstrip(ctx context.Context, r *FilmstripRequest) (*FilmstripResponse, error)
+	FindDuplicateSegments(ctx context.Context, maxDistance int) ([]DuplicateSegments, error)
+	StoredRanges(ctx context.Context) ([]StoredRange, error)
+	StorageGrowth() StorageGrowth
+	MemoryHeadroom() (MemoryHeadroom, error)
 	Close()
 }
 
@@ -107,6 +160,19 @@ type SaveRequest struct {
 // SaveResponse is the response to the Save method.
 type SaveResponse struct {
 	Filename string
+	// Codec is the codec of the saved video. It is unknown for async saves.
+	Codec CodecType
+	// Clips is every file saved, in time order, when the range spans a change of codec
+	// and MixedCodecSplit is used. Filename is the first. It is nil otherwise.
+	Clips []SavedClip
+}
+
+// SavedClip is a file saved from the part of a range recorded in one codec.
+type SavedClip struct {
+	Filename string
+	// From is when the footage in the file starts.
+	From  time.Time
+	Codec CodecType
 }
 
 // Validate returns an error if the SaveRequest is invalid.
@@ -124,11 +190,40 @@ func (r *SaveRequest) Validate() error {
 type FetchRequest struct {
 	From time.Time
 	To   time.Time
+	// ActiveSegment is how a range extending into the segment still being written is handled.
+	ActiveSegment ActiveSegmentPolicy
 }
 
+// ActiveSegmentPolicy is how Fetch handles the newest segment, which is still being written
+// and has no trailer yet so can't be concatenated as is.
+type ActiveSegmentPolicy int
+
+const (
+	// ActiveSegmentExclude rejects ranges extending into the active segment with ErrActiveSegment.
+	ActiveSegmentExclude ActiveSegmentPolicy = iota
+	// ActiveSegmentSnapshot includes the part of the active segment flushed to disk so far,
+	// up to the last complete fragment. This requires a flush policy so the segment is
+	// fragmented, otherwise nothing of it is readable until it is closed. Segments encoded
+	// from frames are never fragmented, so frame polling stores reject it.
+	ActiveSegmentSnapshot
+)
+
 // FetchResponse is the resonse to the Fetch method.
 type FetchResponse struct {
 	Video []byte
+	// Codec is the codec of Video.
+	Codec CodecType
+	// Clips is all the video, in time order, when the range spans a change of codec and
+	// MixedCodecSplit is used. Video is the first. It is nil otherwise.
+	Clips []FetchedClip
+}
+
+// FetchedClip is the video from the part of a range recorded in one codec.
+type FetchedClip struct {
+	Video []byte
+	// From is when the footage in Video starts.
+	From  time.Time
+	Codec CodecType
 }
 
 // Validate returns an error if the FetchRequest is invalid.
@@ -136,6 +231,9 @@ func (r *FetchRequest) Validate() error {
 	if r.From.After(r.To) {
 		return errors.New("'from' timestamp is after 'to' timestamp")
 	}
+	if r.ActiveSegment != ActiveSegmentExclude && r.ActiveSegment != ActiveSegmentSnapshot {
+		return fmt.Errorf("invalid active segment policy %d", r.ActiveSegment)
+	}
 	return nil
 }
 
@@ -155,28 +253,52 @@ func NewFramePollingVideoStore(config Config, logger logging.Logger) (VideoStore
 		config:      config,
 		workers:     utils.NewBackgroundStoppableWorkers(),
 	}
+	vs.readOnlyFilesystem = isReadOnlyFilesystem
+	if vs.readOnlyFilesystem(config.Storage.StoragePath) {
+		return nil, ErrReadOnlyStorage
+	}
 	if err := createDir(config.Storage.StoragePath); err != nil {
 		return nil, err
 	}
+	if err := createDir(config.Storage.recordPath()); err != nil {
+		return nil, err
+	}
 	err := createDir(vs.config.Storage.UploadPath)
 	if err != nil {
 		return nil, err
 	}
+	if err := createArchiveDir(config.Storage); err != nil {
+		return nil, err
+	}
 
 	// Create concater to handle concatenation of video clips when requested.
 	vs.concater, err = newConcater(
 		config.Storage.StoragePath,
 		config.Storage.UploadPath,
+		config.Storage.Archive.Path,
 		logger,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	// Everything which can fail is set up before the encoder and workers are started, so
+	// a failure doesn't leave them running.
+	if vs.cleaner, err = newStorageCleaner(config.Storage, vs.readOnlyFilesystem, logger); err != nil {
+		return nil, err
+	}
+	if vs.growth, err = newGrowthTracker(config.Storage, logger); err != nil {
+		return nil, err
+	}
+	if vs.remounts, err = vs.storageRemountWatcher(); err != nil {
+		return nil, err
+	}
+
 	encoder, err := newEncoder(
 		vs.config.Encoder,
 		vs.config.FramePoller.Framerate,
-		vs.config.Storage.StoragePath,
+		vs.config.Storage.recordPath(),
+		vs.config.Storage.OpenRetry,
 		logger,
 	)
 	if err != nil {
@@ -199,7 +321,21 @@ func NewFramePollingVideoStore(config Config, logger logging.Logger) (VideoStore
 			config.FramePoller.Framerate,
 			encoder)
 	})
+	vs.encoder = encoder
+	vs.memory = newMemoryMonitor(config.MemoryPressure, nil, logger)
 	vs.workers.Add(vs.deleter)
+	vs.workers.Add(vs.hasher)
+	vs.workers.Add(vs.remountChecker)
+	if config.MemoryPressure.Threshold > 0 {
+		vs.workers.Add(vs.memoryChecker)
+	}
+	if config.Storage.Live.Path != "" {
+		vs.workers.Add(vs.migrator)
+	}
+	if config.Storage.Archive.Path != "" {
+		vs.workers.Add(vs.archiver)
+	}
+	vs.libav = processLibAV.acquire()
 
 	return vs, nil
 }
@@ -213,8 +349,11 @@ func NewReadOnlyVideoStore(config Config, logger logging.Logger) (VideoStore, er
 		return nil, err
 	}
 
-	if err := createDir(config.Storage.StoragePath); err != nil {
-		return nil, err
+	// A storage path on a read-only filesystem can't be created, and has no video yet.
+	if !isReadOnlyFilesystem(config.Storage.StoragePath) {
+		if err := createDir(config.Storage.StoragePath); err != nil {
+			return nil, err
+		}
 	}
 	if err := createDir(config.Storage.UploadPath); err != nil {
 		return nil, err
@@ -223,6 +362,7 @@ func NewReadOnlyVideoStore(config Config, logger logging.Logger) (VideoStore, er
 	concater, err := newConcater(
 		config.Storage.StoragePath,
 		config.Storage.UploadPath,
+		config.Storage.Archive.Path,
 		logger,
 	)
 	if err != nil {
@@ -235,11 +375,18 @@ func NewReadOnlyVideoStore(config Config, logger logging.Logger) (VideoStore, er
 		logger:   logger,
 		config:   config,
 		workers:  utils.NewBackgroundStoppableWorkers(),
+		libav:    processLibAV.acquire(),
 	}, nil
 }
 
 // NewRTPVideoStore returns a VideoStore that stores video it receives from the caller.
 func NewRTPVideoStore(config Config, logger logging.Logger) (RTPVideoStore, error) {
+	return newRTPVideoStore(config, config.rawSegmenterOptions(), logger)
+}
+
+// newRTPVideoStore is NewRTPVideoStore with the segmenter's options, which tests set
+// hooks in.
+func newRTPVideoStore(config Config, opts rawSegmenterOptions, logger logging.Logger) (RTPVideoStore, error) {
 	if config.Type != SourceTypeRTP {
 		return nil, fmt.Errorf("config type must be %s", SourceTypeRTP)
 	}
@@ -247,30 +394,94 @@ func NewRTPVideoStore(config Config, logger logging.Logger) (RTPVideoStore, erro
 		return nil, err
 	}
 
-	if err := createDir(config.Storage.StoragePath); err != nil {
+	rawSegmenter, err := newRawSegmenter(config.Storage.recordPath(), opts, logger)
+	if err != nil {
 		return nil, err
 	}
+	// A storage path on a read-only filesystem can't be created, nor is it written to.
+	if !rawSegmenter.readOnlyFilesystem(config.Storage.StoragePath) {
+		if err := createDir(config.Storage.StoragePath); err != nil {
+			return nil, err
+		}
+	}
 	if err := createDir(config.Storage.UploadPath); err != nil {
 		return nil, err
 	}
+	if err := createArchiveDir(config.Storage); err != nil {
+		return nil, err
+	}
 
 	concater, err := newConcater(
 		config.Storage.StoragePath,
 		config.Storage.UploadPath,
+		config.Storage.Archive.Path,
 		logger,
 	)
 	if err != nil {
 		return nil, err
 	}
+	vs, err := startRTPVideoStore(config, concater, rawSegmenter, logger)
+	if err != nil {
+		return nil, err
+	}
+	vs.libav = processLibAV.acquire()
+	return vs, nil
+}
 
-	rawSegmenter, err := newRawSegmenter(
-		config.Storage.StoragePath,
-		logger,
-	)
+// reconfigure returns an RTP video store with config which takes over vs's segmenter,
+// reconfigured with RawSegmenter.Reconfigure, and stops vs's workers. If the config is
+// invalid vs is left running and nil is returned with the error. If the segmenter
+// fails to reconfigure vs is returned with the error to be closed.
+func (vs *videostore) reconfigure(ctx context.Context, config Config, logger logging.Logger) (*videostore, error) {
+	if config.Type != SourceTypeRTP {
+		return nil, fmt.Errorf("config type must be %s", SourceTypeRTP)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	for _, path := range []string{config.Storage.StoragePath, config.Storage.UploadPath} {
+		// A storage path on a read-only filesystem can't be created, nor is it written to.
+		if path == config.Storage.StoragePath && vs.rawSegmenter.readOnlyFilesystem(path) {
+			continue
+		}
+		if err := createDir(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := createArchiveDir(config.Storage); err != nil {
+		return nil, err
+	}
+	concater, err := newConcater(config.Storage.StoragePath, config.Storage.UploadPath, config.Storage.Archive.Path, logger)
 	if err != nil {
 		return nil, err
 	}
 
+	vs.workers.Stop()
+	// A segmenter on read-only storage doesn't write, so has nothing to reconfigure.
+	if !vs.rawSegmenter.readOnly {
+		if err := vs.rawSegmenter.Reconfigure(ctx, config); err != nil {
+			return vs, err
+		}
+	}
+	// Segments left in a live path which is no longer recorded to are complete.
+	old := vs.config.Storage
+	if old.Live.Path != "" && (old.Live.Path != config.Storage.Live.Path || old.StoragePath != config.Storage.StoragePath) {
+		if err := migrateSegments(old, true, vs.settingDurations(), vs.logger); err != nil {
+			vs.logger.Error("failed to migrate segments", err)
+		}
+	}
+	next, err := startRTPVideoStore(config, concater, vs.rawSegmenter, logger)
+	if err != nil {
+		return nil, err
+	}
+	// The previous store isn't closed, so its reference is handed over.
+	next.libav, vs.libav = vs.libav, nil
+	return next, nil
+}
+
+// startRTPVideoStore returns an RTP video store writing with rawSegmenter and starts its
+// workers.
+func startRTPVideoStore(config Config, concater *concater, rawSegmenter *RawSegmenter, logger logging.Logger) (*videostore, error) {
 	vs := &videostore{
 		typ:          config.Type,
 		concater:     concater,
@@ -279,8 +490,41 @@ func NewRTPVideoStore(config Config, logger logging.Logger) (RTPVideoStore, erro
 		config:       config,
 		workers:      utils.NewBackgroundStoppableWorkers(),
 	}
+	vs.readOnlyFilesystem = rawSegmenter.readOnlyFilesystem
 
+	// There is nothing to clean up on read-only storage and the
+	// segmenter refuses to write to it.
+	if rawSegmenter.readOnly {
+		logger.Warnf("storage path %s is read-only, video will not be stored", config.Storage.StoragePath)
+		return vs, nil
+	}
+	var err error
+	if vs.cleaner, err = newStorageCleaner(config.Storage, vs.readOnlyFilesystem, logger); err != nil {
+		return nil, err
+	}
+	if vs.growth, err = newGrowthTracker(config.Storage, logger); err != nil {
+		return nil, err
+	}
+	if vs.remounts, err = vs.storageRemountWatcher(); err != nil {
+		return nil, err
+	}
+	// A reconfigured segmenter may still be shedding memory for the store it came from.
+	rawSegmenter.setMemoryPressure(false, false)
+	vs.memory = newMemoryMonitor(config.MemoryPressure, func(underPressure bool) {
+		rawSegmenter.setMemoryPressure(underPressure, config.MemoryPressure.DropPackets)
+	}, logger)
 	vs.workers.Add(vs.deleter)
+	vs.workers.Add(vs.hasher)
+	vs.workers.Add(vs.remountChecker)
+	if config.MemoryPressure.Threshold > 0 {
+		vs.workers.Add(vs.memoryChecker)
+	}
+	if config.Storage.Live.Path != "" {
+		vs.workers.Add(vs.migrator)
+	}
+	if config.Storage.Archive.Path != "" {
+		vs.workers.Add(vs.archiver)
+	}
 	return vs, nil
 }
 
@@ -288,13 +532,59 @@ func (vs *videostore) Segmenter() *RawSegmenter {
 	return vs.rawSegmenter
 }
 
-func (vs *videostore) Fetch(_ context.Context, r *FetchRequest) (*FetchResponse, error) {
+func (vs *videostore) Fetch(ctx context.Context, r *FetchRequest) (*FetchResponse, error) {
+	var clips []FetchedClip
+	err := vs.fetch(ctx, r, func(outputs []concatOutput) error {
+		for _, o := range outputs {
+			videoBytes, err := readVideoFile(o.path)
+			if err != nil {
+				return err
+			}
+			clips = append(clips, FetchedClip{Video: videoBytes, From: o.from, Codec: o.codec})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &FetchResponse{Video: clips[0].Video, Codec: clips[0].Codec}
+	if len(clips) > 1 {
+		res.Clips = clips
+	}
+	return res, nil
+}
+
+// FetchClipTo writes the video matching r to w instead of returning it in memory.
+// w may block to apply backpressure. A range spanning a change of codec can only be
+// written as one clip with MixedCodecTranscode, ErrMixedCodec is returned otherwise.
+func (vs *videostore) FetchClipTo(ctx context.Context, r *FetchRequest, w io.Writer) error {
+	return vs.fetch(ctx, r, func(outputs []concatOutput) error {
+		if len(outputs) > 1 {
+			return ErrMixedCodec
+		}
+		f, err := os.Open(outputs[0].path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// fetch concatenates the video matching r into temporary files, more than one if it is split
+// at changes of codec, and calls read with them. The temporary files are removed once read returns.
+func (vs *videostore) fetch(ctx context.Context, r *FetchRequest, read func(outputs []concatOutput) error) error {
 	// Convert incoming local times to UTC for consistent timestamp handling
 	// All internal operations and segmenter timestamps are in UTC
 	r.From = r.From.UTC()
 	r.To = r.To.UTC()
 	if err := r.Validate(); err != nil {
-		return nil, err
+		return err
+	}
+	if r.ActiveSegment == ActiveSegmentSnapshot && vs.encoder != nil {
+		return fmt.Errorf("%w: segments encoded from frames can't be snapshotted, only an RTP store's with a flush policy can",
+			ErrActiveSegment)
 	}
 	vs.logger.Debug("fetch command received and validated")
 	fetchFilePath := generateOutputFilePath(
@@ -303,30 +593,55 @@ func (vs *videostore) Fetch(_ context.Context, r *FetchRequest) (*FetchResponse,
 		"",
 		tempPath)
 
-	// Always attempt to remove the concat file after the operation.
+	fetchFilePaths := []string{fetchFilePath}
+	nextPath := func(from time.Time) string {
+		path := generateOutputFilePath(vs.config.Storage.OutputFileNamePrefix, from, "", tempPath)
+		fetchFilePaths = append(fetchFilePaths, path)
+		return path
+	}
+
+	// Always attempt to remove the concat files after the operation.
 	// This handles error cases in Concat where it fails in the middle
 	// of writing.
 	defer func() {
-		if _, statErr := os.Stat(fetchFilePath); os.IsNotExist(statErr) {
-			vs.logger.Debugf("temporary file (%s) does not exist, skipping removal", fetchFilePath)
-			return
-		}
-		if err := os.Remove(fetchFilePath); err != nil {
-			vs.logger.Warnf("failed to delete temporary file (%s): %v", fetchFilePath, err)
+		for _, fetchFilePath := range fetchFilePaths {
+			if _, statErr := os.Stat(fetchFilePath); os.IsNotExist(statErr) {
+				vs.logger.Debugf("temporary file (%s) does not exist, skipping removal", fetchFilePath)
+				continue
+			}
+			if err := os.Remove(fetchFilePath); err != nil {
+				vs.logger.Warnf("failed to delete temporary file (%s): %v", fetchFilePath, err)
+			}
 		}
 	}()
-	if err := vs.concater.Concat(r.From, r.To, fetchFilePath); err != nil {
+	var outputs []concatOutput
+	var err error
+	if r.ActiveSegment == ActiveSegmentSnapshot {
+		outputs, err = vs.concatWithActive(r.From, r.To, fetchFilePath)
+	} else {
+		outputs, err = vs.concatRuns(ctx, r.From, r.To, fetchFilePath, nextPath)
+	}
+	if err != nil {
 		vs.logger.Error("failed to concat files ", err)
+		return err
+	}
+	return read(outputs)
+}
+
+// concatWithActive is concater.ConcatWithActive returning the output written. Only the
+// segments in the codec of the first are included.
+func (vs *videostore) concatWithActive(from, to time.Time, path string) ([]concatOutput, error) {
+	if err := vs.concater.ConcatWithActive(from, to, path); err != nil {
 		return nil, err
 	}
-	videoBytes, err := readVideoFile(fetchFilePath)
+	info, err := getVideoInfo(path)
 	if err != nil {
 		return nil, err
 	}
-	return &FetchResponse{Video: videoBytes}, nil
+	return []concatOutput{{path: path, from: from, codec: codecTypeOf(info.codec)}}, nil
 }
 
-func (vs *videostore) Save(_ context.Context, r *SaveRequest) (*SaveResponse, error) {
+func (vs *videostore) Save(ctx context.Context, r *SaveRequest) (*SaveResponse, error) {
 	// Convert incoming local times to UTC for consistent timestamp handling
 	// All internal operations and segmenter timestamps are in UTC
 	r.From = r.From.UTC()
@@ -345,16 +660,30 @@ func (vs *videostore) Save(_ context.Context, r *SaveRequest) (*SaveResponse, er
 	if r.Async {
 		vs.logger.Debug("running save command asynchronously")
 		vs.workers.Add(func(ctx context.Context) {
-			vs.asyncSave(ctx, r.From, r.To, uploadFilePath)
+			vs.asyncSave(ctx, r.From, r.To, uploadFilePath, r.Metadata)
 		})
 		return &SaveResponse{Filename: uploadFileName}, nil
 	}
 
-	if err := vs.concater.Concat(r.From, r.To, uploadFilePath); err != nil {
+	outputs, err := vs.concatRuns(ctx, r.From, r.To, uploadFilePath, vs.savePath(r.Metadata))
+	if err != nil {
 		vs.logger.Error("failed to concat files ", err)
 		return nil, err
 	}
-	return &SaveResponse{Filename: uploadFileName}, nil
+	res := &SaveResponse{Filename: uploadFileName, Codec: outputs[0].codec}
+	if len(outputs) > 1 {
+		for _, o := range outputs {
+			res.Clips = append(res.Clips, SavedClip{Filename: filepath.Base(o.path), From: o.from, Codec: o.codec})
+		}
+	}
+	return res, nil
+}
+
+// savePath returns the upload path of the clip of a save starting at from.
+func (vs *videostore) savePath(metadata string) func(from time.Time) string {
+	return func(from time.Time) string {
+		return generateOutputFilePath(vs.config.Storage.OutputFileNamePrefix, from, metadata, vs.config.Storage.UploadPath)
+	}
 }
 
 func (vs *videostore) fetchFrames(ctx context.Context, framePoller FramePollerConfig,
@@ -402,8 +731,20 @@ func (vs *videostore) processFrames(
 			return
 		case <-ticker.C:
 			frame, ok := vs.latestFrame.Load().([]byte)
-			if ok && frame != nil {
-				encoder.encode(frame)
+			if !ok || frame == nil {
+				continue
+			}
+			err := encoder.encode(frame)
+			if err == nil {
+				continue
+			}
+			vs.logger.Error(err)
+			// The segmenter is set up again by the next frame, so the segment which
+			// couldn't be opened is retried once file descriptors are freed.
+			if errors.Is(err, ErrTooManyOpenFiles) {
+				if err := encoder.reopen(); err != nil {
+					vs.logger.Errorf("failed to restart encoder: %v", err)
+				}
 			}
 		}
 	}
@@ -411,6 +752,7 @@ func (vs *videostore) processFrames(
 
 // deleter is a go routine that cleans up old clips if storage is full. Runs on interval
 // and deletes the oldest clip until the storage size is below the configured max.
+// Storage growth is sampled on the same interval.
 func (vs *videostore) deleter(ctx context.Context) {
 	ticker := time.NewTicker(deleterInterval * time.Minute)
 	defer ticker.Stop()
@@ -419,8 +761,11 @@ func (vs *videostore) deleter(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			if err := vs.growth.sample(time.Now()); err != nil {
+				vs.logger.Warnf("failed to sample storage growth: %v", err)
+			}
 			// Perform the deletion of the oldest clip
-			if err := cleanupStorage(vs.config.Storage.StoragePath, vs.config.Storage.SizeGB, vs.logger); err != nil {
+			if _, err := vs.cleaner.cleanup(ctx); err != nil {
 				vs.logger.Error("failed to clean up storage", err)
 				continue
 			}
@@ -428,19 +773,25 @@ func (vs *videostore) deleter(ctx context.Context) {
 	}
 }
 
-func cleanupStorage(storagePath string, maxStorageSizeGB int, logger logging.Logger) error {
-	maxStorageSize := int64(maxStorageSizeGB) * gigabyte
+// cleanupStorage deletes the oldest files in storagePath until it is under maxStorageSize bytes
+// and returns the files deleted. Use storageCleaner rather than calling it directly so that
+// concurrent cleanups don't race.
+func cleanupStorage(storagePath string, maxStorageSize int64, readOnlyFilesystem func(path string) bool, logger logging.Logger) ([]string, error) {
+	if readOnlyFilesystem(storagePath) {
+		return nil, ErrReadOnlyStorage
+	}
 	currStorageSize, err := getDirectorySize(storagePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if currStorageSize < maxStorageSize {
-		return nil
+		return nil, nil
 	}
 	files, err := getSortedFiles(storagePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var deleted []string
 	for _, file := range files {
 		if currStorageSize < maxStorageSize {
 			break
@@ -448,24 +799,28 @@ func cleanupStorage(storagePath string, maxStorageSizeGB int, logger logging.Log
 		logger.Debugf("deleting file: %s", file)
 		err := os.Remove(file.name)
 		if err != nil {
-			return err
+			return deleted, err
+		}
+		deleted = append(deleted, file.name)
+		if err := removeSidecar(file.name); err != nil {
+			return deleted, err
 		}
 		logger.Debugf("deleted file: %s", file)
 		// NOTE: This is going to be super slow
 		// we should speed this up
 		currStorageSize, err = getDirectorySize(storagePath)
 		if err != nil {
-			return err
+			return deleted, err
 		}
 	}
-	return nil
+	return deleted, nil
 }
 
 // asyncSave command will run the concat operation in the background.
 // It waits for the segment duration before running to ensure the last segment
 // is written to storage before concatenation.
 // TODO: (seanp) Optimize this to immediately run as soon as the current segment is completed.
-func (vs *videostore) asyncSave(ctx context.Context, from, to time.Time, path string) {
+func (vs *videostore) asyncSave(ctx context.Context, from, to time.Time, path, metadata string) {
 	segmentDur := time.Duration(segmentSeconds) * time.Second
 	totalTimeout := time.Duration(asyncTimeout)*time.Second + segmentDur
 	ctx, cancel := context.WithTimeout(ctx, totalTimeout)
@@ -475,8 +830,7 @@ func (vs *videostore) asyncSave(ctx context.Context, from, to time.Time, path st
 	select {
 	case <-timer.C:
 		vs.logger.Debugf("executing concat for %s", path)
-		err := vs.concater.Concat(from, to, path)
-		if err != nil {
+		if _, err := vs.concatRuns(ctx, from, to, path, vs.savePath(metadata)); err != nil {
 			vs.logger.Error("failed to concat files ", err)
 		}
 		return
@@ -486,6 +840,15 @@ func (vs *videostore) asyncSave(ctx context.Context, from, to time.Time, path st
 	}
 }
 
+// StorageGrowth returns how fast stored video is growing as of the last sample, taken every
+// deleterInterval. It is zero for stores which don't record video.
+func (vs *videostore) StorageGrowth() StorageGrowth {
+	if vs.growth == nil {
+		return StorageGrowth{}
+	}
+	return vs.growth.current()
+}
+
 // Close closes the video storage camera component.
 func (vs *videostore) Close() {
 	if vs.workers != nil {
@@ -496,4 +859,12 @@ func (vs *videostore) Close() {
 			vs.logger.Errorf(err.Error())
 		}
 	}
+	// Recording has stopped so every live segment is complete.
+	recording := vs.typ == SourceTypeFrame || (vs.typ == SourceTypeRTP && !vs.rawSegmenter.readOnly)
+	if recording && vs.config.Storage.Live.Path != "" {
+		if err := migrateSegments(vs.config.Storage, true, vs.settingDurations(), vs.logger); err != nil {
+			vs.logger.Error("failed to migrate segments", err)
+		}
+	}
+	vs.libav.release()
 }
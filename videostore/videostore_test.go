@@ -0,0 +1,119 @@
+package videostore
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// encodeFrames encodes frames at framerate and returns the path of the segment written.
+func encodeFrames(t *testing.T, frames [][]byte, framerate int) string {
+	logger := logging.NewTestLogger(t)
+	storagePath := t.TempDir()
+	enc, err := newEncoder(EncoderConfig{Bitrate: 1000000, Preset: "ultrafast"}, framerate, storagePath, OpenRetryPolicy{}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, enc.initialize(), test.ShouldBeNil)
+	for _, frame := range frames {
+		test.That(t, enc.encode(frame), test.ShouldBeNil)
+	}
+	enc.close()
+	segments, err := filepath.Glob(filepath.Join(storagePath, "*.mp4"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(segments), test.ShouldEqual, 1)
+	return segments[0]
+}
+
+// storeTestSegment encodes frames at framerate into a segment in storagePath starting at start.
+func storeTestSegment(t *testing.T, storagePath string, start time.Time, frames [][]byte, framerate int) string {
+	test.That(t, os.MkdirAll(storagePath, 0o755), test.ShouldBeNil)
+	segment := filepath.Join(storagePath, strconv.FormatInt(start.Unix(), 10)+".mp4")
+	test.That(t, os.Rename(encodeFrames(t, frames, framerate), segment), test.ShouldBeNil)
+	return segment
+}
+
+// storeInProgressSegment writes an empty segment starting at start. The newest segment
+// is still being written so it bounds the time range that can be fetched.
+func storeInProgressSegment(t *testing.T, storagePath string, start time.Time) {
+	segment := filepath.Join(storagePath, strconv.FormatInt(start.Unix(), 10)+".mp4")
+	test.That(t, os.WriteFile(segment, nil, 0o600), test.ShouldBeNil)
+}
+
+func TestReadOnlyStorage(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	config := validRTPConfig(t)
+	storagePath := config.Storage.StoragePath
+
+	// Record a few seconds of video to read back.
+	const framerate = 10
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	frame := solidJPEG(t, color.Black)
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = frame
+	}
+	storeTestSegment(t, storagePath, start, frames, framerate)
+	storeInProgressSegment(t, storagePath, start.Add(time.Minute))
+
+	opts := config.rawSegmenterOptions()
+	readOnly := func(path string) bool { return path == storagePath }
+	opts.readOnlyFilesystem = readOnly
+	vs, err := newRTPVideoStore(config, opts, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer vs.Close()
+
+	t.Run("Writes fail with ErrReadOnlyStorage", func(t *testing.T) {
+		test.That(t, vs.Segmenter().Init(CodecTypeH264, 640, 480), test.ShouldBeError, ErrReadOnlyStorage)
+		test.That(t, vs.Segmenter().WritePacket([]byte{0, 0, 0, 1}, 0, 0, true), test.ShouldBeError, ErrReadOnlyStorage)
+		_, err := cleanupStorage(storagePath, gigabyte, readOnly, logger)
+		test.That(t, err, test.ShouldBeError, ErrReadOnlyStorage)
+	})
+
+	t.Run("Fetch still works", func(t *testing.T) {
+		res, err := vs.Fetch(context.Background(), &FetchRequest{From: start, To: start.Add(2 * time.Second)})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(res.Video), test.ShouldBeGreaterThan, 0)
+	})
+
+	// Nothing was written to or deleted from storage.
+	entries, err := os.ReadDir(storagePath)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(entries), test.ShouldEqual, 2)
+}
+
+func TestMissingReadOnlyStorage(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	storagePath := filepath.Join(readOnlyMount(t), "video-store-missing", "storage")
+	if _, err := os.Stat(storagePath); err == nil {
+		t.Skipf("%s exists", storagePath)
+	}
+
+	t.Run("An RTP store is read-only", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Storage.StoragePath = storagePath
+		vs, err := NewRTPVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, vs.Segmenter().Init(CodecTypeH264, 640, 480), test.ShouldBeError, ErrReadOnlyStorage)
+
+		// Reconfiguring it doesn't try to create the storage path either.
+		next, err := vs.(*videostore).reconfigure(context.Background(), config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		defer next.Close()
+		test.That(t, next.Segmenter().Init(CodecTypeH264, 640, 480), test.ShouldBeError, ErrReadOnlyStorage)
+	})
+
+	t.Run("A read-only store starts without video", func(t *testing.T) {
+		config := validRTPConfig(t)
+		config.Type = SourceTypeReadOnly
+		config.Storage.StoragePath = storagePath
+		vs, err := NewReadOnlyVideoStore(config, logger)
+		test.That(t, err, test.ShouldBeNil)
+		vs.Close()
+	})
+}
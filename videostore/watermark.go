@@ -0,0 +1,145 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	// Registers the JPEG decoder with image.DecodeConfig.
+	_ "image/jpeg"
+	// Registers the PNG decoder with image.DecodeConfig.
+	_ "image/png"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// watermarkPositions are the supported Watermark.Position values.
+var watermarkPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// Watermark is an image, such as a logo, overlaid on an export.
+type Watermark struct {
+	// ImagePath is the path of a PNG or JPEG image in the export input path, see
+	// StorageConfig.ExportInputPath. PNG transparency is kept.
+	ImagePath string
+	// Position is where the image is placed, one of watermarkPositions. Defaults to bottom-right.
+	Position string
+	// Opacity is from 0 (transparent) to 1 (opaque). 0 uses 1.
+	Opacity float64
+	// Scale is the width of the image as a fraction of the video width. 0 keeps the image's size.
+	Scale float64
+	// Timestamp, if set, draws the wall clock time of each frame next to the image.
+	Timestamp bool
+}
+
+// Validate returns an error if the Watermark is invalid or its image can't be loaded.
+func (w *Watermark) Validate() error {
+	if w.ImagePath == "" {
+		return errors.New("watermark image path can't be blank")
+	}
+	if w.Position != "" && !slices.Contains(watermarkPositions, w.Position) {
+		return fmt.Errorf("invalid watermark position %s, must be one of: %s",
+			w.Position, strings.Join(watermarkPositions, ", "))
+	}
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return errors.New("watermark opacity must be between 0 and 1")
+	}
+	if w.Scale < 0 || w.Scale > 1 {
+		return errors.New("watermark scale must be between 0 and 1")
+	}
+	_, err := w.imageSize()
+	return err
+}
+
+// imageSize returns the size of the watermark image.
+func (w *Watermark) imageSize() (image.Point, error) {
+	f, err := os.Open(w.ImagePath)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("failed to open watermark image: %w", err)
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("failed to load watermark image %s: %w", w.ImagePath, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return image.Point{}, fmt.Errorf("watermark image %s is empty", w.ImagePath)
+	}
+	return image.Pt(config.Width, config.Height), nil
+}
+
+// filter returns the filters which overlay the watermark on video of size, starting at from.
+// Unlike other export filters it splits the graph to load the image as a second input.
+func (w *Watermark) filter(size image.Point, from time.Time) (string, error) {
+	imageSize, err := w.imageSize()
+	if err != nil {
+		return "", err
+	}
+	if w.Scale > 0 {
+		width := max(int(math.Round(w.Scale*float64(size.X))), 1)
+		imageSize = image.Pt(width, max(imageSize.Y*width/imageSize.X, 1))
+	}
+	opacity := w.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+	margin := size.X / 40
+	position := w.Position
+	if position == "" {
+		position = "bottom-right"
+	}
+	var x, y int
+	switch position {
+	case "top-left":
+		x, y = margin, margin
+	case "top-right":
+		x, y = size.X-imageSize.X-margin, margin
+	case "bottom-left":
+		x, y = margin, size.Y-imageSize.Y-margin
+	case "bottom-right":
+		x, y = size.X-imageSize.X-margin, size.Y-imageSize.Y-margin
+	case "center":
+		x, y = (size.X-imageSize.X)/2, (size.Y-imageSize.Y)/2
+	}
+
+	overlay := strings.Join([]string{
+		"null[main]",
+		fmt.Sprintf("movie=filename=%s,format=rgba,scale=w=%d:h=%d,colorchannelmixer=aa=%.3f[watermark]",
+			escapeFilterValue(w.ImagePath), imageSize.X, imageSize.Y, opacity),
+		fmt.Sprintf("[main][watermark]overlay=x=%d:y=%d", x, y),
+	}, ";")
+	if !w.Timestamp {
+		return overlay, nil
+	}
+
+	font, err := findFont()
+	if err != nil {
+		return "", err
+	}
+	// The time is drawn beside the image, under it unless that would leave the frame.
+	textY := fmt.Sprintf("%d", y+imageSize.Y+margin/2)
+	if strings.HasPrefix(position, "bottom") {
+		textY = fmt.Sprintf("%d-text_h", y-margin/2)
+	}
+	textX := fmt.Sprintf("%d", x)
+	if strings.HasSuffix(position, "right") {
+		textX = fmt.Sprintf("%d-text_w", x+imageSize.X)
+	}
+	// pts is relative to the start of the export, which is offset to wall clock time.
+	text := fmt.Sprintf(`%%{pts:localtime:%.6f:%%Y-%%m-%%d %%H\:%%M\:%%S}`,
+		float64(from.UnixMicro())/1e6)
+	timestamp := "drawtext=" + strings.Join([]string{
+		"fontfile=" + escapeFilterValue(font),
+		"text=" + escapeFilterValue(text),
+		"fontsize=h/30",
+		"fontcolor=white",
+		fmt.Sprintf("alpha=%.3f", opacity),
+		"shadowcolor=black",
+		"shadowx=1",
+		"shadowy=1",
+		"x=" + textX,
+		"y=" + textY,
+	}, ":")
+	return overlay + "," + timestamp, nil
+}
@@ -0,0 +1,120 @@
+package videostore
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestExportWatermark(t *testing.T) {
+	const framerate = 10
+	black := solidJPEG(t, color.Black)
+	frames := make([][]byte, 3*framerate)
+	for i := range frames {
+		frames[i] = black
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	vs, config := newTestExportStore(t, start, frames, framerate)
+
+	logo := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	draw.Draw(logo, logo.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	test.That(t, os.MkdirAll(config.Storage.ExportInputPath, 0o755), test.ShouldBeNil)
+	logoPath := filepath.Join(config.Storage.ExportInputPath, "logo.png")
+	f, err := os.Create(logoPath)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, png.Encode(f, logo), test.ShouldBeNil)
+	test.That(t, f.Close(), test.ShouldBeNil)
+
+	// brightness exports with watermark and returns the mean brightness of r in the
+	// full size frame one second in.
+	brightness := func(t *testing.T, watermark *Watermark, r image.Rectangle) int {
+		res, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(3 * time.Second),
+			Watermark: watermark,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		gray, err := grayFrameAt(filepath.Join(config.Storage.UploadPath, res.Filename), time.Second, 640, 480)
+		test.That(t, err, test.ShouldBeNil)
+		return meanBrightness(&image.Gray{Pix: gray, Stride: 640, Rect: image.Rect(0, 0, 640, 480)}, r)
+	}
+	// The margin is 1/40th of the width, 16px.
+	topRight := image.Rect(640-16-64, 16, 640-16, 16+48).Inset(4)
+	bottomRight := image.Rect(640-16-64, 480-16-48, 640-16, 480-16).Inset(4)
+
+	t.Run("Watermark is drawn at its position", func(t *testing.T) {
+		watermark := &Watermark{ImagePath: logoPath, Position: "top-right"}
+		test.That(t, brightness(t, watermark, topRight), test.ShouldBeGreaterThan, 200)
+		test.That(t, brightness(t, watermark, bottomRight), test.ShouldBeLessThan, 40)
+	})
+
+	t.Run("Watermark defaults to the bottom right", func(t *testing.T) {
+		watermark := &Watermark{ImagePath: logoPath}
+		test.That(t, brightness(t, watermark, bottomRight), test.ShouldBeGreaterThan, 200)
+		test.That(t, brightness(t, watermark, topRight), test.ShouldBeLessThan, 40)
+	})
+
+	t.Run("Opacity blends the watermark with the video", func(t *testing.T) {
+		watermark := &Watermark{ImagePath: logoPath, Position: "top-right", Opacity: 0.5}
+		b := brightness(t, watermark, topRight)
+		test.That(t, b, test.ShouldBeBetween, 90, 170)
+	})
+
+	t.Run("Scale sizes the watermark relative to the video", func(t *testing.T) {
+		// 0.2 of the width is 128x96.
+		watermark := &Watermark{ImagePath: logoPath, Position: "top-left", Scale: 0.2}
+		test.That(t, brightness(t, watermark, image.Rect(16, 16, 16+128, 16+96).Inset(4)), test.ShouldBeGreaterThan, 200)
+	})
+
+	t.Run("Watermark with timestamp is drawn", func(t *testing.T) {
+		if _, err := findFont(); err != nil {
+			t.Skip(err)
+		}
+		watermark := &Watermark{ImagePath: logoPath, Position: "top-left", Timestamp: true}
+		test.That(t, brightness(t, watermark, image.Rect(16, 16, 16+64, 16+48).Inset(4)), test.ShouldBeGreaterThan, 200)
+		// The time is drawn under the logo.
+		test.That(t, brightness(t, watermark, image.Rect(16, 16+48+8, 16+160, 16+48+8+16)), test.ShouldBeGreaterThan, 10)
+	})
+
+	t.Run("Watermark image must load", func(t *testing.T) {
+		_, err := vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(3 * time.Second),
+			Watermark: &Watermark{ImagePath: "missing.png"},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		notImage := filepath.Join(t.TempDir(), "logo.png")
+		test.That(t, os.WriteFile(notImage, []byte("not an image"), 0o600), test.ShouldBeNil)
+		err = (&Watermark{ImagePath: notImage}).Validate()
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to load watermark image")
+	})
+
+	t.Run("Watermark image must be in the export input path", func(t *testing.T) {
+		outside := filepath.Join(t.TempDir(), "logo.png")
+		b, err := os.ReadFile(logoPath)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, os.WriteFile(outside, b, 0o600), test.ShouldBeNil)
+		_, err = vs.Export(context.Background(), &ExportRequest{
+			From:      start,
+			To:        start.Add(3 * time.Second),
+			Watermark: &Watermark{ImagePath: outside},
+		})
+		test.That(t, err, test.ShouldBeError, "export input "+outside+" is outside of the export input path")
+	})
+
+	t.Run("Invalid watermark is rejected", func(t *testing.T) {
+		test.That(t, (&Watermark{ImagePath: logoPath, Position: "middle"}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Watermark{ImagePath: logoPath, Opacity: 2}).Validate(), test.ShouldNotBeNil)
+		test.That(t, (&Watermark{ImagePath: logoPath, Scale: -1}).Validate(), test.ShouldNotBeNil)
+	})
+}